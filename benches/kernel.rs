@@ -0,0 +1,170 @@
+//! Kernel hot-path benchmarks.
+//!
+//! `cargo bench --bench kernel` (or `just bench`). Criterion writes
+//! per-benchmark `estimates.json` under `target/criterion/`; compare runs with
+//! `--save-baseline <name>` / `--baseline <name>`.
+
+use std::collections::HashMap;
+use std::hint::black_box;
+use std::sync::Arc;
+
+use criterion::{criterion_group, criterion_main, BatchSize, BenchmarkId, Criterion, Throughput};
+
+use jeeves_core::kernel::protocol::Instruction;
+use jeeves_core::kernel::Kernel;
+use jeeves_core::run::{Run, WorkerIdentity};
+use jeeves_core::types::{AgentName, OutputKey, RequestId, RunId, SessionId, UserId};
+use jeeves_core::workflow::Workflow;
+
+/// Linear `s0 → s1 → … → s{n-1}` workflow.
+fn linear_workflow(stages: usize) -> Workflow {
+    let mut builder = Workflow::builder("bench")
+        .max_iterations(1_000)
+        .max_llm_calls(1_000)
+        .max_agent_hops(1_000);
+    for i in 0..stages {
+        builder = builder.agent(&format!("s{}", i));
+        if i + 1 < stages {
+            builder = builder.next(&format!("s{}", i + 1));
+        }
+    }
+    builder.build().unwrap()
+}
+
+/// Run whose outputs hold `agents × keys` string values of `value_len` bytes.
+fn large_run(agents: usize, keys: usize, value_len: usize) -> Run {
+    let mut run = Run::new("bench-user", "bench-session", "benchmark input", None);
+    let value = serde_json::Value::String("x".repeat(value_len));
+    for a in 0..agents {
+        let output: HashMap<_, _> = (0..keys)
+            .map(|k| (OutputKey::must(format!("key{}", k)), value.clone()))
+            .collect();
+        run.outputs.insert(AgentName::must(format!("agent{}", a)), output.into());
+    }
+    run
+}
+
+fn session_init(c: &mut Criterion) {
+    let workflow = linear_workflow(5);
+    let mut group = c.benchmark_group("session_init");
+    group.throughput(Throughput::Elements(1));
+    group.bench_function("initialize_orchestration", |b| {
+        let mut kernel = Kernel::new();
+        let mut n = 0u64;
+        b.iter(|| {
+            n += 1;
+            let run_id = RunId::must(format!("run{}", n));
+            let run = Run::new("bench-user", "bench-session", "hello", None);
+            black_box(kernel.initialize_orchestration(run_id, workflow.clone(), run, false).unwrap())
+        });
+    });
+    group.finish();
+}
+
+fn orchestrator_steps(c: &mut Criterion) {
+    let mut group = c.benchmark_group("orchestrator_steps");
+    let worker = WorkerIdentity::in_process();
+    for stages in [5usize, 25] {
+        let workflow = linear_workflow(stages);
+        group.throughput(Throughput::Elements(stages as u64));
+        group.bench_with_input(BenchmarkId::from_parameter(stages), &workflow, |b, workflow| {
+            b.iter_batched(
+                || {
+                    let mut kernel = Kernel::new();
+                    let run_id = RunId::must("steps");
+                    let run = Run::new("bench-user", "bench-session", "hello", None);
+                    let _state = kernel
+                        .initialize_orchestration(run_id.clone(), workflow.clone(), run, false)
+                        .unwrap();
+                    (kernel, run_id)
+                },
+                |(mut kernel, run_id)| loop {
+                    match kernel.get_next_instruction(&run_id).unwrap() {
+                        Instruction::RunAgent { agent, .. } => kernel
+                            .process_agent_result(
+                                &run_id,
+                                &agent,
+                                &worker,
+                                serde_json::json!({"ok": true}),
+                                None,
+                                Default::default(),
+                                true,
+                                "",
+                                false,
+                            )
+                            .unwrap(),
+                        other => break black_box(other),
+                    }
+                },
+                BatchSize::SmallInput,
+            );
+        });
+    }
+    group.finish();
+}
+
+fn run_copy_costs(c: &mut Criterion) {
+    let mut group = c.benchmark_group("run_copy");
+    for (agents, value_len) in [(10usize, 1_024usize), (50, 16_384)] {
+        let run = large_run(agents, 4, value_len);
+        let bytes = serde_json::to_vec(&run).unwrap().len();
+        let label = format!("{}x{}B", agents * 4, value_len);
+        group.throughput(Throughput::Bytes(bytes as u64));
+        group.bench_with_input(BenchmarkId::new("clone", &label), &run, |b, run| {
+            b.iter(|| black_box(run.clone()));
+        });
+        // Outputs are copy-on-write: only the written agent's map is copied.
+        group.bench_with_input(BenchmarkId::new("clone_then_write", &label), &run, |b, run| {
+            b.iter(|| {
+                let mut copy = run.clone();
+                if let Some(output) = copy.outputs.get_mut("agent0") {
+                    Arc::make_mut(output).insert(OutputKey::must("extra"), serde_json::json!(1));
+                }
+                black_box(copy)
+            });
+        });
+        group.bench_with_input(BenchmarkId::new("serialize", &label), &run, |b, run| {
+            b.iter(|| black_box(serde_json::to_vec(run).unwrap()));
+        });
+    }
+    group.finish();
+}
+
+fn scheduling(c: &mut Criterion) {
+    let mut group = c.benchmark_group("next_runnable");
+    for users in [10usize, 100, 1_000] {
+        group.throughput(Throughput::Elements(users as u64 * 10));
+        group.bench_with_input(BenchmarkId::new("users", users), &users, |b, &users| {
+            b.iter_batched(
+                || {
+                    let mut kernel = Kernel::new();
+                    for u in 0..users {
+                        for r in 0..10 {
+                            let id = format!("u{}r{}", u, r);
+                            kernel
+                                .create_run(
+                                    RunId::must(id.clone()),
+                                    RequestId::must(id.clone()),
+                                    UserId::must(format!("u{}", u)),
+                                    SessionId::must(id),
+                                    None,
+                                )
+                                .unwrap();
+                        }
+                    }
+                    kernel
+                },
+                |mut kernel| {
+                    while let Some(run_id) = kernel.next_runnable() {
+                        black_box(run_id);
+                    }
+                },
+                BatchSize::SmallInput,
+            );
+        });
+    }
+    group.finish();
+}
+
+criterion_group!(benches, session_init, orchestrator_steps, run_copy_costs, scheduling);
+criterion_main!(benches);
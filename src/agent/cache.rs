@@ -0,0 +1,123 @@
+//! Per-run LLM response cache.
+//!
+//! Loop-backs often re-send a byte-identical request. When a stage sets
+//! `cache_prompts`, the runner hands `LlmAgent` the run's `PromptCache` and
+//! a repeat is answered locally. Hits are reported as `llm_cache_hits` and
+//! never count toward `llm_calls` or token usage.
+
+use std::collections::{HashMap, VecDeque};
+use std::sync::Mutex;
+
+use crate::agent::llm::{ChatRequest, ChatResponse};
+
+/// Entries kept per run before the oldest is evicted.
+pub const DEFAULT_PROMPT_CACHE_CAPACITY: usize = 64;
+
+/// Bounded request → response map, evicting oldest-inserted first. Keyed
+/// by the full serialized request, so distinct requests never share an entry.
+#[derive(Debug)]
+pub struct PromptCache {
+    capacity: usize,
+    entries: Mutex<CacheEntries>,
+}
+
+#[derive(Debug, Default)]
+struct CacheEntries {
+    responses: HashMap<String, ChatResponse>,
+    order: VecDeque<String>,
+}
+
+impl PromptCache {
+    pub fn new(capacity: usize) -> Self {
+        Self {
+            capacity,
+            entries: Mutex::new(CacheEntries::default()),
+        }
+    }
+
+    /// The serialized request (messages, model, sampling params, tools,
+    /// response format). `None` if the request can't be serialized.
+    pub fn key(request: &ChatRequest) -> Option<String> {
+        serde_json::to_string(request).ok()
+    }
+
+    pub fn get(&self, key: &str) -> Option<ChatResponse> {
+        self.entries.lock().ok()?.responses.get(key).cloned()
+    }
+
+    pub fn insert(&self, key: String, response: ChatResponse) {
+        if self.capacity == 0 {
+            return;
+        }
+        let Ok(mut entries) = self.entries.lock() else {
+            return;
+        };
+        if entries.responses.insert(key.clone(), response).is_none() {
+            entries.order.push_back(key);
+        }
+        while entries.order.len() > self.capacity {
+            if let Some(oldest) = entries.order.pop_front() {
+                entries.responses.remove(&oldest);
+            }
+        }
+    }
+
+    pub fn len(&self) -> usize {
+        self.entries.lock().map(|e| e.responses.len()).unwrap_or(0)
+    }
+
+    pub fn is_empty(&self) -> bool {
+        self.len() == 0
+    }
+}
+
+impl Default for PromptCache {
+    fn default() -> Self {
+        Self::new(DEFAULT_PROMPT_CACHE_CAPACITY)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::agent::llm::{ChatMessage, TokenUsage};
+
+    fn request(text: &str) -> ChatRequest {
+        ChatRequest {
+            messages: vec![ChatMessage::user(text)],
+            temperature: None,
+            max_tokens: None,
+            model: None,
+            tools: None,
+            response_format: None,
+        }
+    }
+
+    fn response(text: &str) -> ChatResponse {
+        ChatResponse {
+            content: Some(text.to_string()),
+            tool_calls: vec![],
+            usage: TokenUsage::default(),
+            model: "mock".to_string(),
+        }
+    }
+
+    #[test]
+    fn identical_requests_share_a_key() {
+        assert_eq!(PromptCache::key(&request("a")), PromptCache::key(&request("a")));
+        assert_ne!(PromptCache::key(&request("a")), PromptCache::key(&request("b")));
+    }
+
+    #[test]
+    fn evicts_oldest_beyond_capacity() {
+        let cache = PromptCache::new(2);
+        for text in ["a", "b", "c"] {
+            let key = PromptCache::key(&request(text)).unwrap();
+            cache.insert(key, response(text));
+        }
+        assert_eq!(cache.len(), 2);
+        assert!(cache.get(&PromptCache::key(&request("a")).unwrap()).is_none());
+        let hit = cache.get(&PromptCache::key(&request("c")).unwrap()).unwrap();
+        assert_eq!(hit.content.as_deref(), Some("c"));
+    }
+}
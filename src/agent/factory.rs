@@ -9,6 +9,7 @@ use crate::agent::{
 };
 use crate::agent::llm::LlmProvider;
 use crate::agent::prompts::PromptRegistry;
+use crate::agent::tokens::{CharRatioEstimator, TokenEstimator};
 use crate::tools::{AclToolExecutor, ContentResolver, ToolRegistry};
 
 /// Builds an `AgentRegistry` from one or more `Workflow`s plus shared resources.
@@ -27,6 +28,7 @@ pub struct AgentFactoryBuilder {
     content_resolver: Option<Arc<dyn ContentResolver>>,
     hooks: Vec<crate::agent::hooks::DynHook>,
     agent_hooks: Vec<crate::agent::hooks::DynAgentHook>,
+    token_estimator: Arc<dyn TokenEstimator>,
 }
 
 impl std::fmt::Debug for AgentFactoryBuilder {
@@ -51,6 +53,7 @@ impl AgentFactoryBuilder {
             content_resolver: None,
             hooks: Vec::new(),
             agent_hooks: Vec::new(),
+            token_estimator: Arc::new(CharRatioEstimator::default()),
         }
     }
 
@@ -75,6 +78,13 @@ impl AgentFactoryBuilder {
         self
     }
 
+    /// Token estimator for every `LlmAgent` built by this factory (default:
+    /// `CharRatioEstimator` at 4 chars/token).
+    pub fn with_token_estimator(mut self, estimator: Arc<dyn TokenEstimator>) -> Self {
+        self.token_estimator = estimator;
+        self
+    }
+
     /// Add a single workflow.
     pub fn add_workflow(mut self, workflow: Workflow) -> Self {
         self.workflows.insert(workflow.name.clone(), workflow);
@@ -139,6 +149,7 @@ fn merge_agents(
                 max_tool_rounds: crate::agent::DEFAULT_MAX_TOOL_ROUNDS,
                 content_resolver: ctx.content_resolver.clone(),
                 hooks: ctx.hooks.clone(),
+                token_estimator: ctx.token_estimator.clone(),
             })
         } else if ctx.tools.get(agent_name.as_str()).is_some() {
             Arc::new(ToolDelegatingAgent {
@@ -237,6 +237,15 @@ mod tests {
             context_overflow: None,
             interrupt_response: None,
             response_format: None,
+            rendered_prompt: None,
+            prompt_cache: None,
+            cancellation: None,
+            security_context: None,
+            tool_policy: None,
+            locale: None,
+            timezone: None,
+            params: HashMap::new(),
+            idempotency_key: None,
         };
         let mut output = AgentOutput {
             output: json!({"k": "v"}),
@@ -244,6 +253,9 @@ mod tests {
             success: true,
             error_message: String::new(),
             interrupt_request: None,
+            artifacts: vec![],
+            user_log: vec![],
+            failure_class: Default::default(),
         };
 
         NoOp.before_agent(&ctx).await;
@@ -281,6 +293,15 @@ mod tests {
             context_overflow: None,
             interrupt_response: None,
             response_format: None,
+            rendered_prompt: None,
+            prompt_cache: None,
+            cancellation: None,
+            security_context: None,
+            tool_policy: None,
+            locale: None,
+            timezone: None,
+            params: HashMap::new(),
+            idempotency_key: None,
         };
         let mut output = AgentOutput {
             output: json!({"response": "ok"}),
@@ -288,6 +309,9 @@ mod tests {
             success: true,
             error_message: String::new(),
             interrupt_request: None,
+            artifacts: vec![],
+            user_log: vec![],
+            failure_class: Default::default(),
         };
 
         Tag.after_agent(&ctx, &mut output).await;
@@ -36,7 +36,8 @@ impl MessageContent {
         }
     }
 
-    /// Approximate character length (used for token estimation: chars/4).
+    /// Approximate character length. References count as their id plus a
+    /// fixed overhead; blobs as their encoded length.
     pub fn len(&self) -> usize {
         match self {
             Self::Text(s) => s.len(),
@@ -11,16 +11,29 @@ pub struct ToolCallResult {
     pub success: bool,
     pub latency_ms: u64,
     pub error_type: Option<String>,
+    /// Serialized size of the call's arguments.
+    #[serde(default)]
+    pub bytes_in: u64,
+    /// Size of the result text handed back to the model.
+    #[serde(default)]
+    pub bytes_out: u64,
 }
 
 /// Aggregate metrics from one agent's execution (one Instruction round).
 #[derive(Debug, Clone, Default, Serialize, Deserialize)]
 pub struct AgentExecutionMetrics {
     pub llm_calls: i32,
+    /// LLM requests answered from the run's `PromptCache` (not in `llm_calls`).
+    #[serde(default)]
+    pub llm_cache_hits: i32,
     pub tool_calls: i32,
     pub tokens_in: Option<i64>,
     pub tokens_out: Option<i64>,
     pub duration_ms: i64,
     #[serde(default)]
     pub tool_results: Vec<ToolCallResult>,
+    /// Tool calls refused under the stage's `tool_policy`. They never ran,
+    /// so they are not in `tool_calls` or `tool_results`.
+    #[serde(default)]
+    pub refused_tools: Vec<String>,
 }
@@ -1,12 +1,14 @@
 //! Agent execution layer. `LlmAgent` runs a ReAct tool loop; streaming is
 //! opt-in via `AgentContext.event_tx`.
 
+pub mod cache;
 pub mod factory;
 pub mod hooks;
 pub mod llm;
 pub mod metrics;
 pub mod policy;
 pub mod prompts;
+pub mod tokens;
 
 use async_trait::async_trait;
 use std::collections::HashMap;
@@ -19,9 +21,11 @@ use tracing::instrument;
 use crate::agent::llm::{
     collect_stream, ChatMessage, ChatRequest, LlmProvider, MessageContent, RunEvent, ToolCall,
 };
+use crate::agent::cache::PromptCache;
 use crate::agent::metrics::{AgentExecutionMetrics, ToolCallResult};
-use crate::agent::policy::ContextOverflow;
+use crate::agent::policy::{ContextOverflow, FailureClass};
 use crate::agent::prompts::PromptRegistry;
+use crate::agent::tokens::{CharRatioEstimator, TokenEstimator};
 use crate::tools::{ContentPart, ContentResolver, ToolRegistry};
 
 #[must_use]
@@ -34,6 +38,23 @@ pub struct AgentOutput {
     /// Set when the agent wants the worker to suspend on a flow interrupt
     /// (e.g. tool confirmation). The worker stores it on the run.
     pub interrupt_request: Option<crate::run::FlowInterrupt>,
+    /// Artifacts produced by this execution; the runner records them under
+    /// the current stage.
+    pub artifacts: Vec<crate::run::Artifact>,
+    /// Progress lines for the end user; the runner records them under the
+    /// current stage.
+    pub user_log: Vec<crate::run::UserLogNote>,
+    /// Retry treatment when `success` is false. Ignored on success.
+    pub failure_class: FailureClass,
+}
+
+impl AgentOutput {
+    /// Add a line to the run's user-visible log. The kernel truncates and
+    /// caps these (see `Run::append_user_log`).
+    pub fn log_to_user(mut self, level: crate::run::UserLogLevel, message: impl Into<String>) -> Self {
+        self.user_log.push(crate::run::UserLogNote { level, message: message.into() });
+        self
+    }
 }
 
 #[derive(Debug, Clone)]
@@ -48,13 +69,35 @@ pub struct AgentContext {
     pub event_tx: Option<mpsc::Sender<RunEvent>>,
     pub stage_name: Option<String>,
     pub workflow_name: Arc<str>,
-    /// chars/4 heuristic; checked at the top of every ReAct round.
+    /// Sized by `LlmAgent::token_estimator`; checked at the top of every
+    /// ReAct round.
     pub max_context_tokens: Option<i64>,
     pub context_overflow: Option<ContextOverflow>,
     /// Set by the worker on resume after a tool-confirmation interrupt.
     pub interrupt_response: Option<serde_json::Value>,
     /// Verbatim LLM-provider hint forwarded as-is; kernel does not parse it.
     pub response_format: Option<serde_json::Value>,
+    /// Stage `prompt_template` already rendered by the kernel. When set,
+    /// `LlmAgent` uses it as the system prompt instead of its `prompt_key`.
+    pub rendered_prompt: Option<String>,
+    /// Run-scoped response cache, supplied by the runner when the stage sets
+    /// `cache_prompts`.
+    pub prompt_cache: Option<Arc<crate::agent::cache::PromptCache>>,
+    /// Fired when the kernel cancels the run mid-stage. Agents doing long
+    /// work outside the runner's control should check it between steps.
+    pub cancellation: Option<tokio_util::sync::CancellationToken>,
+    /// Stage sandbox policy. Tool executors that honour it read it here.
+    pub security_context: Option<crate::workflow::SecurityContext>,
+    /// Agent's tool allow/deny lists; `LlmAgent` refuses calls outside them.
+    pub tool_policy: Option<crate::workflow::ToolPolicy>,
+    /// Run's language tag and IANA time zone, when the caller supplied them.
+    pub locale: Option<String>,
+    pub timezone: Option<String>,
+    /// Caller's read-only per-request parameters (`Run::params`).
+    pub params: HashMap<String, serde_json::Value>,
+    /// Stable across retries and re-dispatches of one stage visit; pass it
+    /// to downstream APIs that deduplicate side effects.
+    pub idempotency_key: Option<String>,
 }
 
 #[async_trait]
@@ -80,6 +123,8 @@ pub struct LlmAgent {
     pub content_resolver: Option<Arc<dyn ContentResolver>>,
     /// Hooks run in registration order; the first non-`Continue` decision wins.
     pub hooks: Vec<crate::agent::hooks::DynHook>,
+    /// Sizes the prompt against the stage's `max_context_tokens`.
+    pub token_estimator: Arc<dyn TokenEstimator>,
 }
 
 impl Default for LlmAgent {
@@ -96,6 +141,7 @@ impl Default for LlmAgent {
             max_tool_rounds: 10,
             content_resolver: None,
             hooks: Vec::new(),
+            token_estimator: Arc::new(CharRatioEstimator::default()),
         }
     }
 }
@@ -120,10 +166,13 @@ impl Agent for LlmAgent {
             vars.insert(key.clone(), value_to_string(value));
         }
 
-        let prompt_text = self
-            .prompts
-            .render(self.prompt_key.as_str(), &vars)
-            .unwrap_or_else(|| format!("No prompt found for key: {}", self.prompt_key));
+        let prompt_text = match ctx.rendered_prompt {
+            Some(ref rendered) => rendered.clone(),
+            None => self
+                .prompts
+                .render(self.prompt_key.as_str(), &vars)
+                .unwrap_or_else(|| format!("No prompt found for key: {}", self.prompt_key)),
+        };
 
         let mut messages = vec![
             ChatMessage::system(prompt_text),
@@ -133,16 +182,19 @@ impl Agent for LlmAgent {
         let tool_defs = Arc::new(build_tool_defs(&self.tools));
 
         let mut total_llm_calls = 0i32;
+        let mut total_cache_hits = 0i32;
         let mut total_tool_calls = 0i32;
         let mut total_tokens_in = 0i64;
         let mut total_tokens_out = 0i64;
         let mut last_response = None;
         let mut tool_results: Vec<ToolCallResult> = Vec::new();
+        let mut refused_tools: Vec<String> = Vec::new();
 
         for _round in 0..self.max_tool_rounds {
             if let Some(max_tokens) = ctx.max_context_tokens {
+                let model = self.model.as_deref();
                 let estimated_tokens: i64 = messages.iter()
-                    .map(|m| (m.content.len() / 4) as i64)
+                    .map(|m| self.token_estimator.estimate_message(m, model))
                     .sum();
 
                 if estimated_tokens > max_tokens {
@@ -154,7 +206,7 @@ impl Agent for LlmAgent {
                             // message; drop oldest intermediates until under the limit.
                             let mut running_est = estimated_tokens;
                             while messages.len() > 2 && running_est > max_tokens {
-                                let dropped_tokens = (messages[1].content.len() / 4) as i64;
+                                let dropped_tokens = self.token_estimator.estimate_message(&messages[1], model);
                                 messages.remove(1);
                                 running_est -= dropped_tokens;
                             }
@@ -168,11 +220,13 @@ impl Agent for LlmAgent {
                                 }),
                                 metrics: AgentExecutionMetrics {
                                     llm_calls: total_llm_calls,
+                                    llm_cache_hits: total_cache_hits,
                                     tool_calls: total_tool_calls,
                                     tokens_in: Some(total_tokens_in),
                                     tokens_out: Some(total_tokens_out),
                                     duration_ms: start.elapsed().as_millis() as i64,
                                     tool_results: tool_results.clone(),
+                                    refused_tools: refused_tools.clone(),
                                 },
                                 success: false,
                                 error_message: format!(
@@ -180,6 +234,9 @@ impl Agent for LlmAgent {
                                     estimated_tokens, max_tokens
                                 ),
                                 interrupt_request: None,
+                                artifacts: vec![],
+                                user_log: vec![],
+                                failure_class: FailureClass::Fatal,
                             });
                         }
                     }
@@ -199,21 +256,52 @@ impl Agent for LlmAgent {
                 response_format: ctx.response_format.clone(),
             };
 
-            let mut resp = match self.llm.chat_stream(&req).await {
-                Ok(stream) => match collect_stream(stream, ctx.event_tx.as_ref(), ctx.stage_name.as_deref(), ctx.workflow_name.clone()).await {
-                    Ok(resp) => resp,
+            let cache_key = ctx.prompt_cache.as_ref().and_then(|_| PromptCache::key(&req));
+            let cached = match (&ctx.prompt_cache, &cache_key) {
+                (Some(cache), Some(key)) => cache.get(key),
+                _ => None,
+            };
+            let from_cache = cached.is_some();
+
+            let mut resp = match cached {
+                Some(hit) => {
+                    tracing::debug!(round = _round, "prompt_cache_hit");
+                    if let (Some(tx), Some(content)) = (&ctx.event_tx, &hit.content) {
+                        let _ = tx
+                            .send(RunEvent::Delta {
+                                content: content.clone(),
+                                stage: ctx.stage_name.clone(),
+                                pipeline: ctx.workflow_name.clone(),
+                            })
+                            .await;
+                    }
+                    hit
+                }
+                None => match self.llm.chat_stream(&req).await {
+                    Ok(stream) => match collect_stream(stream, ctx.event_tx.as_ref(), ctx.stage_name.as_deref(), ctx.workflow_name.clone()).await {
+                        Ok(resp) => resp,
+                        Err(e) => return Ok(make_error_output(e, start, total_llm_calls + 1)),
+                    },
                     Err(e) => return Ok(make_error_output(e, start, total_llm_calls + 1)),
                 },
-                Err(e) => return Ok(make_error_output(e, start, total_llm_calls + 1)),
             };
 
+            // Cache the provider's response, before hooks rewrite it.
+            if let (false, Some(cache), Some(key)) = (from_cache, &ctx.prompt_cache, cache_key) {
+                cache.insert(key, resp.clone());
+            }
+
             for hook in &self.hooks {
                 hook.after_llm_call(&mut resp).await;
             }
 
-            total_llm_calls += 1;
-            total_tokens_in += resp.usage.prompt_tokens as i64;
-            total_tokens_out += resp.usage.completion_tokens as i64;
+            if from_cache {
+                total_cache_hits += 1;
+            } else {
+                total_llm_calls += 1;
+                total_tokens_in += resp.usage.prompt_tokens as i64;
+                total_tokens_out += resp.usage.completion_tokens as i64;
+            }
 
             if resp.tool_calls.is_empty() {
                 last_response = Some(resp);
@@ -226,6 +314,16 @@ impl Agent for LlmAgent {
             messages.push(ChatMessage::assistant(content, Some(resp.tool_calls.clone())));
 
             for tc in &resp.tool_calls {
+                // Refused calls never run, so they do not count against
+                // `max_tool_calls`; the kernel sees them in `refused_tools`.
+                if ctx.tool_policy.as_ref().is_some_and(|policy| !policy.permits(&tc.name)) {
+                    tracing::warn!(tool_name = %tc.name, "tool_not_permitted");
+                    refused_tools.push(tc.name.clone());
+                    let refusal = serde_json::json!({"error": "tool_not_permitted", "tool": &tc.name});
+                    messages.push(ChatMessage::tool_result(&tc.id, refusal.to_string()));
+                    continue;
+                }
+
                 total_tool_calls += 1;
                 tracing::debug!(tool_name = %tc.name, "tool_execute");
 
@@ -243,13 +341,18 @@ impl Agent for LlmAgent {
                                 "tool": &tc.name,
                             }),
                             interrupt_request: Some(interrupt),
+                            artifacts: vec![],
+                            user_log: vec![],
+                            failure_class: FailureClass::default(),
                             metrics: AgentExecutionMetrics {
                                 llm_calls: total_llm_calls,
+                                llm_cache_hits: total_cache_hits,
                                 tool_calls: total_tool_calls,
                                 tokens_in: Some(total_tokens_in),
                                 tokens_out: Some(total_tokens_out),
                                 duration_ms: start.elapsed().as_millis() as i64,
                                 tool_results: tool_results.clone(),
+                                refused_tools: refused_tools.clone(),
                             },
                             success: true,
                             error_message: String::new(),
@@ -278,6 +381,7 @@ impl Agent for LlmAgent {
                 }
 
                 let params = tc.arguments.clone();
+                let bytes_in = params.to_string().len() as u64;
                 let tool_start = Instant::now();
 
                 // Exactly one of the two states applies; encode via enum so the
@@ -344,6 +448,8 @@ impl Agent for LlmAgent {
                     success: tool_success,
                     latency_ms: tool_start.elapsed().as_millis() as u64,
                     error_type: tool_error,
+                    bytes_in,
+                    bytes_out: result_text.len() as u64,
                 });
 
                 if let Some(ref tx) = ctx.event_tx {
@@ -378,11 +484,13 @@ impl Agent for LlmAgent {
         let duration = start.elapsed();
         let metrics = AgentExecutionMetrics {
             llm_calls: total_llm_calls,
+            llm_cache_hits: total_cache_hits,
             tool_calls: total_tool_calls,
             tokens_in: Some(total_tokens_in),
             tokens_out: Some(total_tokens_out),
             duration_ms: duration.as_millis() as i64,
             tool_results,
+            refused_tools,
         };
 
         let output = match last_response {
@@ -396,6 +504,9 @@ impl Agent for LlmAgent {
             success: true,
             error_message: String::new(),
             interrupt_request: None,
+            artifacts: vec![],
+            user_log: vec![],
+            failure_class: FailureClass::default(),
         })
     }
 }
@@ -471,13 +582,18 @@ impl Agent for ToolDelegatingAgent {
                         "message": &confirmation.message,
                     }),
                     interrupt_request: Some(interrupt),
+                    artifacts: vec![],
+                    user_log: vec![],
+                    failure_class: FailureClass::default(),
                     metrics: AgentExecutionMetrics {
                         llm_calls: 0,
+                        llm_cache_hits: 0,
                         tool_calls: 0,
                         tokens_in: None,
                         tokens_out: None,
                         duration_ms: 0,
                         tool_results: vec![],
+                        refused_tools: vec![],
                     },
                     success: true,
                     error_message: String::new(),
@@ -485,9 +601,10 @@ impl Agent for ToolDelegatingAgent {
             }
         }
 
+        let bytes_in = params.to_string().len() as u64;
         let start = std::time::Instant::now();
-        let (result, success, error_message) = match self.tools.execute_for(self.agent_name.as_str(), self.tool_name.as_str(), params).await {
-            Ok(tool_output) => (tool_output.data, true, String::new()),
+        let (result, success, error_message, failure_class) = match self.tools.execute_for(self.agent_name.as_str(), self.tool_name.as_str(), params).await {
+            Ok(tool_output) => (tool_output.data, true, String::new(), FailureClass::default()),
             Err(e) => {
                 let err_str = e.to_string();
                 if let Some(ref tx) = ctx.event_tx {
@@ -499,10 +616,11 @@ impl Agent for ToolDelegatingAgent {
                         })
                         .await;
                 }
-                (serde_json::json!({"error": err_str}), false, err_str)
+                (serde_json::json!({"error": err_str}), false, err_str, FailureClass::from_error(&e))
             }
         };
         let duration_ms = start.elapsed().as_millis() as i64;
+        let bytes_out = result.to_string().len() as u64;
 
         if let Some(ref tx) = ctx.event_tx {
             let _ = tx
@@ -519,6 +637,7 @@ impl Agent for ToolDelegatingAgent {
             output: result,
             metrics: AgentExecutionMetrics {
                 llm_calls: 0,
+                llm_cache_hits: 0,
                 tool_calls: 1,
                 tokens_in: None,
                 tokens_out: None,
@@ -528,11 +647,17 @@ impl Agent for ToolDelegatingAgent {
                     success,
                     latency_ms: duration_ms as u64,
                     error_type: if error_message.is_empty() { None } else { Some(error_message.clone()) },
+                    bytes_in,
+                    bytes_out,
                 }],
+                refused_tools: vec![],
             },
             success,
             error_message,
             interrupt_request: None,
+            artifacts: vec![],
+            user_log: vec![],
+            failure_class,
         })
     }
 }
@@ -549,15 +674,20 @@ impl Agent for DeterministicAgent {
             output: serde_json::json!({}),
             metrics: AgentExecutionMetrics {
                 llm_calls: 0,
+                llm_cache_hits: 0,
                 tool_calls: 0,
                 tokens_in: None,
                 tokens_out: None,
                 duration_ms: 0,
                 tool_results: vec![],
+                refused_tools: vec![],
             },
             success: true,
             error_message: String::new(),
             interrupt_request: None,
+            artifacts: vec![],
+            user_log: vec![],
+            failure_class: FailureClass::default(),
         })
     }
 }
@@ -662,15 +792,20 @@ fn make_error_output(
         output: serde_json::json!({"error": e.to_string()}),
         metrics: AgentExecutionMetrics {
             llm_calls,
+            llm_cache_hits: 0,
             tool_calls: 0,
             tokens_in: None,
             tokens_out: None,
             duration_ms: start.elapsed().as_millis() as i64,
             tool_results: vec![],
+            refused_tools: vec![],
         },
         success: false,
         error_message: e.to_string(),
         interrupt_request: None,
+        artifacts: vec![],
+        user_log: vec![],
+        failure_class: FailureClass::from_error(&e),
     }
 }
 
@@ -696,6 +831,15 @@ mod tests {
             context_overflow: Some(overflow),
             interrupt_response: None,
             response_format: None,
+            rendered_prompt: None,
+            prompt_cache: None,
+            cancellation: None,
+            security_context: None,
+            tool_policy: None,
+            locale: None,
+            timezone: None,
+            params: HashMap::new(),
+            idempotency_key: None,
         }
     }
 
@@ -717,6 +861,7 @@ mod tests {
             max_tool_rounds: 10,
             content_resolver: None,
             hooks: Vec::new(),
+            token_estimator: Arc::new(CharRatioEstimator::default()),
         };
 
         let long_input = "x".repeat(200);
@@ -783,6 +928,7 @@ mod tests {
             max_tool_rounds: 5,
             content_resolver: None,
             hooks: Vec::new(),
+            token_estimator: Arc::new(CharRatioEstimator::default()),
         };
 
         let ctx = ctx_with_overflow("short input", 50, ContextOverflow::TruncateOldest);
@@ -793,6 +939,59 @@ mod tests {
         assert_eq!(result.metrics.tool_calls, 1);
     }
 
+    #[tokio::test]
+    async fn test_tool_policy_refuses_denied_tool() {
+        use crate::agent::llm::mock::SequentialMockLlmProvider;
+        use crate::agent::llm::{ChatResponse, TokenUsage, ToolCall};
+
+        let usage = TokenUsage { prompt_tokens: 10, completion_tokens: 5, total_tokens: 15 };
+        let llm = Arc::new(SequentialMockLlmProvider::new(vec![
+            ChatResponse {
+                content: None,
+                tool_calls: vec![ToolCall {
+                    id: "call_1".to_string(),
+                    name: "delete_repo".to_string(),
+                    arguments: serde_json::json!({}),
+                }],
+                usage: usage.clone(),
+                model: "mock".to_string(),
+            },
+            ChatResponse {
+                content: Some(r#"{"response":"gave up"}"#.to_string()),
+                tool_calls: vec![],
+                usage,
+                model: "mock".to_string(),
+            },
+        ]));
+        let agent = LlmAgent {
+            llm,
+            prompts: Arc::new(crate::agent::prompts::PromptRegistry::empty()),
+            tools: Arc::new(ToolRegistry::new()),
+            agent_name: "test".into(),
+            prompt_key: "test".into(),
+            temperature: None,
+            max_tokens: None,
+            model: None,
+            max_tool_rounds: 5,
+            content_resolver: None,
+            hooks: Vec::new(),
+            token_estimator: Arc::new(CharRatioEstimator::default()),
+        };
+
+        let mut ctx = ctx_with_overflow("clean up", 10_000, ContextOverflow::Fail);
+        ctx.tool_policy = Some(crate::workflow::ToolPolicy {
+            allowed_tools: None,
+            denied_tools: vec!["delete_repo".into()],
+        });
+        let result = agent.process(&ctx).await.unwrap();
+
+        assert!(result.success);
+        assert_eq!(result.metrics.llm_calls, 2);
+        assert_eq!(result.metrics.tool_calls, 0, "refused calls do not count against max_tool_calls");
+        assert!(result.metrics.tool_results.is_empty(), "refused call never reaches the registry");
+        assert_eq!(result.metrics.refused_tools, vec!["delete_repo".to_string()]);
+    }
+
     #[tokio::test]
     async fn test_context_overflow_fail_strategy_returns_error() {
         let llm = Arc::new(MockLlmProvider::new("should not be called"));
@@ -808,6 +1007,7 @@ mod tests {
             max_tool_rounds: 10,
             content_resolver: None,
             hooks: Vec::new(),
+            token_estimator: Arc::new(CharRatioEstimator::default()),
         };
 
         let long_input = "y".repeat(500);
@@ -835,6 +1035,7 @@ mod tests {
             max_tool_rounds: 10,
             content_resolver: None,
             hooks: Vec::new(),
+            token_estimator: Arc::new(CharRatioEstimator::default()),
         };
 
         let ctx = AgentContext {
@@ -849,6 +1050,15 @@ mod tests {
             context_overflow: None,
             interrupt_response: None,
             response_format: None,
+            rendered_prompt: None,
+            prompt_cache: None,
+            cancellation: None,
+            security_context: None,
+            tool_policy: None,
+            locale: None,
+            timezone: None,
+            params: HashMap::new(),
+            idempotency_key: None,
         };
 
         let result = agent.process(&ctx).await.unwrap();
@@ -11,3 +11,29 @@ pub enum ContextOverflow {
     /// Drop oldest non-system messages until under the limit.
     TruncateOldest,
 }
+
+/// How the runner's retry loop treats a failed agent execution.
+#[derive(Debug, Clone, Copy, Serialize, Deserialize, Default, PartialEq, Eq)]
+#[serde(rename_all = "snake_case")]
+pub enum FailureClass {
+    /// Transient; retried under the stage's `retry_policy`.
+    #[default]
+    Retryable,
+    /// Retrying cannot help (bad input, denied, over budget); routes to
+    /// `error_next` immediately.
+    Fatal,
+    /// Upstream asked to back off. The next attempt waits at least
+    /// `retry_after_ms`, even past the policy's `max_backoff_ms`.
+    Throttled { retry_after_ms: u64 },
+}
+
+impl FailureClass {
+    /// Default classification for a kernel error surfaced by an agent.
+    pub fn from_error(error: &crate::types::Error) -> Self {
+        use crate::types::Error;
+        match error {
+            Error::Internal { .. } | Error::Timeout(_) | Error::Io(_) => Self::Retryable,
+            _ => Self::Fatal,
+        }
+    }
+}
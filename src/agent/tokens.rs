@@ -0,0 +1,118 @@
+//! Token estimation for context-window checks.
+//!
+//! `LlmAgent` compares estimated prompt size against a stage's
+//! `max_context_tokens` before every LLM call. The default estimator divides
+//! character counts by a per-model ratio; consumers with a real tokenizer
+//! plug in their own [`TokenEstimator`].
+
+use crate::agent::llm::{ChatMessage, MessageContent};
+use crate::tools::ContentPart;
+
+/// Characters per token when no model ratio matches.
+pub const DEFAULT_CHARS_PER_TOKEN: f64 = 4.0;
+
+/// Approximate token count for text sent to `model`.
+pub trait TokenEstimator: Send + Sync + std::fmt::Debug {
+    fn estimate(&self, text: &str, model: Option<&str>) -> i64;
+
+    /// Estimate for one chat message. Text parts go through `estimate`;
+    /// references and blobs count their encoded length at the default ratio.
+    fn estimate_message(&self, message: &ChatMessage, model: Option<&str>) -> i64 {
+        match &message.content {
+            MessageContent::Text(text) => self.estimate(text, model),
+            MessageContent::Parts(parts) => parts
+                .iter()
+                .map(|part| match part {
+                    ContentPart::Text { text } => self.estimate(text, model),
+                    other => (MessageContent::Parts(vec![other.clone()]).len() as f64
+                        / DEFAULT_CHARS_PER_TOKEN)
+                        .ceil() as i64,
+                })
+                .sum(),
+        }
+    }
+}
+
+/// Character-ratio estimator with per-model overrides matched by prefix
+/// (longest prefix wins).
+#[derive(Debug, Clone)]
+pub struct CharRatioEstimator {
+    default_ratio: f64,
+    model_ratios: Vec<(String, f64)>,
+}
+
+impl CharRatioEstimator {
+    pub fn new(default_ratio: f64) -> Self {
+        Self {
+            default_ratio,
+            model_ratios: Vec::new(),
+        }
+    }
+
+    /// Use `chars_per_token` for models whose name starts with `prefix`.
+    pub fn with_model(mut self, prefix: impl Into<String>, chars_per_token: f64) -> Self {
+        self.model_ratios.push((prefix.into(), chars_per_token));
+        self
+    }
+
+    fn ratio_for(&self, model: Option<&str>) -> f64 {
+        model
+            .and_then(|m| {
+                self.model_ratios
+                    .iter()
+                    .filter(|(prefix, _)| m.starts_with(prefix.as_str()))
+                    .max_by_key(|(prefix, _)| prefix.len())
+                    .map(|(_, ratio)| *ratio)
+            })
+            .unwrap_or(self.default_ratio)
+    }
+}
+
+impl Default for CharRatioEstimator {
+    fn default() -> Self {
+        Self::new(DEFAULT_CHARS_PER_TOKEN)
+    }
+}
+
+impl TokenEstimator for CharRatioEstimator {
+    fn estimate(&self, text: &str, model: Option<&str>) -> i64 {
+        let ratio = self.ratio_for(model);
+        if text.is_empty() || ratio <= 0.0 {
+            return 0;
+        }
+        (text.chars().count() as f64 / ratio).ceil() as i64
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn longest_model_prefix_wins() {
+        let estimator = CharRatioEstimator::default()
+            .with_model("claude", 3.5)
+            .with_model("claude-haiku", 2.0);
+        let text = "x".repeat(14);
+        assert_eq!(estimator.estimate(&text, None), 4);
+        assert_eq!(estimator.estimate(&text, Some("claude-sonnet")), 4);
+        assert_eq!(estimator.estimate(&text, Some("claude-haiku-4")), 7);
+        assert_eq!(estimator.estimate("", Some("claude")), 0);
+    }
+
+    #[test]
+    fn counts_characters_not_bytes() {
+        let estimator = CharRatioEstimator::new(1.0);
+        assert_eq!(estimator.estimate("héllo", None), 5);
+    }
+
+    #[test]
+    fn message_parts_are_summed() {
+        let estimator = CharRatioEstimator::default();
+        let message = ChatMessage::user(MessageContent::Parts(vec![
+            ContentPart::Text { text: "x".repeat(8) },
+            ContentPart::Text { text: "y".repeat(4) },
+        ]));
+        assert_eq!(estimator.estimate_message(&message, None), 3);
+    }
+}
@@ -36,7 +36,15 @@ async fn run_kernel_actor(
                     tracing::info!("Kernel actor channel closed");
                     break;
                 };
+                if kernel.command_profiler.is_none() {
+                    dispatch(&mut kernel, cmd).await;
+                    continue;
+                }
+                let (command, queued, started) = (cmd.name(), rx.len(), std::time::Instant::now());
                 dispatch(&mut kernel, cmd).await;
+                if let Some(profiler) = kernel.command_profiler.as_mut() {
+                    profiler.record(command, started.elapsed(), queued);
+                }
             }
         }
     }
@@ -53,25 +61,19 @@ async fn dispatch(kernel: &mut Kernel, cmd: KernelCommand) {
             force,
             resp_tx,
         } => {
-            // Auto-create a run record if not already registered.
-            if kernel.lifecycle.get(&run_id).is_none() {
-                let _ = kernel.create_run(
-                    run_id.clone(),
-                    run.identity.request_id.clone(),
-                    run.identity.user_id.clone(),
-                    run.identity.session_id.clone(),
-                    None,
-                );
-            }
-            let result = kernel.initialize_orchestration(
-                run_id.clone(),
-                *workflow,
-                *run,
-                force,
-            );
+            // Normalize, classify and auto-create a run record if not
+            // already registered.
+            let mut run = run;
+            let result = kernel.admit_run(&run_id, &mut run).and_then(|()| {
+                kernel.initialize_orchestration(run_id.clone(), *workflow, *run, force)
+            });
             let _ = resp_tx.send(result);
         }
 
+        KernelCommand::MigrateSession { run_id, workflow, resp_tx } => {
+            let _ = resp_tx.send(kernel.migrate_session(&run_id, *workflow));
+        }
+
         KernelCommand::GetNextInstruction {
             run_id,
             resp_tx,
@@ -89,6 +91,7 @@ async fn dispatch(kernel: &mut Kernel, cmd: KernelCommand) {
         KernelCommand::ProcessAgentResult {
             run_id,
             agent_name,
+            worker,
             output,
             metadata_updates,
             metrics,
@@ -100,6 +103,7 @@ async fn dispatch(kernel: &mut Kernel, cmd: KernelCommand) {
             let result = kernel.process_agent_result(
                 &run_id,
                 &agent_name,
+                &worker,
                 output,
                 metadata_updates,
                 metrics,
@@ -118,6 +122,46 @@ async fn dispatch(kernel: &mut Kernel, cmd: KernelCommand) {
             let _ = resp_tx.send(result);
         }
 
+        KernelCommand::ExplainLatency { run_id, resp_tx } => {
+            let _ = resp_tx.send(kernel.explain_latency(&run_id));
+        }
+
+        KernelCommand::ExportSession { run_id, format, resp_tx } => {
+            let _ = resp_tx.send(kernel.export_session(&run_id, format));
+        }
+
+        KernelCommand::GetProvenance { run_id, key, resp_tx } => {
+            let _ = resp_tx.send(kernel.get_provenance(&run_id, &key));
+        }
+
+        KernelCommand::GetRunTemplate { name, resp_tx } => {
+            let _ = resp_tx.send(kernel.get_run_template(&name));
+        }
+
+        KernelCommand::InstantiateRunTemplate { name, user_id, session_id, raw_input, metadata, resp_tx } => {
+            let _ = resp_tx.send(kernel.instantiate_run_template(&name, &user_id, &session_id, &raw_input, metadata));
+        }
+
+        KernelCommand::InstantiateForInput { user_id, session_id, raw_input, metadata, resp_tx } => {
+            let _ = resp_tx.send(kernel.instantiate_for_input(&user_id, &session_id, &raw_input, metadata));
+        }
+
+        KernelCommand::ReplayRun { archived, overrides, resp_tx } => {
+            let _ = resp_tx.send(kernel.replay_run(&archived, *overrides));
+        }
+
+        KernelCommand::StartCanary { name, workflow, percent, resp_tx } => {
+            let _ = resp_tx.send(kernel.start_canary(&name, workflow, percent));
+        }
+
+        KernelCommand::AbortCanary { name, resp_tx } => {
+            let _ = resp_tx.send(kernel.abort_canary(&name));
+        }
+
+        KernelCommand::GetCanaryStatus { name, resp_tx } => {
+            let _ = resp_tx.send(kernel.canary_status(&name));
+        }
+
         KernelCommand::CreateRun {
             run_id,
             request_id,
@@ -135,6 +179,19 @@ async fn dispatch(kernel: &mut Kernel, cmd: KernelCommand) {
             let _ = resp_tx.send(result);
         }
 
+        KernelCommand::CancelRun {
+            run_id,
+            reason,
+            resp_tx,
+        } => {
+            let result = kernel.cancel_run(&run_id, reason);
+            let _ = resp_tx.send(result);
+        }
+
+        KernelCommand::CheckCancelled { run_id, resp_tx } => {
+            let _ = resp_tx.send(kernel.check_cancelled(&run_id));
+        }
+
         KernelCommand::TerminateRun {
             run_id,
             resp_tx,
@@ -143,11 +200,81 @@ async fn dispatch(kernel: &mut Kernel, cmd: KernelCommand) {
             let _ = resp_tx.send(result);
         }
 
+        KernelCommand::RecordArtifacts { run_id, artifacts, resp_tx } => {
+            let _ = resp_tx.send(kernel.record_artifacts(&run_id, artifacts));
+        }
+
+        KernelCommand::RecordUserLog { run_id, notes, resp_tx } => {
+            let _ = resp_tx.send(kernel.record_user_log(&run_id, notes));
+        }
+
+        KernelCommand::ReportAgentProgress { run_id, agent, partial_output, resp_tx } => {
+            let _ = resp_tx.send(kernel.report_agent_progress(&run_id, &agent, partial_output));
+        }
+
+        KernelCommand::NextRunnable { resp_tx } => {
+            let _ = resp_tx.send(kernel.next_runnable());
+        }
+
+        KernelCommand::ClaimNextInstruction {
+            worker,
+            capabilities,
+            lease_seconds,
+            resp_tx,
+        } => {
+            let result = kernel.claim_next_instruction(&worker, &capabilities, lease_seconds);
+            // Same auto-terminate as GetNextInstruction.
+            if let Ok(Some(claim)) = &result {
+                if matches!(claim.instruction, Instruction::Terminate { .. }) {
+                    let _ = kernel.terminate_run(&claim.run_id);
+                }
+            }
+            let _ = resp_tx.send(result);
+        }
+
+        KernelCommand::RenewLease {
+            run_id,
+            worker_id,
+            lease_seconds,
+            resp_tx,
+        } => {
+            let _ = resp_tx.send(kernel.renew_lease(&run_id, &worker_id, lease_seconds));
+        }
+
+        KernelCommand::SetSchedulingPaused { paused, resp_tx } => {
+            if paused {
+                kernel.pause_scheduling();
+            } else {
+                kernel.resume_scheduling();
+            }
+            let _ = resp_tx.send(());
+        }
+
+        KernelCommand::SetDefaultQuota { quota, resp_tx } => {
+            let _ = resp_tx.send(kernel.set_default_quota(quota));
+        }
+
         KernelCommand::GetSystemStatus { resp_tx } => {
             let status = kernel.get_system_status();
             let _ = resp_tx.send(status);
         }
 
+        KernelCommand::GetCommandProfile { resp_tx } => {
+            let profile = kernel
+                .command_profiler
+                .as_ref()
+                .map(|profiler| profiler.profile())
+                .ok_or_else(|| crate::types::Error::state_transition("command profiling is not enabled"));
+            let _ = resp_tx.send(profile);
+        }
+
+        KernelCommand::GetRemainingBudget { run_id, resp_tx } => {
+            let budget = kernel
+                .get_remaining_budget(&run_id)
+                .ok_or_else(|| crate::types::Error::not_found(format!("Run {} not found", run_id)));
+            let _ = resp_tx.send(budget);
+        }
+
         KernelCommand::ResolveInterrupt {
             run_id,
             interrupt_id,
@@ -158,6 +285,19 @@ async fn dispatch(kernel: &mut Kernel, cmd: KernelCommand) {
             let _ = resp_tx.send(result);
         }
 
+        KernelCommand::IssueResolutionToken {
+            run_id,
+            interrupt_id,
+            approved,
+            resp_tx,
+        } => {
+            let _ = resp_tx.send(kernel.issue_resolution_token(&run_id, &interrupt_id, approved));
+        }
+
+        KernelCommand::ResolveInterruptWithToken { token, resp_tx } => {
+            let _ = resp_tx.send(kernel.resolve_interrupt_with_token(&token));
+        }
+
         KernelCommand::SetRunInterrupt {
             run_id,
             interrupt,
@@ -167,6 +307,34 @@ async fn dispatch(kernel: &mut Kernel, cmd: KernelCommand) {
             let _ = resp_tx.send(result);
         }
 
+        KernelCommand::GetUserUsageHistory { user_id, granularity, since, resp_tx } => {
+            let _ = resp_tx.send(kernel.get_user_usage_history(&user_id, granularity, since));
+        }
+
+        KernelCommand::PurgeUser { user_id, resp_tx } => {
+            let _ = resp_tx.send(kernel.purge_user(&user_id));
+        }
+
+        KernelCommand::ListStaleSessions { idle_ttl_seconds, resp_tx } => {
+            let _ = resp_tx.send(kernel.list_stale_sessions(idle_ttl_seconds));
+        }
+
+        KernelCommand::SearchRuns { query, resp_tx } => {
+            let _ = resp_tx.send(kernel.search_runs(&query));
+        }
+
+        KernelCommand::CleanupStaleSessions { idle_ttl_seconds, resp_tx } => {
+            let _ = resp_tx.send(kernel.cleanup_stale_sessions(idle_ttl_seconds));
+        }
+
+        KernelCommand::ReapZombies { force, resp_tx } => {
+            let _ = resp_tx.send(kernel.reap_zombies(force));
+        }
+
+        KernelCommand::ApplyRetention { resp_tx } => {
+            let _ = resp_tx.send(kernel.apply_retention());
+        }
+
         KernelCommand::GetToolHealth { tool_name, resp_tx } => {
             let report = match tool_name {
                 Some(ref name) => serde_json::to_value(kernel.tools.health.check_tool_health(name)),
@@ -0,0 +1,201 @@
+//! Request classification. A consumer-supplied [`RunClassifier`] labels each
+//! run at session init; labels land on `RunRecord::labels` and in
+//! `run.audit.metadata["labels"]` (visible to routing functions), and select
+//! a per-label quota profile when the kernel creates the run record, and
+//! map to a run template for `Kernel::instantiate_for_input`.
+//! `SystemStatus::active_runs_by_label` reports live load per label.
+
+use std::collections::HashMap;
+use std::sync::Arc;
+
+use crate::run::Run;
+
+use super::ResourceQuota;
+
+/// Assigns labels (e.g. `"code-question"`, `"ops-task"`) to a run.
+pub trait RunClassifier: Send + Sync {
+    fn classify(&self, run: &Run) -> Vec<String>;
+}
+
+impl<F> RunClassifier for F
+where
+    F: Fn(&Run) -> Vec<String> + Send + Sync,
+{
+    fn classify(&self, run: &Run) -> Vec<String> {
+        self(run)
+    }
+}
+
+/// Kernel-side classification state: the classifier, label → quota profiles
+/// and label → run template names.
+#[derive(Default)]
+pub struct Classification {
+    classifier: Option<Arc<dyn RunClassifier>>,
+    quota_profiles: HashMap<String, ResourceQuota>,
+    label_templates: HashMap<String, String>,
+}
+
+impl Classification {
+    pub fn set_classifier(&mut self, classifier: Arc<dyn RunClassifier>) {
+        self.classifier = Some(classifier);
+    }
+
+    pub fn set_quota_profile(&mut self, label: impl Into<String>, quota: ResourceQuota) {
+        self.quota_profiles.insert(label.into(), quota);
+    }
+
+    pub fn set_label_template(&mut self, label: impl Into<String>, template: impl Into<String>) {
+        self.label_templates.insert(label.into(), template.into());
+    }
+
+    /// Labels for `run`; empty when no classifier is registered.
+    pub fn classify(&self, run: &Run) -> Vec<String> {
+        self.classifier
+            .as_ref()
+            .map(|c| c.classify(run))
+            .unwrap_or_default()
+    }
+
+    /// Quota profile of the first label that has one.
+    pub fn quota_for(&self, labels: &[String]) -> Option<ResourceQuota> {
+        labels
+            .iter()
+            .find_map(|label| self.quota_profiles.get(label))
+            .cloned()
+    }
+
+    /// Run template of the first label that has one.
+    pub fn template_for(&self, labels: &[String]) -> Option<&str> {
+        labels
+            .iter()
+            .find_map(|label| self.label_templates.get(label))
+            .map(String::as_str)
+    }
+}
+
+impl std::fmt::Debug for Classification {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        f.debug_struct("Classification")
+            .field("has_classifier", &self.classifier.is_some())
+            .field("quota_profiles", &self.quota_profiles.keys().collect::<Vec<_>>())
+            .field("label_templates", &self.label_templates)
+            .finish()
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::kernel::actor::spawn;
+    use crate::kernel::test_helpers::create_test_workflow;
+    use crate::kernel::Kernel;
+    use crate::types::RunId;
+    use tokio_util::sync::CancellationToken;
+
+    fn keyword_classifier(run: &Run) -> Vec<String> {
+        if run.raw_input.contains("stack trace") {
+            vec!["code-question".to_string()]
+        } else {
+            vec![]
+        }
+    }
+
+    #[test]
+    fn quota_for_uses_first_matching_label() {
+        let mut c = Classification::default();
+        let tight = ResourceQuota { max_llm_calls: 3, ..ResourceQuota::default() };
+        c.set_quota_profile("ops-task", tight.clone());
+        let labels = vec!["unknown".to_string(), "ops-task".to_string()];
+        assert_eq!(c.quota_for(&labels), Some(tight));
+        assert_eq!(c.quota_for(&[]), None);
+    }
+
+    #[test]
+    fn template_for_uses_first_matching_label() {
+        let mut c = Classification::default();
+        c.set_label_template("code-question", "code-pipeline");
+        let labels = vec!["unknown".to_string(), "code-question".to_string()];
+        assert_eq!(c.template_for(&labels), Some("code-pipeline"));
+        assert_eq!(c.template_for(&[]), None);
+    }
+
+    #[test]
+    fn instantiate_for_input_picks_template_by_label() {
+        let mut kernel = Kernel::new();
+        kernel.set_classifier(Arc::new(keyword_classifier));
+        kernel.set_label_template("code-question", "code-pipeline");
+        kernel
+            .add_run_template(crate::kernel::templates::RunTemplate::new(
+                "code-pipeline",
+                create_test_workflow(),
+            ))
+            .unwrap();
+
+        let (_, run) = kernel
+            .instantiate_for_input("u1", "s1", "here is my stack trace", None)
+            .unwrap();
+        assert_eq!(run.audit.metadata["template"], serde_json::json!("code-pipeline"));
+
+        let err = kernel.instantiate_for_input("u1", "s1", "hello", None).unwrap_err();
+        assert_eq!(err.to_error_code(), "NOT_FOUND");
+    }
+
+    #[test]
+    fn classify_without_classifier_is_empty() {
+        let c = Classification::default();
+        assert!(c.classify(&Run::anonymous()).is_empty());
+    }
+
+    #[tokio::test]
+    async fn session_init_labels_run_and_applies_quota_profile() {
+        let mut kernel = Kernel::new();
+        kernel.set_classifier(Arc::new(keyword_classifier));
+        let profile = ResourceQuota { max_llm_calls: 7, ..ResourceQuota::default() };
+        kernel.set_quota_profile("code-question", profile);
+
+        let cancel = CancellationToken::new();
+        let handle = spawn(kernel, cancel.clone());
+        let run_id = RunId::must("classified");
+        let run = Run::new("u1", "s1", "here is my stack trace", None);
+        let state = handle
+            .initialize_session(run_id.clone(), create_test_workflow(), run, false)
+            .await
+            .unwrap();
+        assert_eq!(state.run["audit"]["metadata"]["labels"], serde_json::json!(["code-question"]));
+        cancel.cancel();
+    }
+
+    #[test]
+    fn labels_and_quota_land_on_run_record() {
+        let mut kernel = Kernel::new();
+        kernel.set_classifier(Arc::new(keyword_classifier));
+        kernel.set_quota_profile("code-question", ResourceQuota { max_llm_calls: 7, ..ResourceQuota::default() });
+
+        let mut run = Run::new("u1", "s1", "stack trace attached", None);
+        let run_id = RunId::must("r1");
+        kernel.admit_run(&run_id, &mut run).unwrap();
+
+        let record = kernel.lifecycle.get(&run_id).unwrap();
+        assert_eq!(record.labels, vec!["code-question".to_string()]);
+        assert_eq!(record.quota.max_llm_calls, 7);
+        assert_eq!(run.audit.metadata["labels"], serde_json::json!(["code-question"]));
+    }
+
+    #[test]
+    fn system_status_counts_active_runs_per_label() {
+        let mut kernel = Kernel::new();
+        kernel.set_classifier(Arc::new(keyword_classifier));
+        for (id, input) in [("r1", "stack trace"), ("r2", "another stack trace"), ("r3", "hello")] {
+            let mut run = Run::new("u1", "s1", input, None);
+            kernel.admit_run(&RunId::must(id), &mut run).unwrap();
+        }
+        let status = kernel.get_system_status();
+        assert_eq!(status.active_runs_by_label.get("code-question"), Some(&2));
+        assert_eq!(status.active_runs_by_label.len(), 1);
+
+        kernel.lifecycle.run(&RunId::must("r1")).unwrap();
+        kernel.terminate_run(&RunId::must("r1")).unwrap();
+        let status = kernel.get_system_status();
+        assert_eq!(status.active_runs_by_label.get("code-question"), Some(&1));
+    }
+}
@@ -1,21 +1,31 @@
 //! Kernel orchestration methods — initialize, get_next_instruction, process_agent_result.
 
-use std::collections::HashMap;
+use std::collections::{BTreeMap, HashMap};
 
 use tracing::instrument;
 
 use crate::agent::policy::ContextOverflow;
-use crate::run::{Run, FlowInterrupt};
+use crate::run::{output_size, Artifact, Run, FlowInterrupt, TerminalReason};
 use crate::types::{Error, RunId, RequestId, Result, SessionId, UserId};
+use crate::workflow::{DeliverySemantics, OutputOverflow, StateField};
 
 use super::merge_state_field;
 use super::orchestrator;
 use super::{Kernel, RunStatus, RemainingBudget, ResourceQuota, SystemStatus};
 
+/// `run.outputs` entry whose `final_response` is the user-facing answer.
+/// Abnormal terminations render their `terminal_responses` fallback there.
+pub const INTEGRATION_AGENT: &str = "integration";
+
 impl Kernel {
     /// Stores `run` in `runs` and hands it to the orchestrator
     /// to seed the session. The orchestrator updates the run's workflow
     /// bounds in place; ownership stays with `runs`.
+    ///
+    /// Input that can never fit a token limit is rejected first (see
+    /// `precheck::InputTooLarge`). When the input or the session is
+    /// rejected (validation, `max_concurrent_sessions`), a run record
+    /// admitted for it is dropped so no session-less run is left behind.
     #[instrument(skip(self, workflow, run), fields(run_id = %run_id))]
     pub fn initialize_orchestration(
         &mut self,
@@ -24,11 +34,33 @@ impl Kernel {
         mut run: Run,
         force: bool,
     ) -> Result<orchestrator::RunSnapshot> {
-        let state = self.orchestrator
-            .initialize_session(run_id.clone(), workflow, &mut run, force)?;
-        self.runs.insert(run_id, run);
+        let quota = self.lifecycle.get(&run_id)
+            .map_or_else(|| self.lifecycle.get_default_quota(), |record| &record.quota);
+        let result = super::precheck::check_input_fits(self.token_estimator.as_ref(), &run, &workflow, quota)
+            .and_then(|()| self.orchestrator.initialize_session(run_id.clone(), workflow, &mut run, force));
+        match result {
+            Ok(state) => {
+                self.runs.insert(run_id, run);
+                Ok(state)
+            }
+            Err(err) => {
+                if self.orchestrator.get_session(&run_id).is_none() {
+                    self.lifecycle.remove(&run_id);
+                    self.templates.forget(&run_id);
+                }
+                tracing::info!(run_id = %run_id, error = %err, "session_rejected");
+                Err(err)
+            }
+        }
+    }
 
-        Ok(state)
+    /// Move `run_id`'s live session onto `workflow` (a new version of its
+    /// definition), remapping stages through `workflow.stage_renames`.
+    #[instrument(skip(self, workflow), fields(run_id = %run_id))]
+    pub fn migrate_session(&mut self, run_id: &RunId, workflow: orchestrator::Workflow) -> Result<orchestrator::RunSnapshot> {
+        let run = self.runs.get_mut(run_id)
+            .ok_or_else(|| Error::not_found(format!("Run not found: {}", run_id)))?;
+        self.orchestrator.migrate_session(run_id, workflow, run)
     }
 
     /// Fetches and enriches the next instruction for `run_id`. The
@@ -43,7 +75,16 @@ impl Kernel {
     ) -> Result<orchestrator::Instruction> {
         let run = self.runs.get_mut(run_id)
             .ok_or_else(|| Error::not_found(format!("Run not found for run_id: {}", run_id)))?;
+        let waiting_on = run.interrupts.interrupt.as_ref().map(|i| i.id.clone());
         let mut instruction = self.orchestrator.get_next_instruction(run_id, run)?;
+        // The orchestrator drops an interrupt whose `expires_at` has passed.
+        if let Some(interrupt_id) = waiting_on.filter(|_| !run.interrupts.is_pending()) {
+            if self.interrupts.expire(interrupt_id.as_str()) {
+                if let Some(record) = self.lifecycle.get_mut(run_id) {
+                    record.pending_interrupt = None;
+                }
+            }
+        }
 
         match &mut instruction {
             orchestrator::Instruction::RunAgent { agent: _, context }=> {
@@ -56,7 +97,12 @@ impl Kernel {
 
                 if let Some(env) = self.runs.get_mut(run_id) {
                     context.interrupt_response = env.audit.metadata.remove("_interrupt_response");
+                    context.deadline_remaining_ms = env.remaining_time().map(|left| left.num_milliseconds());
+                    context.locale = env.locale.clone();
+                    context.timezone = env.timezone.clone();
+                    context.params = env.params.clone();
                 }
+                context.cancellation = self.orchestrator.get_cancellation(run_id);
 
                 let stage_name = self.runs.get(run_id)
                     .map(|e| e.current_stage.clone())
@@ -65,26 +111,73 @@ impl Kernel {
                 if let Some(sc) = self.orchestrator.get_stage_config(run_id, stage_name.as_str()) {
                     context.timeout_seconds = sc.timeout_seconds;
                     context.retry_policy = sc.retry_policy.clone();
+                    context.delivery = sc.delivery;
+                    if sc.delivery == DeliverySemantics::AtLeastOnce {
+                        // History grows only on report, so the key holds
+                        // until this visit's result lands.
+                        context.idempotency_key = self.runs.get(run_id).map(|run| {
+                            format!("{}:{}:{}", run_id, stage_name, run.audit.processing_history.len())
+                        });
+                    }
+                    context.required_capabilities = sc.agent_config.required_capabilities.clone();
+                    context.allowed_regions = self
+                        .orchestrator
+                        .get_session(run_id)
+                        .zip(self.runs.get(run_id))
+                        .and_then(|(session, run)| allowed_regions(&session.workflow, run));
+                    context.security_context = sc.security_context.clone();
+                    context.tool_policy = Some(sc.agent_config.tool_policy.clone()).filter(|p| !p.is_unrestricted());
+                    context.cache_prompts = sc.agent_config.cache_prompts;
+                    if let (Some(template), Some(run)) = (&sc.agent_config.prompt_template, self.runs.get(run_id)) {
+                        context.rendered_prompt = Some(render_stage_prompt(template, run));
+                    }
                 }
 
                 context.response_format = self.orchestrator.get_stage_response_format(run_id, stage_name.as_str());
             }
-            orchestrator::Instruction::Terminate { context, .. } => {
+            orchestrator::Instruction::Terminate { reason, message, context } => {
+                self.templates.record_outcome(run_id, *reason);
+                if let (Some(template), Some(run)) = (
+                    self.orchestrator.get_terminal_response(run_id, *reason),
+                    self.runs.get_mut(run_id),
+                ) {
+                    render_terminal_response(run, &template, *reason, message.as_deref());
+                }
                 if let Some(run) = self.runs.get(run_id) {
                     let total_duration_ms = (chrono::Utc::now() - run.audit.created_at)
                         .num_milliseconds();
                     context.agent_context = Some(serde_json::json!({
                         "outputs": &run.outputs,
+                        "artifacts": &run.artifacts,
+                        "user_log": &run.user_log,
                         "aggregate_metrics": {
                             "total_duration_ms": total_duration_ms,
                             "total_llm_calls": run.metrics.llm_calls,
+                            "total_llm_cache_hits": run.metrics.llm_cache_hits,
                             "total_tool_calls": run.metrics.tool_calls,
+                            "total_tool_calls_refused": run.metrics.tool_calls_refused,
                             "total_tokens_in": run.metrics.tokens_in,
                             "total_tokens_out": run.metrics.tokens_out,
                             "stages_executed": &run.stage_order,
                         }
                     }));
                 }
+                if let Some(workflow) = self.orchestrator.get_session(run_id).map(|session| &session.workflow) {
+                    if !workflow.cleanup_agents.is_empty() {
+                        context.cleanup_agents = workflow.cleanup_agents.clone();
+                        context.timeout_seconds = Some(workflow.cleanup_timeout());
+                        // Cleanup agents find what to release in the run's
+                        // input, state and metadata.
+                        if let (Some(run), Some(payload)) = (
+                            self.runs.get(run_id),
+                            context.agent_context.as_mut().and_then(|c| c.as_object_mut()),
+                        ) {
+                            payload.insert("raw_input".to_string(), serde_json::json!(&run.raw_input));
+                            payload.insert("state".to_string(), serde_json::json!(&run.state));
+                            payload.insert("metadata".to_string(), serde_json::json!(&run.audit.metadata));
+                        }
+                    }
+                }
             }
             _ => {}
         }
@@ -105,13 +198,15 @@ impl Kernel {
     /// Merges an agent's output into the run, reports it to the
     /// orchestrator, and applies the metrics delta to the run record. The
     /// caller pulls the next instruction separately — the split is what
-    /// keeps fork/parallel paths deadlock-free.
+    /// keeps fork/parallel paths deadlock-free. `worker` (non-empty id) is
+    /// stamped on the stage's `ProcessingRecord`.
     #[allow(clippy::too_many_arguments)]
-    #[instrument(skip(self, output, metrics), fields(run_id = %run_id))]
+    #[instrument(skip(self, worker, output, metrics), fields(run_id = %run_id, worker = %worker.id))]
     pub fn process_agent_result(
         &mut self,
         run_id: &RunId,
         agent_name: &str,
+        worker: &crate::run::WorkerIdentity,
         output: serde_json::Value,
         metadata_updates: Option<HashMap<String, serde_json::Value>>,
         metrics: orchestrator::AgentExecutionMetrics,
@@ -119,21 +214,62 @@ impl Kernel {
         error_message: &str,
         break_loop: bool,
     ) -> Result<()> {
+        if worker.id.is_empty() {
+            return Err(Error::validation("agent result requires a worker identity with a non-empty id"));
+        }
         // Pull scalars now so we can move `metrics` into the orchestrator below.
         let llm_calls = metrics.llm_calls;
         let tool_calls = metrics.tool_calls;
         let tokens_in = metrics.tokens_in.unwrap_or(0);
         let tokens_out = metrics.tokens_out.unwrap_or(0);
         let duration_ms = metrics.duration_ms;
+        let tool_bytes: u64 = metrics.tool_results.iter().map(|call| call.bytes_in + call.bytes_out).sum();
+        let max_tool_bytes = self.lifecycle.get(run_id).map_or(0, |record| record.quota.max_tool_bytes);
+
+        self.leases.release_if_held_by(run_id, &worker.id, chrono::Utc::now())?;
 
         for tool_result in &metrics.tool_results {
             self.tools.health.record_execution(&tool_result.name, tool_result.success, tool_result.latency_ms, tool_result.error_type.clone());
         }
+        if !metrics.refused_tools.is_empty() {
+            tracing::info!(run_id = %run_id, agent = %agent_name, tools = ?metrics.refused_tools, "tool_calls_refused");
+        }
 
         // Lift state_schema + output_key out before the &mut run borrow.
         let state_schema = self.orchestrator.get_state_schema(run_id).cloned().unwrap_or_default();
         let output_key = self.orchestrator.get_stage_output_key(run_id, agent_name)
             .unwrap_or_else(|| agent_name.to_string());
+        let write_once = self.orchestrator.is_output_write_once(run_id, agent_name);
+        let output_budget = self.orchestrator.get_output_budget(run_id);
+        let track_provenance = self.orchestrator.get_session(run_id).is_some_and(|session| session.workflow.track_provenance);
+        // Calls the worker made outside the stage's tool policy.
+        let forbidden_tools: Vec<String> = self
+            .runs
+            .get(run_id)
+            .and_then(|run| self.orchestrator.get_stage_config(run_id, run.current_stage.as_str()))
+            .map(|stage| {
+                metrics
+                    .tool_results
+                    .iter()
+                    .filter(|call| !stage.agent_config.tool_policy.permits(&call.name))
+                    .map(|call| call.name.clone())
+                    .collect()
+            })
+            .unwrap_or_default();
+        let (reported_stage, missing_capabilities) = self
+            .runs
+            .get(run_id)
+            .and_then(|run| self.orchestrator.get_stage_config(run_id, run.current_stage.as_str()))
+            .map(|stage| {
+                (stage.name.clone(), worker.missing_capabilities(&stage.agent_config.required_capabilities))
+            })
+            .unwrap_or_default();
+        let outside_residency = self
+            .orchestrator
+            .sessions
+            .get(run_id)
+            .zip(self.runs.get(run_id))
+            .is_some_and(|(session, run)| !region_permitted(&session.workflow, run, &worker.region));
 
         {
             let run = self.runs.get_mut(run_id)
@@ -158,10 +294,40 @@ impl Kernel {
                     }),
                 );
             }
-            run.outputs.insert(agent_name.into(), agent_output);
+            // The final result supersedes whatever the agent reported mid-stage.
+            run.partial_outputs.remove(agent_name);
+            // Write-once: keep the first output and skip the state merge so a
+            // misconfigured re-run cannot clobber or double-append.
+            let rejected = write_once && run.outputs.contains_key(agent_name);
+            if rejected {
+                tracing::warn!(run_id = %run_id, agent = %agent_name, "output_overwrite_rejected");
+                let violation = serde_json::json!({
+                    "agent_name": agent_name,
+                    "iteration": run.iteration,
+                    "at": chrono::Utc::now().to_rfc3339(),
+                });
+                match run.audit.metadata.get_mut("output_write_violations") {
+                    Some(serde_json::Value::Array(list)) => list.push(violation),
+                    _ => {
+                        run.audit.metadata.insert(
+                            "output_write_violations".to_string(),
+                            serde_json::Value::Array(vec![violation]),
+                        );
+                    }
+                }
+            } else {
+                let output: crate::run::OutputMap = agent_output.into();
+                let added = output_size(&output);
+                let replaced = run.outputs.insert(agent_name.into(), output).map_or(0, |old| output_size(&old));
+                run.metrics.output_bytes = (run.metrics.output_bytes + added).saturating_sub(replaced);
+                if track_provenance {
+                    run.record_provenance(agent_name);
+                }
+            }
 
+            let merge_fields: &[StateField] = if rejected { &[] } else { &state_schema };
             let mut state_matched = false;
-            for field in &state_schema {
+            for field in merge_fields {
                 if field.key == output_key {
                     let output_value = serde_json::Value::Object(
                         run.outputs.get(agent_name)
@@ -173,7 +339,7 @@ impl Kernel {
                     break;
                 }
             }
-            if !state_schema.is_empty() && !state_matched {
+            if !merge_fields.is_empty() && !state_matched {
                 tracing::debug!(output_key = %output_key, "output_key has no matching state_schema entry");
             }
 
@@ -186,6 +352,52 @@ impl Kernel {
             let effective_failed = !success;
             self.orchestrator.report_agent_result(run_id, agent_name, metrics, run, effective_failed, break_loop)?;
 
+            if let Some((max_bytes, overflow)) = output_budget {
+                enforce_output_budget(run, agent_name, max_bytes, overflow);
+            }
+            let run_tool_bytes = run.metrics.tool_bytes_in + run.metrics.tool_bytes_out;
+            if max_tool_bytes > 0 && run_tool_bytes > max_tool_bytes && !run.is_terminated() {
+                tracing::warn!(run_id = %run_id, tool_bytes = run_tool_bytes, limit = max_tool_bytes, "tool_bytes_exceeded");
+                run.terminate_with(
+                    TerminalReason::ToolBytesExceeded,
+                    Some(format!("Tool calls used {} bytes, limit {}", run_tool_bytes, max_tool_bytes)),
+                );
+            }
+            // Overrides whatever routing just decided, including `Completed`.
+            if !forbidden_tools.is_empty() {
+                tracing::warn!(run_id = %run_id, agent = %agent_name, tools = ?forbidden_tools, "tool_policy_violation");
+                run.terminate_with(
+                    TerminalReason::PolicyViolation,
+                    Some(format!(
+                        "Agent '{}' called tools outside its policy: {}",
+                        agent_name,
+                        forbidden_tools.join(", ")
+                    )),
+                );
+            }
+            if !missing_capabilities.is_empty() {
+                tracing::warn!(run_id = %run_id, worker = %worker.id, missing = ?missing_capabilities, "capability_violation");
+                run.terminate_with(
+                    TerminalReason::PolicyViolation,
+                    Some(format!(
+                        "Worker '{}' lacks capabilities required by stage '{}': {}",
+                        worker.id,
+                        reported_stage,
+                        missing_capabilities.join(", ")
+                    )),
+                );
+            }
+            if outside_residency {
+                tracing::warn!(run_id = %run_id, worker = %worker.id, region = %worker.region, "residency_violation");
+                run.terminate_with(
+                    TerminalReason::PolicyViolation,
+                    Some(format!(
+                        "Worker '{}' in region '{}' is outside the run's allowed regions",
+                        worker.id, worker.region
+                    )),
+                );
+            }
+
             let now = chrono::Utc::now();
             run.audit.processing_history.push(crate::run::ProcessingRecord {
                 agent: agent_name.to_string(),
@@ -204,16 +416,64 @@ impl Kernel {
                 tool_calls,
                 tokens_in,
                 tokens_out,
+                worker: Some(worker.clone()),
             });
         }
 
         if let Some(uid) = self.lifecycle.get(run_id).map(|p| p.user_id.as_str().to_string()) {
             self.record_user_usage(&uid, llm_calls, tool_calls, tokens_in, tokens_out);
+            self.resources.record_tool_bytes(&uid, tool_bytes);
         }
+        self.apply_demotion(run_id);
 
         Ok(())
     }
 
+    /// Demote a live run whose usage has crossed a `DemotionPolicy`
+    /// threshold: stamp `RunRecord::demoted_at`, note it in
+    /// `metadata["demoted"]`, and log `run_demoted`.
+    fn apply_demotion(&mut self, run_id: &RunId) {
+        let Some(policy) = &self.demotion else {
+            return;
+        };
+        let Some(record) = self.lifecycle.get(run_id) else {
+            return;
+        };
+        if record.demoted_at.is_some()
+            || record.state.is_terminal()
+            || record.labels.iter().any(|label| policy.exempt_labels.contains(label))
+        {
+            return;
+        }
+        let Some(budget) = self.get_remaining_budget(run_id) else {
+            return;
+        };
+        let crossed = policy.thresholds.iter().find_map(|(dimension, threshold)| {
+            let used = *budget.percent_used.get(dimension.as_str())?;
+            (used >= *threshold).then(|| (dimension.clone(), used, *threshold))
+        });
+        let Some((dimension, used, threshold)) = crossed else {
+            return;
+        };
+
+        let now = chrono::Utc::now();
+        tracing::info!(run_id = %run_id, dimension = %dimension, percent_used = used, threshold, "run_demoted");
+        if let Some(record) = self.lifecycle.get_mut(run_id) {
+            record.demoted_at = Some(now);
+        }
+        if let Some(run) = self.runs.get_mut(run_id) {
+            run.audit.metadata.insert(
+                "demoted".to_string(),
+                serde_json::json!({
+                    "dimension": dimension,
+                    "percent_used": used,
+                    "threshold": threshold,
+                    "at": now.to_rfc3339(),
+                }),
+            );
+        }
+    }
+
     /// Get orchestration session state.
     pub fn get_orchestration_state(
         &self,
@@ -224,6 +484,29 @@ impl Kernel {
         self.orchestrator.get_session_state(run_id, run)
     }
 
+    /// Where a run's time went: per-stage execution, waits between stages,
+    /// and per-agent totals (`Run::explain_latency`).
+    pub fn explain_latency(&self, run_id: &RunId) -> Result<crate::run::LatencyReport> {
+        let run = self.runs.get(run_id)
+            .ok_or_else(|| Error::not_found(format!("Run not found: {}", run_id)))?;
+        Ok(run.explain_latency())
+    }
+
+    /// Who wrote output `key`, per agent, oldest first (`Run::get_provenance`).
+    /// Empty unless the workflow sets `track_provenance`.
+    pub fn get_provenance(&self, run_id: &RunId, key: &str) -> Result<Vec<crate::run::Provenance>> {
+        let run = self.runs.get(run_id)
+            .ok_or_else(|| Error::not_found(format!("Run not found: {}", run_id)))?;
+        Ok(run.get_provenance(key))
+    }
+
+    /// Markdown or HTML transcript of a run (`Run::export_transcript`).
+    pub fn export_session(&self, run_id: &RunId, format: crate::run::TranscriptFormat) -> Result<String> {
+        let run = self.runs.get(run_id)
+            .ok_or_else(|| Error::not_found(format!("Run not found: {}", run_id)))?;
+        Ok(run.export_transcript(format))
+    }
+
     /// Reads the run and stage config, packs them into the JSON shape
     /// the worker expects, and returns it alongside the per-stage context-window
     /// bounds.
@@ -232,10 +515,17 @@ impl Kernel {
         run_id: &RunId,
     ) -> Option<(serde_json::Value, Option<i64>, Option<ContextOverflow>)> {
         let run = self.runs.get(run_id)?;
+        let stage_config = self.orchestrator.get_stage_config(run_id, run.current_stage.as_str());
+        let view = stage_config.map(|sc| sc.agent_config.context_view.clone()).unwrap_or_default();
+        let outputs: HashMap<&crate::types::AgentName, &crate::run::OutputMap> = run
+            .outputs
+            .iter()
+            .filter(|(agent_name, _)| view.includes_output(agent_name.as_str()))
+            .collect();
 
         let mut template_vars = serde_json::Map::new();
-        for (agent_name, output) in &run.outputs {
-            for (key, value) in output {
+        for (agent_name, output) in &outputs {
+            for (key, value) in output.iter() {
                 template_vars.insert(format!("{}_{}", agent_name, key), value.clone());
             }
         }
@@ -243,14 +533,13 @@ impl Kernel {
             template_vars.insert(key.clone(), value.clone());
         }
 
-        let agent_context = serde_json::json!({
+        let mut agent_context = serde_json::json!({
             "envelope_id": run.identity.envelope_id.as_str(),
             "request_id": run.identity.request_id.as_str(),
             "user_id": run.identity.user_id.as_str(),
             "session_id": run.identity.session_id.as_str(),
             "raw_input": &run.raw_input,
-            "outputs": &run.outputs,
-            "state": &run.state,
+            "outputs": &outputs,
             "metadata": &run.audit.metadata,
             "template_vars": serde_json::Value::Object(template_vars),
             "llm_call_count": run.metrics.llm_calls,
@@ -259,10 +548,11 @@ impl Kernel {
             "tokens_out": run.metrics.tokens_out,
             "circuit_broken_tools": self.tools.health.get_circuit_broken_tools(),
         });
+        if !view.omit_state {
+            agent_context["state"] = serde_json::json!(&run.state);
+        }
 
-        let stage_name = run.current_stage.clone();
-        let (max_context_tokens, context_overflow) = self.orchestrator
-            .get_stage_config(run_id, stage_name.as_str())
+        let (max_context_tokens, context_overflow) = stage_config
             .map(|sc| {
                 let overflow = if sc.max_context_tokens.is_some() {
                     Some(sc.context_overflow)
@@ -276,6 +566,34 @@ impl Kernel {
         Some((agent_context, max_context_tokens, context_overflow))
     }
 
+    /// Normalize and classify `run`, tag its metadata with the labels, and
+    /// make sure a run record exists. A newly created record takes the quota
+    /// profile of its labels (falling back to the default quota). A
+    /// normalizer error is returned before any record is created.
+    pub fn admit_run(&mut self, run_id: &RunId, run: &mut Run) -> Result<()> {
+        self.apply_retention();
+        self.normalization.apply(run)?;
+        let labels = self.classification.classify(run);
+        if !labels.is_empty() {
+            run.audit.metadata.insert("labels".to_string(), serde_json::json!(labels));
+        }
+        if self.lifecycle.get(run_id).is_none() {
+            let quota = self.classification.quota_for(&labels);
+            let _ = self.create_run(
+                run_id.clone(),
+                run.identity.request_id.clone(),
+                run.identity.user_id.clone(),
+                run.identity.session_id.clone(),
+                quota,
+            );
+        }
+        if let Some(record) = self.lifecycle.get_mut(run_id) {
+            record.labels = labels;
+        }
+        self.templates.track(run_id, run);
+        Ok(())
+    }
+
     /// Create a new run record.
     #[instrument(skip(self), fields(run_id = %run_id))]
     pub fn create_run(
@@ -286,7 +604,9 @@ impl Kernel {
         session_id: SessionId,
         quota: Option<ResourceQuota>,
     ) -> Result<super::RunRecord> {
-        self.lifecycle.create(run_id, request_id, user_id, session_id, quota)
+        let record = self.lifecycle.create(run_id, request_id, user_id, session_id, quota)?;
+        self.resources.record_run(record.user_id.as_str());
+        Ok(record)
     }
 
     /// Check whether the run has exceeded its quota. Reads live counters from
@@ -299,7 +619,8 @@ impl Kernel {
             .get(run_id)
             .ok_or_else(|| Error::not_found(format!("Run {} not found", run_id)))?;
         let usage = self.usage_from_run(run_id, record);
-        if let Some(violation) = usage.exceeds_quota(&record.quota) {
+        let quota = record.quota.effective_at(usage.elapsed_seconds);
+        if let Some(violation) = usage.exceeds_quota(&quota) {
             return Err(Error::quota_exceeded(format!(
                 "Run {} quota exceeded: {}",
                 run_id, violation
@@ -320,6 +641,7 @@ impl Kernel {
             iterations: run.map_or(0, |r| r.iteration),
             tokens_in: run.map_or(0, |r| r.metrics.tokens_in),
             tokens_out: run.map_or(0, |r| r.metrics.tokens_out),
+            tool_bytes: run.map_or(0, |r| r.metrics.tool_bytes_in + r.metrics.tool_bytes_out),
             elapsed_seconds: record.elapsed_seconds(),
         }
     }
@@ -338,18 +660,60 @@ impl Kernel {
             .record_usage(user_id, llm_calls, tool_calls, tokens_in, tokens_out);
     }
 
+    /// Per-user usage rollups from `since` (inclusive, UTC) to today. History
+    /// is in-memory and covers at most `USAGE_HISTORY_DAYS`; consumers that
+    /// need longer retention persist the returned buckets themselves.
+    pub fn get_user_usage_history(
+        &self,
+        user_id: &str,
+        granularity: super::UsageGranularity,
+        since: chrono::NaiveDate,
+    ) -> Vec<super::UsageBucket> {
+        self.resources.usage_history(user_id, granularity, since)
+    }
+
     /// Set a tool-confirmation interrupt on a run. The workflow loop
     /// suspends the stage; the consumer resolves via `resolve_run_interrupt`.
+    /// Attachments are checked first (see `FlowInterrupt::validate_attachments`).
+    ///
+    /// An interrupt over the workflow's `max_interrupts_per_kind` is not
+    /// raised: the run terminates with `InterruptLimitExceeded` instead and
+    /// the workflow loop sees the termination next.
     pub fn set_run_interrupt(&mut self, run_id: &RunId, interrupt: FlowInterrupt) -> Result<()> {
+        interrupt.validate_attachments()?;
+        // The stage is suspended, not in flight: free it for re-claim on resume.
+        self.leases.release(run_id);
         // Register in interrupt manager (so resolve_interrupt can find it by ID)
         let interrupt_id = interrupt.id.clone();
-        if let Some(run) = self.runs.get(run_id) {
+        if let Some(run) = self.runs.get_mut(run_id) {
+            let workflow = self.orchestrator.get_session(run_id).map(|session| &session.workflow);
+            let pipeline = workflow.map(|w| w.name.as_str()).unwrap_or_default();
+            if let Some(max) = workflow.and_then(|w| w.max_interrupts_per_kind) {
+                let window = workflow.and_then(|w| w.interrupt_window_seconds);
+                // A window reaching past chrono's range covers the whole run.
+                let since = window
+                    .and_then(|secs| chrono::Duration::try_seconds(i64::try_from(secs).ok()?))
+                    .and_then(|window| chrono::Utc::now().checked_sub_signed(window));
+                let kind = interrupt.kind();
+                let raised = run.interrupts_raised(kind, since);
+                if raised >= max as usize {
+                    tracing::warn!(run_id = %run_id, kind = kind.as_str(), raised, limit = max, "interrupt_limit_exceeded");
+                    self.interrupts.record_limited(kind, pipeline);
+                    let scope = window.map_or(String::new(), |secs| format!(" in the last {}s", secs));
+                    run.terminate_with(
+                        TerminalReason::InterruptLimitExceeded,
+                        Some(format!("Run raised {} {} interrupts{}, limit {}", raised + 1, kind.as_str(), scope, max)),
+                    );
+                    return Ok(());
+                }
+            }
             self.interrupts.register_flow_interrupt(
                 interrupt.clone(),
                 &run.identity.request_id,
                 &run.identity.user_id,
                 &run.identity.session_id,
                 &run.identity.envelope_id,
+                pipeline,
             );
         }
 
@@ -366,6 +730,10 @@ impl Kernel {
     }
 
     /// Resolve a pending interrupt and stash the response for the next agent dispatch.
+    ///
+    /// First response wins. Interrupt IDs are never reused, so the ID is the
+    /// version: a second resolver racing on the same interrupt gets
+    /// `StateTransition` (FAILED_PRECONDITION) and the first response stands.
     pub fn resolve_run_interrupt(
         &mut self,
         run_id: &RunId,
@@ -374,12 +742,18 @@ impl Kernel {
     ) -> Result<()> {
         let response_json = serde_json::to_value(&response).unwrap_or_default();
         if !self.interrupts.resolve(interrupt_id, response) {
+            if self.interrupts.get_response(interrupt_id).is_some() {
+                return Err(Error::state_transition(format!(
+                    "Interrupt {} was already resolved",
+                    interrupt_id
+                )));
+            }
             return Err(Error::not_found(format!("Interrupt {} not found", interrupt_id)));
         }
 
         if let Some(run) = self.runs.get_mut(run_id) {
-            run.audit.metadata.insert("_interrupt_response".to_string(), response_json);
-            run.clear_interrupt();
+            run.audit.metadata.insert("_interrupt_response".to_string(), response_json.clone());
+            run.close_interrupt(crate::run::InterruptOutcome::Resolved, Some(response_json));
         }
         if let Some(record) = self.lifecycle.get_mut(run_id) {
             record.pending_interrupt = None;
@@ -387,26 +761,461 @@ impl Kernel {
         Ok(())
     }
 
-    /// Terminate a run and remove it from the kernel.
+    /// Mint a single-use token that resolves `run_id`'s pending interrupt
+    /// with `approved` when redeemed through `resolve_interrupt_with_token`.
+    /// For approval links sent by email or chat.
+    pub fn issue_resolution_token(&mut self, run_id: &RunId, interrupt_id: &str, approved: bool) -> Result<String> {
+        let pending_here = self
+            .runs
+            .get(run_id)
+            .and_then(|run| run.interrupts.interrupt.as_ref())
+            .is_some_and(|interrupt| interrupt.id.as_str() == interrupt_id);
+        if !pending_here {
+            return Err(Error::not_found(format!(
+                "Run {} has no pending interrupt {}",
+                run_id, interrupt_id
+            )));
+        }
+        self.interrupts
+            .issue_token(run_id, interrupt_id, approved)
+            .ok_or_else(|| Error::not_found(format!("Interrupt {} not found", interrupt_id)))
+    }
+
+    /// Redeem a token from `issue_resolution_token`, resolving its interrupt
+    /// with the bound decision. Returns the run it resumed. Unknown, used,
+    /// and revoked tokens are all `NotFound`.
+    pub fn resolve_interrupt_with_token(&mut self, token: &str) -> Result<RunId> {
+        let bound = self
+            .interrupts
+            .redeem_token(token)
+            .ok_or_else(|| Error::not_found("Resolution token is invalid or already used"))?;
+        let response = crate::run::InterruptResponse {
+            text: None,
+            approved: Some(bound.approved),
+            decision: None,
+            data: None,
+            received_at: chrono::Utc::now(),
+        };
+        self.resolve_run_interrupt(&bound.run_id, bound.interrupt_id.as_str(), response)?;
+        Ok(bound.run_id)
+    }
+
+    /// Mark a run terminated with `reason`. The session stays in place so the
+    /// worker's next `get_next_instruction` observes a `Terminate` carrying
+    /// `reason` (and the run is cleaned up there). Idempotent: an already
+    /// terminated run keeps its original reason.
+    pub fn cancel_run(&mut self, run_id: &RunId, reason: crate::run::TerminalReason) -> Result<()> {
+        let run = self.runs.get_mut(run_id)
+            .ok_or_else(|| Error::not_found(format!("Run not found: {}", run_id)))?;
+        if !run.is_terminated() {
+            tracing::info!(run_id = %run_id, reason = ?reason, "run_cancelled");
+            run.terminate_with(reason, Some("Run cancelled".to_string()));
+        }
+        if let Some(token) = self.orchestrator.get_cancellation(run_id) {
+            token.cancel();
+        }
+        Ok(())
+    }
+
+    /// The run's terminal reason if it has been terminated (cancelled,
+    /// bounds exceeded), `None` while it may continue. Long-running workers
+    /// poll this between LLM or tool calls.
+    pub fn check_cancelled(&self, run_id: &RunId) -> Result<Option<crate::run::TerminalReason>> {
+        self.runs
+            .get(run_id)
+            .map(Run::terminal_reason)
+            .ok_or_else(|| Error::not_found(format!("Run not found: {}", run_id)))
+    }
+
+    /// Record artifacts against the run's current stage. Call before
+    /// `process_agent_result`, which advances the stage.
+    pub fn record_artifacts(&mut self, run_id: &RunId, artifacts: Vec<Artifact>) -> Result<()> {
+        let run = self.runs.get_mut(run_id)
+            .ok_or_else(|| Error::not_found(format!("Run not found for run_id: {}", run_id)))?;
+        let stage = run.current_stage.clone();
+        for artifact in artifacts {
+            run.record_artifact(stage.clone(), artifact);
+        }
+        Ok(())
+    }
+
+    /// Append agent progress notes to the run's user log under the current
+    /// stage. Call before `process_agent_result`, which advances the stage.
+    pub fn record_user_log(&mut self, run_id: &RunId, notes: Vec<crate::run::UserLogNote>) -> Result<()> {
+        let run = self.runs.get_mut(run_id)
+            .ok_or_else(|| Error::not_found(format!("Run not found for run_id: {}", run_id)))?;
+        let stage = run.current_stage.clone();
+        run.append_user_log(stage, notes);
+        Ok(())
+    }
+
+    /// Record an intermediate output from `agent` without closing its
+    /// stage. Only the agent of the run's current stage may report, and only
+    /// while the run is live; its final `process_agent_result` clears them.
+    pub fn report_agent_progress(&mut self, run_id: &RunId, agent: &str, partial_output: serde_json::Value) -> Result<()> {
+        let run = self.runs.get(run_id)
+            .ok_or_else(|| Error::not_found(format!("Run not found for run_id: {}", run_id)))?;
+        if run.is_terminated() {
+            return Err(Error::state_transition(format!("Run {} is terminated", run_id)));
+        }
+        let stage = run.current_stage.clone();
+        let stage_agent = self.orchestrator.get_stage_config(run_id, stage.as_str()).map(|s| s.agent.as_str());
+        if stage_agent != Some(agent) {
+            return Err(Error::validation(format!(
+                "Agent '{}' is not running stage '{}' of run {}",
+                agent, stage, run_id
+            )));
+        }
+        if let Some(run) = self.runs.get_mut(run_id) {
+            run.append_partial_output(agent.into(), stage, partial_output);
+        }
+        Ok(())
+    }
+
+    /// Pick the next `Ready` run (per-user round-robin) and mark it
+    /// `Running`. `None` when nothing is ready or scheduling is paused.
+    pub fn next_runnable(&mut self) -> Option<RunId> {
+        self.lifecycle.next_runnable()
+    }
+
+    /// Worker-pull mode: hand `worker` the next instruction from any
+    /// session whose current agent is in `capabilities` (empty = any).
+    /// Sessions that are leased, waiting on an interrupt, restricted to
+    /// regions other than the worker's, or (for `RunAgent`) outside the
+    /// worker's agents or needing capabilities it lacks are skipped; the least recently served session
+    /// wins, after every session not demoted by the `DemotionPolicy` (demoted
+    /// ones age back in after its `max_wait_seconds`). A `RunAgent` is leased for `lease_seconds`; a `Terminate` is
+    /// handed to whichever permitted worker asks first. `None` when nothing
+    /// is claimable or scheduling is paused.
+    pub fn claim_next_instruction(
+        &mut self,
+        worker: &crate::run::WorkerIdentity,
+        capabilities: &[String],
+        lease_seconds: u64,
+    ) -> Result<Option<super::Claim>> {
+        if self.lifecycle.is_scheduling_paused() {
+            return Ok(None);
+        }
+        let now = chrono::Utc::now();
+        let lease_until = super::leases::lease_expiry(now, lease_seconds)?;
+        // Demoted sessions idle since before this have aged back in.
+        let aged_before = self
+            .demotion
+            .as_ref()
+            .and_then(|policy| policy.max_wait_seconds)
+            .and_then(|secs| chrono::Duration::try_seconds(i64::try_from(secs).ok()?))
+            .and_then(|wait| now.checked_sub_signed(wait));
+        let run_id = self
+            .orchestrator
+            .sessions
+            .values()
+            .filter(|session| !self.leases.is_held(&session.run_id, now))
+            .filter(|session| {
+                self.runs
+                    .get(&session.run_id)
+                    .is_some_and(|run| region_permitted(&session.workflow, run, &worker.region))
+            })
+            .filter(|session| match self.runs.get(&session.run_id) {
+                Some(run) if run.is_terminated() => true,
+                Some(run) if run.interrupts.is_pending() => false,
+                Some(run) => match session.workflow.stages.iter().find(|stage| stage.name == run.current_stage) {
+                    Some(stage) => {
+                        (capabilities.is_empty() || capabilities.iter().any(|c| c == stage.agent.as_str()))
+                            && worker.missing_capabilities(&stage.agent_config.required_capabilities).is_empty()
+                    }
+                    None => capabilities.is_empty(),
+                },
+                None => false,
+            })
+            // Demoted runs go last until they have waited out the policy's
+            // `max_wait_seconds`; otherwise the least recently served.
+            .min_by_key(|session| {
+                let demoted = self.lifecycle.get(&session.run_id).is_some_and(|record| record.demoted_at.is_some());
+                let aged = aged_before.is_some_and(|cutoff| session.last_activity_at <= cutoff);
+                (demoted && !aged, session.last_activity_at)
+            })
+            .map(|session| session.run_id.clone());
+        let Some(run_id) = run_id else {
+            return Ok(None);
+        };
+        if self.leases.is_lapsed(&run_id, now) {
+            self.leases.release(&run_id);
+            self.fail_ambiguous_delivery(&run_id);
+        }
+
+        let instruction = self.get_next_instruction(&run_id)?;
+        let lease_expires_at = match &instruction {
+            orchestrator::Instruction::RunAgent { .. } => {
+                Some(self.leases.grant(run_id.clone(), &worker.id, lease_until))
+            }
+            _ => None,
+        };
+        tracing::debug!(run_id = %run_id, worker_id = %worker.id, region = %worker.region, "instruction_claimed");
+        Ok(Some(super::Claim {
+            run_id,
+            instruction,
+            lease_expires_at,
+        }))
+    }
+
+    /// A lapsed lease on an `at_most_once` stage ends the run instead of
+    /// handing the stage to another worker.
+    fn fail_ambiguous_delivery(&mut self, run_id: &RunId) {
+        let Some(run) = self.runs.get_mut(run_id) else {
+            return;
+        };
+        let at_most_once = self
+            .orchestrator
+            .get_stage_config(run_id, run.current_stage.as_str())
+            .is_some_and(|stage| stage.delivery == DeliverySemantics::AtMostOnce);
+        if at_most_once && !run.is_terminated() {
+            tracing::warn!(run_id = %run_id, stage = %run.current_stage, "delivery_ambiguous");
+            run.terminate_with(
+                TerminalReason::DeliveryAmbiguous,
+                Some(format!(
+                    "Lease on at_most_once stage '{}' lapsed without a result; it may or may not have run",
+                    run.current_stage
+                )),
+            );
+        }
+    }
+
+    /// Look up a run template registered with `add_run_template`.
+    pub fn get_run_template(&self, name: &str) -> Result<super::templates::RunTemplate> {
+        self.templates.get(name)
+    }
+
+    /// Workflow and run for a new session on template `name`, on the
+    /// canary workflow when the template has one and the session falls in
+    /// its share.
+    pub fn instantiate_run_template(
+        &self,
+        name: &str,
+        user_id: &str,
+        session_id: &str,
+        raw_input: &str,
+        metadata: Option<serde_json::Value>,
+    ) -> Result<(crate::workflow::Workflow, Run)> {
+        self.templates.instantiate(name, user_id, session_id, raw_input, metadata)
+    }
+
+    /// Workflow and run for a new session on the template mapped (via
+    /// `set_label_template`) to the first label the classifier gives the
+    /// normalized input. `NOT_FOUND` when no label maps to a template.
+    pub fn instantiate_for_input(
+        &self,
+        user_id: &str,
+        session_id: &str,
+        raw_input: &str,
+        metadata: Option<serde_json::Value>,
+    ) -> Result<(crate::workflow::Workflow, Run)> {
+        let mut probe = Run::new(user_id, session_id, raw_input, metadata.clone());
+        self.normalization.apply(&mut probe)?;
+        let labels = self.classification.classify(&probe);
+        let name = self
+            .classification
+            .template_for(&labels)
+            .ok_or_else(|| Error::not_found(format!("No run template for labels {:?}", labels)))?;
+        self.templates.instantiate(name, user_id, session_id, raw_input, metadata)
+    }
+
+    pub fn replay_run(
+        &self,
+        archived: &Run,
+        overrides: super::templates::ReplayOverrides,
+    ) -> Result<(crate::workflow::Workflow, Run)> {
+        self.templates.replay(archived, overrides)
+    }
+
+    pub fn start_canary(&mut self, name: &str, workflow: crate::workflow::Workflow, percent: u8) -> Result<()> {
+        self.templates.start_canary(name, workflow, percent)?;
+        tracing::info!(template = %name, percent, "canary_started");
+        Ok(())
+    }
+
+    pub fn abort_canary(&mut self, name: &str) -> Result<()> {
+        self.templates.abort_canary(name)?;
+        tracing::warn!(template = %name, "canary_aborted");
+        Ok(())
+    }
+
+    pub fn canary_status(&self, name: &str) -> Result<super::templates::CanaryStatus> {
+        self.templates.canary_status(name)
+    }
+
+    /// Extend `worker_id`'s lease on `run_id` (heartbeat for long stages).
+    /// `FAILED_PRECONDITION` once the lease has lapsed or been re-claimed —
+    /// the worker should drop the stage rather than report it.
+    pub fn renew_lease(&mut self, run_id: &RunId, worker_id: &str, lease_seconds: u64) -> Result<chrono::DateTime<chrono::Utc>> {
+        self.leases.renew(run_id, worker_id, lease_seconds)
+    }
+
+    /// Stop handing out work from `next_runnable` for a maintenance window.
+    /// Run creation, session init, agent results and interrupt resolution
+    /// keep working; runs already handed out continue.
+    pub fn pause_scheduling(&mut self) {
+        if !self.lifecycle.is_scheduling_paused() {
+            tracing::info!(ready = self.lifecycle.count_by_state(RunStatus::Ready), "scheduling_paused");
+        }
+        self.lifecycle.set_scheduling_paused(true);
+    }
+
+    pub fn resume_scheduling(&mut self) {
+        if self.lifecycle.is_scheduling_paused() {
+            tracing::info!(ready = self.lifecycle.count_by_state(RunStatus::Ready), "scheduling_resumed");
+        }
+        self.lifecycle.set_scheduling_paused(false);
+    }
+
+    /// Change the quota applied to new runs without a restart (incident
+    /// response). Runs already created keep their quota; label profiles
+    /// still take precedence. The change is logged with both values.
+    pub fn set_default_quota(&mut self, quota: ResourceQuota) -> Result<()> {
+        quota.validate()?;
+        tracing::info!(
+            previous = ?self.lifecycle.get_default_quota(),
+            quota = ?quota,
+            "default_quota_changed"
+        );
+        self.lifecycle.set_default_quota(quota);
+        Ok(())
+    }
+
+    /// Terminate a run. Its session and lease go at once; the run and its
+    /// record stay as a zombie for the retention window, if one is set.
+    /// Zombies past their window are reaped on the way.
     pub fn terminate_run(&mut self, run_id: &RunId) -> Result<()> {
         self.lifecycle.terminate(run_id)?;
         if let Some(run) = self.runs.get_mut(run_id) {
+            if let Some(interrupt) = &run.interrupts.interrupt {
+                self.interrupts.abandon(interrupt.id.as_str());
+            }
             run.complete("Run terminated");
+            if let Some(reason) = run.terminal_reason() {
+                self.templates.record_outcome(run_id, reason);
+            }
+        }
+        self.templates.forget(run_id);
+        if self.lifecycle.get(run_id).is_none() {
+            self.runs.remove(run_id);
         }
-        self.runs.remove(run_id);
         self.orchestrator.cleanup_session(run_id);
+        self.leases.release(run_id);
+        self.apply_retention();
         Ok(())
     }
 
-    /// Cleanup stale orchestration sessions and their runs.
+    /// Remove terminated runs whose retention window has passed, or every
+    /// terminated run when `force`. There is no background sweep: this runs
+    /// on `terminate_run` and `admit_run`, and consumers may call it.
+    /// Returns the number removed.
+    pub fn reap_zombies(&mut self, force: bool) -> usize {
+        let expired = self.lifecycle.expired_zombies(chrono::Utc::now(), force);
+        for run_id in &expired {
+            self.lifecycle.remove(run_id);
+            self.runs.remove(run_id);
+            tracing::debug!(run_id = %run_id, force, "zombie_reaped");
+        }
+        expired.len()
+    }
+
+    /// Retention scrubber: reap zombies past `set_zombie_retention` and drop
+    /// resolved interrupts past `set_interrupt_retention`. Runs with
+    /// `terminate_run` and `admit_run`, like `reap_zombies`, and on demand.
+    /// A pass that removes anything is logged as `retention_applied`.
+    pub fn apply_retention(&mut self) -> super::RetentionReport {
+        let report = super::RetentionReport {
+            zombies_reaped: self.reap_zombies(false),
+            interrupts_removed: self.interrupts.drop_expired_resolved(chrono::Utc::now()),
+        };
+        if report != super::RetentionReport::default() {
+            tracing::info!(
+                zombies = report.zombies_reaped,
+                interrupts = report.interrupts_removed,
+                "retention_applied"
+            );
+        }
+        report
+    }
+
+    /// Erase everything the kernel holds for `user_id`: runs (live or not),
+    /// their sessions and run records, interrupts, and usage history. For
+    /// deletion requests; the report is logged as `user_data_purged`.
+    pub fn purge_user(&mut self, user_id: &UserId) -> super::PurgeReport {
+        let mut runs_removed: Vec<RunId> = self
+            .runs
+            .iter()
+            .filter(|(_, run)| &run.identity.user_id == user_id)
+            .map(|(id, _)| id.clone())
+            .chain(
+                self.lifecycle
+                    .records
+                    .values()
+                    .filter(|r| &r.user_id == user_id)
+                    .map(|r| r.run_id.clone()),
+            )
+            .collect();
+        runs_removed.sort_by(|a, b| a.as_str().cmp(b.as_str()));
+        runs_removed.dedup();
+
+        for run_id in &runs_removed {
+            self.lifecycle.remove(run_id);
+            self.templates.forget(run_id);
+            self.runs.remove(run_id);
+            self.orchestrator.cleanup_session(run_id);
+            self.leases.release(run_id);
+        }
+
+        let report = super::PurgeReport {
+            user_id: user_id.as_str().to_string(),
+            runs_removed,
+            interrupts_removed: self.interrupts.purge_user(user_id),
+            usage_removed: self.resources.purge_user(user_id.as_str()),
+        };
+        tracing::info!(
+            user_id = %report.user_id,
+            runs = report.runs_removed.len(),
+            interrupts = report.interrupts_removed,
+            usage = report.usage_removed,
+            "user_data_purged"
+        );
+        report
+    }
+
+    /// Run IDs whose orchestration session has been idle for longer than
+    /// `idle_ttl_seconds`. Inspection only; nothing is removed.
+    pub fn list_stale_sessions(&self, idle_ttl_seconds: i64) -> Result<Vec<RunId>> {
+        self.orchestrator.list_stale_sessions(idle_ttl_seconds)
+    }
+
+    /// Runs still held by the kernel that match `query`, most recently
+    /// received first. A scan over live runs; meant for operator lookups,
+    /// not the dispatch path.
+    pub fn search_runs(&self, query: &super::RunQuery) -> Vec<RunId> {
+        let mut matches: Vec<(&RunId, &Run)> = self.runs.iter().filter(|(_, run)| query.matches(run)).collect();
+        matches.sort_by(|(a_id, a), (b_id, b)| b.received_at.cmp(&a.received_at).then_with(|| a_id.cmp(b_id)));
+        matches
+            .into_iter()
+            .take(query.limit.unwrap_or(usize::MAX))
+            .map(|(run_id, _)| run_id.clone())
+            .collect()
+    }
+
+    /// Cleanup stale orchestration sessions, their runs, and their run
+    /// records. The kernel runs no background ticker; consumers call this
+    /// (via `KernelHandle::cleanup_stale_sessions`) on their own schedule.
     /// Returns the count of sessions removed.
-    pub fn cleanup_stale_sessions(&mut self, max_age_seconds: i64) -> usize {
-        let removed = self.orchestrator.cleanup_stale_sessions(max_age_seconds);
+    pub fn cleanup_stale_sessions(&mut self, max_age_seconds: i64) -> Result<usize> {
+        let removed = self.orchestrator.cleanup_stale_sessions(max_age_seconds)?;
         let count = removed.len();
         for run_id in &removed {
+            self.lifecycle.remove(run_id);
+            self.templates.forget(run_id);
             self.runs.remove(run_id);
+            self.leases.release(run_id);
+            tracing::info!(run_id = %run_id, idle_ttl_seconds = max_age_seconds, "stale_session_removed");
         }
-        count
+        Ok(count)
     }
 
     /// Cleanup stale user usage entries.
@@ -429,25 +1238,994 @@ impl Kernel {
             runs_total: total,
             runs_by_state: by_state,
             active_orchestration_sessions: orchestrator_sessions,
+            active_runs_by_label: self.lifecycle.count_active_by_label(),
+            scheduling_paused: self.lifecycle.is_scheduling_paused(),
+            tool_bytes_total: self.resources.tool_bytes_total(),
+            demoted_runs: self
+                .lifecycle
+                .records
+                .values()
+                .filter(|record| record.demoted_at.is_some() && !record.state.is_terminal())
+                .count(),
+            interrupts: self.interrupts.stats(chrono::Utc::now()),
         }
     }
 
-    /// Get remaining resource budget for a run.
+    /// Get remaining resource budget for a run, with the share of each
+    /// bound already used.
     pub fn get_remaining_budget(&self, run_id: &RunId) -> Option<RemainingBudget> {
         let record = self.lifecycle.get(run_id)?;
         let usage = self.usage_from_run(run_id, record);
+        let quota = record.quota.effective_at(usage.elapsed_seconds);
+        // (total, left) in seconds, measured from run creation.
+        let deadline = self.runs.get(run_id).and_then(|run| {
+            let total = run.limits.deadline? - run.audit.created_at;
+            let left = run.remaining_time()?;
+            Some((total.num_milliseconds() as f64 / 1000.0, left.num_milliseconds().max(0) as f64 / 1000.0))
+        });
+
+        let mut percent_used = BTreeMap::new();
+        let mut bounded = |key: &'static str, used: f64, limit: f64| {
+            if limit > 0.0 {
+                percent_used.insert(key, used / limit * 100.0);
+            }
+        };
+        bounded("llm_calls", f64::from(usage.llm_calls), f64::from(quota.max_llm_calls));
+        bounded("tool_calls", f64::from(usage.tool_calls), f64::from(quota.max_tool_calls));
+        bounded("iterations", f64::from(usage.iterations), f64::from(quota.max_iterations));
+        bounded("agent_hops", f64::from(usage.agent_hops), f64::from(quota.max_agent_hops));
+        bounded("tokens_in", usage.tokens_in as f64, f64::from(quota.max_input_tokens));
+        bounded("tokens_out", usage.tokens_out as f64, f64::from(quota.max_output_tokens));
+        bounded("time", usage.elapsed_seconds, f64::from(quota.timeout_seconds));
+        bounded("tool_bytes", usage.tool_bytes as f64, quota.max_tool_bytes as f64);
+        if let Some((total, left)) = deadline {
+            bounded("deadline", total - left, total);
+        }
+        let most_constrained = percent_used
+            .iter()
+            .max_by(|a, b| a.1.total_cmp(b.1))
+            .map(|(key, _)| *key);
+
         Some(RemainingBudget {
-            llm_calls_remaining: (record.quota.max_llm_calls - usage.llm_calls).max(0),
-            iterations_remaining: (record.quota.max_iterations - usage.iterations).max(0),
-            agent_hops_remaining: (record.quota.max_agent_hops - usage.agent_hops).max(0),
-            tokens_in_remaining: (record.quota.max_input_tokens as i64 - usage.tokens_in).max(0),
-            tokens_out_remaining: (record.quota.max_output_tokens as i64 - usage.tokens_out).max(0),
-            time_remaining_seconds: if record.quota.timeout_seconds > 0 {
-                (record.quota.timeout_seconds as f64 - usage.elapsed_seconds).max(0.0)
+            llm_calls_remaining: (quota.max_llm_calls - usage.llm_calls).max(0),
+            tool_calls_remaining: (quota.max_tool_calls - usage.tool_calls).max(0),
+            iterations_remaining: (quota.max_iterations - usage.iterations).max(0),
+            agent_hops_remaining: (quota.max_agent_hops - usage.agent_hops).max(0),
+            tokens_in_remaining: (quota.max_input_tokens as i64 - usage.tokens_in).max(0),
+            tokens_out_remaining: (quota.max_output_tokens as i64 - usage.tokens_out).max(0),
+            time_remaining_seconds: if quota.timeout_seconds > 0 {
+                (quota.timeout_seconds as f64 - usage.elapsed_seconds).max(0.0)
             } else {
                 f64::MAX
             },
+            deadline_remaining_seconds: deadline.map(|(_, left)| left),
+            tool_bytes_remaining: if quota.max_tool_bytes > 0 {
+                quota.max_tool_bytes.saturating_sub(usage.tool_bytes)
+            } else {
+                u64::MAX
+            },
+            percent_used,
+            most_constrained,
         })
     }
 }
 
+/// Data residency: `region` must be in the workflow's `allowed_regions` and
+/// in the run's `metadata["allowed_regions"]` (per-tenant, set by the
+/// consumer or a normalizer), each when present.
+fn region_permitted(workflow: &crate::workflow::Workflow, run: &Run, region: &str) -> bool {
+    allowed_regions(workflow, run).map_or(true, |allowed| allowed.iter().any(|r| r == region))
+}
+
+/// Regions `run` may execute in: the workflow's `allowed_regions` narrowed
+/// by the run's `metadata.allowed_regions`. `None` when neither restricts.
+fn allowed_regions(workflow: &crate::workflow::Workflow, run: &Run) -> Option<Vec<String>> {
+    let tenant: Option<Vec<String>> = run
+        .audit
+        .metadata
+        .get("allowed_regions")
+        .and_then(|v| v.as_array())
+        .map(|allowed| allowed.iter().filter_map(|r| r.as_str().map(str::to_string)).collect());
+    match tenant {
+        None if workflow.allowed_regions.is_empty() => None,
+        None => Some(workflow.allowed_regions.clone()),
+        Some(tenant) if workflow.allowed_regions.is_empty() => Some(tenant),
+        Some(tenant) => Some(workflow.allowed_regions.iter().filter(|r| tenant.contains(r)).cloned().collect()),
+    }
+}
+
+/// Bring `run.outputs` back under `max_bytes`. `CompactOldest` stubs out
+/// other agents' outputs in processing order (the latest agent's output is
+/// kept) and lists them under `metadata["compacted_outputs"]`; whatever is
+/// still over budget terminates with `OutputBudgetExceeded`.
+fn enforce_output_budget(run: &mut Run, latest_agent: &str, max_bytes: u64, overflow: OutputOverflow) {
+    if run.metrics.output_bytes <= max_bytes || run.is_terminated() {
+        return;
+    }
+    if overflow == OutputOverflow::CompactOldest {
+        let mut oldest_first: Vec<String> = Vec::new();
+        for record in &run.audit.processing_history {
+            if record.agent != latest_agent && !oldest_first.contains(&record.agent) {
+                oldest_first.push(record.agent.clone());
+            }
+        }
+        for agent in oldest_first {
+            if run.metrics.output_bytes <= max_bytes {
+                break;
+            }
+            let Some(output) = run.outputs.get_mut(agent.as_str()) else {
+                continue;
+            };
+            if output.contains_key("_compacted") {
+                continue;
+            }
+            let original = output_size(output);
+            let stub: crate::run::OutputMap = HashMap::from([
+                ("_compacted".into(), serde_json::Value::Bool(true)),
+                ("original_bytes".into(), serde_json::json!(original)),
+            ])
+            .into();
+            let compacted = output_size(&stub);
+            *output = stub;
+            run.metrics.output_bytes = run.metrics.output_bytes.saturating_sub(original) + compacted;
+            tracing::info!(agent = %agent, original_bytes = original, "output_compacted");
+            match run.audit.metadata.get_mut("compacted_outputs") {
+                Some(serde_json::Value::Array(list)) => list.push(serde_json::json!(agent)),
+                _ => {
+                    run.audit.metadata.insert("compacted_outputs".to_string(), serde_json::json!([agent]));
+                }
+            }
+        }
+        if run.metrics.output_bytes <= max_bytes {
+            return;
+        }
+    }
+    tracing::warn!(output_bytes = run.metrics.output_bytes, max_bytes, "output_budget_exceeded");
+    run.terminate_with(
+        TerminalReason::OutputBudgetExceeded,
+        Some(format!("Outputs are {} bytes, budget is {}", run.metrics.output_bytes, max_bytes)),
+    );
+}
+
+/// Render a stage `prompt_template` against the run. Same variable naming as
+/// the `template_vars` block in the agent context; string values are inserted
+/// verbatim, everything else as compact JSON.
+fn render_stage_prompt(template: &str, run: &Run) -> String {
+    crate::agent::prompts::render_template(template, &template_vars(run))
+}
+
+/// Render a workflow's fallback response into
+/// `outputs["integration"]["final_response"]`. An existing response wins,
+/// so repeated `Terminate` fetches don't rewrite it.
+fn render_terminal_response(run: &mut Run, template: &str, reason: TerminalReason, message: Option<&str>) {
+    let mut vars = template_vars(run);
+    let reason_name = serde_json::to_value(reason)
+        .ok()
+        .and_then(|v| v.as_str().map(str::to_string))
+        .unwrap_or_default();
+    vars.insert("terminal_reason".to_string(), reason_name);
+    vars.insert("terminal_message".to_string(), message.unwrap_or_default().to_string());
+    let rendered = crate::agent::prompts::render_template(template, &vars);
+    let outputs = run.outputs.entry(INTEGRATION_AGENT.into()).or_default();
+    std::sync::Arc::make_mut(outputs)
+        .entry("final_response".into())
+        .or_insert(serde_json::Value::String(rendered));
+    run.recount_output_bytes();
+}
+
+/// Template variables: `raw_input`, `{agent}_{key}` per output, state keys
+/// and metadata keys.
+fn template_vars(run: &Run) -> HashMap<String, String> {
+    fn as_text(v: &serde_json::Value) -> String {
+        match v {
+            serde_json::Value::String(s) => s.clone(),
+            other => other.to_string(),
+        }
+    }
+
+    let mut vars = HashMap::new();
+    vars.insert("raw_input".to_string(), run.raw_input.clone());
+    for (agent_name, output) in &run.outputs {
+        for (key, value) in output.iter() {
+            vars.insert(format!("{}_{}", agent_name, key), as_text(value));
+        }
+    }
+    for (key, value) in &run.state {
+        vars.insert(key.clone(), as_text(value));
+    }
+    for (key, value) in &run.audit.metadata {
+        vars.insert(key.clone(), as_text(value));
+    }
+    vars
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::kernel::test_helpers::{create_test_run, stage};
+    use crate::run::WorkerIdentity;
+    use crate::workflow::Workflow;
+
+    #[test]
+    fn run_agent_carries_rendered_prompt_template() {
+        let mut kernel = Kernel::new();
+        let mut first = stage("classify", "classify", None, Some("answer"));
+        first.agent_config.prompt_template = Some("Classify: {raw_input}".to_string());
+        let mut second = stage("answer", "answer", None, None);
+        second.agent_config.prompt_template =
+            Some("Intent {classify_intent} for {raw_input} ({locale})".to_string());
+        let workflow = Workflow::test_default("templated", vec![first, second]);
+
+        let mut run = create_test_run();
+        run.raw_input = "reset my password".to_string();
+        run.audit.metadata.insert("locale".to_string(), serde_json::json!("en-GB"));
+        let run_id = RunId::must("tmpl");
+        let _state = kernel.initialize_orchestration(run_id.clone(), workflow, run, false).unwrap();
+
+        let instr = kernel.get_next_instruction(&run_id).unwrap();
+        match instr {
+            orchestrator::Instruction::RunAgent { context, .. } => {
+                assert_eq!(context.rendered_prompt.as_deref(), Some("Classify: reset my password"));
+            }
+            other => panic!("expected RunAgent, got {:?}", other),
+        }
+
+        kernel
+            .process_agent_result(
+                &run_id,
+                "classify",
+                &WorkerIdentity::in_process(),
+                serde_json::json!({"intent": "account"}),
+                None,
+                Default::default(),
+                true,
+                "",
+                false,
+            )
+            .unwrap();
+        let instr = kernel.get_next_instruction(&run_id).unwrap();
+        match instr {
+            orchestrator::Instruction::RunAgent { context, .. } => assert_eq!(
+                context.rendered_prompt.as_deref(),
+                Some("Intent account for reset my password (en-GB)")
+            ),
+            other => panic!("expected RunAgent, got {:?}", other),
+        }
+    }
+
+    #[test]
+    fn abnormal_terminate_renders_terminal_response() {
+        let mut kernel = Kernel::new();
+        let mut looping = stage("draft", "draft", None, Some("draft"));
+        looping.max_visits = Some(5);
+        let mut workflow = Workflow::test_default("fallback", vec![looping]);
+        workflow.max_llm_calls = 1;
+        workflow.terminal_responses = vec![crate::workflow::TerminalResponse {
+            reason: TerminalReason::MaxLlmCallsExceeded,
+            template: "Sorry, I ran out of budget ({terminal_reason}). Draft: {draft_text}".to_string(),
+        }];
+        let run_id = RunId::must("fallback");
+        let _state = kernel
+            .initialize_orchestration(run_id.clone(), workflow, create_test_run(), false)
+            .unwrap();
+
+        let _ = kernel.get_next_instruction(&run_id).unwrap();
+        kernel
+            .process_agent_result(
+                &run_id,
+                "draft",
+                &WorkerIdentity::in_process(),
+                serde_json::json!({"text": "half an answer"}),
+                None,
+                orchestrator::AgentExecutionMetrics { llm_calls: 1, ..Default::default() },
+                true,
+                "",
+                false,
+            )
+            .unwrap();
+
+        let instr = kernel.get_next_instruction(&run_id).unwrap();
+        match instr {
+            orchestrator::Instruction::Terminate { reason, context, .. } => {
+                assert_eq!(reason, TerminalReason::MaxLlmCallsExceeded);
+                let outputs = &context.agent_context.unwrap()["outputs"];
+                assert_eq!(
+                    outputs[INTEGRATION_AGENT]["final_response"],
+                    "Sorry, I ran out of budget (MAX_LLM_CALLS_EXCEEDED). Draft: half an answer"
+                );
+            }
+            other => panic!("expected Terminate, got {:?}", other),
+        }
+    }
+
+    #[test]
+    fn run_deadline_surfaces_and_terminates() {
+        let mut kernel = Kernel::new();
+        let mut workflow = crate::kernel::test_helpers::create_test_workflow();
+        workflow.max_duration_seconds = Some(300);
+        let run_id = RunId::must("deadline");
+        let _state = kernel
+            .initialize_orchestration(run_id.clone(), workflow.clone(), create_test_run(), false)
+            .unwrap();
+        match kernel.get_next_instruction(&run_id).unwrap() {
+            orchestrator::Instruction::RunAgent { context, .. } => {
+                let left = context.deadline_remaining_ms.unwrap();
+                assert!(left > 0 && left <= 300_000, "{}", left);
+            }
+            other => panic!("expected RunAgent, got {:?}", other),
+        }
+
+        // A caller-set deadline earlier than the workflow's is kept.
+        let mut run = create_test_run();
+        run.limits.deadline = Some(chrono::Utc::now() - chrono::Duration::seconds(1));
+        let run_id = RunId::must("expired");
+        let _state = kernel
+            .initialize_orchestration(run_id.clone(), workflow, run, false)
+            .unwrap();
+        match kernel.get_next_instruction(&run_id).unwrap() {
+            orchestrator::Instruction::Terminate { reason, .. } => {
+                assert_eq!(reason, TerminalReason::TimeoutExceeded);
+            }
+            other => panic!("expected Terminate, got {:?}", other),
+        }
+    }
+
+    #[test]
+    fn cancel_run_surfaces_reason_on_next_instruction() {
+        let mut kernel = Kernel::new();
+        let run_id = RunId::must("cancel");
+        let _state = kernel
+            .initialize_orchestration(run_id.clone(), crate::kernel::test_helpers::create_test_workflow(), create_test_run(), false)
+            .unwrap();
+
+        kernel.cancel_run(&run_id, crate::run::TerminalReason::ClientCancelled).unwrap();
+        kernel.cancel_run(&run_id, crate::run::TerminalReason::UserCancelled).unwrap();
+        match kernel.get_next_instruction(&run_id).unwrap() {
+            orchestrator::Instruction::Terminate { reason, .. } => {
+                assert_eq!(reason, crate::run::TerminalReason::ClientCancelled);
+            }
+            other => panic!("expected Terminate, got {:?}", other),
+        }
+        assert!(kernel.cancel_run(&RunId::must("missing"), crate::run::TerminalReason::ClientCancelled).is_err());
+    }
+
+    #[test]
+    fn remaining_budget_reports_percent_used_and_tightest_dimension() {
+        let mut kernel = Kernel::with_quota(Some(ResourceQuota {
+            max_llm_calls: 4,
+            max_input_tokens: 1_000,
+            timeout_seconds: 0,
+            ..ResourceQuota::default()
+        }));
+        let mut workflow = crate::kernel::test_helpers::create_test_workflow();
+        workflow.max_duration_seconds = Some(600);
+        let run_id = RunId::must("budget");
+        let mut run = create_test_run();
+        kernel.admit_run(&run_id, &mut run).unwrap();
+        let _state = kernel.initialize_orchestration(run_id.clone(), workflow, run, false).unwrap();
+        let run = kernel.runs.get_mut(&run_id).unwrap();
+        run.metrics.llm_calls = 1;
+        run.metrics.tokens_in = 900;
+
+        let budget = kernel.get_remaining_budget(&run_id).unwrap();
+        assert_eq!((budget.llm_calls_remaining, budget.tokens_in_remaining), (3, 100));
+        assert_eq!(budget.percent_used["llm_calls"], 25.0);
+        assert_eq!(budget.percent_used["tokens_in"], 90.0);
+        assert!(!budget.percent_used.contains_key("time"), "no quota timeout");
+        assert!(!budget.percent_used.contains_key("tool_bytes"));
+        assert!(budget.percent_used["deadline"] < 1.0);
+        assert!(budget.deadline_remaining_seconds.unwrap() > 590.0);
+        assert_eq!(budget.time_remaining_seconds, f64::MAX);
+        assert_eq!(budget.most_constrained, Some("tokens_in"));
+        assert!(kernel.get_remaining_budget(&RunId::must("missing")).is_none());
+    }
+
+    #[test]
+    fn regenerating_quota_refills_remaining_budget() {
+        use crate::kernel::{QuotaField, QuotaRegeneration, ResourceQuota};
+        use crate::types::{RequestId, SessionId, UserId};
+
+        let mut kernel = Kernel::with_quota(Some(ResourceQuota {
+            max_llm_calls: 2,
+            regeneration: vec![QuotaRegeneration {
+                field: QuotaField::LlmCalls,
+                amount: 1,
+                every_seconds: 60,
+                cap: 5,
+            }],
+            ..ResourceQuota::default()
+        }));
+        let run_id = RunId::must("regen");
+        kernel
+            .create_run(run_id.clone(), RequestId::must("r"), UserId::must("u"), SessionId::must("s"), None)
+            .unwrap();
+        assert_eq!(kernel.get_remaining_budget(&run_id).unwrap().llm_calls_remaining, 2);
+
+        let started = chrono::Utc::now() - chrono::Duration::seconds(150);
+        kernel.lifecycle.get_mut(&run_id).unwrap().started_at = Some(started);
+        assert_eq!(kernel.get_remaining_budget(&run_id).unwrap().llm_calls_remaining, 4);
+
+        let started = chrono::Utc::now() - chrono::Duration::seconds(3_600);
+        let record = kernel.lifecycle.get_mut(&run_id).unwrap();
+        record.started_at = Some(started);
+        record.quota.timeout_seconds = 0;
+        assert_eq!(kernel.get_remaining_budget(&run_id).unwrap().llm_calls_remaining, 5);
+        assert!(kernel.check_quota(&run_id).is_ok());
+    }
+
+    #[test]
+    fn default_quota_change_applies_to_new_runs_only() {
+        use crate::kernel::ResourceQuota;
+        use crate::types::{RequestId, SessionId, UserId};
+
+        let mut kernel = Kernel::new();
+        let create = |kernel: &mut Kernel, id: &str| {
+            kernel
+                .create_run(RunId::must(id), RequestId::must("r"), UserId::must("u"), SessionId::must("s"), None)
+                .unwrap()
+        };
+        let before = create(&mut kernel, "before");
+
+        let err = kernel
+            .set_default_quota(ResourceQuota { max_llm_calls: 0, ..ResourceQuota::default() })
+            .unwrap_err();
+        assert!(err.to_string().contains("max_llm_calls"), "{}", err);
+
+        kernel
+            .set_default_quota(ResourceQuota { max_llm_calls: 7, ..ResourceQuota::default() })
+            .unwrap();
+        assert_eq!(create(&mut kernel, "after").quota.max_llm_calls, 7);
+        assert_eq!(kernel.lifecycle.get(&RunId::must("before")).unwrap().quota, before.quota);
+    }
+
+    #[test]
+    fn run_agent_carries_stage_security_context() {
+        let mut kernel = Kernel::new();
+        let sandbox = crate::workflow::SecurityContext {
+            allowed_paths: vec!["/workspace".to_string()],
+            network_allowlist: vec!["api.internal:443".to_string()],
+            max_subprocesses: Some(2),
+        };
+        let workflow = Workflow::builder("sandboxed")
+            .agent("tools")
+            .security_context(sandbox.clone())
+            .build()
+            .unwrap();
+        let run_id = RunId::must("sandbox");
+        let _state = kernel
+            .initialize_orchestration(run_id.clone(), workflow, create_test_run(), false)
+            .unwrap();
+        match kernel.get_next_instruction(&run_id).unwrap() {
+            orchestrator::Instruction::RunAgent { context, .. } => {
+                assert_eq!(context.security_context, Some(sandbox));
+            }
+            other => panic!("expected RunAgent, got {:?}", other),
+        }
+    }
+
+    #[test]
+    fn context_view_trims_dispatch_context() {
+        let mut kernel = Kernel::new();
+        let workflow = Workflow::builder("trimmed")
+            .agent("search").next("rank")
+            .agent("rank").next("answer")
+            .agent("answer")
+            .context_view(crate::workflow::ContextView {
+                context_outputs: Some(vec!["rank".into()]),
+                omit_state: true,
+            })
+            .build()
+            .unwrap();
+        let run_id = RunId::must("trimmed");
+        let _state = kernel
+            .initialize_orchestration(run_id.clone(), workflow, create_test_run(), false)
+            .unwrap();
+        for (agent, output) in [("search", serde_json::json!({"hits": [1, 2, 3]})), ("rank", serde_json::json!({"top": 2}))] {
+            let _ = kernel.get_next_instruction(&run_id).unwrap();
+            report(&mut kernel, &run_id, agent, output);
+        }
+
+        match kernel.get_next_instruction(&run_id).unwrap() {
+            orchestrator::Instruction::RunAgent { context, .. } => {
+                let payload = context.agent_context.unwrap();
+                assert_eq!(payload["outputs"], serde_json::json!({"rank": {"top": 2}}));
+                assert_eq!(payload["template_vars"]["rank_top"], 2);
+                assert!(payload["template_vars"].get("search_hits").is_none());
+                assert!(payload.get("state").is_none());
+            }
+            other => panic!("expected RunAgent, got {:?}", other),
+        }
+    }
+
+    #[test]
+    fn tool_calls_outside_policy_terminate_the_run() {
+        let mut kernel = Kernel::new();
+        let policy = crate::workflow::ToolPolicy {
+            allowed_tools: Some(vec!["search".into(), "read_file".into()]),
+            denied_tools: vec!["read_file".into()],
+        };
+        let workflow = Workflow::builder("scoped")
+            .agent("tools")
+            .tool_policy(policy.clone())
+            .build()
+            .unwrap();
+        let run_id = RunId::must("scoped");
+        let _state = kernel
+            .initialize_orchestration(run_id.clone(), workflow, create_test_run(), false)
+            .unwrap();
+        match kernel.get_next_instruction(&run_id).unwrap() {
+            orchestrator::Instruction::RunAgent { context, .. } => assert_eq!(context.tool_policy, Some(policy)),
+            other => panic!("expected RunAgent, got {:?}", other),
+        }
+
+        let call = |name: &str| crate::agent::metrics::ToolCallResult {
+            name: name.to_string(),
+            success: true,
+            latency_ms: 1,
+            error_type: None,
+            ..Default::default()
+        };
+        let metrics = orchestrator::AgentExecutionMetrics {
+            tool_calls: 3,
+            tool_results: vec![call("search"), call("read_file"), call("shell")],
+            ..Default::default()
+        };
+        kernel
+            .process_agent_result(&run_id, "tools", &WorkerIdentity::in_process(), serde_json::json!({}), None, metrics, true, "", false)
+            .unwrap();
+        let run = kernel.runs.get(&run_id).unwrap();
+        assert_eq!(run.terminal_reason(), Some(TerminalReason::PolicyViolation));
+        let message = run.termination.as_ref().and_then(|t| t.message.clone()).unwrap_or_default();
+        assert!(message.contains("read_file, shell"), "{}", message);
+    }
+
+    #[test]
+    fn refused_tool_calls_are_counted_without_terminating() {
+        let mut kernel = Kernel::new();
+        let workflow = Workflow::builder("scoped")
+            .agent("tools")
+            .tool_policy(crate::workflow::ToolPolicy {
+                allowed_tools: Some(vec!["search".into()]),
+                denied_tools: vec![],
+            })
+            .build()
+            .unwrap();
+        let run_id = RunId::must("refused");
+        let _state = kernel
+            .initialize_orchestration(run_id.clone(), workflow, create_test_run(), false)
+            .unwrap();
+        let _ = kernel.get_next_instruction(&run_id).unwrap();
+
+        let metrics = orchestrator::AgentExecutionMetrics {
+            refused_tools: vec!["shell".into(), "shell".into()],
+            ..Default::default()
+        };
+        kernel
+            .process_agent_result(&run_id, "tools", &WorkerIdentity::in_process(), serde_json::json!({}), None, metrics, true, "", false)
+            .unwrap();
+        let run = kernel.runs.get(&run_id).unwrap();
+        assert_ne!(run.terminal_reason(), Some(TerminalReason::PolicyViolation));
+        assert_eq!(run.metrics.tool_calls, 0);
+        assert_eq!(run.metrics.tool_calls_refused, 2);
+    }
+
+    #[test]
+    fn tool_bytes_over_quota_terminate_the_run() {
+        let mut kernel = Kernel::with_quota(Some(ResourceQuota { max_tool_bytes: 100, ..ResourceQuota::default() }));
+        let run_id = RunId::must("grep-everything");
+        let mut run = create_test_run();
+        kernel.admit_run(&run_id, &mut run).unwrap();
+        let _state = kernel
+            .initialize_orchestration(run_id.clone(), crate::kernel::test_helpers::create_test_workflow(), run, false)
+            .unwrap();
+        let _ = kernel.get_next_instruction(&run_id).unwrap();
+
+        let metrics = orchestrator::AgentExecutionMetrics {
+            tool_calls: 1,
+            tool_results: vec![crate::agent::metrics::ToolCallResult {
+                name: "grep".to_string(),
+                success: true,
+                bytes_in: 40,
+                bytes_out: 80,
+                ..Default::default()
+            }],
+            ..Default::default()
+        };
+        kernel
+            .process_agent_result(&run_id, "agent1", &WorkerIdentity::in_process(), serde_json::json!({}), None, metrics, true, "", false)
+            .unwrap();
+
+        let run = kernel.runs.get(&run_id).unwrap();
+        assert_eq!((run.metrics.tool_bytes_in, run.metrics.tool_bytes_out), (40, 80));
+        assert_eq!(run.terminal_reason(), Some(TerminalReason::ToolBytesExceeded));
+        assert_eq!(kernel.get_remaining_budget(&run_id).unwrap().tool_bytes_remaining, 0);
+        assert!(kernel.check_quota(&run_id).unwrap_err().to_string().contains("tool_bytes 120 > 100"));
+        assert_eq!(kernel.get_system_status().tool_bytes_total, 120);
+    }
+
+    #[test]
+    fn interrupt_attachments_are_size_checked_and_round_trip() {
+        use crate::run::{Artifact, InterruptAttachment, MAX_INLINE_ATTACHMENT_BYTES};
+
+        let mut kernel = Kernel::new();
+        let run_id = RunId::must("attach");
+        let _state = kernel
+            .initialize_orchestration(run_id.clone(), crate::kernel::test_helpers::create_test_workflow(), create_test_run(), false)
+            .unwrap();
+
+        let oversize = FlowInterrupt::new()
+            .with_attachment(InterruptAttachment::inline("a.bin", "application/octet-stream", vec![0; MAX_INLINE_ATTACHMENT_BYTES]))
+            .with_attachment(InterruptAttachment::inline("b.bin", "application/octet-stream", vec![0; 1]));
+        let err = kernel.set_run_interrupt(&run_id, oversize).unwrap_err();
+        assert_eq!(err.to_error_code(), "INVALID_ARGUMENT");
+        assert!(!kernel.runs.get(&run_id).unwrap().interrupts.is_pending());
+
+        let interrupt = FlowInterrupt::new()
+            .with_message("Apply this patch?".into())
+            .with_attachment(InterruptAttachment::inline("fix.diff", "text/x-diff", b"-old\n+new\n".to_vec()))
+            .with_attachment(InterruptAttachment::artifact(
+                "build.log",
+                Artifact { uri: "s3://logs/1".into(), kind: "log".into(), mime_type: Some("text/plain".into()), size_bytes: Some(1 << 30) },
+            ));
+        kernel.set_run_interrupt(&run_id, interrupt.clone()).unwrap();
+
+        let wire = serde_json::to_value(kernel.get_next_instruction(&run_id).unwrap()).unwrap();
+        let attachments = &wire["interrupt"]["attachments"];
+        assert_eq!(attachments[0]["content"], serde_json::json!({"kind": "inline", "data": "LW9sZAorbmV3Cg=="}));
+        assert_eq!(attachments[1]["content"]["kind"], "artifact");
+        let back: FlowInterrupt = serde_json::from_value(wire["interrupt"].clone()).unwrap();
+        assert_eq!(back, interrupt);
+    }
+
+    #[test]
+    fn oversize_input_is_rejected_before_a_run_exists() {
+        let mut kernel = Kernel::with_quota(Some(ResourceQuota { max_input_tokens: 10, ..ResourceQuota::default_quota() }));
+        let mut workflow = crate::kernel::test_helpers::create_test_workflow();
+        workflow.stages[0].agent_config.has_llm = true;
+        let run_id = RunId::must("too_big");
+        let mut run = create_test_run();
+        run.raw_input = "x".repeat(400);
+        kernel.admit_run(&run_id, &mut run).unwrap();
+
+        let err = kernel.initialize_orchestration(run_id.clone(), workflow, run, false).unwrap_err();
+        assert_eq!(err.to_error_code(), "INVALID_ARGUMENT");
+        assert!(err.to_string().contains("quota.max_input_tokens 10"));
+        assert!(kernel.lifecycle.get(&run_id).is_none());
+        assert!(!kernel.runs.contains_key(&run_id));
+    }
+
+    #[test]
+    fn expired_interrupts_show_up_in_system_status() {
+        let mut kernel = Kernel::new();
+        let run_id = RunId::must("lapse");
+        let workflow = crate::kernel::test_helpers::create_test_workflow();
+        let pipeline = workflow.name.clone();
+        let _state = kernel.initialize_orchestration(run_id.clone(), workflow, create_test_run(), false).unwrap();
+        let mut interrupt = FlowInterrupt::new().with_message("Ship it?".into());
+        interrupt.expires_at = Some(chrono::Utc::now() - chrono::Duration::seconds(1));
+        kernel.set_run_interrupt(&run_id, interrupt).unwrap();
+
+        let stats = &kernel.get_system_status().interrupts;
+        assert_eq!((stats[0].pipeline.as_str(), stats[0].kind.as_str()), (pipeline.as_str(), "confirmation"));
+        assert_eq!((stats[0].created, stats[0].pending), (1, 1));
+
+        let instruction = kernel.get_next_instruction(&run_id).unwrap();
+        assert!(matches!(instruction, orchestrator::Instruction::RunAgent { .. }));
+        let stats = &kernel.get_system_status().interrupts;
+        assert_eq!((stats[0].expired, stats[0].pending, stats[0].expiry_rate), (1, 0, 1.0));
+        assert_eq!(stats[0].pending_age_p50_ms, None);
+    }
+
+    #[test]
+    fn interrupts_over_the_per_kind_limit_terminate_the_run() {
+        let mut kernel = Kernel::new();
+        let run_id = RunId::must("chatty");
+        let mut workflow = crate::kernel::test_helpers::create_test_workflow();
+        workflow.max_interrupts_per_kind = Some(2);
+        let _state = kernel.initialize_orchestration(run_id.clone(), workflow, create_test_run(), false).unwrap();
+        let answer = || crate::run::InterruptResponse {
+            text: Some("main".into()),
+            approved: None,
+            decision: None,
+            data: None,
+            received_at: chrono::Utc::now(),
+        };
+
+        for _ in 0..2 {
+            let question = FlowInterrupt::new().with_question("Which branch?".into());
+            let interrupt_id = question.id.clone();
+            kernel.set_run_interrupt(&run_id, question).unwrap();
+            kernel.resolve_run_interrupt(&run_id, interrupt_id.as_str(), answer()).unwrap();
+        }
+        // Counted per kind: a confirmation still gets through.
+        kernel.set_run_interrupt(&run_id, FlowInterrupt::new().with_message("Push?".into())).unwrap();
+        let run = kernel.runs.get_mut(&run_id).unwrap();
+        assert!(run.interrupts.is_pending());
+        run.close_interrupt(crate::run::InterruptOutcome::Resolved, None);
+
+        kernel.set_run_interrupt(&run_id, FlowInterrupt::new().with_question("Really?".into())).unwrap();
+        let run = kernel.runs.get(&run_id).unwrap();
+        assert_eq!(run.terminal_reason(), Some(TerminalReason::InterruptLimitExceeded));
+        assert_eq!(run.termination.as_ref().unwrap().message.as_deref(), Some("Run raised 3 question interrupts, limit 2"));
+        assert!(!run.interrupts.is_pending());
+        let stats = &kernel.get_system_status().interrupts;
+        let questions = stats.iter().find(|s| s.kind.as_str() == "question").unwrap();
+        assert_eq!((questions.created, questions.limited), (2, 1));
+    }
+
+    #[test]
+    fn interrupt_limit_only_counts_the_window() {
+        let mut run = create_test_run();
+        let mut old = FlowInterrupt::new().with_question("Old?".into());
+        old.created_at = chrono::Utc::now() - chrono::Duration::seconds(120);
+        run.set_interrupt(old);
+        run.close_interrupt(crate::run::InterruptOutcome::Resolved, None);
+        run.set_interrupt(FlowInterrupt::new().with_question("New?".into()));
+
+        let kind = crate::run::InterruptKind::Question;
+        assert_eq!(run.interrupts_raised(kind, None), 2);
+        assert_eq!(run.interrupts_raised(kind, Some(chrono::Utc::now() - chrono::Duration::seconds(60))), 1);
+        assert_eq!(run.interrupts_raised(crate::run::InterruptKind::Confirmation, None), 0);
+    }
+
+    #[test]
+    fn resolution_token_resolves_its_interrupt_once() {
+        let mut kernel = Kernel::new();
+        let run_id = RunId::must("approve");
+        let _state = kernel
+            .initialize_orchestration(run_id.clone(), crate::kernel::test_helpers::create_test_workflow(), create_test_run(), false)
+            .unwrap();
+        let interrupt = FlowInterrupt::new().with_message("Delete the branch?".into());
+        let interrupt_id = interrupt.id.clone();
+        kernel.set_run_interrupt(&run_id, interrupt).unwrap();
+
+        let err = kernel.issue_resolution_token(&RunId::must("other"), interrupt_id.as_str(), true).unwrap_err();
+        assert_eq!(err.to_error_code(), "NOT_FOUND");
+        let reject = kernel.issue_resolution_token(&run_id, interrupt_id.as_str(), false).unwrap();
+        let approve = kernel.issue_resolution_token(&run_id, interrupt_id.as_str(), true).unwrap();
+
+        assert_eq!(kernel.resolve_interrupt_with_token(&reject).unwrap(), run_id);
+        let run = kernel.runs.get(&run_id).unwrap();
+        assert!(!run.interrupts.is_pending());
+        assert_eq!(run.audit.metadata["_interrupt_response"]["approved"], serde_json::json!(false));
+        assert!(kernel.resolve_interrupt_with_token(&reject).is_err(), "single use");
+        assert!(kernel.resolve_interrupt_with_token(&approve).is_err(), "sibling revoked");
+    }
+
+    #[test]
+    fn run_agent_carries_locale_and_timezone() {
+        let mut kernel = Kernel::new();
+        let run = Run::new(
+            "user",
+            "sess",
+            "when is my appointment?",
+            Some(serde_json::json!({"locale": "de-DE", "timezone": "Europe/Berlin"})),
+        );
+        assert_eq!(run.locale.as_deref(), Some("de-DE"));
+        let run_id = RunId::must("localized");
+        let _state = kernel
+            .initialize_orchestration(run_id.clone(), crate::kernel::test_helpers::create_test_workflow(), run, false)
+            .unwrap();
+        match kernel.get_next_instruction(&run_id).unwrap() {
+            orchestrator::Instruction::RunAgent { context, .. } => {
+                assert_eq!(context.locale.as_deref(), Some("de-DE"));
+                assert_eq!(context.timezone.as_deref(), Some("Europe/Berlin"));
+            }
+            other => panic!("expected RunAgent, got {:?}", other),
+        }
+    }
+
+    #[test]
+    fn run_agent_carries_params_unchanged_by_metadata_updates() {
+        let mut kernel = Kernel::new();
+        let params: HashMap<String, serde_json::Value> =
+            serde_json::from_value(serde_json::json!({"branch": "release/2.1", "depth": 3})).unwrap();
+        let run = create_test_run().with_params(params.clone());
+        let run_id = RunId::must("params");
+        let _state = kernel
+            .initialize_orchestration(run_id.clone(), crate::kernel::test_helpers::create_test_workflow(), run, false)
+            .unwrap();
+        let _first = kernel.get_next_instruction(&run_id).unwrap();
+
+        let updates: HashMap<String, serde_json::Value> =
+            serde_json::from_value(serde_json::json!({"branch": "main"})).unwrap();
+        kernel
+            .process_agent_result(
+                &run_id,
+                "agent1",
+                &WorkerIdentity::in_process(),
+                serde_json::json!({}),
+                Some(updates),
+                Default::default(),
+                true,
+                "",
+                false,
+            )
+            .unwrap();
+        match kernel.get_next_instruction(&run_id).unwrap() {
+            orchestrator::Instruction::RunAgent { agent, context } => {
+                assert_eq!(agent, "agent2");
+                assert_eq!(context.params, params);
+            }
+            other => panic!("expected RunAgent, got {:?}", other),
+        }
+        assert_eq!(kernel.runs[&run_id].audit.metadata["branch"], serde_json::json!("main"));
+    }
+
+    #[test]
+    fn run_agent_carries_cancellation_token() {
+        let mut kernel = Kernel::new();
+        let run_id = RunId::must("token");
+        let _state = kernel
+            .initialize_orchestration(run_id.clone(), crate::kernel::test_helpers::create_test_workflow(), create_test_run(), false)
+            .unwrap();
+        let token = match kernel.get_next_instruction(&run_id).unwrap() {
+            orchestrator::Instruction::RunAgent { context, .. } => context.cancellation.unwrap(),
+            other => panic!("expected RunAgent, got {:?}", other),
+        };
+        assert_eq!(kernel.check_cancelled(&run_id).unwrap(), None);
+        assert!(!token.is_cancelled());
+
+        kernel.cancel_run(&run_id, TerminalReason::UserCancelled).unwrap();
+        assert!(token.is_cancelled());
+        assert_eq!(kernel.check_cancelled(&run_id).unwrap(), Some(TerminalReason::UserCancelled));
+        assert!(kernel.check_cancelled(&RunId::must("missing")).is_err());
+    }
+
+    #[test]
+    fn agent_progress_is_visible_until_the_final_result() {
+        let mut kernel = Kernel::new();
+        let run_id = RunId::must("progress");
+        let _state = kernel
+            .initialize_orchestration(run_id.clone(), crate::kernel::test_helpers::create_test_workflow(), create_test_run(), false)
+            .unwrap();
+        let _ = kernel.get_next_instruction(&run_id).unwrap();
+
+        kernel.report_agent_progress(&run_id, "agent1", serde_json::json!({ "files_scanned": 120 })).unwrap();
+        kernel.report_agent_progress(&run_id, "agent1", serde_json::json!({ "files_scanned": 480 })).unwrap();
+        let err = kernel.report_agent_progress(&run_id, "agent2", serde_json::json!({})).unwrap_err();
+        assert!(err.to_string().contains("not running stage 'stage1'"));
+
+        let state = kernel.get_orchestration_state(&run_id).unwrap();
+        let partials = &state.run["partial_outputs"]["agent1"];
+        assert_eq!(partials.as_array().unwrap().len(), 2);
+        assert_eq!(partials[1]["output"]["files_scanned"], 480);
+        assert_eq!(partials[1]["stage"], "stage1");
+        assert_eq!(kernel.runs.get(&run_id).unwrap().current_stage.as_str(), "stage1");
+
+        kernel
+            .process_agent_result(&run_id, "agent1", &WorkerIdentity::in_process(), serde_json::json!({ "files_scanned": 512 }), None, Default::default(), true, "", false)
+            .unwrap();
+        let run = kernel.runs.get(&run_id).unwrap();
+        assert!(run.partial_outputs.is_empty());
+        assert_eq!(run.current_stage.as_str(), "stage2");
+    }
+
+    fn report(kernel: &mut Kernel, run_id: &RunId, agent: &str, output: serde_json::Value) {
+        kernel
+            .process_agent_result(run_id, agent, &WorkerIdentity::in_process(), output, None, Default::default(), true, "", false)
+            .unwrap();
+    }
+
+    #[test]
+    fn tracked_provenance_names_the_latest_writer() {
+        let mut kernel = Kernel::new();
+        let mut workflow = crate::kernel::test_helpers::create_test_workflow();
+        workflow.track_provenance = true;
+        let run_id = RunId::must("audited");
+        let _state = kernel
+            .initialize_orchestration(run_id.clone(), workflow, create_test_run(), false)
+            .unwrap();
+
+        report(&mut kernel, &run_id, "agent1", serde_json::json!({"claim": "draft"}));
+        report(&mut kernel, &run_id, "agent2", serde_json::json!({"claim": "reviewed", "score": 4}));
+
+        let claim = kernel.get_provenance(&run_id, "claim").unwrap();
+        let writers: Vec<(&str, &str, i32)> = claim.iter().map(|p| (p.agent.as_str(), p.stage.as_str(), p.stage_number)).collect();
+        assert_eq!(writers, vec![("agent1", "stage1", 1), ("agent2", "stage2", 2)]);
+        assert_eq!(kernel.get_provenance(&run_id, "score").unwrap().len(), 1);
+        assert!(kernel.get_provenance(&RunId::must("missing"), "claim").is_err());
+    }
+
+    #[test]
+    fn write_once_outputs_keep_first_write() {
+        let mut kernel = Kernel::new();
+        let mut refine = stage("refine", "refine", None, Some("refine"));
+        refine.max_visits = Some(3);
+        let mut workflow = Workflow::test_default("once", vec![refine]);
+        workflow.write_once_outputs = true;
+        let run_id = RunId::must("once");
+        let _state = kernel
+            .initialize_orchestration(run_id.clone(), workflow.clone(), create_test_run(), false)
+            .unwrap();
+
+        report(&mut kernel, &run_id, "refine", serde_json::json!({"draft": "v1"}));
+        report(&mut kernel, &run_id, "refine", serde_json::json!({"draft": "v2"}));
+
+        let run = kernel.runs.get(&run_id).unwrap();
+        assert_eq!(run.outputs["refine"]["draft"], serde_json::json!("v1"));
+        let violations = run.audit.metadata["output_write_violations"].as_array().unwrap();
+        assert_eq!(violations.len(), 1);
+        assert_eq!(violations[0]["agent_name"], serde_json::json!("refine"));
+
+        // Stage-level opt-out restores overwrite semantics.
+        workflow.stages[0].overwrite_output = true;
+        let run_id = RunId::must("overwrite");
+        let _state = kernel
+            .initialize_orchestration(run_id.clone(), workflow, create_test_run(), false)
+            .unwrap();
+        report(&mut kernel, &run_id, "refine", serde_json::json!({"draft": "v1"}));
+        report(&mut kernel, &run_id, "refine", serde_json::json!({"draft": "v2"}));
+        let run = kernel.runs.get(&run_id).unwrap();
+        assert_eq!(run.outputs["refine"]["draft"], serde_json::json!("v2"));
+        assert!(!run.audit.metadata.contains_key("output_write_violations"));
+    }
+
+    fn budgeted_session(kernel: &mut Kernel, id: &str, overflow: OutputOverflow) -> RunId {
+        let mut workflow = Workflow::test_default(
+            "budget",
+            vec![
+                stage("a", "a", None, Some("b")),
+                stage("b", "b", None, Some("c")),
+                stage("c", "c", None, Some("d")),
+                stage("d", "d", None, None),
+            ],
+        );
+        workflow.max_output_bytes = Some(200);
+        workflow.output_overflow = overflow;
+        let run_id = RunId::must(id);
+        let _state = kernel
+            .initialize_orchestration(run_id.clone(), workflow, create_test_run(), false)
+            .unwrap();
+        run_id
+    }
+
+    #[test]
+    fn output_budget_terminates_by_default() {
+        let mut kernel = Kernel::new();
+        let run_id = budgeted_session(&mut kernel, "over", OutputOverflow::Terminate);
+        let text = "x".repeat(100);
+
+        report(&mut kernel, &run_id, "a", serde_json::json!({ "text": text }));
+        let run = kernel.runs.get(&run_id).unwrap();
+        assert!(!run.is_terminated());
+        assert_eq!(run.metrics.output_bytes, output_size(&run.outputs["a"]));
+
+        report(&mut kernel, &run_id, "b", serde_json::json!({ "text": text }));
+        let run = kernel.runs.get(&run_id).unwrap();
+        assert_eq!(run.terminal_reason(), Some(TerminalReason::OutputBudgetExceeded));
+    }
+
+    #[test]
+    fn output_budget_compacts_oldest_outputs() {
+        let mut kernel = Kernel::new();
+        let run_id = budgeted_session(&mut kernel, "compact", OutputOverflow::CompactOldest);
+        let text = "x".repeat(100);
+
+        for agent in ["a", "b", "c"] {
+            report(&mut kernel, &run_id, agent, serde_json::json!({ "text": text }));
+        }
+        let run = kernel.runs.get(&run_id).unwrap();
+        assert!(!run.is_terminated());
+        assert!(run.metrics.output_bytes <= 200);
+        assert_eq!(run.outputs["a"]["_compacted"], serde_json::json!(true));
+        assert_eq!(run.outputs["b"]["_compacted"], serde_json::json!(true));
+        assert_eq!(run.outputs["c"]["text"], serde_json::json!(text));
+        assert_eq!(run.audit.metadata["compacted_outputs"], serde_json::json!(["a", "b"]));
+    }
+
+    #[test]
+    fn run_agent_without_template_has_no_rendered_prompt() {
+        let mut kernel = Kernel::new();
+        let workflow = crate::kernel::test_helpers::create_test_workflow();
+        let run_id = RunId::must("plain");
+        let _state = kernel
+            .initialize_orchestration(run_id.clone(), workflow, create_test_run(), false)
+            .unwrap();
+        let instr = kernel.get_next_instruction(&run_id).unwrap();
+        match instr {
+            orchestrator::Instruction::RunAgent { context, .. } => assert!(context.rendered_prompt.is_none()),
+            other => panic!("expected RunAgent, got {:?}", other),
+        }
+    }
+}
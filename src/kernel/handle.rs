@@ -4,7 +4,7 @@
 use crate::agent::metrics::AgentExecutionMetrics;
 use crate::run::Run;
 use crate::kernel::protocol::{Instruction, RunSnapshot};
-use crate::kernel::{RunRecord, SystemStatus};
+use crate::kernel::{RunQuery, RunRecord, SystemStatus};
 use crate::workflow::Workflow;
 use crate::types::{RunId, RequestId, Result, SessionId, UserId};
 use std::collections::HashMap;
@@ -21,6 +21,12 @@ pub(crate) enum KernelCommand {
         force: bool,
         resp_tx: oneshot::Sender<Result<RunSnapshot>>,
     },
+    /// Move a live session onto a new workflow version.
+    MigrateSession {
+        run_id: RunId,
+        workflow: Box<Workflow>,
+        resp_tx: oneshot::Sender<Result<RunSnapshot>>,
+    },
     /// Get the next instruction for a run.
     GetNextInstruction {
         run_id: RunId,
@@ -30,6 +36,7 @@ pub(crate) enum KernelCommand {
     ProcessAgentResult {
         run_id: RunId,
         agent_name: String,
+        worker: crate::run::WorkerIdentity,
         output: serde_json::Value,
         metadata_updates: Option<HashMap<String, serde_json::Value>>,
         metrics: AgentExecutionMetrics,
@@ -43,6 +50,68 @@ pub(crate) enum KernelCommand {
         run_id: RunId,
         resp_tx: oneshot::Sender<Result<RunSnapshot>>,
     },
+    /// Latency breakdown of a run.
+    ExplainLatency {
+        run_id: RunId,
+        resp_tx: oneshot::Sender<Result<crate::run::LatencyReport>>,
+    },
+    /// Human-readable transcript of a run.
+    ExportSession {
+        run_id: RunId,
+        format: crate::run::TranscriptFormat,
+        resp_tx: oneshot::Sender<Result<String>>,
+    },
+    /// Who wrote an output key.
+    GetProvenance {
+        run_id: RunId,
+        key: String,
+        resp_tx: oneshot::Sender<Result<Vec<crate::run::Provenance>>>,
+    },
+    /// Look up a named run template.
+    GetRunTemplate {
+        name: String,
+        resp_tx: oneshot::Sender<Result<super::templates::RunTemplate>>,
+    },
+    /// Workflow and run for a new session on a template (canary-aware).
+    InstantiateRunTemplate {
+        name: String,
+        user_id: String,
+        session_id: String,
+        raw_input: String,
+        metadata: Option<serde_json::Value>,
+        resp_tx: oneshot::Sender<Result<(crate::workflow::Workflow, crate::run::Run)>>,
+    },
+    /// Workflow and run for a new session on the template its labels select.
+    InstantiateForInput {
+        user_id: String,
+        session_id: String,
+        raw_input: String,
+        metadata: Option<serde_json::Value>,
+        resp_tx: oneshot::Sender<Result<(crate::workflow::Workflow, crate::run::Run)>>,
+    },
+    /// Workflow and fresh run re-running an archived run.
+    ReplayRun {
+        archived: Box<Run>,
+        overrides: Box<super::templates::ReplayOverrides>,
+        resp_tx: oneshot::Sender<Result<(crate::workflow::Workflow, crate::run::Run)>>,
+    },
+    /// Start (or replace) a template's canary.
+    StartCanary {
+        name: String,
+        workflow: crate::workflow::Workflow,
+        percent: u8,
+        resp_tx: oneshot::Sender<Result<()>>,
+    },
+    /// Send all new sessions for a template back to stable.
+    AbortCanary {
+        name: String,
+        resp_tx: oneshot::Sender<Result<()>>,
+    },
+    /// Per-version counts for a template's canary.
+    GetCanaryStatus {
+        name: String,
+        resp_tx: oneshot::Sender<Result<super::templates::CanaryStatus>>,
+    },
     /// Create a run record (lifecycle).
     CreateRun {
         run_id: RunId,
@@ -51,15 +120,82 @@ pub(crate) enum KernelCommand {
         session_id: SessionId,
         resp_tx: oneshot::Sender<Result<RunRecord>>,
     },
+    /// Mark a run terminated; the worker sees `Terminate` on its next fetch.
+    CancelRun {
+        run_id: RunId,
+        reason: crate::run::TerminalReason,
+        resp_tx: oneshot::Sender<Result<()>>,
+    },
+    /// Terminal reason of a run, `None` while it may continue.
+    CheckCancelled {
+        run_id: RunId,
+        resp_tx: oneshot::Sender<Result<Option<crate::run::TerminalReason>>>,
+    },
     /// Terminate a run.
     TerminateRun {
         run_id: RunId,
         resp_tx: oneshot::Sender<Result<()>>,
     },
+    /// Attach artifacts to the run's current stage.
+    RecordArtifacts {
+        run_id: RunId,
+        artifacts: Vec<crate::run::Artifact>,
+        resp_tx: oneshot::Sender<Result<()>>,
+    },
+    /// Append agent progress notes to the run's user log.
+    RecordUserLog {
+        run_id: RunId,
+        notes: Vec<crate::run::UserLogNote>,
+        resp_tx: oneshot::Sender<Result<()>>,
+    },
+    /// Record a mid-stage partial output for the running agent.
+    ReportAgentProgress {
+        run_id: RunId,
+        agent: String,
+        partial_output: serde_json::Value,
+        resp_tx: oneshot::Sender<Result<()>>,
+    },
+    /// Pick the next ready run (round-robin across users).
+    NextRunnable {
+        resp_tx: oneshot::Sender<Option<RunId>>,
+    },
+    /// Worker-pull: claim the next instruction from any eligible session.
+    ClaimNextInstruction {
+        worker: crate::run::WorkerIdentity,
+        capabilities: Vec<String>,
+        lease_seconds: u64,
+        resp_tx: oneshot::Sender<Result<Option<super::Claim>>>,
+    },
+    /// Extend a worker's lease on a claimed `RunAgent`.
+    RenewLease {
+        run_id: RunId,
+        worker_id: String,
+        lease_seconds: u64,
+        resp_tx: oneshot::Sender<Result<chrono::DateTime<chrono::Utc>>>,
+    },
+    /// Stop (`paused: true`) or resume handing out work from `NextRunnable`.
+    SetSchedulingPaused {
+        paused: bool,
+        resp_tx: oneshot::Sender<()>,
+    },
+    /// Replace the default quota for new runs.
+    SetDefaultQuota {
+        quota: super::ResourceQuota,
+        resp_tx: oneshot::Sender<Result<()>>,
+    },
     /// Get system status.
     GetSystemStatus {
         resp_tx: oneshot::Sender<SystemStatus>,
     },
+    /// Actor time per command kind.
+    GetCommandProfile {
+        resp_tx: oneshot::Sender<Result<super::profile::CommandProfile>>,
+    },
+    /// Remaining budget and per-dimension usage for a run.
+    GetRemainingBudget {
+        run_id: RunId,
+        resp_tx: oneshot::Sender<Result<super::RemainingBudget>>,
+    },
     /// Resolve a pending interrupt.
     ResolveInterrupt {
         run_id: RunId,
@@ -67,6 +203,18 @@ pub(crate) enum KernelCommand {
         response: crate::run::InterruptResponse,
         resp_tx: oneshot::Sender<Result<()>>,
     },
+    /// Mint a single-use approve/reject token for a pending interrupt.
+    IssueResolutionToken {
+        run_id: RunId,
+        interrupt_id: String,
+        approved: bool,
+        resp_tx: oneshot::Sender<Result<String>>,
+    },
+    /// Resolve an interrupt by redeeming a resolution token.
+    ResolveInterruptWithToken {
+        token: String,
+        resp_tx: oneshot::Sender<Result<RunId>>,
+    },
     /// Set an interrupt without a lifecycle transition (tool-confirmation gate).
     SetRunInterrupt {
         run_id: RunId,
@@ -74,6 +222,45 @@ pub(crate) enum KernelCommand {
         resp_tx: oneshot::Sender<Result<()>>,
     },
 
+    /// Per-user usage rollups.
+    GetUserUsageHistory {
+        user_id: String,
+        granularity: crate::kernel::UsageGranularity,
+        since: chrono::NaiveDate,
+        resp_tx: oneshot::Sender<Vec<crate::kernel::UsageBucket>>,
+    },
+
+    /// Erase all kernel-held data for a user.
+    PurgeUser {
+        user_id: UserId,
+        resp_tx: oneshot::Sender<crate::kernel::PurgeReport>,
+    },
+
+    /// Sessions idle for longer than the TTL (inspection only).
+    ListStaleSessions {
+        idle_ttl_seconds: i64,
+        resp_tx: oneshot::Sender<Result<Vec<RunId>>>,
+    },
+    /// Runs matching a metadata query.
+    SearchRuns {
+        query: RunQuery,
+        resp_tx: oneshot::Sender<Vec<RunId>>,
+    },
+    /// Remove sessions idle for longer than the TTL.
+    CleanupStaleSessions {
+        idle_ttl_seconds: i64,
+        resp_tx: oneshot::Sender<Result<usize>>,
+    },
+    /// Remove terminated runs past their retention window (or all, forced).
+    ReapZombies {
+        force: bool,
+        resp_tx: oneshot::Sender<usize>,
+    },
+    /// Run the retention scrubber (zombies and resolved interrupts).
+    ApplyRetention {
+        resp_tx: oneshot::Sender<crate::kernel::RetentionReport>,
+    },
+
     /// Single-tool or full-system health snapshot.
     GetToolHealth {
         tool_name: Option<String>,
@@ -87,35 +274,77 @@ pub(crate) enum KernelCommand {
     },
 }
 
+impl KernelCommand {
+    /// Variant name, e.g. `GetNextInstruction`.
+    pub(crate) fn name(&self) -> &'static str {
+        match self {
+            Self::InitializeSession { .. } => "InitializeSession",
+            Self::MigrateSession { .. } => "MigrateSession",
+            Self::GetNextInstruction { .. } => "GetNextInstruction",
+            Self::ProcessAgentResult { .. } => "ProcessAgentResult",
+            Self::GetSessionState { .. } => "GetSessionState",
+            Self::ExplainLatency { .. } => "ExplainLatency",
+            Self::ExportSession { .. } => "ExportSession",
+            Self::GetProvenance { .. } => "GetProvenance",
+            Self::GetRunTemplate { .. } => "GetRunTemplate",
+            Self::InstantiateRunTemplate { .. } => "InstantiateRunTemplate",
+            Self::InstantiateForInput { .. } => "InstantiateForInput",
+            Self::ReplayRun { .. } => "ReplayRun",
+            Self::StartCanary { .. } => "StartCanary",
+            Self::AbortCanary { .. } => "AbortCanary",
+            Self::GetCanaryStatus { .. } => "GetCanaryStatus",
+            Self::CreateRun { .. } => "CreateRun",
+            Self::CancelRun { .. } => "CancelRun",
+            Self::CheckCancelled { .. } => "CheckCancelled",
+            Self::TerminateRun { .. } => "TerminateRun",
+            Self::RecordArtifacts { .. } => "RecordArtifacts",
+            Self::RecordUserLog { .. } => "RecordUserLog",
+            Self::ReportAgentProgress { .. } => "ReportAgentProgress",
+            Self::NextRunnable { .. } => "NextRunnable",
+            Self::ClaimNextInstruction { .. } => "ClaimNextInstruction",
+            Self::RenewLease { .. } => "RenewLease",
+            Self::SetSchedulingPaused { .. } => "SetSchedulingPaused",
+            Self::SetDefaultQuota { .. } => "SetDefaultQuota",
+            Self::GetSystemStatus { .. } => "GetSystemStatus",
+            Self::GetCommandProfile { .. } => "GetCommandProfile",
+            Self::GetRemainingBudget { .. } => "GetRemainingBudget",
+            Self::ResolveInterrupt { .. } => "ResolveInterrupt",
+            Self::IssueResolutionToken { .. } => "IssueResolutionToken",
+            Self::ResolveInterruptWithToken { .. } => "ResolveInterruptWithToken",
+            Self::SetRunInterrupt { .. } => "SetRunInterrupt",
+            Self::GetUserUsageHistory { .. } => "GetUserUsageHistory",
+            Self::PurgeUser { .. } => "PurgeUser",
+            Self::ListStaleSessions { .. } => "ListStaleSessions",
+            Self::SearchRuns { .. } => "SearchRuns",
+            Self::CleanupStaleSessions { .. } => "CleanupStaleSessions",
+            Self::ReapZombies { .. } => "ReapZombies",
+            Self::ApplyRetention { .. } => "ApplyRetention",
+            Self::GetToolHealth { .. } => "GetToolHealth",
+            Self::RegisterRoutingFn { .. } => "RegisterRoutingFn",
+        }
+    }
+}
+
 impl std::fmt::Debug for KernelCommand {
     fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
         match self {
             Self::RegisterRoutingFn { name, .. } => {
                 f.debug_struct("RegisterRoutingFn").field("name", name).finish()
             }
-            other => {
-                write!(f, "KernelCommand::{}", match other {
-                    Self::InitializeSession { .. } => "InitializeSession",
-                    Self::GetNextInstruction { .. } => "GetNextInstruction",
-                    Self::ProcessAgentResult { .. } => "ProcessAgentResult",
-                    Self::GetSessionState { .. } => "GetSessionState",
-                    Self::CreateRun { .. } => "CreateRun",
-                    Self::TerminateRun { .. } => "TerminateRun",
-                    Self::GetSystemStatus { .. } => "GetSystemStatus",
-                    Self::ResolveInterrupt { .. } => "ResolveInterrupt",
-                    Self::SetRunInterrupt { .. } => "SetRunInterrupt",
-                    Self::GetToolHealth { .. } => "GetToolHealth",
-                    Self::RegisterRoutingFn { .. } => unreachable!(),
-                })
-            }
+            other => write!(f, "KernelCommand::{}", other.name()),
         }
     }
 }
 
 /// Typed handle to the kernel actor. Clone-able, Send + Sync.
+///
+/// A handle obtained via [`KernelHandle::read_only`] only serves queries
+/// (session state, system status, tool health); mutating calls return
+/// `Error::StateTransition` (`FAILED_PRECONDITION`) without reaching the actor.
 #[derive(Clone, Debug)]
 pub struct KernelHandle {
     tx: mpsc::Sender<KernelCommand>,
+    read_only: bool,
 }
 
 /// Send a KernelCommand and await the oneshot response.
@@ -138,7 +367,41 @@ impl KernelHandle {
     /// channel half is internal; consumers obtain a `KernelHandle` via
     /// [`kernel::actor::spawn`](crate::kernel::actor::spawn).
     pub(crate) fn new(tx: mpsc::Sender<KernelCommand>) -> Self {
-        Self { tx }
+        Self { tx, read_only: false }
+    }
+
+    /// Query-only view over the same kernel actor. Intended for dashboards
+    /// and observers that must not mutate run state.
+    pub fn read_only(&self) -> Self {
+        Self { tx: self.tx.clone(), read_only: true }
+    }
+
+    /// Whether this handle rejects mutating calls.
+    pub fn is_read_only(&self) -> bool {
+        self.read_only
+    }
+
+    /// Liveness: the actor loop is still receiving commands. Answered without
+    /// a round-trip, so it stays cheap for frequent probes.
+    pub fn is_alive(&self) -> bool {
+        !self.tx.is_closed()
+    }
+
+    /// Free slots in the actor's command queue. Zero means callers are
+    /// queuing behind a saturated kernel; a readiness probe can shed load on
+    /// a low value.
+    pub fn queue_headroom(&self) -> usize {
+        self.tx.capacity()
+    }
+
+    fn ensure_writable(&self, operation: &str) -> Result<()> {
+        if self.read_only {
+            return Err(crate::types::Error::state_transition(format!(
+                "{} rejected: kernel handle is read-only",
+                operation
+            )));
+        }
+        Ok(())
     }
 
     /// Register a named routing function on the kernel's orchestrator.
@@ -147,6 +410,7 @@ impl KernelHandle {
         name: impl Into<String>,
         routing_fn: std::sync::Arc<dyn crate::kernel::routing::RoutingFn>,
     ) -> Result<()> {
+        self.ensure_writable("register_routing_fn")?;
         let (resp_tx, resp_rx) = oneshot::channel();
         self.tx
             .send(KernelCommand::RegisterRoutingFn {
@@ -170,6 +434,7 @@ impl KernelHandle {
         run: Run,
         force: bool,
     ) -> Result<RunSnapshot> {
+        self.ensure_writable("initialize_session")?;
         kernel_request!(self, InitializeSession {
             run_id: run_id,
             workflow: Box::new(workflow),
@@ -178,19 +443,33 @@ impl KernelHandle {
         })
     }
 
+    /// Move a live session onto a new version of its workflow so it picks up
+    /// changed routing without restarting. Stages are remapped through
+    /// `workflow.stage_renames`; see `Kernel::migrate_session`.
+    pub async fn migrate_session(&self, run_id: &RunId, workflow: Workflow) -> Result<RunSnapshot> {
+        self.ensure_writable("migrate_session")?;
+        kernel_request!(self, MigrateSession {
+            run_id: run_id.clone(),
+            workflow: Box::new(workflow),
+        })
+    }
+
     /// Get the next instruction for a run.
     pub async fn get_next_instruction(&self, run_id: &RunId) -> Result<Instruction> {
+        self.ensure_writable("get_next_instruction")?;
         kernel_request!(self, GetNextInstruction {
             run_id: run_id.clone(),
         })
     }
 
     /// Report agent result (mutation only — caller fetches next instruction separately).
+    /// `worker` identifies who executed the stage; an empty id is rejected.
     #[allow(clippy::too_many_arguments)]
     pub async fn process_agent_result(
         &self,
         run_id: &RunId,
         agent_name: &str,
+        worker: &crate::run::WorkerIdentity,
         output: serde_json::Value,
         metadata_updates: Option<HashMap<String, serde_json::Value>>,
         metrics: AgentExecutionMetrics,
@@ -198,9 +477,11 @@ impl KernelHandle {
         error_message: &str,
         break_loop: bool,
     ) -> Result<()> {
+        self.ensure_writable("process_agent_result")?;
         kernel_request!(self, ProcessAgentResult {
             run_id: run_id.clone(),
             agent_name: agent_name.to_string(),
+            worker: worker.clone(),
             output: output,
             metadata_updates: metadata_updates,
             metrics: metrics,
@@ -217,6 +498,125 @@ impl KernelHandle {
         })
     }
 
+    /// Where the run's time went: critical path, wait vs. execute, and
+    /// per-agent contributions. For archived runs call
+    /// `Run::explain_latency` directly.
+    pub async fn explain_latency(&self, run_id: &RunId) -> Result<crate::run::LatencyReport> {
+        kernel_request!(self, ExplainLatency {
+            run_id: run_id.clone(),
+        })
+    }
+
+    /// Markdown or HTML transcript of the run (stages, durations, key
+    /// outputs, interrupts, final response) for attaching to tickets. For
+    /// archived runs call `Run::export_transcript` directly.
+    pub async fn export_session(&self, run_id: &RunId, format: crate::run::TranscriptFormat) -> Result<String> {
+        kernel_request!(self, ExportSession {
+            run_id: run_id.clone(),
+            format: format,
+        })
+    }
+
+    /// Which agent wrote output `key`, at which stage and iteration, oldest
+    /// write first. Empty unless the workflow sets `track_provenance`. For
+    /// archived runs call `Run::get_provenance` directly.
+    pub async fn get_provenance(&self, run_id: &RunId, key: &str) -> Result<Vec<crate::run::Provenance>> {
+        kernel_request!(self, GetProvenance {
+            run_id: run_id.clone(),
+            key: key.to_string(),
+        })
+    }
+
+    /// Named run template, for `RunTemplate::instantiate`. `NOT_FOUND` for
+    /// unknown names.
+    pub async fn get_run_template(&self, name: &str) -> Result<super::templates::RunTemplate> {
+        kernel_request!(self, GetRunTemplate {
+            name: name.to_string(),
+        })
+    }
+
+    /// Workflow and run for a new session on template `name`. While the
+    /// template has a live canary, that share of sessions gets the canary
+    /// workflow; `audit.metadata` records `template` and
+    /// `template_version` either way.
+    pub async fn instantiate_run_template(
+        &self,
+        name: &str,
+        user_id: &str,
+        session_id: &str,
+        raw_input: &str,
+        metadata: Option<serde_json::Value>,
+    ) -> Result<(crate::workflow::Workflow, crate::run::Run)> {
+        kernel_request!(self, InstantiateRunTemplate {
+            name: name.to_string(),
+            user_id: user_id.to_string(),
+            session_id: session_id.to_string(),
+            raw_input: raw_input.to_string(),
+            metadata: metadata,
+        })
+    }
+
+    /// Workflow and run for a new session on the template mapped to the
+    /// first classifier label of the (normalized) input; see
+    /// `Kernel::set_label_template`. `NOT_FOUND` when no label maps to a
+    /// template.
+    pub async fn instantiate_for_input(
+        &self,
+        user_id: &str,
+        session_id: &str,
+        raw_input: &str,
+        metadata: Option<serde_json::Value>,
+    ) -> Result<(crate::workflow::Workflow, crate::run::Run)> {
+        kernel_request!(self, InstantiateForInput {
+            user_id: user_id.to_string(),
+            session_id: session_id.to_string(),
+            raw_input: raw_input.to_string(),
+            metadata: metadata,
+        })
+    }
+
+    /// Workflow and a fresh run re-running `archived` (a live or stored
+    /// `Run`), for `initialize_session` under a new run id. Uses the
+    /// template version `archived` got unless `overrides.workflow` is set;
+    /// `INVALID_ARGUMENT` for a non-template run without one.
+    pub async fn replay_run(
+        &self,
+        archived: Run,
+        overrides: super::templates::ReplayOverrides,
+    ) -> Result<(crate::workflow::Workflow, crate::run::Run)> {
+        kernel_request!(self, ReplayRun {
+            archived: Box::new(archived),
+            overrides: Box::new(overrides),
+        })
+    }
+
+    /// Route `percent` (0–100) of new sessions for template `name` to
+    /// `workflow`. Replaces any running canary and resets its counts.
+    pub async fn start_canary(&self, name: &str, workflow: crate::workflow::Workflow, percent: u8) -> Result<()> {
+        self.ensure_writable("start_canary")?;
+        kernel_request!(self, StartCanary {
+            name: name.to_string(),
+            workflow: workflow,
+            percent: percent,
+        })
+    }
+
+    /// Abort switch: every new session for `name` goes to stable. Counts
+    /// stay readable through `canary_status`.
+    pub async fn abort_canary(&self, name: &str) -> Result<()> {
+        self.ensure_writable("abort_canary")?;
+        kernel_request!(self, AbortCanary {
+            name: name.to_string(),
+        })
+    }
+
+    /// Runs started and outcomes per version for `name`'s canary.
+    pub async fn canary_status(&self, name: &str) -> Result<super::templates::CanaryStatus> {
+        kernel_request!(self, GetCanaryStatus {
+            name: name.to_string(),
+        })
+    }
+
     /// Create a run record.
     pub async fn create_run(
         &self,
@@ -225,6 +625,7 @@ impl KernelHandle {
         user_id: UserId,
         session_id: SessionId,
     ) -> Result<RunRecord> {
+        self.ensure_writable("create_run")?;
         kernel_request!(self, CreateRun {
             run_id: run_id,
             request_id: request_id,
@@ -233,13 +634,137 @@ impl KernelHandle {
         })
     }
 
+    /// Cancel a run with `reason`. The worker driving it observes
+    /// `Instruction::Terminate { reason, .. }` on its next fetch.
+    pub async fn cancel_run(&self, run_id: &RunId, reason: crate::run::TerminalReason) -> Result<()> {
+        self.ensure_writable("cancel_run")?;
+        kernel_request!(self, CancelRun {
+            run_id: run_id.clone(),
+            reason: reason,
+        })
+    }
+
+    /// `Some(reason)` once the run has been terminated, so a worker in the
+    /// middle of a stage can abort instead of spending budget on it. The
+    /// `cancellation` token on `RunAgent` fires for the same events.
+    pub async fn check_cancelled(&self, run_id: &RunId) -> Result<Option<crate::run::TerminalReason>> {
+        kernel_request!(self, CheckCancelled {
+            run_id: run_id.clone(),
+        })
+    }
+
     /// Terminate a run.
     pub async fn terminate_run(&self, run_id: &RunId) -> Result<()> {
+        self.ensure_writable("terminate_run")?;
         kernel_request!(self, TerminateRun {
             run_id: run_id.clone(),
         })
     }
 
+    /// Attach artifacts (reports, patches) to the run's current stage. They
+    /// appear in `WorkerResult::artifacts` keyed by stage.
+    pub async fn record_artifacts(
+        &self,
+        run_id: &RunId,
+        artifacts: Vec<crate::run::Artifact>,
+    ) -> Result<()> {
+        self.ensure_writable("record_artifacts")?;
+        kernel_request!(self, RecordArtifacts {
+            run_id: run_id.clone(),
+            artifacts: artifacts,
+        })
+    }
+
+    /// Append progress notes to the run's user-visible log under its
+    /// current stage. They appear in `WorkerResult::user_log`.
+    pub async fn record_user_log(&self, run_id: &RunId, notes: Vec<crate::run::UserLogNote>) -> Result<()> {
+        self.ensure_writable("record_user_log")?;
+        kernel_request!(self, RecordUserLog {
+            run_id: run_id.clone(),
+            notes: notes,
+        })
+    }
+
+    /// Report an intermediate finding from the agent running the current
+    /// stage, without ending it. Partial outputs appear under
+    /// `partial_outputs` in `get_session_state` until the agent's
+    /// `process_agent_result` closes the stage.
+    pub async fn report_agent_progress(
+        &self,
+        run_id: &RunId,
+        agent: &str,
+        partial_output: serde_json::Value,
+    ) -> Result<()> {
+        self.ensure_writable("report_agent_progress")?;
+        kernel_request!(self, ReportAgentProgress {
+            run_id: run_id.clone(),
+            agent: agent.to_string(),
+            partial_output: partial_output,
+        })
+    }
+
+    /// Claim the next ready run, fair across users. `None` when nothing is
+    /// ready or scheduling is paused.
+    pub async fn next_runnable(&self) -> Result<Option<RunId>> {
+        self.ensure_writable("next_runnable")?;
+        Ok(kernel_request!(self, NextRunnable {}))
+    }
+
+    /// Worker-pull mode: claim the next instruction from any session whose
+    /// current agent is in `capabilities` (empty = any) and whose allowed
+    /// regions include `worker.region`. A claimed `RunAgent` is leased to
+    /// `worker.id` for `lease_seconds`; report it via `process_agent_result`
+    /// (or `renew_lease`) before the lease lapses.
+    pub async fn claim_next_instruction(
+        &self,
+        worker: &crate::run::WorkerIdentity,
+        capabilities: Vec<String>,
+        lease_seconds: u64,
+    ) -> Result<Option<super::Claim>> {
+        self.ensure_writable("claim_next_instruction")?;
+        kernel_request!(self, ClaimNextInstruction {
+            worker: worker.clone(),
+            capabilities: capabilities,
+            lease_seconds: lease_seconds,
+        })
+    }
+
+    /// Heartbeat for a long-running claimed stage. Fails once the lease has
+    /// lapsed or been re-claimed by another worker.
+    pub async fn renew_lease(
+        &self,
+        run_id: &RunId,
+        worker_id: &str,
+        lease_seconds: u64,
+    ) -> Result<chrono::DateTime<chrono::Utc>> {
+        self.ensure_writable("renew_lease")?;
+        kernel_request!(self, RenewLease {
+            run_id: run_id.clone(),
+            worker_id: worker_id.to_string(),
+            lease_seconds: lease_seconds,
+        })
+    }
+
+    /// Stop handing out work from `next_runnable` (maintenance windows).
+    /// Session init, agent results and interrupt resolution still work.
+    pub async fn pause_scheduling(&self) -> Result<()> {
+        self.ensure_writable("pause_scheduling")?;
+        Ok(kernel_request!(self, SetSchedulingPaused { paused: true }))
+    }
+
+    pub async fn resume_scheduling(&self) -> Result<()> {
+        self.ensure_writable("resume_scheduling")?;
+        Ok(kernel_request!(self, SetSchedulingPaused { paused: false }))
+    }
+
+    /// Replace the quota applied to runs created from now on. Rejects
+    /// non-positive limits with `INVALID_ARGUMENT`; existing runs are
+    /// unaffected.
+    pub async fn set_default_quota(&self, quota: super::ResourceQuota) -> Result<()> {
+        self.ensure_writable("set_default_quota")?;
+        kernel_request!(self, SetDefaultQuota { quota: quota, })
+    }
+
     /// Set a pending interrupt on a run without a lifecycle transition.
     ///
     /// Used by the worker workflow loop for tool confirmation gates. Does NOT
@@ -249,19 +774,22 @@ impl KernelHandle {
         run_id: &RunId,
         interrupt: crate::run::FlowInterrupt,
     ) -> Result<()> {
+        self.ensure_writable("set_run_interrupt")?;
         kernel_request!(self, SetRunInterrupt {
             run_id: run_id.clone(),
             interrupt: interrupt,
         })
     }
 
-    /// Resolve a pending interrupt for a run.
+    /// Resolve a pending interrupt for a run. Fails with FAILED_PRECONDITION
+    /// if another caller already resolved it.
     pub async fn resolve_interrupt(
         &self,
         run_id: &RunId,
         interrupt_id: &str,
         response: crate::run::InterruptResponse,
     ) -> Result<()> {
+        self.ensure_writable("resolve_interrupt")?;
         kernel_request!(self, ResolveInterrupt {
             run_id: run_id.clone(),
             interrupt_id: interrupt_id.to_string(),
@@ -269,6 +797,94 @@ impl KernelHandle {
         })
     }
 
+    /// Mint a single-use token that approves (`true`) or rejects the run's
+    /// pending interrupt when passed to `resolve_interrupt_with_token`.
+    pub async fn issue_resolution_token(&self, run_id: &RunId, interrupt_id: &str, approved: bool) -> Result<String> {
+        self.ensure_writable("issue_resolution_token")?;
+        kernel_request!(self, IssueResolutionToken {
+            run_id: run_id.clone(),
+            interrupt_id: interrupt_id.to_string(),
+            approved: approved,
+        })
+    }
+
+    /// Resolve the interrupt a token is bound to, without the caller knowing
+    /// the run or user. Returns the resumed run.
+    pub async fn resolve_interrupt_with_token(&self, token: &str) -> Result<RunId> {
+        self.ensure_writable("resolve_interrupt_with_token")?;
+        kernel_request!(self, ResolveInterruptWithToken {
+            token: token.to_string(),
+        })
+    }
+
+    /// Daily or weekly usage buckets for `user_id` from `since` (UTC) to
+    /// today, oldest first.
+    pub async fn get_user_usage_history(
+        &self,
+        user_id: &str,
+        granularity: crate::kernel::UsageGranularity,
+        since: chrono::NaiveDate,
+    ) -> Result<Vec<crate::kernel::UsageBucket>> {
+        Ok(kernel_request!(self, GetUserUsageHistory {
+            user_id: user_id.to_string(),
+            granularity: granularity,
+            since: since,
+        }))
+    }
+
+    /// Erase everything the kernel holds for `user_id` (runs, sessions,
+    /// interrupts, usage history) and report what was removed.
+    pub async fn purge_user(&self, user_id: &UserId) -> Result<crate::kernel::PurgeReport> {
+        self.ensure_writable("purge_user")?;
+        Ok(kernel_request!(self, PurgeUser {
+            user_id: user_id.clone(),
+        }))
+    }
+
+    /// Run IDs whose session has been idle for longer than
+    /// `idle_ttl_seconds`, oldest first. Nothing is removed.
+    pub async fn list_stale_sessions(&self, idle_ttl_seconds: i64) -> Result<Vec<RunId>> {
+        kernel_request!(self, ListStaleSessions {
+            idle_ttl_seconds: idle_ttl_seconds,
+        })
+    }
+
+    /// Runs matching `query` by metadata, user, and receive time, most
+    /// recent first.
+    pub async fn search_runs(&self, query: RunQuery) -> Result<Vec<RunId>> {
+        Ok(kernel_request!(self, SearchRuns {
+            query: query,
+        }))
+    }
+
+    /// Remove sessions (and their runs) idle for longer than
+    /// `idle_ttl_seconds`. The kernel has no background sweep; call this from
+    /// a consumer-owned interval. Returns the number removed.
+    pub async fn cleanup_stale_sessions(&self, idle_ttl_seconds: i64) -> Result<usize> {
+        self.ensure_writable("cleanup_stale_sessions")?;
+        kernel_request!(self, CleanupStaleSessions {
+            idle_ttl_seconds: idle_ttl_seconds,
+        })
+    }
+
+    /// Remove terminated runs kept past `Kernel::set_zombie_retention`'s
+    /// window; `force` removes every terminated run now. Returns the number
+    /// removed.
+    pub async fn reap_zombies(&self, force: bool) -> Result<usize> {
+        self.ensure_writable("reap_zombies")?;
+        Ok(kernel_request!(self, ReapZombies {
+            force: force,
+        }))
+    }
+
+    /// Apply the kernel's retention windows now: terminated runs past
+    /// `Kernel::set_zombie_retention` and resolved interrupts past
+    /// `Kernel::set_interrupt_retention` are removed. Returns what went.
+    pub async fn apply_retention(&self) -> Result<crate::kernel::RetentionReport> {
+        self.ensure_writable("apply_retention")?;
+        Ok(kernel_request!(self, ApplyRetention {}))
+    }
+
     /// `Some(name)` returns that tool's health report; `None` returns the
     /// full-system report.
     pub async fn get_tool_health(&self, tool_name: Option<&str>) -> Result<serde_json::Value> {
@@ -277,6 +893,22 @@ impl KernelHandle {
         })
     }
 
+    /// Remaining budget for `run_id`, including percent used per bounded
+    /// dimension and the most constrained one. `NOT_FOUND` without a run
+    /// record.
+    pub async fn get_remaining_budget(&self, run_id: &RunId) -> Result<super::RemainingBudget> {
+        kernel_request!(self, GetRemainingBudget {
+            run_id: run_id.clone(),
+        })
+    }
+
+    /// How long each command kind has held the actor, and mailbox depth.
+    /// `FAILED_PRECONDITION` unless the kernel was built with
+    /// `enable_command_profiling`.
+    pub async fn get_command_profile(&self) -> Result<super::profile::CommandProfile> {
+        kernel_request!(self, GetCommandProfile {})
+    }
+
     /// Get system status.
     pub async fn get_system_status(&self) -> SystemStatus {
         let (resp_tx, resp_rx) = oneshot::channel();
@@ -290,12 +922,132 @@ impl KernelHandle {
                 runs_total: 0,
                 runs_by_state: Default::default(),
                 active_orchestration_sessions: 0,
+                active_runs_by_label: Default::default(),
+                scheduling_paused: false,
+                tool_bytes_total: 0,
+                interrupts: Vec::new(),
             };
         }
         resp_rx.await.unwrap_or(SystemStatus {
             runs_total: 0,
             runs_by_state: Default::default(),
             active_orchestration_sessions: 0,
+            active_runs_by_label: Default::default(),
+            scheduling_paused: false,
+            tool_bytes_total: 0,
+            demoted_runs: 0,
+            interrupts: Vec::new(),
         })
     }
 }
+
+#[cfg(test)]
+mod tests {
+    use crate::kernel::actor::spawn;
+    use crate::kernel::test_helpers::{create_test_run, create_test_workflow};
+    use crate::kernel::Kernel;
+    use crate::types::{RequestId, RunId, SessionId, UserId};
+    use tokio_util::sync::CancellationToken;
+
+    #[tokio::test]
+    async fn read_only_handle_serves_queries() {
+        let cancel = CancellationToken::new();
+        let handle = spawn(Kernel::new(), cancel.clone());
+        let run_id = RunId::must("ro_query");
+        let _state = handle
+            .initialize_session(run_id.clone(), create_test_workflow(), create_test_run(), false)
+            .await
+            .unwrap();
+
+        let reader = handle.read_only();
+        assert!(reader.is_read_only());
+        assert!(!handle.is_read_only());
+        assert!(reader.get_session_state(&run_id).await.is_ok());
+        assert_eq!(reader.get_system_status().await.active_orchestration_sessions, 1);
+        cancel.cancel();
+    }
+
+    #[tokio::test]
+    async fn command_profile_counts_commands_when_enabled() {
+        let cancel = CancellationToken::new();
+        let disabled = spawn(Kernel::new(), cancel.clone());
+        let err = disabled.get_command_profile().await.unwrap_err();
+        assert_eq!(err.to_error_code(), "FAILED_PRECONDITION");
+
+        let mut kernel = Kernel::new();
+        kernel.enable_command_profiling();
+        let handle = spawn(kernel, cancel.clone());
+        let run_id = RunId::must("profiled");
+        let _state = handle
+            .initialize_session(run_id.clone(), create_test_workflow(), create_test_run(), false)
+            .await
+            .unwrap();
+        let _instruction = handle.get_next_instruction(&run_id).await.unwrap();
+        let _instruction = handle.get_next_instruction(&run_id).await.unwrap();
+
+        let profile = handle.get_command_profile().await.unwrap();
+        let count = |name: &str| profile.commands.iter().find(|c| c.command == name).map(|c| c.count);
+        assert_eq!(count("InitializeSession"), Some(1));
+        assert_eq!(count("GetNextInstruction"), Some(2));
+        assert_eq!(count("GetCommandProfile"), None, "recorded after it replies");
+        cancel.cancel();
+    }
+
+    #[tokio::test]
+    async fn liveness_follows_the_actor() {
+        let cancel = CancellationToken::new();
+        let handle = spawn(Kernel::new(), cancel.clone());
+        assert!(handle.is_alive());
+        assert!(handle.queue_headroom() > 0);
+
+        cancel.cancel();
+        for _ in 0..100 {
+            if !handle.is_alive() {
+                break;
+            }
+            tokio::task::yield_now().await;
+        }
+        assert!(!handle.is_alive());
+    }
+
+    #[tokio::test]
+    async fn read_only_handle_rejects_mutations() {
+        let cancel = CancellationToken::new();
+        let handle = spawn(Kernel::new(), cancel.clone());
+        let reader = handle.read_only();
+        let run_id = RunId::must("ro_mutate");
+
+        let err = reader
+            .initialize_session(run_id.clone(), create_test_workflow(), create_test_run(), false)
+            .await
+            .unwrap_err();
+        assert_eq!(err.to_error_code(), "FAILED_PRECONDITION");
+
+        let err = reader.terminate_run(&run_id).await.unwrap_err();
+        assert_eq!(err.to_error_code(), "FAILED_PRECONDITION");
+
+        // Nothing reached the actor.
+        assert_eq!(handle.get_system_status().await.runs_total, 0);
+        cancel.cancel();
+    }
+
+    #[tokio::test]
+    async fn paused_scheduling_still_accepts_runs() {
+        let cancel = CancellationToken::new();
+        let handle = spawn(Kernel::new(), cancel.clone());
+        handle.pause_scheduling().await.unwrap();
+        assert!(handle.get_system_status().await.scheduling_paused);
+
+        let run_id = RunId::must("queued");
+        handle
+            .create_run(run_id.clone(), RequestId::must("req"), UserId::must("u1"), SessionId::must("s1"))
+            .await
+            .unwrap();
+        assert_eq!(handle.next_runnable().await.unwrap(), None);
+
+        handle.resume_scheduling().await.unwrap();
+        assert!(!handle.get_system_status().await.scheduling_paused);
+        assert_eq!(handle.next_runnable().await.unwrap(), Some(run_id));
+        cancel.cancel();
+    }
+}
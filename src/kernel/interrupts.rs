@@ -3,12 +3,25 @@
 //! Tracks pending `FlowInterrupt`s by id and the consumer-supplied responses.
 //! The kernel uses this to suspend a stage when an agent requests
 //! confirmation and to thread the response back into the next agent dispatch.
+//!
+//! Resolution tokens let an approval link resolve one interrupt one way
+//! without the caller holding user credentials. A token is an unguessable
+//! random string kept here, not a signed claim: it is valid while its
+//! interrupt is pending, works once, and dies with its sibling when the
+//! interrupt resolves by any path.
+//!
+//! Counters per interrupt kind and pipeline feed `InterruptStats`, the
+//! aging report surfaced in `SystemStatus`: how fast interrupts arrive, how
+//! long humans take to answer, how many lapse, and how old the unanswered
+//! ones are. Interrupts refused by a workflow's `max_interrupts_per_kind`
+//! are counted there too, as `limited`.
 
 use chrono::{DateTime, Utc};
-use std::collections::HashMap;
+use serde::Serialize;
+use std::collections::{HashMap, VecDeque};
 
-use crate::run::{FlowInterrupt, InterruptResponse};
-use crate::types::{EnvelopeId, InterruptId, RequestId, SessionId, UserId};
+use crate::run::{FlowInterrupt, InterruptKind, InterruptResponse};
+use crate::types::{EnvelopeId, InterruptId, RequestId, RunId, SessionId, UserId};
 
 /// Lightweight bookkeeping for a pending interrupt.
 #[derive(Debug, Clone)]
@@ -18,18 +31,87 @@ pub struct PendingInterrupt {
     pub user_id: UserId,
     pub session_id: SessionId,
     pub envelope_id: EnvelopeId,
+    /// Workflow name of the owning run, for per-pipeline metrics.
+    pub pipeline: String,
     pub registered_at: DateTime<Utc>,
 }
 
+/// What a resolution token is bound to.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct ResolutionToken {
+    pub run_id: RunId,
+    pub interrupt_id: InterruptId,
+    /// Recorded as `InterruptResponse::approved` on redemption.
+    pub approved: bool,
+}
+
+/// Resolution times kept per (kind, pipeline) for the median.
+const RESOLUTION_SAMPLES: usize = 256;
+
+/// Lifetime counters for one (kind, pipeline) pair.
+#[derive(Debug, Default)]
+struct InterruptCounters {
+    created: u64,
+    resolved: u64,
+    expired: u64,
+    limited: u64,
+    /// Most recent resolution times, oldest first.
+    resolution_ms: VecDeque<i64>,
+}
+
+/// Metrics for one interrupt kind in one pipeline. Rates cover the time
+/// since the kernel started; ages and the median are in milliseconds.
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct InterruptStats {
+    pub kind: InterruptKind,
+    pub pipeline: String,
+    pub created: u64,
+    pub created_per_hour: f64,
+    pub resolved: u64,
+    pub expired: u64,
+    /// `expired / (resolved + expired)`; 0 until one of them happens.
+    pub expiry_rate: f64,
+    /// Refused by the workflow's `max_interrupts_per_kind`; each one ended
+    /// its run with `INTERRUPT_LIMIT_EXCEEDED`. Not included in `created`.
+    pub limited: u64,
+    /// Over the last `RESOLUTION_SAMPLES` resolutions.
+    pub median_resolution_ms: Option<i64>,
+    pub pending: usize,
+    pub pending_age_p50_ms: Option<i64>,
+    pub pending_age_p90_ms: Option<i64>,
+    pub pending_age_max_ms: Option<i64>,
+}
+
 /// Lightweight registry: pending interrupts by id + resolved responses.
 ///
-/// Held by `Kernel` and accessed via `&mut self`. No state machine, no TTL,
-/// no kind discrimination — `FlowInterrupt` self-describes via its
-/// `message` / `question` / `data` fields.
-#[derive(Debug, Default)]
+/// Held by `Kernel` and accessed via `&mut self`. No state machine and no
+/// TTL of its own: the orchestrator notices an expired interrupt and the
+/// kernel reports it here via `expire`.
+#[derive(Debug)]
 pub struct InterruptService {
     pending: HashMap<InterruptId, PendingInterrupt>,
-    resolved: HashMap<InterruptId, InterruptResponse>,
+    /// Resolved responses, keyed by interrupt id with the owning user kept
+    /// for `purge_user` and the resolution time for `drop_expired_resolved`.
+    resolved: HashMap<InterruptId, (UserId, DateTime<Utc>, InterruptResponse)>,
+    /// How long resolved responses are kept; `None` keeps them until purged.
+    retention: Option<chrono::Duration>,
+    /// Outstanding single-use resolution tokens.
+    tokens: HashMap<String, ResolutionToken>,
+    counters: HashMap<(InterruptKind, String), InterruptCounters>,
+    started_at: DateTime<Utc>,
+}
+
+impl Default for InterruptService {
+    fn default() -> Self {
+        Self {
+            pending: HashMap::new(),
+            resolved: HashMap::new(),
+            retention: None,
+            tokens: HashMap::new(),
+            counters: HashMap::new(),
+            started_at: Utc::now(),
+        }
+    }
 }
 
 impl InterruptService {
@@ -45,8 +127,10 @@ impl InterruptService {
         user_id: &UserId,
         session_id: &SessionId,
         envelope_id: &EnvelopeId,
+        pipeline: &str,
     ) {
         let id = interrupt.id.clone();
+        self.counters.entry((interrupt.kind(), pipeline.to_string())).or_default().created += 1;
         self.pending.insert(
             id,
             PendingInterrupt {
@@ -55,11 +139,17 @@ impl InterruptService {
                 user_id: user_id.clone(),
                 session_id: session_id.clone(),
                 envelope_id: envelope_id.clone(),
+                pipeline: pipeline.to_string(),
                 registered_at: Utc::now(),
             },
         );
     }
 
+    /// Count an interrupt refused by its workflow's interrupt limit.
+    pub fn record_limited(&mut self, kind: InterruptKind, pipeline: &str) {
+        self.counters.entry((kind, pipeline.to_string())).or_default().limited += 1;
+    }
+
     /// Resolve a pending interrupt with the consumer's response.
     /// Returns true if `interrupt_id` was registered.
     pub fn resolve(
@@ -67,14 +157,108 @@ impl InterruptService {
         interrupt_id: &str,
         response: InterruptResponse,
     ) -> bool {
-        if self.pending.remove(interrupt_id).is_some() {
-            self.resolved.insert(InterruptId::must(interrupt_id), response);
+        if let Some(pending) = self.pending.remove(interrupt_id) {
+            let elapsed_ms = (Utc::now() - pending.registered_at).num_milliseconds().max(0);
+            let counters = self.counters_for(&pending);
+            counters.resolved += 1;
+            if counters.resolution_ms.len() == RESOLUTION_SAMPLES {
+                counters.resolution_ms.pop_front();
+            }
+            counters.resolution_ms.push_back(elapsed_ms);
+            self.resolved.insert(InterruptId::must(interrupt_id), (pending.user_id, Utc::now(), response));
+            self.tokens.retain(|_, token| token.interrupt_id.as_str() != interrupt_id);
             true
         } else {
             false
         }
     }
 
+    /// Drop a pending interrupt whose `expires_at` passed before anyone
+    /// answered, counting it as expired. Returns true if it was pending.
+    pub fn expire(&mut self, interrupt_id: &str) -> bool {
+        let Some(pending) = self.pending.remove(interrupt_id) else {
+            return false;
+        };
+        self.counters_for(&pending).expired += 1;
+        self.tokens.retain(|_, token| token.interrupt_id.as_str() != interrupt_id);
+        true
+    }
+
+    /// Drop a pending interrupt whose run ended without an answer. Not
+    /// counted as resolved or expired.
+    pub fn abandon(&mut self, interrupt_id: &str) {
+        if self.pending.remove(interrupt_id).is_some() {
+            self.tokens.retain(|_, token| token.interrupt_id.as_str() != interrupt_id);
+        }
+    }
+
+    fn counters_for(&mut self, pending: &PendingInterrupt) -> &mut InterruptCounters {
+        self.counters
+            .entry((pending.interrupt.kind(), pending.pipeline.clone()))
+            .or_default()
+    }
+
+    /// Per-(kind, pipeline) metrics as of `now`, sorted by pipeline then kind.
+    pub fn stats(&self, now: DateTime<Utc>) -> Vec<InterruptStats> {
+        let mut ages: HashMap<(InterruptKind, &str), Vec<i64>> = HashMap::new();
+        for pending in self.pending.values() {
+            let age_ms = (now - pending.registered_at).num_milliseconds().max(0);
+            ages.entry((pending.interrupt.kind(), pending.pipeline.as_str())).or_default().push(age_ms);
+        }
+        let hours = ((now - self.started_at).num_milliseconds().max(1) as f64) / 3_600_000.0;
+
+        let mut stats: Vec<InterruptStats> = self
+            .counters
+            .iter()
+            .map(|((kind, pipeline), counters)| {
+                let mut pending_ages = ages.remove(&(*kind, pipeline.as_str())).unwrap_or_default();
+                pending_ages.sort_unstable();
+                let mut resolutions: Vec<i64> = counters.resolution_ms.iter().copied().collect();
+                resolutions.sort_unstable();
+                let finished = counters.resolved + counters.expired;
+                InterruptStats {
+                    kind: *kind,
+                    pipeline: pipeline.clone(),
+                    created: counters.created,
+                    created_per_hour: counters.created as f64 / hours,
+                    resolved: counters.resolved,
+                    expired: counters.expired,
+                    expiry_rate: if finished == 0 { 0.0 } else { counters.expired as f64 / finished as f64 },
+                    limited: counters.limited,
+                    median_resolution_ms: percentile(&resolutions, 50),
+                    pending: pending_ages.len(),
+                    pending_age_p50_ms: percentile(&pending_ages, 50),
+                    pending_age_p90_ms: percentile(&pending_ages, 90),
+                    pending_age_max_ms: pending_ages.last().copied(),
+                }
+            })
+            .collect();
+        stats.sort_by(|a, b| a.pipeline.cmp(&b.pipeline).then_with(|| a.kind.cmp(&b.kind)));
+        stats
+    }
+
+    /// Mint a single-use token that resolves `interrupt_id` with `approved`.
+    /// `None` if the interrupt is not pending.
+    pub fn issue_token(&mut self, run_id: &RunId, interrupt_id: &str, approved: bool) -> Option<String> {
+        let pending = self.pending.get(interrupt_id)?;
+        let token = format!("irt_{}", uuid::Uuid::new_v4().simple());
+        self.tokens.insert(
+            token.clone(),
+            ResolutionToken {
+                run_id: run_id.clone(),
+                interrupt_id: pending.interrupt.id.clone(),
+                approved,
+            },
+        );
+        Some(token)
+    }
+
+    /// Consume `token`. `None` if it was never issued, already used, or its
+    /// interrupt has been resolved.
+    pub fn redeem_token(&mut self, token: &str) -> Option<ResolutionToken> {
+        self.tokens.remove(token)
+    }
+
     /// Look up a pending interrupt by id.
     pub fn get_pending(&self, interrupt_id: &str) -> Option<&PendingInterrupt> {
         self.pending.get(interrupt_id)
@@ -82,13 +266,48 @@ impl InterruptService {
 
     /// Look up a resolved response by id.
     pub fn get_response(&self, interrupt_id: &str) -> Option<&InterruptResponse> {
-        self.resolved.get(interrupt_id)
+        self.resolved.get(interrupt_id).map(|(_, _, response)| response)
     }
 
     /// Number of currently pending interrupts.
     pub fn pending_count(&self) -> usize {
         self.pending.len()
     }
+
+    /// Drop every pending and resolved interrupt owned by `user_id`.
+    /// Returns the number removed.
+    pub fn purge_user(&mut self, user_id: &UserId) -> usize {
+        let before = self.pending.len() + self.resolved.len();
+        self.pending.retain(|_, p| &p.user_id != user_id);
+        self.resolved.retain(|_, (owner, _, _)| owner != user_id);
+        let pending = &self.pending;
+        self.tokens.retain(|_, token| pending.contains_key(&token.interrupt_id));
+        before - self.pending.len() - self.resolved.len()
+    }
+
+    pub fn set_retention(&mut self, retention: Option<chrono::Duration>) {
+        self.retention = retention;
+    }
+
+    /// Drop resolved responses older than the retention window at `now`.
+    /// Returns the number removed; none without a window.
+    pub fn drop_expired_resolved(&mut self, now: DateTime<Utc>) -> usize {
+        let Some(cutoff) = self.retention.and_then(|retention| now.checked_sub_signed(retention)) else {
+            return 0;
+        };
+        let before = self.resolved.len();
+        self.resolved.retain(|_, (_, resolved_at, _)| *resolved_at > cutoff);
+        before - self.resolved.len()
+    }
+}
+
+/// Nearest-rank percentile of an ascending slice.
+pub(crate) fn percentile(sorted: &[i64], pct: usize) -> Option<i64> {
+    if sorted.is_empty() {
+        return None;
+    }
+    let rank = (pct * sorted.len()).div_ceil(100).max(1);
+    Some(sorted[rank - 1])
 }
 
 #[cfg(test)]
@@ -121,6 +340,7 @@ mod tests {
             &UserId::must("user"),
             &SessionId::must("sess"),
             &EnvelopeId::must("env"),
+            "pipe",
         );
         assert_eq!(svc.pending_count(), 1);
         assert!(svc.get_pending(id.as_str()).is_some());
@@ -130,6 +350,145 @@ mod tests {
         assert!(svc.get_response(id.as_str()).is_some());
     }
 
+    #[test]
+    fn resolved_responses_age_out_after_retention() {
+        let mut svc = InterruptService::new();
+        let interrupt = make_interrupt();
+        let id = interrupt.id.clone();
+        svc.register_flow_interrupt(
+            interrupt,
+            &RequestId::must("req"),
+            &UserId::must("alice"),
+            &SessionId::must("sess"),
+            &EnvelopeId::must("env"),
+            "pipe",
+        );
+        svc.resolve(id.as_str(), make_response());
+
+        let later = Utc::now() + chrono::TimeDelta::seconds(120);
+        assert_eq!(svc.drop_expired_resolved(later), 0, "no window, kept");
+        svc.set_retention(Some(chrono::TimeDelta::seconds(300)));
+        assert_eq!(svc.drop_expired_resolved(later), 0, "inside the window");
+        assert!(svc.get_response(id.as_str()).is_some());
+        svc.set_retention(Some(chrono::TimeDelta::seconds(60)));
+        assert_eq!(svc.drop_expired_resolved(later), 1);
+        assert!(svc.get_response(id.as_str()).is_none());
+    }
+
+    #[test]
+    fn purge_user_drops_pending_and_resolved() {
+        let mut svc = InterruptService::new();
+        let register = |svc: &mut InterruptService, user: &str| {
+            let interrupt = make_interrupt();
+            let id = interrupt.id.clone();
+            svc.register_flow_interrupt(
+                interrupt,
+                &RequestId::must("req"),
+                &UserId::must(user),
+                &SessionId::must("sess"),
+                &EnvelopeId::must("env"),
+                "pipe",
+            );
+            id
+        };
+        let resolved = register(&mut svc, "alice");
+        svc.resolve(resolved.as_str(), make_response());
+        register(&mut svc, "alice");
+        let kept = register(&mut svc, "bob");
+
+        assert_eq!(svc.purge_user(&UserId::must("alice")), 2);
+        assert!(svc.get_response(resolved.as_str()).is_none());
+        assert_eq!(svc.pending_count(), 1);
+        assert!(svc.get_pending(kept.as_str()).is_some());
+    }
+
+    #[test]
+    fn tokens_are_single_use_and_die_with_the_interrupt() {
+        let mut svc = InterruptService::new();
+        let interrupt = make_interrupt();
+        let id = interrupt.id.clone();
+        let run_id = RunId::must("run");
+        assert!(svc.issue_token(&run_id, id.as_str(), true).is_none(), "not pending yet");
+        svc.register_flow_interrupt(
+            interrupt,
+            &RequestId::must("req"),
+            &UserId::must("user"),
+            &SessionId::must("sess"),
+            &EnvelopeId::must("env"),
+            "pipe",
+        );
+
+        let approve = svc.issue_token(&run_id, id.as_str(), true).unwrap();
+        let reject = svc.issue_token(&run_id, id.as_str(), false).unwrap();
+        assert_ne!(approve, reject);
+        let redeemed = svc.redeem_token(&approve).unwrap();
+        assert_eq!(redeemed.interrupt_id, id);
+        assert!(redeemed.approved);
+        assert!(svc.redeem_token(&approve).is_none(), "single use");
+
+        assert!(svc.resolve(id.as_str(), make_response()));
+        assert!(svc.redeem_token(&reject).is_none(), "sibling revoked on resolve");
+    }
+
+    #[test]
+    fn stats_break_down_by_kind_and_pipeline() {
+        let mut svc = InterruptService::new();
+        let register = |svc: &mut InterruptService, interrupt: FlowInterrupt, pipeline: &str| {
+            let id = interrupt.id.clone();
+            svc.register_flow_interrupt(
+                interrupt,
+                &RequestId::must("req"),
+                &UserId::must("user"),
+                &SessionId::must("sess"),
+                &EnvelopeId::must("env"),
+                pipeline,
+            );
+            id
+        };
+        let answered = register(&mut svc, make_interrupt(), "deploy");
+        let lapsed = register(&mut svc, make_interrupt(), "deploy");
+        register(&mut svc, make_interrupt(), "deploy");
+        register(&mut svc, FlowInterrupt::new().with_question("Which region?".into()), "deploy");
+        register(&mut svc, make_interrupt(), "triage");
+
+        assert!(svc.resolve(answered.as_str(), make_response()));
+        assert!(svc.expire(lapsed.as_str()));
+        assert!(!svc.expire(answered.as_str()), "already resolved");
+
+        let later = Utc::now() + chrono::Duration::seconds(60);
+        let stats = svc.stats(later);
+        let keys: Vec<(&str, InterruptKind)> = stats.iter().map(|s| (s.pipeline.as_str(), s.kind)).collect();
+        assert_eq!(
+            keys,
+            [
+                ("deploy", InterruptKind::Confirmation),
+                ("deploy", InterruptKind::Question),
+                ("triage", InterruptKind::Confirmation),
+            ]
+        );
+
+        let deploy = &stats[0];
+        assert_eq!((deploy.created, deploy.resolved, deploy.expired, deploy.pending), (3, 1, 1, 1));
+        assert!((deploy.expiry_rate - 0.5).abs() < 1e-9);
+        assert!(deploy.median_resolution_ms.is_some());
+        assert!(deploy.pending_age_p50_ms.unwrap() >= 60_000);
+        assert_eq!(deploy.pending_age_p50_ms, deploy.pending_age_max_ms);
+        assert!(deploy.created_per_hour > 0.0);
+
+        let question = &stats[1];
+        assert_eq!((question.created, question.pending, question.median_resolution_ms), (1, 1, None));
+        assert_eq!(question.expiry_rate, 0.0);
+    }
+
+    #[test]
+    fn percentile_is_nearest_rank() {
+        let sorted: Vec<i64> = (1..=10).collect();
+        assert_eq!(percentile(&sorted, 50), Some(5));
+        assert_eq!(percentile(&sorted, 90), Some(9));
+        assert_eq!(percentile(&[7], 90), Some(7));
+        assert_eq!(percentile(&[], 50), None);
+    }
+
     #[test]
     fn resolve_unknown_returns_false() {
         let mut svc = InterruptService::new();
@@ -0,0 +1,395 @@
+//! Worker-pull dispatch. Instead of one driver per run, a pool of identical
+//! workers calls `claim_next_instruction`; each claimed `RunAgent` is leased
+//! to one worker until it reports the result or the lease lapses. A lapsed
+//! lease makes the run claimable again (visibility timeout); expiry is
+//! checked at claim time, so no background sweep is needed.
+
+use std::collections::HashMap;
+
+use chrono::{DateTime, Utc};
+
+use crate::kernel::protocol::Instruction;
+use crate::types::{Error, Result, RunId};
+
+/// Instruction handed to a pulling worker.
+#[derive(Debug, Clone)]
+pub struct Claim {
+    pub run_id: RunId,
+    pub instruction: Instruction,
+    /// Set for `RunAgent`. Report the result (or `renew_lease`) before this
+    /// passes; afterwards another worker may be handed the same stage.
+    pub lease_expires_at: Option<DateTime<Utc>>,
+}
+
+#[derive(Debug, Clone)]
+struct Lease {
+    worker_id: String,
+    expires_at: DateTime<Utc>,
+}
+
+/// Expiry of a lease of `lease_seconds` taken at `now`. `INVALID_ARGUMENT`
+/// when it falls outside chrono's date range.
+pub fn lease_expiry(now: DateTime<Utc>, lease_seconds: u64) -> Result<DateTime<Utc>> {
+    i64::try_from(lease_seconds)
+        .ok()
+        .and_then(chrono::Duration::try_seconds)
+        .and_then(|lease| now.checked_add_signed(lease))
+        .ok_or_else(|| Error::validation(format!("lease_seconds {} is out of range", lease_seconds)))
+}
+
+/// In-flight leases by run.
+#[derive(Debug, Default)]
+pub struct LeaseTable {
+    leases: HashMap<RunId, Lease>,
+}
+
+impl LeaseTable {
+    /// A run is held while its lease has not expired.
+    pub fn is_held(&self, run_id: &RunId, now: DateTime<Utc>) -> bool {
+        self.leases.get(run_id).is_some_and(|lease| lease.expires_at > now)
+    }
+
+    /// The run's lease ran out without a report or release: the worker
+    /// holding it may or may not have executed the stage.
+    pub fn is_lapsed(&self, run_id: &RunId, now: DateTime<Utc>) -> bool {
+        self.leases.get(run_id).is_some_and(|lease| lease.expires_at <= now)
+    }
+
+    pub fn grant(&mut self, run_id: RunId, worker_id: &str, expires_at: DateTime<Utc>) -> DateTime<Utc> {
+        self.leases.insert(
+            run_id,
+            Lease {
+                worker_id: worker_id.to_string(),
+                expires_at,
+            },
+        );
+        expires_at
+    }
+
+    /// Extend `worker_id`'s live lease. Fails once the lease has lapsed or
+    /// belongs to someone else — the worker no longer owns the stage.
+    pub fn renew(&mut self, run_id: &RunId, worker_id: &str, lease_seconds: u64) -> Result<DateTime<Utc>> {
+        let now = Utc::now();
+        let expires_at = lease_expiry(now, lease_seconds)?;
+        match self.leases.get_mut(run_id) {
+            Some(lease) if lease.worker_id == worker_id && lease.expires_at > now => {
+                lease.expires_at = expires_at;
+                Ok(lease.expires_at)
+            }
+            _ => Err(Error::state_transition(format!(
+                "Worker '{}' holds no live lease on run {}",
+                worker_id, run_id
+            ))),
+        }
+    }
+
+    pub fn release(&mut self, run_id: &RunId) {
+        self.leases.remove(run_id);
+    }
+
+    /// Release the run's lease for a result reported by `worker_id`.
+    /// `FAILED_PRECONDITION` while another worker holds a live lease: the
+    /// report is stale (its own lease lapsed and the stage was re-claimed).
+    pub fn release_if_held_by(&mut self, run_id: &RunId, worker_id: &str, now: DateTime<Utc>) -> Result<()> {
+        if let Some(lease) = self.leases.get(run_id) {
+            if lease.worker_id != worker_id && lease.expires_at > now {
+                return Err(Error::state_transition(format!(
+                    "Run {} is leased to worker '{}'; result from '{}' is stale",
+                    run_id, lease.worker_id, worker_id
+                )));
+            }
+        }
+        self.leases.remove(run_id);
+        Ok(())
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use crate::kernel::protocol::Instruction;
+    use crate::kernel::test_helpers::{create_test_run, create_test_workflow};
+    use crate::kernel::Kernel;
+    use crate::run::WorkerIdentity;
+    use crate::types::RunId;
+
+    fn kernel_with_sessions(ids: &[&str]) -> Kernel {
+        let mut kernel = Kernel::new();
+        for id in ids {
+            let _state = kernel
+                .initialize_orchestration(RunId::must(*id), create_test_workflow(), create_test_run(), false)
+                .unwrap();
+        }
+        kernel
+    }
+
+    fn claimed_agent(kernel: &mut Kernel, worker: &str, capabilities: &[&str]) -> Option<(RunId, String)> {
+        let capabilities: Vec<String> = capabilities.iter().map(|c| c.to_string()).collect();
+        kernel
+            .claim_next_instruction(&WorkerIdentity::new(worker, "test", ""), &capabilities, 60)
+            .unwrap()
+            .map(|claim| match claim.instruction {
+                Instruction::RunAgent { agent, .. } => (claim.run_id, agent),
+                other => panic!("expected RunAgent, got {:?}", other),
+            })
+    }
+
+    #[test]
+    fn leased_runs_are_hidden_until_reported() {
+        let mut kernel = kernel_with_sessions(&["r1", "r2"]);
+
+        let (first, agent) = claimed_agent(&mut kernel, "w1", &[]).unwrap();
+        assert_eq!(agent, "agent1");
+        let (second, _) = claimed_agent(&mut kernel, "w2", &[]).unwrap();
+        assert_ne!(first, second);
+        assert!(claimed_agent(&mut kernel, "w3", &[]).is_none());
+
+        kernel
+            .process_agent_result(&first, "agent1", &WorkerIdentity::new("w1", "test", ""), serde_json::json!({}), None, Default::default(), true, "", false)
+            .unwrap();
+        assert_eq!(claimed_agent(&mut kernel, "w3", &[]), Some((first, "agent2".to_string())));
+    }
+
+    #[test]
+    fn capabilities_filter_by_current_agent() {
+        let mut kernel = kernel_with_sessions(&["r1"]);
+        assert!(claimed_agent(&mut kernel, "w1", &["agent2"]).is_none());
+        assert!(claimed_agent(&mut kernel, "w1", &["agent1", "agent2"]).is_some());
+    }
+
+    #[test]
+    fn lapsed_lease_is_reclaimable_and_not_renewable() {
+        let mut kernel = kernel_with_sessions(&["r1"]);
+        let claim = kernel.claim_next_instruction(&WorkerIdentity::new("w1", "test", ""), &[], 0).unwrap().unwrap();
+        assert!(kernel.renew_lease(&claim.run_id, "w1", 60).is_err());
+
+        let (run_id, _) = claimed_agent(&mut kernel, "w2", &[]).unwrap();
+        assert_eq!(run_id, claim.run_id);
+        assert!(kernel.renew_lease(&run_id, "w2", 60).is_ok());
+        let err = kernel.renew_lease(&run_id, "w1", 60).unwrap_err();
+        assert_eq!(err.to_error_code(), "FAILED_PRECONDITION");
+    }
+
+    #[test]
+    fn stale_report_after_reclaim_is_rejected() {
+        let mut kernel = kernel_with_sessions(&["r1"]);
+        let w1 = WorkerIdentity::new("w1", "test", "");
+        let claim = kernel.claim_next_instruction(&w1, &[], 0).unwrap().unwrap();
+        let (run_id, _) = claimed_agent(&mut kernel, "w2", &[]).unwrap();
+        assert_eq!(run_id, claim.run_id);
+
+        let err = kernel
+            .process_agent_result(&run_id, "agent1", &w1, serde_json::json!({}), None, Default::default(), true, "", false)
+            .unwrap_err();
+        assert_eq!(err.to_error_code(), "FAILED_PRECONDITION");
+        assert!(kernel.renew_lease(&run_id, "w2", 60).is_ok(), "w2 still holds the stage");
+
+        kernel
+            .process_agent_result(&run_id, "agent1", &WorkerIdentity::new("w2", "test", ""), serde_json::json!({}), None, Default::default(), true, "", false)
+            .unwrap();
+        assert_eq!(claimed_agent(&mut kernel, "w3", &[]), Some((run_id, "agent2".to_string())));
+    }
+
+    #[test]
+    fn out_of_range_lease_seconds_are_rejected() {
+        let mut kernel = kernel_with_sessions(&["r1"]);
+        let err = kernel
+            .claim_next_instruction(&WorkerIdentity::new("w1", "test", ""), &[], u64::MAX)
+            .unwrap_err();
+        assert_eq!(err.to_error_code(), "INVALID_ARGUMENT");
+        let (run_id, _) = claimed_agent(&mut kernel, "w1", &[]).unwrap();
+        let err = kernel.renew_lease(&run_id, "w1", u64::MAX).unwrap_err();
+        assert_eq!(err.to_error_code(), "INVALID_ARGUMENT");
+    }
+
+    #[test]
+    fn delivery_semantics_govern_redispatch_after_lapse() {
+        let mut kernel = Kernel::new();
+        let mut once = create_test_workflow();
+        once.stages[0].delivery = crate::workflow::DeliverySemantics::AtMostOnce;
+        let _state = kernel
+            .initialize_orchestration(RunId::must("once"), once, create_test_run(), false)
+            .unwrap();
+        let _state = kernel
+            .initialize_orchestration(RunId::must("many"), create_test_workflow(), create_test_run(), false)
+            .unwrap();
+        let worker = WorkerIdentity::new("w1", "test", "");
+        let key = |claim: &super::Claim| match &claim.instruction {
+            Instruction::RunAgent { context, .. } => context.idempotency_key.clone(),
+            other => panic!("expected RunAgent, got {:?}", other),
+        };
+
+        let first = kernel.claim_next_instruction(&worker, &[], 0).unwrap().unwrap();
+        let second = kernel.claim_next_instruction(&worker, &[], 0).unwrap().unwrap();
+        let (once_claim, many_claim) = if first.run_id.as_str() == "once" { (first, second) } else { (second, first) };
+        assert_eq!(key(&once_claim), None);
+        assert_eq!(key(&many_claim).as_deref(), Some("many:stage1:0"));
+
+        // Both leases lapsed: the at-least-once stage is handed out again
+        // under the same key; the at-most-once run ends instead.
+        let mut seen = Vec::new();
+        for _ in 0..2 {
+            let claim = kernel.claim_next_instruction(&worker, &[], 60).unwrap().unwrap();
+            seen.push(claim.run_id.clone());
+            match (claim.run_id.as_str(), &claim.instruction) {
+                ("many", Instruction::RunAgent { .. }) => assert_eq!(key(&claim).as_deref(), Some("many:stage1:0")),
+                ("once", Instruction::Terminate { reason, .. }) => {
+                    assert_eq!(*reason, crate::run::TerminalReason::DeliveryAmbiguous)
+                }
+                (run_id, other) => panic!("unexpected {} {:?}", run_id, other),
+            }
+        }
+        assert_ne!(seen[0], seen[1]);
+    }
+
+    #[test]
+    fn demoted_runs_are_claimed_last() {
+        let mut kernel = Kernel::with_quota(Some(crate::kernel::ResourceQuota {
+            max_llm_calls: 10,
+            ..Default::default()
+        }));
+        let policy = crate::kernel::DemotionPolicy {
+            thresholds: [("llm_calls".to_string(), 70.0)].into_iter().collect(),
+            ..Default::default()
+        };
+        kernel.set_demotion_policy(Some(policy)).unwrap();
+        for id in ["heavy", "light"] {
+            let mut run = create_test_run();
+            kernel.admit_run(&RunId::must(id), &mut run).unwrap();
+            let _state = kernel
+                .initialize_orchestration(RunId::must(id), create_test_workflow(), run, false)
+                .unwrap();
+        }
+        let (first, _) = claimed_agent(&mut kernel, "w1", &[]).unwrap();
+        let _ = claimed_agent(&mut kernel, "w2", &[]).unwrap();
+        let report = |kernel: &mut Kernel, id: &str, llm_calls: i32| {
+            let run_id = RunId::must(id);
+            let worker = WorkerIdentity::new(if run_id == first { "w1" } else { "w2" }, "test", "");
+            let metrics = crate::kernel::orchestrator::AgentExecutionMetrics { llm_calls, ..Default::default() };
+            kernel
+                .process_agent_result(&run_id, "agent1", &worker, serde_json::json!({}), None, metrics, true, "", false)
+                .unwrap();
+        };
+        report(&mut kernel, "heavy", 8);
+        report(&mut kernel, "light", 1);
+
+        // `heavy` was served longer ago but sits at 80% of its LLM budget.
+        assert_eq!(claimed_agent(&mut kernel, "w1", &[]), Some((RunId::must("light"), "agent2".to_string())));
+        assert_eq!(claimed_agent(&mut kernel, "w2", &[]), Some((RunId::must("heavy"), "agent2".to_string())));
+        assert!(kernel.lifecycle.get(&RunId::must("heavy")).unwrap().demoted_at.is_some());
+        let demoted = &kernel.runs.get(&RunId::must("heavy")).unwrap().audit.metadata["demoted"];
+        assert_eq!(demoted["dimension"], "llm_calls");
+        assert_eq!(kernel.get_system_status().demoted_runs, 1);
+
+        let bad = crate::kernel::DemotionPolicy {
+            thresholds: [("tokens_in".to_string(), 0.0)].into_iter().collect(),
+            ..Default::default()
+        };
+        assert!(kernel.set_demotion_policy(Some(bad)).is_err());
+    }
+
+    #[test]
+    fn demoted_runs_age_back_in_after_max_wait() {
+        let mut kernel = Kernel::with_quota(Some(crate::kernel::ResourceQuota {
+            max_llm_calls: 10,
+            ..Default::default()
+        }));
+        let mut policy = crate::kernel::DemotionPolicy {
+            thresholds: [("llm_calls".to_string(), 70.0)].into_iter().collect(),
+            max_wait_seconds: Some(60),
+            ..Default::default()
+        };
+        kernel.set_demotion_policy(Some(policy.clone())).unwrap();
+        for id in ["heavy", "light"] {
+            let mut run = create_test_run();
+            kernel.admit_run(&RunId::must(id), &mut run).unwrap();
+            let _state = kernel
+                .initialize_orchestration(RunId::must(id), create_test_workflow(), run, false)
+                .unwrap();
+        }
+        let metrics = crate::kernel::orchestrator::AgentExecutionMetrics { llm_calls: 8, ..Default::default() };
+        kernel
+            .process_agent_result(&RunId::must("heavy"), "agent1", &WorkerIdentity::in_process(), serde_json::json!({}), None, metrics, true, "", false)
+            .unwrap();
+        assert!(kernel.lifecycle.get(&RunId::must("heavy")).unwrap().demoted_at.is_some());
+
+        // Idle for two minutes: it has waited out its demotion.
+        let waited = chrono::Utc::now() - chrono::Duration::seconds(120);
+        kernel.orchestrator.sessions.get_mut(&RunId::must("heavy")).unwrap().last_activity_at = waited;
+        assert_eq!(claimed_agent(&mut kernel, "w1", &[]), Some((RunId::must("heavy"), "agent2".to_string())));
+
+        policy.max_wait_seconds = Some(0);
+        assert!(kernel.set_demotion_policy(Some(policy.clone())).is_err());
+        policy.max_wait_seconds = Some(u64::MAX);
+        assert!(kernel.set_demotion_policy(Some(policy)).is_err());
+    }
+
+    #[test]
+    fn paused_scheduling_hands_out_nothing() {
+        let mut kernel = kernel_with_sessions(&["r1"]);
+        kernel.pause_scheduling();
+        assert!(claimed_agent(&mut kernel, "w1", &[]).is_none());
+        kernel.resume_scheduling();
+        assert!(claimed_agent(&mut kernel, "w1", &[]).is_some());
+    }
+
+    #[test]
+    fn claims_and_reports_respect_allowed_regions() {
+        let mut kernel = Kernel::new();
+        let mut eu_only = create_test_workflow();
+        eu_only.allowed_regions = vec!["eu-west".to_string()];
+        let _state = kernel
+            .initialize_orchestration(RunId::must("eu"), eu_only, create_test_run(), false)
+            .unwrap();
+        let mut tenant_run = create_test_run();
+        tenant_run.audit.metadata.insert("allowed_regions".to_string(), serde_json::json!(["us-east"]));
+        let _state = kernel
+            .initialize_orchestration(RunId::must("us"), create_test_workflow(), tenant_run, false)
+            .unwrap();
+
+        let us_worker = WorkerIdentity::new("w-us", "test", "").with_region("us-east");
+        let claim = kernel.claim_next_instruction(&us_worker, &[], 60).unwrap().unwrap();
+        assert_eq!(claim.run_id, RunId::must("us"));
+        assert!(kernel.claim_next_instruction(&us_worker, &[], 60).unwrap().is_none());
+        let unplaced = WorkerIdentity::new("w-any", "test", "");
+        assert!(kernel.claim_next_instruction(&unplaced, &[], 60).unwrap().is_none());
+
+        // A result for the EU run reported from the wrong region ends it.
+        let _ = kernel.get_next_instruction(&RunId::must("eu")).unwrap();
+        kernel
+            .process_agent_result(&RunId::must("eu"), "agent1", &us_worker, serde_json::json!({}), None, Default::default(), true, "", false)
+            .unwrap();
+        let run = kernel.runs.get(&RunId::must("eu")).unwrap();
+        assert_eq!(run.terminal_reason(), Some(crate::run::TerminalReason::PolicyViolation));
+    }
+
+    #[test]
+    fn claims_skip_stages_needing_capabilities_the_worker_lacks() {
+        let mut kernel = Kernel::new();
+        let mut workflow = create_test_workflow();
+        workflow.stages[0].agent_config.required_capabilities = vec!["docker".into(), "repo-access".into()];
+        let _state = kernel
+            .initialize_orchestration(RunId::must("r1"), workflow, create_test_run(), false)
+            .unwrap();
+
+        let partial = WorkerIdentity::new("w-partial", "test", "").with_capabilities(["docker"]);
+        assert!(kernel.claim_next_instruction(&partial, &[], 60).unwrap().is_none());
+        let full = WorkerIdentity::new("w-full", "test", "").with_capabilities(["docker", "repo-access", "python3.11"]);
+        let claim = kernel.claim_next_instruction(&full, &[], 0).unwrap().unwrap();
+        match claim.instruction {
+            Instruction::RunAgent { context, .. } => {
+                assert_eq!(context.required_capabilities, ["docker", "repo-access"]);
+            }
+            other => panic!("expected RunAgent, got {:?}", other),
+        }
+
+        // Once the lease lapses, a result reported by a worker without them
+        // ends the run.
+        kernel
+            .process_agent_result(&RunId::must("r1"), "agent1", &partial, serde_json::json!({}), None, Default::default(), true, "", false)
+            .unwrap();
+        let run = kernel.runs.get(&RunId::must("r1")).unwrap();
+        assert_eq!(run.terminal_reason(), Some(crate::run::TerminalReason::PolicyViolation));
+        let message = run.termination.as_ref().and_then(|t| t.message.clone()).unwrap_or_default();
+        assert!(message.ends_with("stage 'stage1': repo-access"), "{}", message);
+    }
+}
@@ -4,8 +4,15 @@
 //! tool-confirmation interrupt stay in `Running`; the kernel doesn't have a
 //! dedicated waiting/blocked state for that case (the pending interrupt ID
 //! lives on `RunRecord::pending_interrupt`).
+//!
+//! With a zombie retention window set, terminated records stay queryable
+//! for that long before `reap_zombies` removes them; without one they are
+//! removed on termination.
+
+use std::collections::{BTreeMap, BTreeSet, HashMap};
+use std::ops::Bound;
 
-use std::collections::HashMap;
+use chrono::{DateTime, Utc};
 
 use crate::types::{Error, RunId, RequestId, Result, SessionId, UserId};
 
@@ -18,6 +25,16 @@ pub use super::types::{RunRecord, RunStatus, ResourceQuota};
 pub struct RunRegistry {
     default_quota: ResourceQuota,
     pub(crate) records: HashMap<RunId, RunRecord>,
+    /// `Ready` runs per user, oldest first. Kept in step with `records` so
+    /// `next_runnable` is O(log n) instead of a scan.
+    ready: BTreeMap<UserId, BTreeSet<(DateTime<Utc>, RunId)>>,
+    /// User served by the last `next_runnable` pick; the round-robin cursor.
+    last_scheduled_user: Option<UserId>,
+    /// While set, `next_runnable` hands out nothing. Creation and
+    /// termination are unaffected.
+    scheduling_paused: bool,
+    /// How long `Terminated` records are kept. Zero removes them at once.
+    zombie_retention: chrono::Duration,
 }
 
 impl RunRegistry {
@@ -25,6 +42,10 @@ impl RunRegistry {
         Self {
             default_quota: default_quota.unwrap_or_default(),
             records: HashMap::new(),
+            ready: BTreeMap::new(),
+            last_scheduled_user: None,
+            scheduling_paused: false,
+            zombie_retention: chrono::Duration::zero(),
         }
     }
 
@@ -43,6 +64,10 @@ impl RunRegistry {
         }
         let mut record = RunRecord::new(run_id.clone(), request_id, user_id, session_id);
         record.quota = quota.unwrap_or_else(|| self.default_quota.clone());
+        self.ready
+            .entry(record.user_id.clone())
+            .or_default()
+            .insert((record.created_at, run_id.clone()));
         self.records.insert(run_id, record.clone());
         Ok(record)
     }
@@ -51,28 +76,111 @@ impl RunRegistry {
     pub fn run(&mut self, run_id: &RunId) -> Result<()> {
         let record = self.records.get_mut(run_id)
             .ok_or_else(|| Error::not_found(format!("unknown run_id: {}", run_id)))?;
-        if record.state != RunStatus::Ready {
-            return Err(Error::state_transition(format!(
-                "cannot run {}: state is {:?}, expected Ready",
-                run_id, record.state
-            )));
-        }
-        record.start();
+        record.start()?;
+        let key = (record.created_at, record.run_id.clone());
+        let user_id = record.user_id.clone();
+        self.unqueue(&user_id, &key);
         Ok(())
     }
 
-    /// Terminate a run and remove its record from the map.
+    fn unqueue(&mut self, user_id: &UserId, key: &(DateTime<Utc>, RunId)) {
+        if let Some(queue) = self.ready.get_mut(user_id) {
+            queue.remove(key);
+            if queue.is_empty() {
+                self.ready.remove(user_id);
+            }
+        }
+    }
+
+    /// Pick the next `Ready` run and transition it to `Running`.
+    ///
+    /// Round-robin across users: the cursor advances to the next user (by
+    /// user id, wrapping) that has a `Ready` run, so one user submitting many
+    /// runs cannot starve another. Within a user, runs go oldest-first.
+    /// Returns `None` while scheduling is paused.
+    ///
+    /// Served from the `ready` index: O(log n) in the number of `Ready` runs.
+    pub fn next_runnable(&mut self) -> Option<RunId> {
+        if self.scheduling_paused {
+            return None;
+        }
+        loop {
+            let after_last = match &self.last_scheduled_user {
+                Some(last) => self.ready.range::<UserId, _>((Bound::Excluded(last), Bound::Unbounded)).next(),
+                None => None,
+            };
+            let user = after_last.or_else(|| self.ready.iter().next()).map(|(user, _)| user.clone())?;
+            let queue = self.ready.get_mut(&user)?;
+            let (_, run_id) = queue.pop_first()?;
+            if queue.is_empty() {
+                self.ready.remove(&user);
+            }
+            // Skip entries whose record left `Ready` behind the index's back.
+            let Some(record) = self.records.get_mut(&run_id) else {
+                continue;
+            };
+            if record.start().is_err() {
+                continue;
+            }
+            self.last_scheduled_user = Some(user);
+            return Some(run_id);
+        }
+    }
+
+    /// Stop (`true`) or resume (`false`) handing out work from `next_runnable`.
+    pub fn set_scheduling_paused(&mut self, paused: bool) {
+        self.scheduling_paused = paused;
+    }
+
+    pub fn is_scheduling_paused(&self) -> bool {
+        self.scheduling_paused
+    }
+
+    /// Terminate a run. Its record is kept as a zombie for the retention
+    /// window, or removed at once when there is none.
     /// Idempotent: if the run_id is unknown, returns Ok(()).
     pub fn terminate(&mut self, run_id: &RunId) -> Result<()> {
-        if let Some(record) = self.records.get_mut(run_id) {
-            if !record.state.is_terminal() {
-                record.complete();
-            }
+        let Some(record) = self.records.get_mut(run_id) else {
+            return Ok(());
+        };
+        if !record.state.is_terminal() {
+            record.complete()?;
+        }
+        let key = (record.created_at, record.run_id.clone());
+        let user_id = record.user_id.clone();
+        self.unqueue(&user_id, &key);
+        if self.zombie_retention <= chrono::Duration::zero() {
+            self.records.remove(run_id);
         }
-        self.records.remove(run_id);
         Ok(())
     }
 
+    /// Remove a run's record whatever its state.
+    pub fn remove(&mut self, run_id: &RunId) {
+        let _ = self.terminate(run_id);
+        self.records.remove(run_id);
+    }
+
+    pub fn set_zombie_retention(&mut self, retention: chrono::Duration) {
+        self.zombie_retention = retention;
+    }
+
+    /// Terminated records past the retention window (all of them when
+    /// `force`). Listed only; the caller removes them.
+    pub fn expired_zombies(&self, now: DateTime<Utc>, force: bool) -> Vec<RunId> {
+        self.records
+            .values()
+            .filter(|record| record.state.is_terminal())
+            .filter(|record| {
+                force
+                    || record.completed_at.map_or(true, |at| {
+                        at.checked_add_signed(self.zombie_retention).is_some_and(|expiry| expiry <= now)
+                    })
+            })
+            .map(|record| record.run_id.clone())
+            .collect()
+    }
+
     /// Get run record by ID.
     pub fn get(&self, run_id: &RunId) -> Option<&RunRecord> {
         self.records.get(run_id)
@@ -98,11 +206,29 @@ impl RunRegistry {
         self.records.values().filter(|r| r.state == state).count()
     }
 
+    /// Count non-terminated records per classifier label. A run with several
+    /// labels counts toward each; unlabeled runs are omitted.
+    pub fn count_active_by_label(&self) -> HashMap<String, usize> {
+        let mut counts = HashMap::new();
+        for record in self.records.values().filter(|r| r.state != RunStatus::Terminated) {
+            for label in &record.labels {
+                *counts.entry(label.clone()).or_insert(0) += 1;
+            }
+        }
+        counts
+    }
+
     /// Get the current default quota.
     pub fn get_default_quota(&self) -> &ResourceQuota {
         &self.default_quota
     }
 
+    /// Replace the quota given to records created from now on. Existing
+    /// records keep the quota they were created with.
+    pub fn set_default_quota(&mut self, quota: ResourceQuota) {
+        self.default_quota = quota;
+    }
+
     /// User IDs that have non-terminated runs.
     pub fn get_active_user_ids(&self) -> std::collections::HashSet<String> {
         self.records
@@ -170,6 +296,17 @@ mod tests {
         assert!(lm.run(&run_id).is_err(), "cannot run a Running run");
     }
 
+    #[test]
+    fn record_helpers_reject_invalid_transitions() {
+        let mut record = submit(&mut RunRegistry::default(), "p1");
+        record.complete().unwrap();
+        let err = record.start().unwrap_err();
+        assert_eq!(err.to_error_code(), "FAILED_PRECONDITION");
+        assert!(record.complete().is_err(), "Terminated is final");
+        assert_eq!(record.state, RunStatus::Terminated);
+        assert!(record.started_at.is_none());
+    }
+
     #[test]
     fn terminate_idempotent_on_missing() {
         let mut lm = RunRegistry::default();
@@ -189,6 +326,72 @@ mod tests {
         assert_eq!(lm.count_by_state(RunStatus::Running), 1);
     }
 
+    fn submit_for(lm: &mut RunRegistry, run_id: &str, user: &str) {
+        lm.create(
+            RunId::must(run_id),
+            RequestId::must(format!("req-{}", run_id)),
+            UserId::must(user),
+            SessionId::must(format!("sess-{}", run_id)),
+            None,
+        ).unwrap();
+    }
+
+    #[test]
+    fn next_runnable_interleaves_users() {
+        let mut lm = RunRegistry::default();
+        // alice floods the registry before bob submits anything.
+        for i in 0..3 {
+            submit_for(&mut lm, &format!("a{}", i), "alice");
+        }
+        submit_for(&mut lm, "b0", "bob");
+        submit_for(&mut lm, "b1", "bob");
+
+        let order: Vec<String> = std::iter::from_fn(|| lm.next_runnable())
+            .map(|id| id.as_str().to_string())
+            .collect();
+        assert_eq!(order, vec!["a0", "b0", "a1", "b1", "a2"]);
+        assert_eq!(lm.count_by_state(RunStatus::Running), 5);
+    }
+
+    #[test]
+    fn next_runnable_skips_non_ready_and_empty() {
+        let mut lm = RunRegistry::default();
+        assert!(lm.next_runnable().is_none());
+
+        submit_for(&mut lm, "x", "alice");
+        lm.run(&RunId::must("x")).unwrap();
+        assert!(lm.next_runnable().is_none(), "Running runs are not picked again");
+
+        submit_for(&mut lm, "y", "alice");
+        assert_eq!(lm.next_runnable().map(|id| id.as_str().to_string()), Some("y".to_string()));
+    }
+
+    #[test]
+    fn next_runnable_skips_terminated_and_manually_started() {
+        let mut lm = RunRegistry::default();
+        submit_for(&mut lm, "a0", "alice");
+        submit_for(&mut lm, "a1", "alice");
+        submit_for(&mut lm, "b0", "bob");
+        lm.terminate(&RunId::must("a0")).unwrap();
+        lm.run(&RunId::must("b0")).unwrap();
+
+        assert_eq!(lm.next_runnable(), Some(RunId::must("a1")));
+        assert!(lm.next_runnable().is_none());
+        assert!(lm.ready.is_empty(), "index drained alongside the records");
+    }
+
+    #[test]
+    fn paused_scheduling_hands_out_nothing() {
+        let mut lm = RunRegistry::default();
+        lm.set_scheduling_paused(true);
+        submit_for(&mut lm, "a1", "alice");
+        assert!(lm.next_runnable().is_none());
+        assert_eq!(lm.get(&RunId::must("a1")).unwrap().state, RunStatus::Ready);
+
+        lm.set_scheduling_paused(false);
+        assert_eq!(lm.next_runnable(), Some(RunId::must("a1")));
+    }
+
     #[test]
     fn active_user_ids_excludes_terminated() {
         let mut lm = RunRegistry::default();
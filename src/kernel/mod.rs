@@ -4,19 +4,26 @@
 //! message channel. Subsystems (lifecycle, resources, interrupts) are plain
 //! structs owned by the Kernel, not separate actors.
 
-use std::collections::HashMap;
+use std::collections::{BTreeMap, HashMap};
 
 pub mod actor;
+pub mod classify;
 pub mod handle;
 pub mod interrupts;
+pub mod leases;
 pub mod lifecycle;
+pub mod normalize;
 pub mod orchestrator;
 mod orchestrator_queries;
 mod orchestrator_session;
+pub mod precheck;
+pub mod profile;
 pub mod protocol;
 pub mod resources;
 pub mod routing;
 pub mod runner;
+pub mod search;
+pub mod templates;
 pub mod types;
 
 #[cfg(test)]
@@ -25,11 +32,18 @@ pub(crate) mod test_helpers;
 mod dispatch;
 
 // Re-export key types
-pub use interrupts::{InterruptService, PendingInterrupt};
+pub use dispatch::INTEGRATION_AGENT;
+pub use interrupts::{InterruptService, InterruptStats, PendingInterrupt, ResolutionToken};
+pub use leases::Claim;
 pub use lifecycle::RunRegistry;
-pub use resources::ResourceTracker;
+pub use precheck::InputTooLarge;
+pub use profile::{CommandProfile, CommandStats};
+pub use resources::{ResourceTracker, UsageBucket, UsageGranularity};
+pub use search::RunQuery;
+pub use templates::{CanaryStatus, ReplayOverrides, RunTemplate, RunTemplates, TemplateVersion, VersionStats};
 pub use types::{
-    RunRecord, RunStatus, QuotaViolation, ResourceQuota, ResourceUsage,
+    DemotionPolicy, RunRecord, RunStatus, QuotaField, QuotaRegeneration, QuotaViolation, ResourceQuota,
+    ResourceUsage,
 };
 
 use crate::run::Run;
@@ -105,6 +119,27 @@ pub struct Kernel {
 
     /// Tool subsystem (catalog, access, health).
     pub(crate) tools: ToolDomain,
+
+    /// Run classifier and per-label quota profiles.
+    pub(crate) classification: classify::Classification,
+
+    /// Input normalizers applied at session init.
+    pub(crate) normalization: normalize::Normalization,
+
+    /// Worker-pull leases on in-flight `RunAgent` instructions.
+    pub(crate) leases: leases::LeaseTable,
+
+    /// Named run templates.
+    pub(crate) templates: templates::RunTemplates,
+
+    /// Sizes run input for the submit-time token check.
+    pub(crate) token_estimator: std::sync::Arc<dyn crate::agent::tokens::TokenEstimator>,
+
+    /// Per-command actor time; `None` unless profiling is enabled.
+    pub(crate) command_profiler: Option<profile::CommandProfiler>,
+
+    /// Usage thresholds past which runs lose scheduling preference.
+    pub(crate) demotion: Option<DemotionPolicy>,
 }
 
 impl Kernel {
@@ -118,6 +153,13 @@ impl Kernel {
             tools: ToolDomain {
                 health: crate::tools::ToolHealthTracker::default(),
             },
+            classification: classify::Classification::default(),
+            normalization: normalize::Normalization::default(),
+            leases: leases::LeaseTable::default(),
+            templates: templates::RunTemplates::default(),
+            token_estimator: std::sync::Arc::new(crate::agent::tokens::CharRatioEstimator::default()),
+            command_profiler: None,
+            demotion: None,
         }
     }
 
@@ -126,6 +168,74 @@ impl Kernel {
         self.orchestrator.register_routing_fn(name, f);
     }
 
+    /// Register the classifier that labels runs at session init.
+    pub fn set_classifier(&mut self, classifier: std::sync::Arc<dyn classify::RunClassifier>) {
+        self.classification.set_classifier(classifier);
+    }
+
+    /// Append a step to the input normalization chain run at session init.
+    pub fn add_input_normalizer(&mut self, normalizer: std::sync::Arc<dyn normalize::InputNormalizer>) {
+        self.normalization.push(normalizer);
+    }
+
+    /// Replace the estimator behind the submit-time input check (default:
+    /// 4 characters per token). Use the same one the agents use.
+    pub fn set_token_estimator(&mut self, estimator: std::sync::Arc<dyn crate::agent::tokens::TokenEstimator>) {
+        self.token_estimator = estimator;
+    }
+
+    /// Record how long each command holds the actor (see `profile`). Off by
+    /// default: it costs a clock read and a map update per command.
+    pub fn enable_command_profiling(&mut self) {
+        self.command_profiler = Some(profile::CommandProfiler::new());
+    }
+
+    /// Register a named run template; `INVALID_ARGUMENT` if its workflow
+    /// does not validate.
+    pub fn add_run_template(&mut self, template: templates::RunTemplate) -> crate::types::Result<()> {
+        self.templates.insert(template)
+    }
+
+    /// Keep terminated runs (state `Terminated`, record and `Run`) queryable
+    /// for `retention` before they are reaped, so usage scrapers can read
+    /// them. Zero, the default, removes them on termination.
+    pub fn set_zombie_retention(&mut self, retention: std::time::Duration) {
+        self.lifecycle
+            .set_zombie_retention(chrono::Duration::from_std(retention).unwrap_or(chrono::TimeDelta::MAX));
+    }
+
+    /// Keep resolved interrupt responses for `retention` after they are
+    /// answered; `apply_retention` drops older ones. `None`, the default,
+    /// keeps them until `purge_user`.
+    pub fn set_interrupt_retention(&mut self, retention: Option<std::time::Duration>) {
+        self.interrupts
+            .set_retention(retention.map(|r| chrono::Duration::from_std(r).unwrap_or(chrono::TimeDelta::MAX)));
+    }
+
+    /// Demote runs whose usage crosses `policy`'s thresholds (see
+    /// `DemotionPolicy`); `None` turns demotion off. Runs already demoted
+    /// stay demoted. `INVALID_ARGUMENT` for non-positive thresholds.
+    pub fn set_demotion_policy(&mut self, policy: Option<DemotionPolicy>) -> crate::types::Result<()> {
+        if let Some(policy) = &policy {
+            policy.validate()?;
+        }
+        self.demotion = policy;
+        Ok(())
+    }
+
+    /// Quota applied to new run records carrying `label`. When a run has
+    /// several labels, the first one with a profile wins.
+    pub fn set_quota_profile(&mut self, label: impl Into<String>, quota: ResourceQuota) {
+        self.classification.set_quota_profile(label, quota);
+    }
+
+    /// Run template used by `instantiate_for_input` for inputs carrying
+    /// `label`. When the input has several labels, the first one with a
+    /// template wins.
+    pub fn set_label_template(&mut self, label: impl Into<String>, template: impl Into<String>) {
+        self.classification.set_label_template(label, template);
+    }
+
     /// Create a Kernel wired from a Config struct.
     pub fn from_config(config: &crate::Config) -> Self {
         let default_quota = ResourceQuota {
@@ -150,6 +260,13 @@ impl Kernel {
             tools: ToolDomain {
                 health: crate::tools::ToolHealthTracker::default(),
             },
+            classification: classify::Classification::default(),
+            normalization: normalize::Normalization::default(),
+            leases: leases::LeaseTable::default(),
+            templates: templates::RunTemplates::default(),
+            token_estimator: std::sync::Arc::new(crate::agent::tokens::CharRatioEstimator::default()),
+            command_profiler: None,
+            demotion: None,
         }
     }
 }
@@ -158,11 +275,45 @@ impl Kernel {
 #[derive(Debug, Clone)]
 pub struct RemainingBudget {
     pub llm_calls_remaining: i32,
+    pub tool_calls_remaining: i32,
     pub iterations_remaining: i32,
     pub agent_hops_remaining: i32,
     pub tokens_in_remaining: i64,
     pub tokens_out_remaining: i64,
+    /// Until the quota's `timeout_seconds` (hard: `check_quota` fails);
+    /// `f64::MAX` when the quota sets none.
     pub time_remaining_seconds: f64,
+    /// Until the run's deadline from `Workflow::max_duration_seconds`
+    /// (the run ends `TimeoutExceeded` at its next instruction); `None`
+    /// when the workflow sets none.
+    pub deadline_remaining_seconds: Option<f64>,
+    /// `u64::MAX` when the quota sets no `max_tool_bytes`.
+    pub tool_bytes_remaining: u64,
+    /// Percent of each bounded dimension used, keyed `llm_calls`,
+    /// `tool_calls`, `iterations`, `agent_hops`, `tokens_in`, `tokens_out`,
+    /// `time`, `deadline`, `tool_bytes`. Unbounded dimensions are absent.
+    pub percent_used: BTreeMap<&'static str, f64>,
+    /// Key of the highest `percent_used` entry: what will run out first.
+    pub most_constrained: Option<&'static str>,
+}
+
+/// What `Kernel::purge_user` removed for one user.
+#[derive(Debug, Clone, Default, PartialEq, Eq, serde::Serialize)]
+pub struct PurgeReport {
+    pub user_id: String,
+    /// Runs dropped along with their sessions and run records.
+    pub runs_removed: Vec<RunId>,
+    pub interrupts_removed: usize,
+    pub usage_removed: bool,
+}
+
+/// What one `Kernel::apply_retention` pass removed.
+#[derive(Debug, Clone, Default, PartialEq, Eq, serde::Serialize)]
+pub struct RetentionReport {
+    /// Terminated runs past the zombie retention window.
+    pub zombies_reaped: usize,
+    /// Resolved interrupt responses past the interrupt retention window.
+    pub interrupts_removed: usize,
 }
 
 /// Full system status snapshot returned by `Kernel::get_system_status()`.
@@ -171,6 +322,17 @@ pub struct SystemStatus {
     pub runs_total: usize,
     pub runs_by_state: HashMap<RunStatus, usize>,
     pub active_orchestration_sessions: usize,
+    /// Ready + running runs per classifier label — load per class of work.
+    pub active_runs_by_label: HashMap<String, usize>,
+    /// Set between `pause_scheduling` and `resume_scheduling`.
+    pub scheduling_paused: bool,
+    /// Tool-call bytes (arguments + results) recorded since start.
+    pub tool_bytes_total: u64,
+    /// Live runs demoted by the `DemotionPolicy`.
+    pub demoted_runs: usize,
+    /// Interrupt rates, resolution times, and pending ages per kind and
+    /// pipeline.
+    pub interrupts: Vec<interrupts::InterruptStats>,
 }
 
 impl Default for Kernel {
@@ -228,6 +390,20 @@ mod tests {
         assert_eq!(usage.tokens_out, 500);
     }
 
+    #[test]
+    fn test_user_usage_history_counts_runs() {
+        let mut kernel = Kernel::new();
+        kernel.create_run(RunId::must("r1"), RequestId::must("req1"), UserId::must("user1"), SessionId::must("sess1"), None).unwrap();
+        kernel.create_run(RunId::must("r2"), RequestId::must("req2"), UserId::must("user1"), SessionId::must("sess1"), None).unwrap();
+        kernel.record_user_usage("user1", 3, 5, 1000, 500);
+
+        let today = chrono::Utc::now().date_naive();
+        let history = kernel.get_user_usage_history("user1", UsageGranularity::Daily, today);
+        assert_eq!(history.len(), 1);
+        assert_eq!(history[0].runs, 2);
+        assert_eq!(history[0].llm_calls, 3);
+    }
+
     #[test]
     fn test_run_lifecycle_create_and_destroy() {
         let mut kernel = Kernel::new();
@@ -244,6 +420,125 @@ mod tests {
         assert_eq!(kernel.lifecycle.count(), 0);
     }
 
+    #[test]
+    fn test_purge_user_removes_only_that_user() {
+        let mut kernel = Kernel::new();
+        for (run, user) in [("a1", "alice"), ("a2", "alice"), ("b1", "bob")] {
+            let run_id = RunId::must(run);
+            let _state = kernel
+                .initialize_orchestration(
+                    run_id,
+                    crate::kernel::test_helpers::create_test_workflow(),
+                    crate::run::Run::new(user, "sess", "hi", None),
+                    false,
+                )
+                .unwrap();
+        }
+        kernel.record_user_usage("alice", 1, 0, 10, 5);
+
+        let report = kernel.purge_user(&UserId::must("alice"));
+        assert_eq!(report.runs_removed, vec![RunId::must("a1"), RunId::must("a2")]);
+        assert!(report.usage_removed);
+        assert!(kernel.resources.get_user_usage("alice").is_none());
+        assert!(kernel.runs.get(&RunId::must("a1")).is_none());
+        assert!(kernel.lifecycle.get(&RunId::must("a2")).is_none());
+        assert!(kernel.runs.get(&RunId::must("b1")).is_some());
+        assert_eq!(kernel.get_system_status().active_orchestration_sessions, 1);
+    }
+
+    #[test]
+    fn test_cleanup_stale_sessions_removes_run_records() {
+        let mut kernel = Kernel::new();
+        let run_id = RunId::must("idle");
+        let run = crate::kernel::test_helpers::create_test_run();
+        kernel.create_run(run_id.clone(), RequestId::must("req1"), UserId::must("user1"), SessionId::must("sess1"), None).unwrap();
+        let _state = kernel
+            .initialize_orchestration(run_id.clone(), crate::kernel::test_helpers::create_test_workflow(), run, false)
+            .unwrap();
+        if let Some(session) = kernel.orchestrator.get_session_mut(&run_id) {
+            session.last_activity_at = chrono::Utc::now() - chrono::TimeDelta::seconds(3600);
+        }
+
+        assert_eq!(kernel.list_stale_sessions(60).unwrap(), vec![run_id.clone()]);
+        assert_eq!(kernel.cleanup_stale_sessions(60).unwrap(), 1);
+        assert!(kernel.runs.get(&run_id).is_none());
+        assert!(kernel.lifecycle.get(&run_id).is_none());
+        assert_eq!(kernel.get_system_status().active_orchestration_sessions, 0);
+    }
+
+    #[test]
+    fn test_rejected_session_leaves_no_run_record() {
+        let mut kernel = Kernel::new();
+        let mut capped = crate::kernel::test_helpers::create_test_workflow();
+        capped.max_concurrent_sessions = Some(1);
+        for id in ["c1", "c2"] {
+            let run_id = RunId::must(id);
+            let mut run = crate::kernel::test_helpers::create_test_run();
+            kernel.admit_run(&run_id, &mut run).unwrap();
+            let result = kernel.initialize_orchestration(run_id, capped.clone(), run, false);
+            if id == "c2" {
+                assert_eq!(result.unwrap_err().to_error_code(), "RESOURCE_EXHAUSTED");
+            }
+        }
+
+        assert!(kernel.lifecycle.get(&RunId::must("c2")).is_none());
+        assert_eq!(kernel.next_runnable(), Some(RunId::must("c1")));
+        assert_eq!(kernel.next_runnable(), None);
+        assert_eq!(kernel.get_system_status().runs_total, 1);
+    }
+
+    #[test]
+    fn test_terminated_runs_linger_for_zombie_window() {
+        let mut kernel = Kernel::new();
+        kernel.set_zombie_retention(std::time::Duration::from_secs(300));
+        for id in ["z1", "z2"] {
+            let run_id = RunId::must(id);
+            let mut run = crate::kernel::test_helpers::create_test_run();
+            kernel.admit_run(&run_id, &mut run).unwrap();
+            let _state = kernel
+                .initialize_orchestration(run_id, crate::kernel::test_helpers::create_test_workflow(), run, false)
+                .unwrap();
+        }
+
+        let z1 = RunId::must("z1");
+        kernel.terminate_run(&z1).unwrap();
+        assert_eq!(kernel.lifecycle.get(&z1).unwrap().state, RunStatus::Terminated);
+        assert!(kernel.runs.get(&z1).is_some_and(|run| run.is_terminated()));
+        assert_eq!(kernel.get_system_status().active_orchestration_sessions, 1);
+        assert_eq!(kernel.reap_zombies(false), 0, "still inside the window");
+
+        // Past the window: reaped on the next termination.
+        kernel.lifecycle.get_mut(&z1).unwrap().completed_at = Some(chrono::Utc::now() - chrono::TimeDelta::seconds(301));
+        kernel.terminate_run(&RunId::must("z2")).unwrap();
+        assert!(kernel.lifecycle.get(&z1).is_none());
+        assert!(kernel.runs.get(&z1).is_none());
+
+        assert_eq!(kernel.reap_zombies(true), 1);
+        assert!(kernel.runs.is_empty());
+    }
+
+    #[test]
+    fn test_apply_retention_reports_what_it_removed() {
+        let mut kernel = Kernel::new();
+        kernel.set_zombie_retention(std::time::Duration::from_secs(300));
+        kernel.set_interrupt_retention(Some(std::time::Duration::from_secs(60)));
+        let run_id = RunId::must("z1");
+        let mut run = crate::kernel::test_helpers::create_test_run();
+        kernel.admit_run(&run_id, &mut run).unwrap();
+        let _state = kernel
+            .initialize_orchestration(run_id.clone(), crate::kernel::test_helpers::create_test_workflow(), run, false)
+            .unwrap();
+        kernel.terminate_run(&run_id).unwrap();
+        assert_eq!(kernel.apply_retention(), RetentionReport::default());
+
+        kernel.lifecycle.get_mut(&run_id).unwrap().completed_at = Some(chrono::Utc::now() - chrono::TimeDelta::seconds(301));
+        assert_eq!(
+            kernel.apply_retention(),
+            RetentionReport { zombies_reaped: 1, interrupts_removed: 0 }
+        );
+        assert!(kernel.runs.get(&run_id).is_none());
+    }
+
 }
 
 #[cfg(test)]
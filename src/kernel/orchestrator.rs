@@ -11,10 +11,11 @@
 //!   - Report results back
 //!   - Have NO control over what runs next
 
-use crate::run::{Run, TerminalReason};
+use crate::run::{InterruptOutcome, Run, TerminalReason};
 use crate::types::{Error, RunId, Result};
 use chrono::{DateTime, Utc};
 use std::collections::HashMap;
+use tokio_util::sync::CancellationToken;
 use tracing::instrument;
 
 pub use super::protocol::{Instruction, RunSnapshot};
@@ -56,6 +57,9 @@ pub struct Orchestration {
     pub(crate) last_activity_at: DateTime<Utc>,
     /// Last routing decision made by report_agent_result (consumed by get_next_instruction).
     pub(crate) last_routing_decision: Option<super::routing::RoutingDecision>,
+    /// Fired by `cancel_run` and when the session is removed; handed to
+    /// workers on every `RunAgent`.
+    pub(crate) cancellation: CancellationToken,
 }
 
 /// Orchestrator manages kernel-side workflow execution.
@@ -106,7 +110,7 @@ impl Orchestrator {
                 .map(|exp| Utc::now() > exp)
                 .unwrap_or(false);
             if expired {
-                run.clear_interrupt();
+                run.close_interrupt(InterruptOutcome::Expired, None);
                 // Fall through to dispatch the agent again now that the interrupt is gone.
             } else {
                 return Ok(Instruction::WaitInterrupt {
@@ -151,10 +155,17 @@ impl Orchestrator {
             .sessions
             .get_mut(run_id)
             .ok_or_else(|| Error::not_found(format!("Unknown process: {}", run_id)))?;
+        session.last_activity_at = Utc::now();
 
         // Bookkeeping
         run.metrics.llm_calls += metrics.llm_calls;
+        run.metrics.llm_cache_hits += metrics.llm_cache_hits;
         run.metrics.tool_calls += metrics.tool_calls;
+        run.metrics.tool_calls_refused += metrics.refused_tools.len() as i32;
+        for call in &metrics.tool_results {
+            run.metrics.tool_bytes_in += call.bytes_in;
+            run.metrics.tool_bytes_out += call.bytes_out;
+        }
         if let Some(tokens_in) = metrics.tokens_in {
             run.metrics.tokens_in += tokens_in;
         }
@@ -163,7 +174,7 @@ impl Orchestrator {
         }
         run.iteration += 1;
 
-        if let Some(reason) = run.check_bounds() {
+        if let Some(reason) = run.exceeded_bounds() {
             run.terminate_with(reason, None);
             return Ok(());
         }
@@ -186,6 +197,10 @@ impl Orchestrator {
 
         *session.stage_visits.entry(current_stage.clone()).or_insert(0) += 1;
 
+        if pipeline_stage.bootstrap {
+            return self.advance_bootstrap(run_id, &pipeline_stage, run, agent_failed);
+        }
+
         let agent_lookup = pipeline_stage.agent.clone();
         let interrupt_response = run.interrupts.interrupt.as_ref()
             .and_then(|i| i.response.as_ref())
@@ -215,6 +230,39 @@ impl Orchestrator {
         self.apply_routing_result(run_id, current_stage.as_str(), next_target, run)
     }
 
+    /// Leave a bootstrap stage: on success to the next stage in definition
+    /// order, on failure to `error_next`, else terminate `BootstrapFailed`.
+    fn advance_bootstrap(&mut self, run_id: &RunId, stage: &Stage, run: &mut Run, agent_failed: bool) -> Result<()> {
+        let session = self
+            .sessions
+            .get_mut(run_id)
+            .ok_or_else(|| Error::not_found(format!("Unknown process: {}", run_id)))?;
+        let (target, reason) = if agent_failed {
+            let Some(error_next) = stage.error_next.clone() else {
+                tracing::warn!(stage = %stage.name, "bootstrap_failed");
+                run.terminate_with(
+                    TerminalReason::BootstrapFailed,
+                    Some(format!("Bootstrap stage '{}' failed", stage.name)),
+                );
+                return Ok(());
+            };
+            (error_next, RoutingReason::ErrorRoute)
+        } else {
+            let position = session.workflow.stages.iter().position(|s| s.name == stage.name);
+            let next = position
+                .and_then(|i| session.workflow.stages.get(i + 1))
+                .map(|s| s.name.clone())
+                .ok_or_else(|| Error::state_transition(format!("No stage after bootstrap stage '{}'", stage.name)))?;
+            (next, RoutingReason::DefaultRoute)
+        };
+        session.last_routing_decision = Some(RoutingDecision {
+            from_stage: stage.name.clone(),
+            target: Some(target.clone()),
+            reason,
+        });
+        self.apply_routing_result(run_id, stage.name.as_str(), Some(target), run)
+    }
+
     /// Advance to the next stage or terminate.
     fn apply_routing_result(
         &mut self,
@@ -231,7 +279,8 @@ impl Orchestrator {
         match next_target {
             Some(target) => {
                 if let Some(target_stage) = session.workflow.stages.iter().find(|s| s.name == target) {
-                    if let Some(max_visits) = target_stage.max_visits {
+                    // A bootstrap stage runs once, even if a routing fn targets it again.
+                    if let Some(max_visits) = target_stage.max_visits.or(target_stage.bootstrap.then_some(1)) {
                         let visits = session.stage_visits.get(target.as_str()).copied().unwrap_or(0);
                         if visits >= max_visits {
                             run.terminate_with(
@@ -352,6 +401,81 @@ mod tests {
         assert!(run.is_terminated());
     }
 
+    #[test]
+    fn bootstrap_stages_run_once_before_the_first_regular_stage() {
+        let bootstrap = |name: &str, error_next: Option<&str>| Stage {
+            bootstrap: true,
+            error_next: error_next.map(Into::into),
+            ..linear_stage(name, None)
+        };
+        let config = Workflow::test_default("p", vec![
+            bootstrap("warm", None),
+            bootstrap("checkout", Some("report")),
+            Stage { routing_fn: Some("again".into()), ..linear_stage("work", None) },
+            linear_stage("report", None),
+        ]);
+        let mut orch = Orchestrator::new();
+        orch.register_routing_fn("again", Arc::new(|_ctx: &RoutingContext<'_>| RoutingResult::Next("warm".into())));
+
+        // Success walks the bootstrap stages in order into the first regular stage.
+        let run_id = RunId::must("ok");
+        let mut run = make_run(&config);
+        orch.initialize_session(run_id.clone(), config.clone(), &mut run, false).unwrap();
+        assert_eq!(run.current_stage.as_str(), "warm");
+        orch.report_agent_result(&run_id, "warm", zero_metrics(), &mut run, false, false).unwrap();
+        assert_eq!(run.current_stage.as_str(), "checkout");
+        orch.report_agent_result(&run_id, "checkout", zero_metrics(), &mut run, false, false).unwrap();
+        assert_eq!(run.current_stage.as_str(), "work");
+        // Routing back into a bootstrap stage is refused.
+        orch.report_agent_result(&run_id, "work", zero_metrics(), &mut run, false, false).unwrap();
+        assert_eq!(run.terminal_reason(), Some(TerminalReason::MaxStageVisitsExceeded));
+
+        // Failure takes error_next when declared...
+        let run_id = RunId::must("routed");
+        let mut run = make_run(&config);
+        orch.initialize_session(run_id.clone(), config.clone(), &mut run, false).unwrap();
+        orch.report_agent_result(&run_id, "warm", zero_metrics(), &mut run, false, false).unwrap();
+        orch.report_agent_result(&run_id, "checkout", zero_metrics(), &mut run, true, false).unwrap();
+        assert_eq!(run.current_stage.as_str(), "report");
+
+        // ...and terminates the run otherwise.
+        let run_id = RunId::must("failed");
+        let mut run = make_run(&config);
+        orch.initialize_session(run_id.clone(), config, &mut run, false).unwrap();
+        orch.report_agent_result(&run_id, "warm", zero_metrics(), &mut run, true, false).unwrap();
+        assert_eq!(run.terminal_reason(), Some(TerminalReason::BootstrapFailed));
+        let instr = orch.get_next_instruction(&run_id, &mut run).unwrap();
+        assert!(matches!(instr, Instruction::Terminate { reason: TerminalReason::BootstrapFailed, .. }));
+    }
+
+    #[test]
+    fn max_iterations_allows_exactly_that_many_stages() {
+        let stages = vec![linear_stage("s1", Some("s2")), linear_stage("s2", Some("s3")), linear_stage("s3", None)];
+        let mut config = Workflow::test_default("p", stages[1..].to_vec());
+        config.stages[0].default_next = Some("s3".into());
+        config.max_iterations = 2;
+        let mut orch = Orchestrator::new();
+
+        // Two stages under max_iterations = 2 complete normally.
+        let run_id = RunId::must("fits");
+        let mut run = make_run(&config);
+        orch.initialize_session(run_id.clone(), config.clone(), &mut run, false).unwrap();
+        orch.report_agent_result(&run_id, "s2", zero_metrics(), &mut run, false, false).unwrap();
+        orch.report_agent_result(&run_id, "s3", zero_metrics(), &mut run, false, false).unwrap();
+        assert_eq!(run.terminal_reason(), Some(TerminalReason::Completed));
+
+        // A third stage is refused before dispatch.
+        config.stages = stages;
+        let run_id = RunId::must("over");
+        let mut run = make_run(&config);
+        orch.initialize_session(run_id.clone(), config, &mut run, false).unwrap();
+        orch.report_agent_result(&run_id, "s1", zero_metrics(), &mut run, false, false).unwrap();
+        orch.report_agent_result(&run_id, "s2", zero_metrics(), &mut run, false, false).unwrap();
+        assert!(!run.is_terminated());
+        let instr = orch.get_next_instruction(&run_id, &mut run).unwrap();
+        assert!(matches!(instr, Instruction::Terminate { reason: TerminalReason::MaxIterationsExceeded, .. }));
+    }
+
     #[test]
     fn routing_fn_overrides_default_next() {
         let config = Workflow::test_default("p", vec![
@@ -442,14 +566,16 @@ mod tests {
             max_visits: Some(100),
             ..Stage::default()
         }]);
-        config.max_iterations = 1;
+        config.max_llm_calls = 2;
         let run_id = RunId::must("p1");
         let mut run = make_run(&config);
         let mut orch = Orchestrator::new();
         orch.initialize_session(run_id.clone(), config, &mut run, false).unwrap();
 
-        orch.report_agent_result(&run_id, "s1", zero_metrics(), &mut run, false, false).unwrap();
-        assert!(run.is_terminated());
+        // A single stage that goes past the limit stops the run at once.
+        let metrics = AgentExecutionMetrics { llm_calls: 3, ..AgentExecutionMetrics::default() };
+        orch.report_agent_result(&run_id, "s1", metrics, &mut run, false, false).unwrap();
+        assert_eq!(run.terminal_reason(), Some(TerminalReason::MaxLlmCallsExceeded));
     }
 
     #[test]
@@ -1,10 +1,11 @@
 //! Orchestrator read-only queries — session state, stage config lookups.
 
-use crate::run::Run;
+use crate::run::{Run, TerminalReason};
 use crate::types::{Error, RunId, Result};
+use tokio_util::sync::CancellationToken;
 
 use super::orchestrator::Orchestrator;
-use crate::workflow::{Stage, StateField};
+use crate::workflow::{OutputOverflow, Stage, StateField};
 use crate::kernel::protocol::{RunSnapshot};
 
 impl Orchestrator {
@@ -66,6 +67,39 @@ impl Orchestrator {
             })
     }
 
+    /// Whether a stage's output must not replace an existing entry: the
+    /// workflow sets `write_once_outputs` and the stage is not marked
+    /// `overwrite_output`.
+    pub fn is_output_write_once(&self, run_id: &RunId, stage_name: &str) -> bool {
+        self.sessions.get(run_id).is_some_and(|session| {
+            session.workflow.write_once_outputs
+                && !session.workflow.stages.iter()
+                    .any(|s| s.name.as_str() == stage_name && s.overwrite_output)
+        })
+    }
+
+    /// `max_output_bytes` and its overflow policy, when the workflow sets one.
+    pub fn get_output_budget(&self, run_id: &RunId) -> Option<(u64, OutputOverflow)> {
+        self.sessions.get(run_id).and_then(|session| {
+            session
+                .workflow
+                .max_output_bytes
+                .map(|max| (max, session.workflow.output_overflow))
+        })
+    }
+
+    /// Fallback response template for an abnormal `reason`, if declared.
+    pub fn get_terminal_response(&self, run_id: &RunId, reason: TerminalReason) -> Option<String> {
+        self.sessions.get(run_id)
+            .and_then(|session| session.workflow.terminal_response(reason))
+            .map(str::to_string)
+    }
+
+    /// Cancellation token for the run's session.
+    pub fn get_cancellation(&self, run_id: &RunId) -> Option<CancellationToken> {
+        self.sessions.get(run_id).map(|session| session.cancellation.clone())
+    }
+
     /// Get the full stage config for a stage by name.
     pub fn get_stage_config(&self, run_id: &RunId, stage_name: &str) -> Option<&Stage> {
         self.sessions.get(run_id)
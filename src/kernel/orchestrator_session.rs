@@ -1,8 +1,9 @@
 //! Orchestrator session lifecycle — initialization, cleanup, state building.
 
 use crate::run::Run;
-use crate::types::{Error, RunId, Result};
+use crate::types::{Error, RunId, Result, StageName};
 use chrono::Utc;
+use tokio_util::sync::CancellationToken;
 use tracing::instrument;
 
 use super::orchestrator::{Orchestrator, Orchestration};
@@ -34,10 +35,35 @@ impl Orchestrator {
         // Validate workflow.
         workflow.validate()?;
 
+        if let Some(cap) = workflow.max_concurrent_sessions {
+            let active = self
+                .sessions
+                .values()
+                .filter(|s| s.workflow.name == workflow.name && s.run_id != run_id)
+                .count();
+            if active >= cap as usize {
+                // Sessions are not queued, so there is no position to
+                // report: the caller retries once the load drops.
+                return Err(Error::quota_exceeded(format!(
+                    "Workflow '{}' is at max_concurrent_sessions ({} active, limit {}); not queued, retry later",
+                    workflow.name, active, cap
+                )));
+            }
+        }
+
         // Initialize run with workflow bounds
         run.max_iterations = workflow.max_iterations;
         run.limits.max_llm_calls = workflow.max_llm_calls;
         run.limits.max_agent_hops = workflow.max_agent_hops;
+        // Validation bounds the duration; a deadline that would still
+        // overflow is left unset rather than panicking.
+        let deadline = workflow
+            .max_duration_seconds
+            .and_then(|secs| chrono::Duration::try_seconds(i64::try_from(secs).ok()?))
+            .and_then(|budget| Utc::now().checked_add_signed(budget));
+        if let Some(deadline) = deadline {
+            run.limits.deadline = Some(run.limits.deadline.map_or(deadline, |d| d.min(deadline)));
+        }
         run.stage_order = workflow.get_stage_order();
 
         // Set initial stage if not set
@@ -53,6 +79,7 @@ impl Orchestrator {
             created_at: now,
             last_activity_at: now,
             last_routing_decision: None,
+            cancellation: CancellationToken::new(),
         };
 
         let state = self.build_session_state(&session, run);
@@ -61,6 +88,73 @@ impl Orchestrator {
         Ok(state)
     }
 
+    /// Move a live session onto a new version of its workflow. The run's
+    /// current stage and per-stage visit counts carry over through
+    /// `workflow.stage_renames` (unlisted stages keep their name); the new
+    /// workflow's bounds apply from here on, while an existing deadline is
+    /// kept. Fails without touching the session if the run has terminated
+    /// or its current stage has no counterpart in the new workflow.
+    #[instrument(skip(self, workflow, run), fields(run_id = %run_id))]
+    pub fn migrate_session(&mut self, run_id: &RunId, workflow: Workflow, run: &mut Run) -> Result<RunSnapshot> {
+        workflow.validate()?;
+        let session = self
+            .sessions
+            .get_mut(run_id)
+            .ok_or_else(|| Error::not_found(format!("Unknown process: {}", run_id)))?;
+        if run.is_terminated() {
+            return Err(Error::state_transition(format!(
+                "Run {} has terminated; nothing to migrate",
+                run_id
+            )));
+        }
+
+        let renamed = |stage: &StageName| -> StageName {
+            workflow
+                .stage_renames
+                .get(stage.as_str())
+                .map_or_else(|| stage.clone(), |new| StageName::must(new.as_str()))
+        };
+        let current_stage = renamed(&run.current_stage);
+        if !workflow.stages.iter().any(|s| s.name == current_stage) {
+            return Err(Error::validation(format!(
+                "Workflow '{}' has no stage for current stage '{}'; add it to stage_renames",
+                workflow.name, run.current_stage
+            )));
+        }
+
+        let mut stage_visits = std::collections::HashMap::new();
+        for (stage, visits) in session.stage_visits.drain() {
+            *stage_visits.entry(renamed(&stage)).or_insert(0) += visits;
+        }
+        session.stage_visits = stage_visits;
+
+        let migration = serde_json::json!({
+            "from": session.workflow.name,
+            "to": workflow.name,
+            "from_stage": run.current_stage,
+            "to_stage": current_stage,
+            "at": Utc::now().to_rfc3339(),
+        });
+        match run.audit.metadata.get_mut("workflow_migrations") {
+            Some(serde_json::Value::Array(list)) => list.push(migration),
+            _ => {
+                run.audit.metadata.insert("workflow_migrations".to_string(), serde_json::json!([migration]));
+            }
+        }
+        tracing::info!(from = %session.workflow.name, to = %workflow.name, stage = %current_stage, "session_migrated");
+
+        run.current_stage = current_stage;
+        run.stage_order = workflow.get_stage_order();
+        run.max_iterations = workflow.max_iterations;
+        run.limits.max_llm_calls = workflow.max_llm_calls;
+        run.limits.max_agent_hops = workflow.max_agent_hops;
+        session.workflow = workflow;
+        session.last_activity_at = Utc::now();
+
+        let session = &self.sessions[run_id];
+        Ok(self.build_session_state(session, run))
+    }
+
     /// Check if a workflow session exists for the given run.
     pub fn has_session(&self, run_id: &RunId) -> bool {
         self.sessions.contains_key(run_id)
@@ -68,27 +162,40 @@ impl Orchestrator {
 
     /// Cleanup a workflow session.
     pub fn cleanup_session(&mut self, run_id: &RunId) -> bool {
-        self.sessions.remove(run_id).is_some()
+        self.sessions
+            .remove(run_id)
+            .map(|session| session.cancellation.cancel())
+            .is_some()
+    }
+
+    /// Run IDs of sessions with no activity for longer than
+    /// `idle_ttl_seconds`, oldest first. Read-only — use it to inspect what
+    /// `cleanup_stale_sessions` would remove. `INVALID_ARGUMENT` when the
+    /// cutoff falls outside chrono's date range.
+    pub fn list_stale_sessions(&self, idle_ttl_seconds: i64) -> Result<Vec<RunId>> {
+        let cutoff = chrono::TimeDelta::try_seconds(idle_ttl_seconds)
+            .and_then(|ttl| Utc::now().checked_sub_signed(ttl))
+            .ok_or_else(|| Error::validation(format!("idle_ttl_seconds {} is out of range", idle_ttl_seconds)))?;
+        let mut stale: Vec<&Orchestration> = self
+            .sessions
+            .values()
+            .filter(|session| session.last_activity_at < cutoff)
+            .collect();
+        stale.sort_by_key(|session| session.last_activity_at);
+        Ok(stale.into_iter().map(|session| session.run_id.clone()).collect())
     }
 
-    /// Cleanup stale workflow sessions older than the given duration.
+    /// Cleanup workflow sessions idle for longer than `max_age_seconds`.
     /// Returns the run IDs of removed sessions so the Kernel can also clean
     /// up the corresponding entries from `runs`.
-    pub fn cleanup_stale_sessions(&mut self, max_age_seconds: i64) -> Vec<RunId> {
-        let cutoff = Utc::now() - chrono::TimeDelta::seconds(max_age_seconds);
-        let mut to_remove = Vec::new();
-
-        for (run_id, session) in &self.sessions {
-            if session.last_activity_at < cutoff {
-                to_remove.push(run_id.clone());
-            }
-        }
-
+    pub fn cleanup_stale_sessions(&mut self, max_age_seconds: i64) -> Result<Vec<RunId>> {
+        let to_remove = self.list_stale_sessions(max_age_seconds)?;
         for run_id in &to_remove {
-            self.sessions.remove(run_id);
+            if let Some(session) = self.sessions.remove(run_id) {
+                session.cancellation.cancel();
+            }
         }
-
-        to_remove
+        Ok(to_remove)
     }
 
     /// Build external session state representation.
@@ -163,6 +270,37 @@ mod tests {
         assert!(result.is_ok());
     }
 
+    #[test]
+    fn test_initialize_session_enforces_max_concurrent_sessions() {
+        let mut orch = Orchestrator::new();
+        let mut capped = create_test_workflow();
+        capped.max_concurrent_sessions = Some(1);
+
+        let mut run = create_test_run();
+        let _state = orch.initialize_session(RunId::must("p1"), capped.clone(), &mut run, false).unwrap();
+
+        let mut run2 = create_test_run();
+        let err = orch
+            .initialize_session(RunId::must("p2"), capped.clone(), &mut run2, false)
+            .unwrap_err();
+        assert_eq!(err.to_error_code(), "RESOURCE_EXHAUSTED");
+        assert!(err.to_string().contains("1 active, limit 1"), "load is reported: {}", err);
+
+        // Replacing the same run doesn't count against the cap.
+        let mut run3 = create_test_run();
+        assert!(orch.initialize_session(RunId::must("p1"), capped.clone(), &mut run3, true).is_ok());
+
+        // Other workflows are unaffected.
+        let mut other = create_test_workflow();
+        other.name = "other".to_string();
+        let mut run4 = create_test_run();
+        assert!(orch.initialize_session(RunId::must("p3"), other, &mut run4, false).is_ok());
+
+        orch.cleanup_session(&RunId::must("p1"));
+        let mut run5 = create_test_run();
+        assert!(orch.initialize_session(RunId::must("p2"), capped, &mut run5, false).is_ok());
+    }
+
     #[test]
     fn test_has_session_true_after_init() {
         let mut orch = Orchestrator::new();
@@ -219,11 +357,93 @@ mod tests {
         }
 
         // Cleanup sessions older than 60 seconds
-        let removed = orch.cleanup_stale_sessions(60);
+        let removed = orch.cleanup_stale_sessions(60).unwrap();
         assert_eq!(removed.len(), 1);
         assert_eq!(removed[0], run_old);
 
         assert!(!orch.has_session(&run_old));
         assert!(orch.has_session(&run_young));
     }
+
+    #[test]
+    fn test_list_stale_sessions_is_read_only_and_ordered() {
+        let mut orch = Orchestrator::new();
+        let workflow = create_test_workflow();
+        for (id, idle_secs) in [("a", 600), ("b", 7200), ("c", 0)] {
+            let mut run = create_test_run();
+            let run_id = RunId::must(id);
+            let _state = orch.initialize_session(run_id.clone(), workflow.clone(), &mut run, false).unwrap();
+            if let Some(session) = orch.sessions.get_mut(&run_id) {
+                session.last_activity_at = Utc::now() - chrono::TimeDelta::seconds(idle_secs);
+            }
+        }
+
+        let stale = orch.list_stale_sessions(60).unwrap();
+        assert_eq!(stale, vec![RunId::must("b"), RunId::must("a")]);
+        assert_eq!(orch.get_session_count(), 3, "listing must not remove sessions");
+    }
+
+    #[test]
+    fn test_stale_session_ttl_out_of_range_is_rejected() {
+        let mut orch = Orchestrator::new();
+        let mut run = create_test_run();
+        let _state = orch.initialize_session(RunId::must("p1"), create_test_workflow(), &mut run, false).unwrap();
+
+        for ttl in [i64::MAX, i64::MIN] {
+            let err = orch.list_stale_sessions(ttl).unwrap_err();
+            assert_eq!(err.to_error_code(), "INVALID_ARGUMENT");
+            let err = orch.cleanup_stale_sessions(ttl).unwrap_err();
+            assert_eq!(err.to_error_code(), "INVALID_ARGUMENT");
+        }
+        assert_eq!(orch.get_session_count(), 1);
+    }
+
+    #[test]
+    fn test_report_agent_result_refreshes_activity() {
+        let mut orch = Orchestrator::new();
+        let run_id = RunId::must("active");
+        let mut run = create_test_run();
+        let _state = orch.initialize_session(run_id.clone(), create_test_workflow(), &mut run, false).unwrap();
+        if let Some(session) = orch.sessions.get_mut(&run_id) {
+            session.last_activity_at = Utc::now() - chrono::TimeDelta::seconds(3600);
+        }
+
+        orch.report_agent_result(&run_id, "agent1", Default::default(), &mut run, false, false)
+            .unwrap();
+        assert!(orch.list_stale_sessions(60).unwrap().is_empty());
+    }
+
+    #[test]
+    fn test_migrate_session_remaps_current_stage() {
+        let mut orch = Orchestrator::new();
+        let run_id = RunId::must("migrating");
+        let mut run = create_test_run();
+        let _state = orch.initialize_session(run_id.clone(), create_test_workflow(), &mut run, false).unwrap();
+        orch.report_agent_result(&run_id, "agent1", Default::default(), &mut run, false, false)
+            .unwrap();
+        assert_eq!(run.current_stage.as_str(), "stage2");
+
+        let mut v2 = crate::workflow::Workflow::test_default(
+            "test_pipeline_v2",
+            vec![
+                stage("draft", "agent1", None, Some("review")),
+                stage("review", "reviewer", None, Some("publish")),
+                stage("publish", "agent2", None, None),
+            ],
+        );
+        let unmapped = v2.clone();
+        let err = orch.migrate_session(&run_id, unmapped, &mut run).unwrap_err();
+        assert!(err.to_string().contains("no stage for current stage 'stage2'"), "{}", err);
+        assert_eq!(run.current_stage.as_str(), "stage2", "failed migration leaves the run alone");
+
+        v2.stage_renames.insert("stage1".to_string(), "draft".to_string());
+        v2.stage_renames.insert("stage2".to_string(), "review".to_string());
+        let state = orch.migrate_session(&run_id, v2, &mut run).unwrap();
+        assert_eq!(state.current_stage.as_str(), "review");
+        assert_eq!(run.stage_order.len(), 3);
+        let session = &orch.sessions[&run_id];
+        assert_eq!(session.workflow.name, "test_pipeline_v2");
+        assert_eq!(session.stage_visits.get("draft"), Some(&1));
+        assert_eq!(run.audit.metadata["workflow_migrations"][0]["to_stage"], "review");
+    }
 }
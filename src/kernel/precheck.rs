@@ -0,0 +1,141 @@
+//! Submit-time input size check. A run whose `raw_input` (plus a stage's
+//! prompt template) can never fit a token limit would only fail after
+//! spending an LLM call, so the kernel rejects it at session init instead.
+//! Estimates come from the kernel's `TokenEstimator`; like the agent-side
+//! check they are approximate, so only inputs over a limit are rejected.
+
+use serde::Serialize;
+
+use crate::agent::policy::ContextOverflow;
+use crate::agent::tokens::TokenEstimator;
+use crate::run::Run;
+use crate::types::{Error, Result};
+use crate::workflow::Workflow;
+
+use super::ResourceQuota;
+
+/// Why an input was rejected: the first limit it cannot fit, with a
+/// truncation target. Carried as the `source` of the `INVALID_ARGUMENT`.
+#[derive(Debug, Clone, PartialEq, Eq, Serialize)]
+pub struct InputTooLarge {
+    /// `quota.max_input_tokens`, `quota.max_context_tokens`, or
+    /// `stages[i].max_context_tokens`.
+    pub limit_name: String,
+    pub limit: i64,
+    pub stage: String,
+    pub input_tokens: i64,
+    /// Estimated tokens of the stage's prompt template.
+    pub overhead_tokens: i64,
+    /// Roughly how many characters of input would fit.
+    pub max_input_chars: usize,
+}
+
+impl std::fmt::Display for InputTooLarge {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        write!(
+            f,
+            "input is ~{} tokens (+{} for stage '{}' prompt), over {} {}; truncate it to about {} characters",
+            self.input_tokens, self.overhead_tokens, self.stage, self.limit_name, self.limit, self.max_input_chars
+        )
+    }
+}
+
+impl std::error::Error for InputTooLarge {}
+
+/// Check `run.raw_input` against every LLM stage of `workflow`: input plus
+/// the stage's prompt template must fit the quota's input and context
+/// bounds and the stage's own `max_context_tokens` (unless the stage
+/// truncates on overflow).
+pub(crate) fn check_input_fits(
+    estimator: &dyn TokenEstimator,
+    run: &Run,
+    workflow: &Workflow,
+    quota: &ResourceQuota,
+) -> Result<()> {
+    let input_tokens = estimator.estimate(&run.raw_input, None);
+    if input_tokens == 0 {
+        return Ok(());
+    }
+    for (i, stage) in workflow.stages.iter().enumerate() {
+        if !stage.agent_config.has_llm {
+            continue;
+        }
+        let overhead_tokens = stage
+            .agent_config
+            .prompt_template
+            .as_deref()
+            .map_or(0, |template| estimator.estimate(template, None));
+        let mut limits = vec![
+            ("quota.max_input_tokens".to_string(), i64::from(quota.max_input_tokens)),
+            ("quota.max_context_tokens".to_string(), i64::from(quota.max_context_tokens)),
+        ];
+        if let (Some(max), ContextOverflow::Fail) = (stage.max_context_tokens, stage.context_overflow) {
+            limits.push((format!("stages[{}].max_context_tokens", i), max));
+        }
+        for (limit_name, limit) in limits {
+            if input_tokens + overhead_tokens <= limit {
+                continue;
+            }
+            let room = (limit - overhead_tokens).max(0) as f64 / input_tokens as f64;
+            let rejection = InputTooLarge {
+                limit_name,
+                limit,
+                stage: stage.name.to_string(),
+                input_tokens,
+                overhead_tokens,
+                max_input_chars: (run.raw_input.chars().count() as f64 * room) as usize,
+            };
+            return Err(Error::validation_with_source(rejection.to_string(), rejection));
+        }
+    }
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::agent::tokens::CharRatioEstimator;
+    use crate::kernel::test_helpers::{create_test_workflow, create_test_run};
+
+    fn run_with_input(chars: usize) -> Run {
+        let mut run = create_test_run();
+        run.raw_input = "x".repeat(chars);
+        run
+    }
+
+    #[test]
+    fn oversize_input_names_the_limit_and_suggests_a_length() {
+        let estimator = CharRatioEstimator::new(1.0);
+        let mut workflow = create_test_workflow();
+        workflow.stages[1].agent_config.has_llm = true;
+        workflow.stages[1].agent_config.prompt_template = Some("y".repeat(20));
+        workflow.stages[1].max_context_tokens = Some(100);
+        let quota = ResourceQuota::default_quota();
+
+        assert!(check_input_fits(&estimator, &run_with_input(80), &workflow, &quota).is_ok());
+
+        let err = check_input_fits(&estimator, &run_with_input(200), &workflow, &quota).unwrap_err();
+        assert_eq!(err.to_error_code(), "INVALID_ARGUMENT");
+        let rejection = std::error::Error::source(&err)
+            .and_then(|source| source.downcast_ref::<InputTooLarge>())
+            .unwrap();
+        assert_eq!(rejection.limit_name, "stages[1].max_context_tokens");
+        assert_eq!((rejection.input_tokens, rejection.overhead_tokens, rejection.max_input_chars), (200, 20, 80));
+        assert!(err.to_string().contains("truncate it to about 80 characters"));
+    }
+
+    #[test]
+    fn truncating_stages_and_non_llm_stages_only_face_the_quota() {
+        let estimator = CharRatioEstimator::new(1.0);
+        let mut workflow = create_test_workflow();
+        workflow.stages[0].max_context_tokens = Some(10);
+        workflow.stages[1].agent_config.has_llm = true;
+        workflow.stages[1].max_context_tokens = Some(10);
+        workflow.stages[1].context_overflow = ContextOverflow::TruncateOldest;
+        let quota = ResourceQuota { max_input_tokens: 50, ..ResourceQuota::default_quota() };
+
+        assert!(check_input_fits(&estimator, &run_with_input(40), &workflow, &quota).is_ok());
+        let err = check_input_fits(&estimator, &run_with_input(60), &workflow, &quota).unwrap_err();
+        assert!(err.to_string().contains("quota.max_input_tokens 50"));
+    }
+}
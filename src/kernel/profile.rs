@@ -0,0 +1,140 @@
+//! Opt-in actor profiling. The kernel holds no locks, so the only place
+//! requests contend is the actor's mailbox: while one command runs, every
+//! other caller waits. The profiler records how long each command kind
+//! holds the actor and how deep the mailbox was when it was picked up, so
+//! slow commands can be found (and split or moved off the actor) before
+//! they show up as tail latency.
+
+use std::collections::{HashMap, VecDeque};
+use std::time::Duration;
+
+use chrono::{DateTime, Utc};
+use serde::Serialize;
+
+use super::interrupts::percentile;
+
+/// Service-time samples kept per command kind.
+const SERVICE_SAMPLES: usize = 1024;
+
+#[derive(Debug, Default)]
+struct CommandCounters {
+    count: u64,
+    total_us: u64,
+    max_us: u64,
+    service_us: VecDeque<i64>,
+}
+
+/// Time one command kind held the actor.
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct CommandStats {
+    pub command: &'static str,
+    pub count: u64,
+    pub total_us: u64,
+    pub max_us: u64,
+    /// Over the last `SERVICE_SAMPLES` executions.
+    pub p50_us: Option<i64>,
+    pub p99_us: Option<i64>,
+}
+
+/// Returned by `KernelHandle::get_command_profile`.
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct CommandProfile {
+    pub since: DateTime<Utc>,
+    /// Commands still queued behind the one being picked up.
+    pub mailbox_depth_max: usize,
+    pub mailbox_depth_mean: f64,
+    /// Largest `total_us` first: the commands that kept others waiting.
+    pub commands: Vec<CommandStats>,
+}
+
+#[derive(Debug)]
+pub struct CommandProfiler {
+    since: DateTime<Utc>,
+    pickups: u64,
+    depth_total: u64,
+    depth_max: usize,
+    commands: HashMap<&'static str, CommandCounters>,
+}
+
+impl CommandProfiler {
+    pub fn new() -> Self {
+        Self {
+            since: Utc::now(),
+            pickups: 0,
+            depth_total: 0,
+            depth_max: 0,
+            commands: HashMap::new(),
+        }
+    }
+
+    /// One command ran for `elapsed` with `queued` others waiting.
+    pub fn record(&mut self, command: &'static str, elapsed: Duration, queued: usize) {
+        self.pickups += 1;
+        self.depth_total += queued as u64;
+        self.depth_max = self.depth_max.max(queued);
+
+        let us = u64::try_from(elapsed.as_micros()).unwrap_or(u64::MAX);
+        let counters = self.commands.entry(command).or_default();
+        counters.count += 1;
+        counters.total_us = counters.total_us.saturating_add(us);
+        counters.max_us = counters.max_us.max(us);
+        if counters.service_us.len() == SERVICE_SAMPLES {
+            counters.service_us.pop_front();
+        }
+        counters.service_us.push_back(i64::try_from(us).unwrap_or(i64::MAX));
+    }
+
+    pub fn profile(&self) -> CommandProfile {
+        let mut commands: Vec<CommandStats> = self
+            .commands
+            .iter()
+            .map(|(command, counters)| {
+                let mut samples: Vec<i64> = counters.service_us.iter().copied().collect();
+                samples.sort_unstable();
+                CommandStats {
+                    command,
+                    count: counters.count,
+                    total_us: counters.total_us,
+                    max_us: counters.max_us,
+                    p50_us: percentile(&samples, 50),
+                    p99_us: percentile(&samples, 99),
+                }
+            })
+            .collect();
+        commands.sort_by(|a, b| b.total_us.cmp(&a.total_us).then_with(|| a.command.cmp(b.command)));
+        CommandProfile {
+            since: self.since,
+            mailbox_depth_max: self.depth_max,
+            mailbox_depth_mean: if self.pickups == 0 { 0.0 } else { self.depth_total as f64 / self.pickups as f64 },
+            commands,
+        }
+    }
+}
+
+impl Default for CommandProfiler {
+    fn default() -> Self {
+        Self::new()
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn ranks_commands_by_time_held() {
+        let mut profiler = CommandProfiler::new();
+        for us in [100, 200, 300] {
+            profiler.record("GetSessionState", Duration::from_micros(us), 0);
+        }
+        profiler.record("SearchRuns", Duration::from_micros(5_000), 4);
+
+        let profile = profiler.profile();
+        assert_eq!(profile.mailbox_depth_max, 4);
+        assert!((profile.mailbox_depth_mean - 1.0).abs() < 1e-9);
+        assert_eq!(profile.commands[0].command, "SearchRuns");
+        let state = &profile.commands[1];
+        assert_eq!((state.count, state.total_us, state.max_us), (3, 600, 300));
+        assert_eq!((state.p50_us, state.p99_us), (Some(200), Some(300)));
+    }
+}
@@ -1,11 +1,14 @@
 //! Kernel ↔ runner contract types. Not part of the consumer-facing API.
 
+use std::collections::HashMap;
+
 use serde::{Deserialize, Serialize};
+use tokio_util::sync::CancellationToken;
 
 use crate::agent::policy::ContextOverflow;
 use crate::run::{FlowInterrupt, TerminalReason};
 use crate::types::{RunId, StageName};
-use crate::workflow::RetryPolicy;
+use crate::workflow::{DeliverySemantics, RetryPolicy, SecurityContext, ToolPolicy};
 
 use super::routing::RoutingDecision;
 
@@ -31,8 +34,61 @@ pub struct AgentDispatchContext {
     pub interrupt_response: Option<serde_json::Value>,
     #[serde(default, skip_serializing_if = "Option::is_none")]
     pub timeout_seconds: Option<u64>,
+    /// Milliseconds left before the run's deadline, when it has one. Agents
+    /// can use it to shorten generations or skip optional tool calls.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub deadline_remaining_ms: Option<i64>,
+    /// The run's `locale` and `timezone`, for formatting dates and picking
+    /// the response language.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub locale: Option<String>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub timezone: Option<String>,
+    /// The run's `params`, verbatim.
+    #[serde(default, skip_serializing_if = "HashMap::is_empty")]
+    pub params: HashMap<String, serde_json::Value>,
     #[serde(default, skip_serializing_if = "Option::is_none")]
     pub retry_policy: Option<RetryPolicy>,
+    #[serde(default)]
+    pub delivery: DeliverySemantics,
+    /// `run:stage:visit`, the same on every repeat of one stage visit
+    /// (retries, re-claims after a lapsed lease). Set for `at_least_once`
+    /// stages; handlers with side effects deduplicate on it.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub idempotency_key: Option<String>,
+    /// Stage `required_capabilities`. A worker missing any should report
+    /// the stage failed without running it; the run then ends with
+    /// `PolicyViolation`.
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub required_capabilities: Vec<String>,
+    /// Regions allowed to execute the stage: the workflow's
+    /// `allowed_regions` narrowed by the run's `metadata.allowed_regions`.
+    /// `None` = any. A worker outside them should report the stage failed
+    /// without running it; the run then ends with `PolicyViolation`.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub allowed_regions: Option<Vec<String>>,
+    /// Stage sandbox policy for tool execution; enforcement is the worker's.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub security_context: Option<SecurityContext>,
+    /// Agent's tool allow/deny lists, when it has any. Results reporting a
+    /// call outside them terminate the run with `PolicyViolation`.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub tool_policy: Option<ToolPolicy>,
+    /// Stage `prompt_template` rendered against the run (raw input, prior
+    /// outputs, state, metadata).
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub rendered_prompt: Option<String>,
+    /// Stage opted into the run's prompt cache (`AgentConfig::cache_prompts`).
+    #[serde(default)]
+    pub cache_prompts: bool,
+    /// On `Terminate`: the workflow's `cleanup_agents`, for the worker to
+    /// run best effort, each bounded by `timeout_seconds`.
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub cleanup_agents: Vec<String>,
+    /// Fired when the run is cancelled or its session removed while the
+    /// stage executes. In-process only; never serialized.
+    #[serde(skip)]
+    pub cancellation: Option<CancellationToken>,
     /// Routing decision that selected this stage; emitted as an audit event.
     #[serde(default, skip_serializing_if = "Option::is_none")]
     pub last_routing_decision: Option<RoutingDecision>,
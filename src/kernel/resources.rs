@@ -2,11 +2,46 @@
 //!
 //! Tracks resource usage across processes and enforces quotas.
 
+use chrono::{Datelike, Duration, NaiveDate, Utc};
 use serde::{Deserialize, Serialize};
-use std::collections::{HashMap, HashSet};
+use std::collections::{BTreeMap, HashMap, HashSet};
 
 use super::types::ResourceUsage;
 
+/// Days of per-user daily buckets kept; older buckets are pruned on write.
+pub const USAGE_HISTORY_DAYS: i64 = 90;
+
+/// Bucket width for `ResourceTracker::usage_history`.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize)]
+pub enum UsageGranularity {
+    Daily,
+    /// ISO weeks, starting Monday.
+    Weekly,
+}
+
+/// Per-user usage rolled up over one day or week (UTC).
+#[derive(Debug, Clone, PartialEq, Default, Serialize, Deserialize)]
+pub struct UsageBucket {
+    /// First day covered by the bucket.
+    pub start: NaiveDate,
+    /// Runs admitted for the user.
+    pub runs: i32,
+    pub llm_calls: i32,
+    pub tool_calls: i32,
+    pub tokens_in: i64,
+    pub tokens_out: i64,
+}
+
+impl UsageBucket {
+    fn add(&mut self, other: &UsageBucket) {
+        self.runs += other.runs;
+        self.llm_calls += other.llm_calls;
+        self.tool_calls += other.tool_calls;
+        self.tokens_in += other.tokens_in;
+        self.tokens_out += other.tokens_out;
+    }
+}
+
 /// Per-user resource tracker. Owned by Kernel; mutated via `&mut self` in the
 /// single-actor loop. Per-run quota lives on `RunRecord.quota` and is checked
 /// via `Kernel::check_quota` against `Run.metrics`.
@@ -14,12 +49,22 @@ use super::types::ResourceUsage;
 pub struct ResourceTracker {
     /// Per-user usage aggregation (optional, for multi-tenant quotas)
     user_usage: HashMap<String, ResourceUsage>,
+    /// Per-user daily buckets (UTC date → totals), kept for
+    /// `USAGE_HISTORY_DAYS`. Survives `clear_user_usage`.
+    #[serde(default)]
+    daily_usage: HashMap<String, BTreeMap<NaiveDate, UsageBucket>>,
+    /// Tool-call bytes across every user since start; unlike `user_usage`
+    /// it is never pruned.
+    #[serde(default)]
+    tool_bytes_total: u64,
 }
 
 impl ResourceTracker {
     pub fn new() -> Self {
         Self {
             user_usage: HashMap::new(),
+            daily_usage: HashMap::new(),
+            tool_bytes_total: 0,
         }
     }
 
@@ -37,6 +82,64 @@ impl ResourceTracker {
         user_usage.tool_calls += tool_calls;
         user_usage.tokens_in += tokens_in;
         user_usage.tokens_out += tokens_out;
+
+        let today = self.today_bucket(user_id);
+        today.llm_calls += llm_calls;
+        today.tool_calls += tool_calls;
+        today.tokens_in += tokens_in;
+        today.tokens_out += tokens_out;
+    }
+
+    /// Record tool-call payload bytes (arguments + results) for a user.
+    pub fn record_tool_bytes(&mut self, user_id: &str, bytes: u64) {
+        self.user_usage.entry(user_id.to_string()).or_default().tool_bytes += bytes;
+        self.tool_bytes_total += bytes;
+    }
+
+    /// Tool-call bytes recorded since the tracker was created.
+    pub fn tool_bytes_total(&self) -> u64 {
+        self.tool_bytes_total
+    }
+
+    /// Count a newly admitted run in the user's daily bucket.
+    pub fn record_run(&mut self, user_id: &str) {
+        self.today_bucket(user_id).runs += 1;
+    }
+
+    /// Rolled-up usage for `user_id` from `since` (inclusive) to today,
+    /// oldest first. Days without activity are omitted.
+    pub fn usage_history(
+        &self,
+        user_id: &str,
+        granularity: UsageGranularity,
+        since: NaiveDate,
+    ) -> Vec<UsageBucket> {
+        let Some(days) = self.daily_usage.get(user_id) else {
+            return Vec::new();
+        };
+        let mut rolled: BTreeMap<NaiveDate, UsageBucket> = BTreeMap::new();
+        for (date, bucket) in days.range(since..) {
+            let start = match granularity {
+                UsageGranularity::Daily => *date,
+                UsageGranularity::Weekly => {
+                    *date - Duration::days(i64::from(date.weekday().num_days_from_monday()))
+                }
+            };
+            rolled
+                .entry(start)
+                .or_insert_with(|| UsageBucket { start, ..UsageBucket::default() })
+                .add(bucket);
+        }
+        rolled.into_values().collect()
+    }
+
+    fn today_bucket(&mut self, user_id: &str) -> &mut UsageBucket {
+        let today = Utc::now().date_naive();
+        let days = self.daily_usage.entry(user_id.to_string()).or_default();
+        let cutoff = today - Duration::days(USAGE_HISTORY_DAYS);
+        days.retain(|date, _| *date > cutoff);
+        days.entry(today)
+            .or_insert_with(|| UsageBucket { start: today, ..UsageBucket::default() })
     }
 
     /// Get usage for a user.
@@ -49,6 +152,14 @@ impl ResourceTracker {
         self.user_usage.remove(user_id);
     }
 
+    /// Remove all usage for a user, including daily history. Returns true
+    /// if anything was held.
+    pub fn purge_user(&mut self, user_id: &str) -> bool {
+        let usage = self.user_usage.remove(user_id).is_some();
+        let history = self.daily_usage.remove(user_id).is_some();
+        usage || history
+    }
+
     /// Remove user_usage entries for users with no active processes.
     /// Also enforces `max_entries` cap. Returns number of entries removed.
     pub fn cleanup_stale_users(
@@ -70,6 +181,15 @@ impl ResourceTracker {
             }
         }
 
+        // History outlives active runs; only the entry cap applies.
+        if self.daily_usage.len() > max_entries {
+            let excess = self.daily_usage.len() - max_entries;
+            let to_remove: Vec<String> = self.daily_usage.keys().take(excess).cloned().collect();
+            for uid in to_remove {
+                self.daily_usage.remove(&uid);
+            }
+        }
+
         before - self.user_usage.len()
     }
 
@@ -83,6 +203,7 @@ impl ResourceTracker {
             total.iterations += usage.iterations;
             total.tokens_in += usage.tokens_in;
             total.tokens_out += usage.tokens_out;
+            total.tool_bytes += usage.tool_bytes;
         }
         total
     }
@@ -143,6 +264,20 @@ mod tests {
         assert_eq!(total.tokens_out, 1000);
     }
 
+    #[test]
+    fn test_tool_bytes_total_survives_user_cleanup() {
+        let mut tracker = ResourceTracker::new();
+
+        tracker.record_tool_bytes("user1", 300);
+        tracker.record_tool_bytes("user2", 200);
+        assert_eq!(tracker.get_user_usage("user1").unwrap().tool_bytes, 300);
+        assert_eq!(tracker.total_usage().tool_bytes, 500);
+
+        tracker.clear_user_usage("user1");
+        assert_eq!(tracker.total_usage().tool_bytes, 200);
+        assert_eq!(tracker.tool_bytes_total(), 500);
+    }
+
     #[test]
     fn test_clear_user_usage() {
         let mut tracker = ResourceTracker::new();
@@ -157,5 +292,34 @@ mod tests {
         let total = tracker.total_usage();
         assert_eq!(total.llm_calls, 0);
     }
+
+    #[test]
+    fn test_usage_history_daily_and_weekly() {
+        let mut tracker = ResourceTracker::new();
+        tracker.record_run("user1");
+        tracker.record_usage("user1", 2, 1, 100, 50);
+
+        // Backfill two earlier days in the ISO week of Monday 2020-03-02.
+        let monday = NaiveDate::from_ymd_opt(2020, 3, 2).unwrap();
+        let days = tracker.daily_usage.get_mut("user1").unwrap();
+        for (offset, calls) in [(0, 1), (2, 4)] {
+            let start = monday + Duration::days(offset);
+            days.insert(start, UsageBucket { start, runs: 1, llm_calls: calls, ..UsageBucket::default() });
+        }
+
+        let daily = tracker.usage_history("user1", UsageGranularity::Daily, monday);
+        assert_eq!(daily.len(), 3);
+        assert_eq!(daily[0].start, monday);
+        let today = daily.last().unwrap();
+        assert_eq!((today.runs, today.llm_calls, today.tokens_in), (1, 2, 100));
+
+        let weekly = tracker.usage_history("user1", UsageGranularity::Weekly, monday);
+        assert_eq!(weekly[0].start, monday);
+        assert_eq!((weekly[0].runs, weekly[0].llm_calls), (2, 5));
+
+        let recent = tracker.usage_history("user1", UsageGranularity::Daily, Utc::now().date_naive());
+        assert_eq!(recent.len(), 1);
+        assert!(tracker.usage_history("nobody", UsageGranularity::Daily, monday).is_empty());
+    }
 }
 
@@ -6,7 +6,8 @@ use serde::{Deserialize, Serialize};
 use std::collections::HashMap;
 use std::sync::Arc;
 
-use crate::types::{AgentName, OutputKey, RoutingFnName, StageName};
+use crate::run::OutputMap;
+use crate::types::{AgentName, RoutingFnName, StageName};
 
 /// Read-only snapshot passed to a [`RoutingFn`].
 #[derive(Debug)]
@@ -14,7 +15,7 @@ pub struct RoutingContext<'a> {
     pub current_stage: &'a str,
     pub agent_name: &'a str,
     pub agent_failed: bool,
-    pub outputs: &'a HashMap<AgentName, HashMap<OutputKey, serde_json::Value>>,
+    pub outputs: &'a HashMap<AgentName, OutputMap>,
     pub metadata: &'a HashMap<String, serde_json::Value>,
     pub interrupt_response: Option<&'a serde_json::Value>,
     pub state: &'a HashMap<String, serde_json::Value>,
@@ -172,12 +173,12 @@ mod tests {
         reg
     }
 
-    fn empty_ctx() -> (HashMap<AgentName, HashMap<OutputKey, serde_json::Value>>, HashMap<String, serde_json::Value>) {
+    fn empty_ctx() -> (HashMap<AgentName, OutputMap>, HashMap<String, serde_json::Value>) {
         (HashMap::new(), HashMap::new())
     }
 
     fn make_ctx<'a>(
-        outputs: &'a HashMap<AgentName, HashMap<OutputKey, serde_json::Value>>,
+        outputs: &'a HashMap<AgentName, OutputMap>,
         metadata: &'a HashMap<String, serde_json::Value>,
         state: &'a HashMap<String, serde_json::Value>,
     ) -> RoutingContext<'a> {
@@ -5,14 +5,16 @@ use std::sync::Arc;
 
 use tracing::{instrument, Instrument};
 
+use crate::agent::cache::PromptCache;
 use crate::agent::llm::{self, RunEvent};
 use crate::agent::metrics::AgentExecutionMetrics;
+use crate::agent::policy::FailureClass;
 use crate::agent::{Agent, AgentContext, AgentOutput, AgentRegistry, DeterministicAgent};
-use crate::run::Run;
+use crate::run::{Run, WorkerIdentity};
 use crate::kernel::handle::KernelHandle;
 use crate::kernel::protocol::{AgentDispatchContext, Instruction};
 use crate::types::{RunId, Result};
-use crate::workflow::Workflow;
+use crate::workflow::{DeliverySemantics, Workflow};
 use tokio::sync::mpsc;
 
 /// Result of running a workflow to completion.
@@ -22,6 +24,10 @@ pub struct WorkerResult {
     pub run_id: RunId,
     pub termination: Option<crate::run::Termination>,
     pub outputs: std::collections::HashMap<crate::types::AgentName, std::collections::HashMap<crate::types::OutputKey, serde_json::Value>>,
+    /// Per-stage artifact manifest.
+    pub artifacts: std::collections::HashMap<crate::types::StageName, Vec<crate::run::Artifact>>,
+    /// Agent progress lines for the end user, oldest first.
+    pub user_log: Vec<crate::run::UserLogEntry>,
     pub aggregate_metrics: Option<llm::AggregateMetrics>,
 }
 
@@ -34,24 +40,52 @@ impl WorkerResult {
     }
 }
 
-/// Run a workflow to completion with a pre-built `Run` (supports metadata).
+/// Run a workflow to completion with a pre-built `Run` (supports metadata),
+/// as `WorkerIdentity::in_process()`: no region and no capabilities, so
+/// stages restricted by `allowed_regions` or `required_capabilities` fail
+/// with `PolicyViolation`. Use [`run_as`] to declare them.
 pub async fn run(
     handle: &KernelHandle,
     run_id: RunId,
     workflow: Workflow,
     run: Run,
     agents: &AgentRegistry,
+) -> Result<WorkerResult> {
+    run_as(handle, run_id, workflow, run, agents, WorkerIdentity::in_process()).await
+}
+
+/// [`run`] as `worker`, whose `region` and `capabilities` are checked
+/// before each stage executes.
+pub async fn run_as(
+    handle: &KernelHandle,
+    run_id: RunId,
+    workflow: Workflow,
+    run: Run,
+    agents: &AgentRegistry,
+    worker: WorkerIdentity,
 ) -> Result<WorkerResult> {
     let workflow_name = workflow.name.clone();
+    let cleanup = abort_cleanup(&workflow, &run);
     let _session = handle
         .initialize_session(run_id.clone(), workflow, run, false)
         .await?;
-    run_loop(handle, &run_id, agents, None, &workflow_name).await
+    drive_loop(handle, &run_id, agents, None, &workflow_name, DisconnectPolicy::Cancel, cleanup, &worker).await
+}
+
+/// What the runner does when the streaming consumer drops its event receiver.
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq)]
+pub enum DisconnectPolicy {
+    /// Cancel the run; it terminates with `TerminalReason::ClientCancelled`.
+    #[default]
+    Cancel,
+    /// Stop emitting events and keep running to completion.
+    Detach,
 }
 
 /// Run a workflow with streaming events. Returns a join handle and event receiver.
 /// The receiver yields `RunEvent` items (StageStarted, Delta, ToolCallStart, etc.).
 /// Session is initialized before spawning so rate-limit errors surface to the caller.
+/// Dropping the receiver cancels the run; see [`run_streaming_with`].
 pub async fn run_streaming(
     handle: KernelHandle,
     run_id: RunId,
@@ -61,8 +95,26 @@ pub async fn run_streaming(
 ) -> Result<(
     tokio::task::JoinHandle<Result<WorkerResult>>,
     mpsc::Receiver<RunEvent>,
+)> {
+    run_streaming_with(handle, run_id, workflow, run, agents, DisconnectPolicy::Cancel, WorkerIdentity::in_process()).await
+}
+
+/// [`run_streaming`] with an explicit policy for a dropped event receiver
+/// and the `worker` identity to execute as (see [`run_as`]).
+pub async fn run_streaming_with(
+    handle: KernelHandle,
+    run_id: RunId,
+    workflow: Workflow,
+    run: Run,
+    agents: Arc<AgentRegistry>,
+    on_disconnect: DisconnectPolicy,
+    worker: WorkerIdentity,
+) -> Result<(
+    tokio::task::JoinHandle<Result<WorkerResult>>,
+    mpsc::Receiver<RunEvent>,
 )> {
     let workflow_name = workflow.name.clone();
+    let cleanup = abort_cleanup(&workflow, &run);
     let _state = handle
         .initialize_session(run_id.clone(), workflow, run, false)
         .await?;
@@ -70,23 +122,103 @@ pub async fn run_streaming(
     let run_id_for_span = run_id.clone();
     let workflow_name_for_span = workflow_name.clone();
     let task = tokio::spawn(async move {
-        run_loop(&handle, &run_id, &agents, Some(tx), &workflow_name).await
+        drive_loop(&handle, &run_id, &agents, Some(tx), &workflow_name, on_disconnect, cleanup, &worker).await
     }.instrument(tracing::info_span!("run_stream", run_id = %run_id_for_span, workflow = %workflow_name_for_span)));
     Ok((task, rx))
 }
 
 /// Run the dispatch loop for an already-initialized session.
 /// Pass `event_tx = Some(tx)` for streaming events, `None` for buffered mode.
-#[instrument(skip(handle, agents, event_tx), fields(run_id = %run_id, workflow = %workflow_name))]
+/// A closed `event_tx` cancels the run (`DisconnectPolicy::Cancel`).
+/// Without the workflow at hand, a drive that aborts on a kernel error
+/// skips `cleanup_agents`; only a `Terminate` runs them here.
 pub async fn run_loop(
     handle: &KernelHandle,
     run_id: &RunId,
     agents: &AgentRegistry,
     event_tx: Option<mpsc::Sender<RunEvent>>,
     workflow_name: &str,
+) -> Result<WorkerResult> {
+    run_loop_as(handle, run_id, agents, event_tx, workflow_name, &WorkerIdentity::in_process()).await
+}
+
+/// [`run_loop`] as `worker` (see [`run_as`]).
+pub async fn run_loop_as(
+    handle: &KernelHandle,
+    run_id: &RunId,
+    agents: &AgentRegistry,
+    event_tx: Option<mpsc::Sender<RunEvent>>,
+    workflow_name: &str,
+    worker: &WorkerIdentity,
+) -> Result<WorkerResult> {
+    drive_loop(handle, run_id, agents, event_tx, workflow_name, DisconnectPolicy::Cancel, None, worker).await
+}
+
+/// Cleanup context for a drive that aborts before it sees `Terminate`
+/// (the session was removed, or the kernel stopped): the workflow's
+/// `cleanup_agents` over the run's initial input and metadata.
+fn abort_cleanup(workflow: &Workflow, run: &Run) -> Option<AgentDispatchContext> {
+    if workflow.cleanup_agents.is_empty() {
+        return None;
+    }
+    Some(AgentDispatchContext {
+        cleanup_agents: workflow.cleanup_agents.clone(),
+        timeout_seconds: Some(workflow.cleanup_timeout()),
+        agent_context: Some(serde_json::json!({
+            "raw_input": &run.raw_input,
+            "metadata": &run.audit.metadata,
+        })),
+        ..Default::default()
+    })
+}
+
+#[allow(clippy::too_many_arguments)]
+#[instrument(skip(handle, agents, event_tx, cleanup, worker), fields(run_id = %run_id, workflow = %workflow_name, worker = %worker.id))]
+async fn drive_loop(
+    handle: &KernelHandle,
+    run_id: &RunId,
+    agents: &AgentRegistry,
+    event_tx: Option<mpsc::Sender<RunEvent>>,
+    workflow_name: &str,
+    on_disconnect: DisconnectPolicy,
+    cleanup: Option<AgentDispatchContext>,
+    worker: &WorkerIdentity,
 ) -> Result<WorkerResult> {
     let workflow_name: Arc<str> = Arc::from(workflow_name);
+    let result = drive_steps(handle, run_id, agents, event_tx, workflow_name.clone(), on_disconnect, worker).await;
+    if let (Err(err), Some(context)) = (&result, &cleanup) {
+        // No `Terminate` will reach this drive; release resources anyway.
+        tracing::warn!(error = %err, "drive_aborted_running_cleanup");
+        run_cleanup(agents, context, workflow_name).await;
+    }
+    result
+}
+
+async fn drive_steps(
+    handle: &KernelHandle,
+    run_id: &RunId,
+    agents: &AgentRegistry,
+    mut event_tx: Option<mpsc::Sender<RunEvent>>,
+    workflow_name: Arc<str>,
+    on_disconnect: DisconnectPolicy,
+    worker: &WorkerIdentity,
+) -> Result<WorkerResult> {
+    // Scoped to this drive; stages opt in via `cache_prompts`.
+    let prompt_cache = Arc::new(PromptCache::default());
     loop {
+        if event_tx.as_ref().is_some_and(|tx| tx.is_closed()) {
+            match on_disconnect {
+                DisconnectPolicy::Cancel => {
+                    tracing::info!("event_receiver_dropped_cancelling");
+                    handle.cancel_run(run_id, crate::run::TerminalReason::ClientCancelled).await?;
+                }
+                DisconnectPolicy::Detach => {
+                    tracing::info!("event_receiver_dropped_detaching");
+                }
+            }
+            event_tx = None;
+        }
+
         let instruction = handle.get_next_instruction(run_id).await?;
 
         match instruction {
@@ -112,12 +244,28 @@ pub async fn run_loop(
                     .and_then(|v| serde_json::from_value(v.clone()).ok())
                     .unwrap_or_default();
 
+                let artifacts = context
+                    .agent_context
+                    .as_ref()
+                    .and_then(|c| c.get("artifacts"))
+                    .and_then(|v| serde_json::from_value(v.clone()).ok())
+                    .unwrap_or_default();
+
+                let user_log = context
+                    .agent_context
+                    .as_ref()
+                    .and_then(|c| c.get("user_log"))
+                    .and_then(|v| serde_json::from_value(v.clone()).ok())
+                    .unwrap_or_default();
+
                 let aggregate_metrics: Option<llm::AggregateMetrics> = context
                     .agent_context
                     .as_ref()
                     .and_then(|c| c.get("aggregate_metrics"))
                     .and_then(|v| serde_json::from_value(v.clone()).ok());
 
+                run_cleanup(agents, &context, workflow_name.clone()).await;
+
                 if let Some(ref tx) = event_tx {
                     let _ = tx
                         .send(RunEvent::Done {
@@ -135,6 +283,8 @@ pub async fn run_loop(
                     run_id: run_id.clone(),
                     termination: Some(crate::run::Termination { reason, message }),
                     outputs,
+                    artifacts,
+                    user_log,
                     aggregate_metrics,
                 });
             }
@@ -163,12 +313,78 @@ pub async fn run_loop(
                         .await;
                 }
 
-                let ctx = build_agent_context(context, event_tx.clone(), Some(agent.clone()), workflow_name.clone());
-                let output = execute_agent_with_policy(
+                let outside_residency = context
+                    .allowed_regions
+                    .as_ref()
+                    .is_some_and(|allowed| !allowed.contains(&worker.region));
+                if outside_residency {
+                    // Never run restricted data here; the kernel ends the
+                    // run with `PolicyViolation`.
+                    tracing::warn!(agent = %agent, region = %worker.region, "outside_allowed_regions");
+                    handle
+                        .process_agent_result(
+                            run_id,
+                            agent,
+                            worker,
+                            serde_json::Value::Null,
+                            None,
+                            AgentExecutionMetrics::default(),
+                            false,
+                            &format!("worker region '{}' is not allowed for this run", worker.region),
+                            false,
+                        )
+                        .await?;
+                    continue;
+                }
+
+                let missing = worker.missing_capabilities(&context.required_capabilities);
+                if !missing.is_empty() {
+                    // Not run here; the kernel ends the run with `PolicyViolation`.
+                    tracing::warn!(agent = %agent, missing = ?missing, "missing_capabilities");
+                    handle
+                        .process_agent_result(
+                            run_id,
+                            agent,
+                            worker,
+                            serde_json::Value::Null,
+                            None,
+                            AgentExecutionMetrics::default(),
+                            false,
+                            &format!("worker lacks capabilities: {}", missing.join(", ")),
+                            false,
+                        )
+                        .await?;
+                    continue;
+                }
+
+                let mut ctx = build_agent_context(context, event_tx.clone(), Some(agent.clone()), workflow_name.clone());
+                if context.cache_prompts {
+                    ctx.prompt_cache = Some(prompt_cache.clone());
+                }
+                let at_most_once = context.delivery == DeliverySemantics::AtMostOnce;
+                let execution = execute_agent_with_policy(
                     agents, agent, &ctx,
                     context.timeout_seconds,
-                    context.retry_policy.as_ref(),
-                ).await;
+                    context.retry_policy.as_ref().filter(|_| !at_most_once),
+                );
+                let output = match ctx.cancellation.clone() {
+                    Some(token) => tokio::select! {
+                        output = execution => Some(output),
+                        () = token.cancelled() => None,
+                    },
+                    None => Some(execution.await),
+                };
+                // Cancelled mid-stage: drop the work; the next fetch observes Terminate.
+                let Some(output) = output else {
+                    tracing::info!(agent = %agent, "stage_cancelled");
+                    continue;
+                };
+
+                if at_most_once && !output.success && output.failure_class != FailureClass::Fatal {
+                    // Not retried: a timeout or transient failure may have
+                    // landed side effects before it surfaced.
+                    tracing::warn!(agent = %agent, error = %output.error_message, "delivery_ambiguous");
+                }
 
                 // Tool confirmation gate: if agent requests an interrupt, suspend stage
                 if let Some(interrupt) = output.interrupt_request {
@@ -194,10 +410,18 @@ pub async fn run_loop(
                         .await;
                 }
 
+                if !output.artifacts.is_empty() {
+                    handle.record_artifacts(run_id, output.artifacts).await?;
+                }
+                if !output.user_log.is_empty() {
+                    handle.record_user_log(run_id, output.user_log).await?;
+                }
+
                 handle
                     .process_agent_result(
                         run_id,
                         agent,
+                        worker,
                         output.output,
                         None,
                         output.metrics,
@@ -234,6 +458,8 @@ pub async fn run_loop(
                         run_id: run_id.clone(),
                         termination: None,
                         outputs: Default::default(),
+                        artifacts: Default::default(),
+                        user_log: Vec::new(),
                         aggregate_metrics: None,
                     });
                 }
@@ -276,6 +502,35 @@ fn build_agent_context(
         context_overflow: context.context_overflow,
         interrupt_response: context.interrupt_response.clone(),
         response_format: context.response_format.clone(),
+        rendered_prompt: context.rendered_prompt.clone(),
+        prompt_cache: None,
+        cancellation: context.cancellation.clone(),
+        security_context: context.security_context.clone(),
+        tool_policy: context.tool_policy.clone(),
+        locale: context.locale.clone(),
+        timezone: context.timezone.clone(),
+        params: context.params.clone(),
+        idempotency_key: context.idempotency_key.clone(),
+    }
+}
+
+/// Run the workflow's cleanup agents from a `Terminate` (or an aborted
+/// drive), once each under its `timeout_seconds`. Best effort: failures are logged and never change
+/// the run's outcome; unregistered agents are skipped.
+async fn run_cleanup(agents: &AgentRegistry, context: &AgentDispatchContext, workflow_name: Arc<str>) {
+    if context.cleanup_agents.is_empty() {
+        return;
+    }
+    let ctx = build_agent_context(context, None, None, workflow_name);
+    for agent in &context.cleanup_agents {
+        if agents.get(agent).is_none() {
+            tracing::warn!(agent = %agent, "cleanup_agent_not_registered");
+            continue;
+        }
+        let output = execute_agent_with_timeout(agents, agent, &ctx, context.timeout_seconds, 0).await;
+        if !output.success {
+            tracing::warn!(agent = %agent, error = %output.error_message, "cleanup_failed");
+        }
     }
 }
 
@@ -320,6 +575,7 @@ async fn execute_with_retry(
         let output = execute_agent_with_timeout(agents, agent_name, ctx, timeout_seconds, attempt).await;
 
         accumulated_metrics.llm_calls += output.metrics.llm_calls;
+        accumulated_metrics.llm_cache_hits += output.metrics.llm_cache_hits;
         accumulated_metrics.tool_calls += output.metrics.tool_calls;
         accumulated_metrics.tokens_in = Some(
             accumulated_metrics.tokens_in.unwrap_or(0) + output.metrics.tokens_in.unwrap_or(0),
@@ -329,8 +585,13 @@ async fn execute_with_retry(
         );
         accumulated_metrics.duration_ms += output.metrics.duration_ms;
         accumulated_metrics.tool_results.extend(output.metrics.tool_results.clone());
+        accumulated_metrics.refused_tools.extend(output.metrics.refused_tools.clone());
 
-        if output.success || output.interrupt_request.is_some() || attempt + 1 >= max_attempts {
+        if output.success
+            || output.interrupt_request.is_some()
+            || output.failure_class == FailureClass::Fatal
+            || attempt + 1 >= max_attempts
+        {
             return AgentOutput {
                 metrics: accumulated_metrics,
                 ..output
@@ -340,7 +601,10 @@ async fn execute_with_retry(
         if let Some(policy) = retry_policy {
             let backoff_ms = (policy.initial_backoff_ms as f64
                 * policy.backoff_multiplier.powi(attempt as i32)) as u64;
-            let capped_ms = backoff_ms.min(policy.max_backoff_ms);
+            let capped_ms = match output.failure_class {
+                FailureClass::Throttled { retry_after_ms } => backoff_ms.min(policy.max_backoff_ms).max(retry_after_ms),
+                _ => backoff_ms.min(policy.max_backoff_ms),
+            };
             tracing::info!(agent = %agent_name, attempt, backoff_ms = capped_ms, "agent_retry");
             tokio::time::sleep(std::time::Duration::from_millis(capped_ms)).await;
         }
@@ -379,6 +643,9 @@ async fn execute_agent_with_timeout(
                 success: false,
                 error_message: msg,
                 interrupt_request: None,
+                artifacts: vec![],
+                user_log: vec![],
+                failure_class: FailureClass::Retryable,
             }
         }
     }
@@ -429,6 +696,9 @@ async fn execute_agent(
                 success: false,
                 error_message: e.to_string(),
                 interrupt_request: None,
+                artifacts: vec![],
+                user_log: vec![],
+                failure_class: FailureClass::from_error(&e),
             }
         }
     }
@@ -0,0 +1,203 @@
+//! Run lookup by metadata. Support tooling asks "which run is the request for
+//! repo X submitted around 14:00?"; `Kernel::search_runs` answers from the
+//! runs the kernel still holds, matching `run.audit.metadata` values by
+//! equality or prefix. Non-string metadata values match on their JSON text.
+//! Runs can also be selected by the worker that executed any of their stages
+//! (`ProcessingRecord::worker`) to size the blast radius of a bad build.
+
+use std::collections::HashMap;
+
+use chrono::{DateTime, Utc};
+
+use crate::run::Run;
+use crate::types::UserId;
+
+/// Filter for `Kernel::search_runs`. Every set condition must hold; the
+/// default query matches every run.
+#[derive(Debug, Clone, Default)]
+pub struct RunQuery {
+    /// `metadata[key]` must equal the value.
+    pub equals: HashMap<String, String>,
+    /// `metadata[key]` must start with the value.
+    pub prefix: HashMap<String, String>,
+    pub user_id: Option<UserId>,
+    /// `received_at` bounds, inclusive.
+    pub received_after: Option<DateTime<Utc>>,
+    pub received_before: Option<DateTime<Utc>>,
+    /// Some stage was executed by this worker id …
+    pub worker_id: Option<String>,
+    /// … (or by any worker, if `worker_id` is unset) at this version.
+    pub worker_version: Option<String>,
+    /// Most recent first; `None` = all matches.
+    pub limit: Option<usize>,
+}
+
+impl RunQuery {
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    pub fn equals(mut self, key: impl Into<String>, value: impl Into<String>) -> Self {
+        self.equals.insert(key.into(), value.into());
+        self
+    }
+
+    pub fn prefix(mut self, key: impl Into<String>, value: impl Into<String>) -> Self {
+        self.prefix.insert(key.into(), value.into());
+        self
+    }
+
+    pub fn user(mut self, user_id: UserId) -> Self {
+        self.user_id = Some(user_id);
+        self
+    }
+
+    pub fn received_between(mut self, after: DateTime<Utc>, before: DateTime<Utc>) -> Self {
+        self.received_after = Some(after);
+        self.received_before = Some(before);
+        self
+    }
+
+    pub fn worker(mut self, worker_id: impl Into<String>) -> Self {
+        self.worker_id = Some(worker_id.into());
+        self
+    }
+
+    pub fn worker_version(mut self, version: impl Into<String>) -> Self {
+        self.worker_version = Some(version.into());
+        self
+    }
+
+    pub fn limit(mut self, limit: usize) -> Self {
+        self.limit = Some(limit);
+        self
+    }
+
+    pub fn matches(&self, run: &Run) -> bool {
+        if self.user_id.as_ref().is_some_and(|user| *user != run.identity.user_id) {
+            return false;
+        }
+        if self.received_after.is_some_and(|after| run.received_at < after)
+            || self.received_before.is_some_and(|before| run.received_at > before)
+        {
+            return false;
+        }
+        if (self.worker_id.is_some() || self.worker_version.is_some())
+            && !run.audit.processing_history.iter().any(|record| {
+                record.worker.as_ref().is_some_and(|worker| {
+                    self.worker_id.as_ref().map_or(true, |id| *id == worker.id)
+                        && self.worker_version.as_ref().map_or(true, |version| *version == worker.version)
+                })
+            })
+        {
+            return false;
+        }
+        let metadata = |key: &str| run.audit.metadata.get(key).map(metadata_text);
+        self.equals
+            .iter()
+            .all(|(key, want)| metadata(key).is_some_and(|have| have == *want))
+            && self
+                .prefix
+                .iter()
+                .all(|(key, want)| metadata(key).is_some_and(|have| have.starts_with(want.as_str())))
+    }
+}
+
+fn metadata_text(value: &serde_json::Value) -> String {
+    match value {
+        serde_json::Value::String(s) => s.clone(),
+        other => other.to_string(),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::kernel::Kernel;
+    use crate::kernel::test_helpers::{create_test_run, create_test_workflow};
+    use crate::run::WorkerIdentity;
+    use crate::types::RunId;
+
+    fn start(kernel: &mut Kernel, id: &str, metadata: serde_json::Value) {
+        let mut run = create_test_run();
+        if let serde_json::Value::Object(fields) = metadata {
+            run.audit.metadata.extend(fields);
+        }
+        let _state = kernel
+            .initialize_orchestration(RunId::must(id), create_test_workflow(), run, false)
+            .unwrap();
+    }
+
+    #[test]
+    fn search_matches_equality_and_prefix() {
+        let mut kernel = Kernel::new();
+        start(&mut kernel, "r1", serde_json::json!({"repo": "jeeves-core", "ticket": 4812}));
+        start(&mut kernel, "r2", serde_json::json!({"repo": "jeeves-web", "ticket": 4813}));
+        start(&mut kernel, "r3", serde_json::json!({"team": "infra"}));
+
+        let ids = |query: RunQuery| -> Vec<String> {
+            let mut ids: Vec<String> =
+                kernel.search_runs(&query).iter().map(|id| id.as_str().to_string()).collect();
+            ids.sort();
+            ids
+        };
+        assert_eq!(ids(RunQuery::new().prefix("repo", "jeeves-")), vec!["r1", "r2"]);
+        assert_eq!(ids(RunQuery::new().equals("ticket", "4813")), vec!["r2"]);
+        assert_eq!(ids(RunQuery::new().prefix("repo", "jeeves").equals("ticket", "4812")), vec!["r1"]);
+        assert!(ids(RunQuery::new().equals("repo", "jeeves")).is_empty());
+        assert_eq!(ids(RunQuery::new()).len(), 3);
+    }
+
+    #[test]
+    fn search_filters_by_time_window_and_limit() {
+        let mut kernel = Kernel::new();
+        start(&mut kernel, "old", serde_json::json!({}));
+        start(&mut kernel, "new", serde_json::json!({}));
+        let now = Utc::now();
+        kernel.runs.get_mut("old").unwrap().received_at = now - chrono::Duration::hours(2);
+
+        let window = RunQuery::new().received_between(now - chrono::Duration::hours(1), now + chrono::Duration::hours(1));
+        assert_eq!(kernel.search_runs(&window), vec![RunId::must("new")]);
+        assert_eq!(kernel.search_runs(&RunQuery::new().limit(1)), vec![RunId::must("new")]);
+    }
+
+    #[test]
+    fn search_finds_runs_touched_by_a_worker_build() {
+        let mut kernel = Kernel::new();
+        let report = |kernel: &mut Kernel, id: &str, worker: &WorkerIdentity| {
+            let run_id = RunId::must(id);
+            let _ = kernel.get_next_instruction(&run_id).unwrap();
+            kernel
+                .process_agent_result(&run_id, "agent1", worker, serde_json::json!({}), None, Default::default(), true, "", false)
+                .unwrap();
+        };
+        for id in ["r1", "r2", "r3"] {
+            start(&mut kernel, id, serde_json::json!({}));
+        }
+        report(&mut kernel, "r1", &WorkerIdentity::new("w1", "1.4.0", "node-a"));
+        report(&mut kernel, "r2", &WorkerIdentity::new("w2", "1.4.1", "node-b"));
+
+        let ids = |query: RunQuery| -> Vec<String> {
+            let mut ids: Vec<String> =
+                kernel.search_runs(&query).iter().map(|id| id.as_str().to_string()).collect();
+            ids.sort();
+            ids
+        };
+        assert_eq!(ids(RunQuery::new().worker("w1")), vec!["r1"]);
+        assert_eq!(ids(RunQuery::new().worker_version("1.4.1")), vec!["r2"]);
+        assert!(ids(RunQuery::new().worker("w1").worker_version("1.4.1")).is_empty());
+
+        let anonymous = kernel.process_agent_result(
+            &RunId::must("r3"),
+            "agent1",
+            &WorkerIdentity::default(),
+            serde_json::json!({}),
+            None,
+            Default::default(),
+            true,
+            "",
+            false,
+        );
+        assert!(anonymous.is_err());
+    }
+}
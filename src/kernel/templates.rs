@@ -0,0 +1,499 @@
+//! Run templates. A named template bundles a workflow (stage order and
+//! bounds) with default run metadata, so every caller starting a
+//! "code-review" run gets the same configuration without repeating it.
+//! Templates are registered on the kernel before spawn, typically parsed
+//! from a local file with `RunTemplate::from_json`.
+//!
+//! A template can also run a canary: a next version of its workflow that
+//! takes a fixed percentage of new sessions while the rest stay on the
+//! stable one. Runs are tagged with the version they got, outcomes are
+//! counted per version, and aborting sends every new session back to
+//! stable.
+//!
+//! An archived templated run can be replayed: a fresh run with the same
+//! input and caller settings, on the version it originally got or on a
+//! newer workflow, so a failing request can be re-run against a fix.
+
+use std::collections::HashMap;
+
+use serde::{Deserialize, Serialize};
+
+use crate::run::{Run, TerminalReason};
+use crate::types::{Error, Result, RunId};
+use crate::workflow::Workflow;
+
+/// Metadata keys stamped on runs started through the kernel's templates.
+pub const TEMPLATE_KEY: &str = "template";
+pub const TEMPLATE_VERSION_KEY: &str = "template_version";
+/// Metadata key linking a replayed run to the `request_id` it re-runs.
+pub const REPLAY_OF_KEY: &str = "replay_of";
+
+/// Metadata recording how the archived run went rather than what was
+/// asked; not carried into a replay.
+const REPLAY_DROPPED_KEYS: &[&str] = &[
+    crate::run::INTERRUPT_HISTORY_KEY,
+    "workflow_migrations",
+    "output_write_violations",
+    "compacted_outputs",
+    "_interrupt_response",
+    REPLAY_OF_KEY,
+];
+
+/// What a replay changes relative to the archived run.
+#[derive(Debug, Clone, Default)]
+pub struct ReplayOverrides {
+    /// Run on this workflow instead of the template version the archived
+    /// run got. Required for runs not started from a template.
+    pub workflow: Option<Workflow>,
+    pub raw_input: Option<String>,
+    /// Layered over the archived metadata key by key.
+    pub metadata: serde_json::Map<String, serde_json::Value>,
+}
+
+/// Which workflow a templated run was started on.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Hash, Serialize, Deserialize)]
+#[serde(rename_all = "snake_case")]
+pub enum TemplateVersion {
+    Stable,
+    Canary,
+}
+
+/// Runs started on one version and how the finished ones ended.
+#[derive(Debug, Clone, Default, PartialEq, Eq, Serialize)]
+pub struct VersionStats {
+    pub started: u64,
+    /// Finished runs by `TerminalReason::outcome` (`completed`, `failed`,
+    /// `bounds_exceeded`).
+    pub outcomes: HashMap<String, u64>,
+}
+
+/// Result of `KernelHandle::canary_status`.
+#[derive(Debug, Clone, PartialEq, Eq, Serialize)]
+pub struct CanaryStatus {
+    pub template: String,
+    pub percent: u8,
+    pub aborted: bool,
+    pub stable: VersionStats,
+    pub canary: VersionStats,
+}
+
+#[derive(Debug)]
+struct Canary {
+    workflow: Workflow,
+    percent: u8,
+    aborted: bool,
+    stable: VersionStats,
+    canary: VersionStats,
+}
+
+impl Canary {
+    fn stats_mut(&mut self, version: TemplateVersion) -> &mut VersionStats {
+        match version {
+            TemplateVersion::Stable => &mut self.stable,
+            TemplateVersion::Canary => &mut self.canary,
+        }
+    }
+}
+
+/// Named workflow plus default metadata for new runs.
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct RunTemplate {
+    pub name: String,
+    pub workflow: Workflow,
+    /// Seeded into `audit.metadata` (`locale`, `timezone`, labels, …);
+    /// metadata passed at instantiation wins key by key.
+    #[serde(default, skip_serializing_if = "serde_json::Map::is_empty")]
+    pub metadata: serde_json::Map<String, serde_json::Value>,
+}
+
+impl RunTemplate {
+    pub fn new(name: impl Into<String>, workflow: Workflow) -> Self {
+        Self {
+            name: name.into(),
+            workflow,
+            metadata: serde_json::Map::new(),
+        }
+    }
+
+    pub fn with_metadata(mut self, key: impl Into<String>, value: serde_json::Value) -> Self {
+        self.metadata.insert(key.into(), value);
+        self
+    }
+
+    /// Parse and validate one template definition.
+    pub fn from_json(json: &str) -> Result<Self> {
+        let template: Self = serde_json::from_str(json)?;
+        template.validate()?;
+        Ok(template)
+    }
+
+    pub fn validate(&self) -> Result<()> {
+        if self.name.trim().is_empty() {
+            return Err(Error::validation("Run template name must not be empty"));
+        }
+        self.workflow.validate()
+    }
+
+    /// The workflow and a fresh run for `runner::run`. `metadata`, when
+    /// given, must be a JSON object.
+    pub fn instantiate(
+        &self,
+        user_id: &str,
+        session_id: &str,
+        raw_input: &str,
+        metadata: Option<serde_json::Value>,
+    ) -> Result<(Workflow, Run)> {
+        let mut merged = self.metadata.clone();
+        match metadata {
+            None => {}
+            Some(serde_json::Value::Object(overrides)) => merged.extend(overrides),
+            Some(_) => return Err(Error::validation("Run metadata must be a JSON object")),
+        }
+        let run = Run::new(user_id, session_id, raw_input, Some(serde_json::Value::Object(merged)));
+        Ok((self.workflow.clone(), run))
+    }
+}
+
+/// Templates held by the kernel, by name.
+#[derive(Debug, Default)]
+pub struct RunTemplates {
+    templates: HashMap<String, RunTemplate>,
+    canaries: HashMap<String, Canary>,
+    /// Runs counted as started under a canary, until their outcome lands.
+    in_flight: HashMap<RunId, (String, TemplateVersion)>,
+}
+
+impl RunTemplates {
+    /// Validate and store `template`, replacing any earlier one of that name.
+    pub fn insert(&mut self, template: RunTemplate) -> Result<()> {
+        template.validate()?;
+        self.templates.insert(template.name.clone(), template);
+        Ok(())
+    }
+
+    pub fn get(&self, name: &str) -> Result<RunTemplate> {
+        self.templates
+            .get(name)
+            .cloned()
+            .ok_or_else(|| Error::not_found(format!("Run template not found: {}", name)))
+    }
+
+    /// Send `percent` of new sessions for `name` to `workflow`. Replaces any
+    /// earlier canary for the template, resetting its counts.
+    pub fn start_canary(&mut self, name: &str, workflow: Workflow, percent: u8) -> Result<()> {
+        self.get(name)?;
+        if percent > 100 {
+            return Err(Error::validation(format!("Canary percent must be 0–100, got {}", percent)));
+        }
+        workflow.validate()?;
+        self.canaries.insert(
+            name.to_string(),
+            Canary {
+                workflow,
+                percent,
+                aborted: false,
+                stable: VersionStats::default(),
+                canary: VersionStats::default(),
+            },
+        );
+        Ok(())
+    }
+
+    /// Route every new session for `name` to stable. Canary runs already
+    /// started finish on the canary workflow and are still counted.
+    pub fn abort_canary(&mut self, name: &str) -> Result<()> {
+        let canary = self.canary_mut(name)?;
+        canary.aborted = true;
+        Ok(())
+    }
+
+    pub fn canary_status(&self, name: &str) -> Result<CanaryStatus> {
+        let canary = self
+            .canaries
+            .get(name)
+            .ok_or_else(|| Error::not_found(format!("No canary for run template: {}", name)))?;
+        Ok(CanaryStatus {
+            template: name.to_string(),
+            percent: canary.percent,
+            aborted: canary.aborted,
+            stable: canary.stable.clone(),
+            canary: canary.canary.clone(),
+        })
+    }
+
+    /// Instantiate `name`, picking the canary workflow for the configured
+    /// share of sessions (by a hash of `session_id`, so a session stays on
+    /// one version). The run's metadata records the template and version.
+    pub fn instantiate(
+        &self,
+        name: &str,
+        user_id: &str,
+        session_id: &str,
+        raw_input: &str,
+        metadata: Option<serde_json::Value>,
+    ) -> Result<(Workflow, Run)> {
+        let mut template = self.get(name)?;
+        let mut version = TemplateVersion::Stable;
+        if let Some(canary) = self.canaries.get(name).filter(|c| !c.aborted) {
+            if session_bucket(session_id) < u64::from(canary.percent) {
+                template.workflow = canary.workflow.clone();
+                version = TemplateVersion::Canary;
+            }
+        }
+        let (workflow, mut run) = template.instantiate(user_id, session_id, raw_input, metadata)?;
+        run.audit.metadata.insert(TEMPLATE_KEY.to_string(), serde_json::json!(name));
+        run.audit.metadata.insert(TEMPLATE_VERSION_KEY.to_string(), serde_json::json!(version));
+        Ok((workflow, run))
+    }
+
+    /// The workflow and a fresh run re-running `archived`: same user,
+    /// session, input, `params` and caller metadata, tagged with
+    /// `REPLAY_OF_KEY`. Without an override workflow the run gets the
+    /// template version recorded on `archived` (a canary only while that
+    /// template still has one); with one, the template tags are dropped so
+    /// the replay is not counted against either version. Metadata agents
+    /// wrote is indistinguishable from the caller's and is carried too;
+    /// settings that must replay exactly belong in `params`.
+    pub fn replay(&self, archived: &Run, overrides: ReplayOverrides) -> Result<(Workflow, Run)> {
+        let mut metadata: serde_json::Map<String, serde_json::Value> = archived
+            .audit
+            .metadata
+            .iter()
+            .filter(|(key, _)| !REPLAY_DROPPED_KEYS.contains(&key.as_str()))
+            .map(|(key, value)| (key.clone(), value.clone()))
+            .collect();
+        metadata.extend(overrides.metadata);
+
+        let workflow = match overrides.workflow {
+            Some(workflow) => {
+                workflow.validate()?;
+                metadata.remove(TEMPLATE_KEY);
+                metadata.remove(TEMPLATE_VERSION_KEY);
+                workflow
+            }
+            None => self.recorded_workflow(&metadata)?,
+        };
+        metadata.insert(REPLAY_OF_KEY.to_string(), serde_json::json!(archived.identity.request_id));
+
+        let raw_input = overrides.raw_input.unwrap_or_else(|| archived.raw_input.clone());
+        let run = Run::new(
+            archived.identity.user_id.as_str(),
+            archived.identity.session_id.as_str(),
+            &raw_input,
+            Some(serde_json::Value::Object(metadata)),
+        )
+        .with_params(archived.params.clone());
+        Ok((workflow, run))
+    }
+
+    /// Workflow of the template version named in a run's metadata.
+    fn recorded_workflow(&self, metadata: &serde_json::Map<String, serde_json::Value>) -> Result<Workflow> {
+        let name = metadata
+            .get(TEMPLATE_KEY)
+            .and_then(|v| v.as_str())
+            .ok_or_else(|| Error::validation("Run was not started from a template; pass a workflow to replay it"))?;
+        let version = metadata
+            .get(TEMPLATE_VERSION_KEY)
+            .and_then(|v| serde_json::from_value::<TemplateVersion>(v.clone()).ok())
+            .unwrap_or(TemplateVersion::Stable);
+        match version {
+            TemplateVersion::Stable => Ok(self.get(name)?.workflow),
+            TemplateVersion::Canary => self
+                .canaries
+                .get(name)
+                .map(|canary| canary.workflow.clone())
+                .ok_or_else(|| Error::not_found(format!("No canary for run template: {}", name))),
+        }
+    }
+
+    /// Count an admitted run against its template's canary, if it has one.
+    pub fn track(&mut self, run_id: &RunId, run: &Run) {
+        let Some(name) = run.audit.metadata.get(TEMPLATE_KEY).and_then(|v| v.as_str()) else {
+            return;
+        };
+        let Some(version) = run
+            .audit
+            .metadata
+            .get(TEMPLATE_VERSION_KEY)
+            .and_then(|v| serde_json::from_value::<TemplateVersion>(v.clone()).ok())
+        else {
+            return;
+        };
+        if self.in_flight.contains_key(run_id) {
+            return;
+        }
+        if let Some(canary) = self.canaries.get_mut(name) {
+            canary.stats_mut(version).started += 1;
+            self.in_flight.insert(run_id.clone(), (name.to_string(), version));
+        }
+    }
+
+    /// Count a tracked run's outcome once; later calls are no-ops.
+    pub fn record_outcome(&mut self, run_id: &RunId, reason: TerminalReason) {
+        let Some((name, version)) = self.in_flight.remove(run_id) else {
+            return;
+        };
+        if let Some(canary) = self.canaries.get_mut(&name) {
+            *canary.stats_mut(version).outcomes.entry(reason.outcome().to_string()).or_default() += 1;
+        }
+    }
+
+    /// Stop tracking a run removed without a terminal outcome.
+    pub fn forget(&mut self, run_id: &RunId) {
+        self.in_flight.remove(run_id);
+    }
+
+    fn canary_mut(&mut self, name: &str) -> Result<&mut Canary> {
+        self.canaries
+            .get_mut(name)
+            .ok_or_else(|| Error::not_found(format!("No canary for run template: {}", name)))
+    }
+}
+
+/// Stable 0–99 bucket for a session id: 64-bit FNV-1a over its UTF-8
+/// bytes, mod 100. Fixed by definition (unlike `DefaultHasher`, whose
+/// output may change between Rust releases), so a session keeps its
+/// stable/canary side across toolchain upgrades and restarts.
+fn session_bucket(session_id: &str) -> u64 {
+    const FNV_OFFSET_BASIS: u64 = 0xcbf2_9ce4_8422_2325;
+    const FNV_PRIME: u64 = 0x0000_0100_0000_01b3;
+    let hash = session_id
+        .bytes()
+        .fold(FNV_OFFSET_BASIS, |hash, byte| (hash ^ u64::from(byte)).wrapping_mul(FNV_PRIME));
+    hash % 100
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::kernel::test_helpers::create_test_workflow;
+
+    #[test]
+    fn session_bucket_is_pinned() {
+        // FNV-1a("a") = 0xaf63dc4c8601ec8c; the buckets must never move.
+        assert_eq!(session_bucket("a"), 0xaf63_dc4c_8601_ec8c % 100);
+        assert_eq!(session_bucket(""), 37);
+        assert_eq!(session_bucket("session-1"), 61);
+        assert_eq!(session_bucket("sess-42"), 60);
+    }
+
+    #[test]
+    fn instantiate_layers_caller_metadata_over_defaults() {
+        let json = serde_json::json!({
+            "name": "code-review",
+            "workflow": create_test_workflow(),
+            "metadata": {"locale": "en-GB", "labels": ["review"]},
+        })
+        .to_string();
+        let template = RunTemplate::from_json(&json).unwrap();
+        let mut templates = RunTemplates::default();
+        templates.insert(template).unwrap();
+
+        let template = templates.get("code-review").unwrap();
+        let (workflow, run) = template
+            .instantiate("alice", "s1", "review #42", Some(serde_json::json!({"locale": "fr-FR"})))
+            .unwrap();
+        assert_eq!(workflow.name, "test_workflow");
+        assert_eq!(run.locale.as_deref(), Some("fr-FR"));
+        assert_eq!(run.audit.metadata["labels"], serde_json::json!(["review"]));
+
+        assert!(template.instantiate("alice", "s1", "x", Some(serde_json::json!([1]))).is_err());
+        assert_eq!(templates.get("missing").unwrap_err().to_error_code(), "NOT_FOUND");
+        let mut unnamed = RunTemplate::new(" ", create_test_workflow());
+        assert!(templates.insert(unnamed.clone()).is_err());
+        unnamed.name = "ok".into();
+        unnamed.workflow.stages.clear();
+        assert!(templates.insert(unnamed).is_err());
+    }
+
+    #[test]
+    fn canary_splits_sessions_and_counts_outcomes_per_version() {
+        use crate::kernel::Kernel;
+        use serde_json::json;
+
+        let mut kernel = Kernel::new();
+        kernel.add_run_template(RunTemplate::new("review", create_test_workflow())).unwrap();
+        let mut next = create_test_workflow();
+        next.name = "test_workflow_v2".into();
+        assert_eq!(kernel.start_canary("review", next.clone(), 101).unwrap_err().to_error_code(), "INVALID_ARGUMENT");
+        assert_eq!(kernel.start_canary("missing", next.clone(), 10).unwrap_err().to_error_code(), "NOT_FOUND");
+        kernel.start_canary("review", next, 30).unwrap();
+
+        let version_of = |kernel: &Kernel, session: &str| {
+            let (workflow, run) = kernel.instantiate_run_template("review", "u", session, "hi", None).unwrap();
+            let version = run.audit.metadata[TEMPLATE_VERSION_KEY].clone();
+            assert_eq!(workflow.name == "test_workflow_v2", version == json!("canary"));
+            (version, run)
+        };
+        let sessions: Vec<String> = (0..200).map(|i| format!("s{}", i)).collect();
+        let canary_sessions: Vec<&String> = sessions.iter().filter(|s| version_of(&kernel, s).0 == json!("canary")).collect();
+        assert!((30..90).contains(&canary_sessions.len()), "{} of 200 on canary", canary_sessions.len());
+        assert_eq!(version_of(&kernel, canary_sessions[0]).0, json!("canary"), "sticky per session");
+
+        let stable_session = sessions.iter().find(|s| !canary_sessions.contains(s)).unwrap();
+        for (id, session) in [("c1", canary_sessions[0]), ("s1", stable_session)] {
+            let (_, mut run) = version_of(&kernel, session);
+            let run_id = RunId::must(id);
+            kernel.admit_run(&run_id, &mut run).unwrap();
+            let _state = kernel.initialize_orchestration(run_id, create_test_workflow(), run, false).unwrap();
+        }
+        let canary_run = RunId::must("c1");
+        kernel.runs.get_mut(&canary_run).unwrap().terminate_with(TerminalReason::PolicyViolation, None);
+        let _ = kernel.get_next_instruction(&canary_run).unwrap();
+        let _ = kernel.get_next_instruction(&canary_run).unwrap();
+        kernel.terminate_run(&RunId::must("s1")).unwrap();
+
+        let status = kernel.canary_status("review").unwrap();
+        assert_eq!((status.percent, status.aborted), (30, false));
+        assert_eq!(status.canary.started, 1);
+        assert_eq!(status.canary.outcomes, HashMap::from([("failed".to_string(), 1)]));
+        assert_eq!(status.stable.outcomes, HashMap::from([("completed".to_string(), 1)]));
+
+        kernel.abort_canary("review").unwrap();
+        assert!(sessions.iter().all(|s| version_of(&kernel, s).0 == json!("stable")));
+        assert!(kernel.canary_status("review").unwrap().aborted);
+    }
+
+    #[test]
+    fn replay_reruns_the_recorded_version_or_an_override() {
+        use serde_json::json;
+
+        let mut templates = RunTemplates::default();
+        templates.insert(RunTemplate::new("review", create_test_workflow())).unwrap();
+        let mut next = create_test_workflow();
+        next.name = "test_workflow_v2".into();
+        templates.start_canary("review", next.clone(), 100).unwrap();
+
+        let (_, mut archived) = templates
+            .instantiate("review", "alice", "s1", "review #42", Some(json!({"repo": "core"})))
+            .unwrap();
+        archived.params.insert("branch".into(), json!("main"));
+        archived.set_interrupt(crate::run::FlowInterrupt::new().with_message("Merge?".into()));
+        archived.close_interrupt(crate::run::InterruptOutcome::Expired, None);
+        assert!(archived.audit.metadata.contains_key(crate::run::INTERRUPT_HISTORY_KEY));
+
+        let (workflow, run) = templates.replay(&archived, ReplayOverrides::default()).unwrap();
+        assert_eq!(workflow.name, "test_workflow_v2", "canary version as recorded");
+        assert_eq!((run.raw_input.as_str(), run.identity.user_id.as_str()), ("review #42", "alice"));
+        assert_ne!(run.identity.request_id, archived.identity.request_id);
+        assert_eq!(run.audit.metadata[REPLAY_OF_KEY], json!(archived.identity.request_id));
+        assert_eq!(run.audit.metadata["repo"], json!("core"));
+        assert_eq!(run.params["branch"], json!("main"));
+        assert!(!run.audit.metadata.contains_key(crate::run::INTERRUPT_HISTORY_KEY));
+
+        let overrides = ReplayOverrides {
+            workflow: Some(create_test_workflow()),
+            raw_input: Some("review #43".into()),
+            metadata: json!({"repo": "fork"}).as_object().unwrap().clone(),
+        };
+        let (workflow, run) = templates.replay(&archived, overrides).unwrap();
+        assert_eq!(workflow.name, "test_workflow");
+        assert_eq!(run.raw_input, "review #43");
+        assert_eq!(run.audit.metadata["repo"], json!("fork"));
+        assert!(!run.audit.metadata.contains_key(TEMPLATE_VERSION_KEY));
+
+        let adhoc = Run::new("bob", "s2", "hello", None);
+        let err = templates.replay(&adhoc, ReplayOverrides::default()).unwrap_err();
+        assert_eq!(err.to_error_code(), "INVALID_ARGUMENT");
+    }
+}
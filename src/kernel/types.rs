@@ -2,7 +2,7 @@
 
 use chrono::{DateTime, Utc};
 use serde::{Deserialize, Serialize};
-use crate::types::{InterruptId, RunId, RequestId, SessionId, UserId};
+use crate::types::{Error, InterruptId, Result, RunId, RequestId, SessionId, UserId};
 
 /// Run lifecycle state.
 ///
@@ -17,8 +17,9 @@ pub enum RunStatus {
     /// Active execution. May be suspended on a pending interrupt; consult
     /// `RunRecord::pending_interrupt` to differentiate.
     Running,
-    /// Terminated. Runs in this state are immediately removed by the
-    /// kernel; they don't linger as zombies.
+    /// Terminated. Removed by the kernel at once, unless a zombie
+    /// retention window (`Kernel::set_zombie_retention`) keeps the record
+    /// and its run queryable until `reap_zombies` clears them.
     Terminated,
 }
 
@@ -27,6 +28,16 @@ impl RunStatus {
     pub fn is_terminal(self) -> bool {
         self == RunStatus::Terminated
     }
+
+    /// Valid edges: `Ready → Running`, and `Ready | Running → Terminated`.
+    pub fn can_transition_to(self, next: RunStatus) -> bool {
+        matches!(
+            (self, next),
+            (RunStatus::Ready, RunStatus::Running)
+                | (RunStatus::Ready, RunStatus::Terminated)
+                | (RunStatus::Running, RunStatus::Terminated)
+        )
+    }
 }
 
 /// Resource quota — bounds enforced per run.
@@ -40,6 +51,36 @@ pub struct ResourceQuota {
     pub max_agent_hops: i32,
     pub max_iterations: i32,
     pub timeout_seconds: i32,
+    /// Bound on tool-call bytes (arguments + results) per run; 0 = no bound.
+    /// A single broad search can dwarf the run's token usage.
+    #[serde(default)]
+    pub max_tool_bytes: u64,
+    /// Limits that refill over the run's lifetime (long-lived conversational
+    /// runs). Applied lazily from elapsed time by `effective_at`.
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub regeneration: Vec<QuotaRegeneration>,
+}
+
+/// Quota dimension that can regenerate.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "snake_case")]
+pub enum QuotaField {
+    LlmCalls,
+    ToolCalls,
+    AgentHops,
+    Iterations,
+    InputTokens,
+    OutputTokens,
+}
+
+/// Refill `field` by `amount` every `every_seconds` of run time, never past
+/// `cap`. E.g. `{llm_calls, 1, 60, 200}` adds one LLM call per minute.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize)]
+pub struct QuotaRegeneration {
+    pub field: QuotaField,
+    pub amount: i32,
+    pub every_seconds: u64,
+    pub cap: i32,
 }
 
 impl ResourceQuota {
@@ -53,8 +94,69 @@ impl ResourceQuota {
             max_agent_hops: 10,
             max_iterations: 20,
             timeout_seconds: 300,
+            max_tool_bytes: 0,
+            regeneration: Vec::new(),
         }
     }
+
+    /// Reject limits no run could work under: every bound must be positive
+    /// (`timeout_seconds` and `max_tool_bytes` may be 0, meaning unbounded) and every
+    /// regeneration rule must refill something on a real period.
+    pub fn validate(&self) -> Result<()> {
+        let bounds = [
+            ("max_input_tokens", self.max_input_tokens),
+            ("max_output_tokens", self.max_output_tokens),
+            ("max_context_tokens", self.max_context_tokens),
+            ("max_llm_calls", self.max_llm_calls),
+            ("max_tool_calls", self.max_tool_calls),
+            ("max_agent_hops", self.max_agent_hops),
+            ("max_iterations", self.max_iterations),
+        ];
+        if let Some((name, value)) = bounds.iter().find(|(_, value)| *value <= 0) {
+            return Err(Error::validation(format!("quota {} must be positive, got {}", name, value)));
+        }
+        if self.timeout_seconds < 0 {
+            return Err(Error::validation(format!(
+                "quota timeout_seconds must not be negative, got {}",
+                self.timeout_seconds
+            )));
+        }
+        for rule in &self.regeneration {
+            if rule.amount <= 0 || rule.every_seconds == 0 {
+                return Err(Error::validation(format!(
+                    "quota regeneration for {:?} needs a positive amount and period",
+                    rule.field
+                )));
+            }
+        }
+        Ok(())
+    }
+
+    /// Limits in force after `elapsed_seconds` of run time, with every
+    /// regeneration rule applied. A rule never lowers a limit that already
+    /// exceeds its cap.
+    pub fn effective_at(&self, elapsed_seconds: f64) -> Self {
+        let mut quota = self.clone();
+        for rule in &self.regeneration {
+            if rule.every_seconds == 0 || rule.amount <= 0 {
+                continue;
+            }
+            let periods = (elapsed_seconds.max(0.0) / rule.every_seconds as f64).floor();
+            let refill = (periods * rule.amount as f64).min(i32::MAX as f64) as i32;
+            let limit = match rule.field {
+                QuotaField::LlmCalls => &mut quota.max_llm_calls,
+                QuotaField::ToolCalls => &mut quota.max_tool_calls,
+                QuotaField::AgentHops => &mut quota.max_agent_hops,
+                QuotaField::Iterations => &mut quota.max_iterations,
+                QuotaField::InputTokens => &mut quota.max_input_tokens,
+                QuotaField::OutputTokens => &mut quota.max_output_tokens,
+            };
+            if *limit < rule.cap {
+                *limit = limit.saturating_add(refill).min(rule.cap);
+            }
+        }
+        quota
+    }
 }
 
 impl Default for ResourceQuota {
@@ -63,6 +165,49 @@ impl Default for ResourceQuota {
     }
 }
 
+/// Scheduling demotion for runs that burn through their quota while still
+/// inside it. Once any listed dimension's `percent_used` (as reported by
+/// `RemainingBudget`) reaches its threshold, `claim_next_instruction`
+/// serves the run only when no undemoted session is claimable, unless it
+/// has waited `max_wait_seconds`.
+#[derive(Debug, Clone, Default, PartialEq, Serialize, Deserialize)]
+pub struct DemotionPolicy {
+    /// `percent_used` key (`llm_calls`, `tokens_in`, `time`, …) → percent
+    /// at which the run is demoted, e.g. `{"llm_calls": 70.0}`.
+    pub thresholds: std::collections::BTreeMap<String, f64>,
+    /// Runs carrying any of these classifier labels are never demoted.
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub exempt_labels: Vec<String>,
+    /// Aging: a demoted session idle this long competes like any other, so
+    /// a steady stream of undemoted work cannot starve it. `None` = never.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub max_wait_seconds: Option<u64>,
+}
+
+impl DemotionPolicy {
+    /// Every threshold must be a positive percentage and `max_wait_seconds`
+    /// in `1..=MAX_WINDOW_SECONDS` when set.
+    pub fn validate(&self) -> Result<()> {
+        if self.max_wait_seconds == Some(0) {
+            return Err(Error::validation("demotion max_wait_seconds must be > 0 when set"));
+        }
+        if let Some(secs) = self.max_wait_seconds.filter(|&s| s > crate::workflow::MAX_WINDOW_SECONDS) {
+            return Err(Error::validation(format!(
+                "demotion max_wait_seconds must be <= {}, got {}",
+                crate::workflow::MAX_WINDOW_SECONDS,
+                secs
+            )));
+        }
+        match self.thresholds.iter().find(|(_, percent)| !(**percent > 0.0)) {
+            Some((key, percent)) => Err(Error::validation(format!(
+                "demotion threshold for {} must be a positive percent, got {}",
+                key, percent
+            ))),
+            None => Ok(()),
+        }
+    }
+}
+
 /// Resource usage tracking.
 #[derive(Debug, Clone, Serialize, Deserialize, PartialEq, Default)]
 pub struct ResourceUsage {
@@ -72,6 +217,8 @@ pub struct ResourceUsage {
     pub iterations: i32,
     pub tokens_in: i64,
     pub tokens_out: i64,
+    #[serde(default)]
+    pub tool_bytes: u64,
     pub elapsed_seconds: f64,
 }
 
@@ -85,6 +232,7 @@ pub enum QuotaViolation {
     TokensIn { used: i64, limit: i64 },
     TokensOut { used: i64, limit: i64 },
     Timeout { elapsed: f64, limit: f64 },
+    ToolBytes { used: u64, limit: u64 },
 }
 
 impl std::fmt::Display for QuotaViolation {
@@ -97,6 +245,7 @@ impl std::fmt::Display for QuotaViolation {
             Self::TokensIn { used, limit } => write!(f, "tokens_in {} > {}", used, limit),
             Self::TokensOut { used, limit } => write!(f, "tokens_out {} > {}", used, limit),
             Self::Timeout { elapsed, limit } => write!(f, "elapsed_seconds {} > {}", elapsed, limit),
+            Self::ToolBytes { used, limit } => write!(f, "tool_bytes {} > {}", used, limit),
         }
     }
 }
@@ -125,6 +274,9 @@ impl ResourceUsage {
         if quota.timeout_seconds > 0 && self.elapsed_seconds > quota.timeout_seconds as f64 {
             return Some(QuotaViolation::Timeout { elapsed: self.elapsed_seconds, limit: quota.timeout_seconds as f64 });
         }
+        if quota.max_tool_bytes > 0 && self.tool_bytes > quota.max_tool_bytes {
+            return Some(QuotaViolation::ToolBytes { used: self.tool_bytes, limit: quota.max_tool_bytes });
+        }
         None
     }
 }
@@ -160,6 +312,15 @@ pub struct RunRecord {
     /// on. None when actively running.
     #[serde(default, skip_serializing_if = "Option::is_none")]
     pub pending_interrupt: Option<InterruptId>,
+
+    /// Labels assigned by the kernel's `RunClassifier` at session init.
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub labels: Vec<String>,
+
+    /// When the kernel's `DemotionPolicy` demoted this run. Sticky for the
+    /// rest of the run.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub demoted_at: Option<DateTime<Utc>>,
 }
 
 impl RunRecord {
@@ -175,19 +336,34 @@ impl RunRecord {
             started_at: None,
             completed_at: None,
             pending_interrupt: None,
+            labels: Vec::new(),
+            demoted_at: None,
         }
     }
 
     /// Transition to RUNNING state.
-    pub(crate) fn start(&mut self) {
-        self.state = RunStatus::Running;
+    pub fn start(&mut self) -> Result<()> {
+        self.transition(RunStatus::Running)?;
         self.started_at = Some(Utc::now());
+        Ok(())
     }
 
     /// Transition to TERMINATED state.
-    pub(crate) fn complete(&mut self) {
-        self.state = RunStatus::Terminated;
+    pub fn complete(&mut self) -> Result<()> {
+        self.transition(RunStatus::Terminated)?;
         self.completed_at = Some(Utc::now());
+        Ok(())
+    }
+
+    fn transition(&mut self, next: RunStatus) -> Result<()> {
+        if !self.state.can_transition_to(next) {
+            return Err(Error::state_transition(format!(
+                "run {}: invalid transition {:?} → {:?}",
+                self.run_id, self.state, next
+            )));
+        }
+        self.state = next;
+        Ok(())
     }
 
     /// Elapsed wall-clock seconds since `start()`. `0.0` before start. Frozen
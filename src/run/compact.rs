@@ -0,0 +1,158 @@
+//! Run compaction — shrink a `Run` before archiving or shipping it offline.
+
+use std::collections::HashSet;
+
+use crate::types::AgentName;
+
+use super::Run;
+
+/// What `Run::compact` is allowed to drop.
+#[derive(Debug, Clone, Default)]
+pub struct CompactOptions {
+    /// Keep only the newest N processing records. `None` keeps all.
+    pub max_history: Option<usize>,
+    /// Agents whose outputs are already summarized downstream; their
+    /// `outputs` entries are removed.
+    pub drop_outputs_of: HashSet<AgentName>,
+}
+
+/// Result of `Run::compact`. Byte counts are serialized JSON sizes.
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq)]
+pub struct CompactionStats {
+    pub bytes_before: usize,
+    pub bytes_after: usize,
+    pub resolved_interrupt_removed: bool,
+    pub empty_outputs_removed: usize,
+    pub history_records_trimmed: usize,
+    pub outputs_dropped: usize,
+}
+
+impl CompactionStats {
+    pub fn bytes_saved(&self) -> usize {
+        self.bytes_before.saturating_sub(self.bytes_after)
+    }
+}
+
+impl Run {
+    /// Strip state that no longer affects execution: an interrupt that already
+    /// carries a response, empty per-agent output maps, history beyond
+    /// `max_history`, and outputs of agents listed in `drop_outputs_of`.
+    pub fn compact(&mut self, opts: &CompactOptions) -> CompactionStats {
+        let mut stats = CompactionStats {
+            bytes_before: serialized_len(self),
+            ..CompactionStats::default()
+        };
+
+        if self
+            .interrupts
+            .interrupt
+            .as_ref()
+            .is_some_and(|i| i.response.is_some())
+        {
+            self.clear_interrupt();
+            stats.resolved_interrupt_removed = true;
+        }
+
+        let before = self.outputs.len();
+        self.outputs.retain(|_, output| !output.is_empty());
+        stats.empty_outputs_removed = before - self.outputs.len();
+
+        let before = self.outputs.len();
+        self.outputs.retain(|agent, _| !opts.drop_outputs_of.contains(agent));
+        stats.outputs_dropped = before - self.outputs.len();
+        self.recount_output_bytes();
+
+        if let Some(max) = opts.max_history {
+            let history = &mut self.audit.processing_history;
+            if history.len() > max {
+                let excess = history.len() - max;
+                history.drain(..excess);
+                stats.history_records_trimmed = excess;
+            }
+        }
+
+        stats.bytes_after = serialized_len(self);
+        stats
+    }
+}
+
+fn serialized_len(run: &Run) -> usize {
+    serde_json::to_vec(run).map(|v| v.len()).unwrap_or(0)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::run::{FlowInterrupt, InterruptResponse, ProcessingRecord, ProcessingStatus};
+    use chrono::Utc;
+    use std::collections::HashMap;
+
+    fn record(agent: &str) -> ProcessingRecord {
+        ProcessingRecord {
+            agent: agent.to_string(),
+            stage_order: 1,
+            started_at: Utc::now(),
+            completed_at: None,
+            duration_ms: 0,
+            status: ProcessingStatus::Success,
+            error: None,
+            llm_calls: 0,
+            tool_calls: 0,
+            tokens_in: 0,
+            tokens_out: 0,
+            worker: None,
+        }
+    }
+
+    #[test]
+    fn compact_strips_resolved_state() {
+        let mut run = Run::anonymous();
+        let mut interrupt = FlowInterrupt::new();
+        interrupt.response = Some(InterruptResponse {
+            text: None,
+            approved: Some(true),
+            decision: None,
+            data: None,
+            received_at: Utc::now(),
+        });
+        run.set_interrupt(interrupt);
+        run.outputs.insert("empty".into(), Default::default());
+        run.outputs.insert(
+            "summarized".into(),
+            HashMap::from([("text".into(), serde_json::json!("long text"))]).into(),
+        );
+        run.outputs.insert(
+            "kept".into(),
+            HashMap::from([("v".into(), serde_json::json!(1))]).into(),
+        );
+        for i in 0..5 {
+            run.add_processing_record(record(&format!("a{}", i)));
+        }
+
+        let stats = run.compact(&CompactOptions {
+            max_history: Some(2),
+            drop_outputs_of: HashSet::from(["summarized".into()]),
+        });
+
+        assert!(stats.resolved_interrupt_removed);
+        assert!(!run.interrupts.is_pending());
+        assert_eq!(stats.empty_outputs_removed, 1);
+        assert_eq!(stats.outputs_dropped, 1);
+        assert_eq!(run.outputs.len(), 1);
+        assert!(run.outputs.contains_key("kept"));
+        assert_eq!(stats.history_records_trimmed, 3);
+        let agents: Vec<&str> = run.audit.processing_history.iter().map(|r| r.agent.as_str()).collect();
+        assert_eq!(agents, vec!["a3", "a4"]);
+        assert!(stats.bytes_saved() > 0);
+    }
+
+    #[test]
+    fn compact_keeps_pending_interrupt() {
+        let mut run = Run::anonymous();
+        run.set_interrupt(FlowInterrupt::new());
+        let stats = run.compact(&CompactOptions::default());
+        assert!(!stats.resolved_interrupt_removed);
+        assert!(run.interrupts.is_pending());
+        assert_eq!(stats.bytes_saved(), 0);
+    }
+}
@@ -2,10 +2,11 @@
 //!
 //! Canonical enum definitions for the Jeeves kernel.
 
+use schemars::JsonSchema;
 use serde::{Deserialize, Serialize};
 
 /// Why processing terminated.
-#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize)]
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize, JsonSchema)]
 #[serde(rename_all = "SCREAMING_SNAKE_CASE")]
 #[non_exhaustive]
 pub enum TerminalReason {
@@ -14,7 +15,24 @@ pub enum TerminalReason {
     MaxLlmCallsExceeded,
     MaxAgentHopsExceeded,
     MaxStageVisitsExceeded,
+    /// The run's deadline (`Workflow::max_duration_seconds`) passed.
+    TimeoutExceeded,
+    /// Stage outputs outgrew `Workflow::max_output_bytes`.
+    OutputBudgetExceeded,
+    /// Tool-call payloads outgrew `ResourceQuota::max_tool_bytes`.
+    ToolBytesExceeded,
+    /// An `at_most_once` stage's lease lapsed without a report: it may or
+    /// may not have taken effect, so it is not dispatched again.
+    DeliveryAmbiguous,
+    /// A bootstrap stage failed and declared no `error_next`.
+    BootstrapFailed,
+    /// The run raised more interrupts of one kind than
+    /// `Workflow::max_interrupts_per_kind` allows.
+    InterruptLimitExceeded,
     UserCancelled,
+    /// The streaming consumer went away (event receiver dropped) and the
+    /// runner was configured to cancel rather than detach.
+    ClientCancelled,
     ToolFailedFatally,
     LlmFailedFatally,
     PolicyViolation,
@@ -32,12 +50,45 @@ impl TerminalReason {
             Self::MaxIterationsExceeded
             | Self::MaxLlmCallsExceeded
             | Self::MaxAgentHopsExceeded
-            | Self::MaxStageVisitsExceeded => "bounds_exceeded",
+            | Self::MaxStageVisitsExceeded
+            | Self::TimeoutExceeded
+            | Self::OutputBudgetExceeded
+            | Self::ToolBytesExceeded
+            | Self::InterruptLimitExceeded => "bounds_exceeded",
             _ => "failed",
         }
     }
 }
 
+/// What a `FlowInterrupt` asks of the consumer. Derived from its fields
+/// (see `FlowInterrupt::kind`), never stored on it.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, PartialOrd, Ord, Hash, Serialize, Deserialize)]
+#[serde(rename_all = "snake_case")]
+pub enum InterruptKind {
+    /// Approve or reject; no `question`.
+    Confirmation,
+    /// Free-text answer to `question`.
+    Question,
+}
+
+impl InterruptKind {
+    pub fn as_str(&self) -> &'static str {
+        match self {
+            Self::Confirmation => "confirmation",
+            Self::Question => "question",
+        }
+    }
+}
+
+/// How an interrupt stopped being pending, as recorded in the run's
+/// interrupt history.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "snake_case")]
+pub enum InterruptOutcome {
+    Resolved,
+    Expired,
+}
+
 /// Loop control verdict.
 #[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize)]
 #[serde(rename_all = "snake_case")]
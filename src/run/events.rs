@@ -27,6 +27,8 @@ pub struct StageMetrics {
 pub struct AggregateMetrics {
     pub total_duration_ms: i64,
     pub total_llm_calls: i32,
+    #[serde(default)]
+    pub total_llm_cache_hits: i32,
     pub total_tool_calls: i32,
     pub total_tokens_in: i64,
     pub total_tokens_out: i64,
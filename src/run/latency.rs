@@ -0,0 +1,133 @@
+//! Latency breakdown of a run from its processing history — where the time
+//! went, for "why was this request slow?". Works on a live or archived
+//! `Run` (e.g. one deserialized offline).
+
+use std::collections::HashMap;
+
+use serde::Serialize;
+
+use super::Run;
+
+/// One processing record on the critical path.
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct LatencySpan {
+    pub agent: String,
+    /// Time between the previous stage finishing (or the run being
+    /// created) and this one starting: queueing, interrupts, worker pickup.
+    pub wait_ms: i64,
+    pub execute_ms: i64,
+}
+
+/// Execution time attributed to one agent across all its visits.
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct AgentLatency {
+    pub agent: String,
+    pub visits: usize,
+    pub execute_ms: i64,
+    /// Fraction of `LatencyReport::total_ms`.
+    pub share: f64,
+}
+
+/// Result of `Run::explain_latency`.
+#[derive(Debug, Clone, Default, PartialEq, Serialize)]
+pub struct LatencyReport {
+    /// Run creation to the last completed stage.
+    pub total_ms: i64,
+    pub execute_ms: i64,
+    pub wait_ms: i64,
+    /// Stages run one after another, so the critical path is the whole
+    /// history in execution order.
+    pub critical_path: Vec<LatencySpan>,
+    /// Slowest agent first.
+    pub by_agent: Vec<AgentLatency>,
+}
+
+impl Run {
+    /// Split the run's elapsed time into per-stage execution and the waits
+    /// between stages. Records without a `completed_at` are skipped.
+    pub fn explain_latency(&self) -> LatencyReport {
+        let mut report = LatencyReport::default();
+        let mut previous_end = self.audit.created_at;
+        let mut by_agent: HashMap<&str, (usize, i64)> = HashMap::new();
+        for record in &self.audit.processing_history {
+            let Some(completed_at) = record.completed_at else {
+                continue;
+            };
+            let wait_ms = (record.started_at - previous_end).num_milliseconds().max(0);
+            let execute_ms = i64::from(record.duration_ms);
+            report.wait_ms += wait_ms;
+            report.execute_ms += execute_ms;
+            report.critical_path.push(LatencySpan { agent: record.agent.clone(), wait_ms, execute_ms });
+            let entry = by_agent.entry(record.agent.as_str()).or_default();
+            entry.0 += 1;
+            entry.1 += execute_ms;
+            previous_end = previous_end.max(completed_at);
+        }
+        report.total_ms = (previous_end - self.audit.created_at).num_milliseconds().max(0);
+
+        let total = report.total_ms.max(1) as f64;
+        report.by_agent = by_agent
+            .into_iter()
+            .map(|(agent, (visits, execute_ms))| AgentLatency {
+                agent: agent.to_string(),
+                visits,
+                execute_ms,
+                share: execute_ms as f64 / total,
+            })
+            .collect();
+        report
+            .by_agent
+            .sort_by(|a, b| b.execute_ms.cmp(&a.execute_ms).then_with(|| a.agent.cmp(&b.agent)));
+        report
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::run::{ProcessingRecord, ProcessingStatus};
+    use chrono::Duration;
+
+    fn record(run: &Run, agent: &str, start_ms: i64, duration_ms: i32) -> ProcessingRecord {
+        let started_at = run.audit.created_at + Duration::milliseconds(start_ms);
+        ProcessingRecord {
+            agent: agent.to_string(),
+            stage_order: 1,
+            started_at,
+            completed_at: Some(started_at + Duration::milliseconds(i64::from(duration_ms))),
+            duration_ms,
+            status: ProcessingStatus::Success,
+            error: None,
+            llm_calls: 0,
+            tool_calls: 0,
+            tokens_in: 0,
+            tokens_out: 0,
+            worker: None,
+        }
+    }
+
+    #[test]
+    fn splits_wait_from_execution() {
+        let mut run = Run::anonymous();
+        // plan 0–100, wait 400 (interrupt), draft 500–1500, review 1500–1700, draft 1700–2200
+        let history = vec![
+            record(&run, "plan", 0, 100),
+            record(&run, "draft", 500, 1000),
+            record(&run, "review", 1500, 200),
+            record(&run, "draft", 1700, 500),
+        ];
+        run.audit.processing_history = history;
+
+        let report = run.explain_latency();
+        assert_eq!(report.total_ms, 2200);
+        assert_eq!(report.execute_ms, 1800);
+        assert_eq!(report.wait_ms, 400);
+        assert_eq!(report.critical_path.len(), 4);
+        assert_eq!(report.critical_path[1], LatencySpan { agent: "draft".into(), wait_ms: 400, execute_ms: 1000 });
+
+        let draft = &report.by_agent[0];
+        assert_eq!((draft.agent.as_str(), draft.visits, draft.execute_ms), ("draft", 2, 1500));
+        assert!((draft.share - 1500.0 / 2200.0).abs() < 1e-9);
+        assert_eq!(report.by_agent.last().unwrap().agent, "plan");
+    }
+}
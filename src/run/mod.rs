@@ -5,31 +5,95 @@
 use chrono::{DateTime, Utc};
 use serde::{Deserialize, Serialize};
 use std::collections::HashMap;
+use std::sync::Arc;
 
 use crate::types::{AgentName, EnvelopeId, OutputKey, RequestId, SessionId, StageName, UserId};
 
+pub mod compact;
 pub mod enums;
 pub mod events;
+pub mod latency;
+pub mod transcript;
 pub mod types;
 
+pub use compact::{CompactOptions, CompactionStats};
 pub use enums::*;
 pub use events::{AggregateMetrics, RunEvent, StageMetrics};
+pub use latency::{AgentLatency, LatencyReport, LatencySpan};
+pub use transcript::TranscriptFormat;
 pub use types::*;
 
+/// One agent's `output_key → value` map. Shared behind `Arc` so cloning a
+/// `Run` (snapshots, compaction, routing) doesn't deep-copy output values;
+/// writers go through `Arc::make_mut`, which copies only a shared map.
+pub type OutputMap = Arc<HashMap<OutputKey, serde_json::Value>>;
+
+/// Serialized byte size of one agent's output map (the unit counted by
+/// `Metrics::output_bytes`).
+pub fn output_size(output: &OutputMap) -> u64 {
+    serde_json::to_vec(output.as_ref()).map_or(0, |bytes| bytes.len() as u64)
+}
+
+/// `audit.metadata` list of interrupts that are no longer pending, oldest
+/// first (see `Run::close_interrupt`).
+pub const INTERRUPT_HISTORY_KEY: &str = "interrupt_history";
+
+fn truncate_chars(text: String, max_chars: usize) -> String {
+    match text.char_indices().nth(max_chars) {
+        Some((cut, _)) => format!("{}…", &text[..cut]),
+        None => text,
+    }
+}
+
 #[must_use]
 #[derive(Debug, Clone, Serialize, Deserialize, PartialEq)]
 pub struct Run {
     pub identity: Identity,
     pub raw_input: String,
     pub received_at: DateTime<Utc>,
+    /// BCP 47 language tag (e.g. `en-GB`), from `metadata.locale` at
+    /// creation. Forwarded on every `RunAgent`.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub locale: Option<String>,
+    /// IANA time zone (e.g. `Europe/London`), from `metadata.timezone` at
+    /// creation. Forwarded on every `RunAgent`.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub timezone: Option<String>,
+    /// Caller's per-request settings (target branch, analysis depth, …).
+    /// Unlike `audit.metadata`, nothing in the run writes to it: it is
+    /// forwarded verbatim on every `RunAgent`.
+    #[serde(default, skip_serializing_if = "HashMap::is_empty")]
+    pub params: HashMap<String, serde_json::Value>,
 
     /// `agent_name → output_key → value`. Any agent can write here.
-    pub outputs: HashMap<AgentName, HashMap<OutputKey, serde_json::Value>>,
+    pub outputs: HashMap<AgentName, OutputMap>,
 
     /// Accumulator merged across loop-backs per `state_schema`.
     #[serde(default, skip_serializing_if = "HashMap::is_empty")]
     pub state: HashMap<String, serde_json::Value>,
 
+    /// `stage_name → artifacts` recorded while that stage ran, in order.
+    #[serde(default, skip_serializing_if = "HashMap::is_empty")]
+    pub artifacts: HashMap<StageName, Vec<Artifact>>,
+
+    /// Progress lines for the end user, oldest first, bounded by
+    /// `MAX_USER_LOG_ENTRIES`.
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub user_log: Vec<UserLogEntry>,
+    /// Notes discarded by the user-log limits.
+    #[serde(default)]
+    pub user_log_dropped: u32,
+
+    /// `agent_name → partial outputs` reported while the agent's stage is
+    /// still running, oldest first. Cleared by the agent's final result.
+    #[serde(default, skip_serializing_if = "HashMap::is_empty")]
+    pub partial_outputs: HashMap<AgentName, Vec<PartialOutput>>,
+
+    /// Parallel to `outputs`: who wrote each key. Filled only when the
+    /// workflow sets `track_provenance`.
+    #[serde(default, skip_serializing_if = "HashMap::is_empty")]
+    pub provenance: HashMap<AgentName, HashMap<OutputKey, Provenance>>,
+
     pub current_stage: StageName,
     pub stage_order: Vec<StageName>,
     pub iteration: i32,
@@ -74,6 +138,10 @@ impl Run {
             }
         }
 
+        let metadata_str = |key: &str| audit_metadata.get(key).and_then(|v| v.as_str()).map(str::to_string);
+        let locale = metadata_str("locale");
+        let timezone = metadata_str("timezone");
+
         Self {
             identity: Identity {
                 envelope_id: EnvelopeId::must(format!("env_{}", uuid_short())),
@@ -83,8 +151,16 @@ impl Run {
             },
             raw_input: raw_input.to_string(),
             received_at: now,
+            locale,
+            timezone,
+            params: HashMap::new(),
             outputs: HashMap::new(),
             state: HashMap::new(),
+            artifacts: HashMap::new(),
+            user_log: Vec::new(),
+            user_log_dropped: 0,
+            partial_outputs: HashMap::new(),
+            provenance: HashMap::new(),
             current_stage: StageName::default(),
             stage_order: Vec::new(),
             iteration: 0,
@@ -92,6 +168,7 @@ impl Run {
             limits: Limits {
                 max_llm_calls: 100,
                 max_agent_hops: 100,
+                deadline: None,
             },
             metrics: Metrics::default(),
             termination: None,
@@ -107,22 +184,77 @@ impl Run {
         }
     }
 
-    /// Returns the terminal reason if this Run has exceeded any bound.
-    /// Called pre-flight in `get_next_instruction` and post-iteration in
-    /// `report_agent_result`.
+    /// Set the caller's read-only parameters (see `params`).
+    pub fn with_params(mut self, params: HashMap<String, serde_json::Value>) -> Self {
+        self.params = params;
+        self
+    }
+
+    /// One Run per input, sharing `user_id`, `session_id`, and `metadata`.
+    /// Siblings share a root request id: each `request_id` is
+    /// `{root}_{index}` and `audit.metadata` carries `root_request_id` and
+    /// `sibling_index` (input order), so map-reduce consumers can regroup
+    /// results without extra bookkeeping.
+    pub fn batch(
+        user_id: &str,
+        session_id: &str,
+        inputs: &[&str],
+        metadata: Option<serde_json::Value>,
+    ) -> Vec<Self> {
+        let root = format!("req_{}", &uuid::Uuid::new_v4().simple().to_string()[..16]);
+        inputs
+            .iter()
+            .enumerate()
+            .map(|(index, input)| {
+                let mut run = Self::new(user_id, session_id, input, metadata.clone());
+                run.identity.request_id = RequestId::must(format!("{}_{}", root, index));
+                run.audit
+                    .metadata
+                    .insert("root_request_id".to_string(), serde_json::json!(root));
+                run.audit
+                    .metadata
+                    .insert("sibling_index".to_string(), serde_json::json!(index));
+                run
+            })
+            .collect()
+    }
+
+    /// Pre-flight bound check in `get_next_instruction`: a counter that has
+    /// *reached* its limit stops the run, since the next dispatch could only
+    /// go over. A limit of N therefore allows N iterations, LLM calls, and
+    /// hops. Limits are always positive (`Workflow::check`).
     pub fn check_bounds(&self) -> Option<TerminalReason> {
-        if self.metrics.llm_calls >= self.limits.max_llm_calls {
+        self.bound_hit(|used, limit| used >= limit)
+    }
+
+    /// Post-iteration bound check in `report_agent_result`: only a counter
+    /// *over* its limit stops the run, so a run that spends exactly its
+    /// budget on its last stage still completes normally.
+    pub fn exceeded_bounds(&self) -> Option<TerminalReason> {
+        self.bound_hit(|used, limit| used > limit)
+    }
+
+    fn bound_hit(&self, hit: impl Fn(i32, i32) -> bool) -> Option<TerminalReason> {
+        if hit(self.metrics.llm_calls, self.limits.max_llm_calls) {
             return Some(TerminalReason::MaxLlmCallsExceeded);
         }
-        if self.iteration >= self.max_iterations {
+        if hit(self.iteration, self.max_iterations) {
             return Some(TerminalReason::MaxIterationsExceeded);
         }
-        if self.metrics.agent_hops >= self.limits.max_agent_hops {
+        if hit(self.metrics.agent_hops, self.limits.max_agent_hops) {
             return Some(TerminalReason::MaxAgentHopsExceeded);
         }
+        if self.remaining_time().is_some_and(|left| left <= chrono::Duration::zero()) {
+            return Some(TerminalReason::TimeoutExceeded);
+        }
         None
     }
 
+    /// Time left before `limits.deadline`; negative once it has passed.
+    pub fn remaining_time(&self) -> Option<chrono::Duration> {
+        self.limits.deadline.map(|deadline| deadline - Utc::now())
+    }
+
     pub fn at_limit(&self) -> bool {
         self.check_bounds().is_some()
     }
@@ -139,6 +271,86 @@ impl Run {
         self.termination = Some(Termination { reason, message });
     }
 
+    /// Recompute `metrics.output_bytes` after a bulk edit of `outputs`.
+    pub fn recount_output_bytes(&mut self) {
+        self.metrics.output_bytes = self.outputs.values().map(output_size).sum();
+    }
+
+    /// Append an artifact to `stage`'s manifest.
+    pub fn record_artifact(&mut self, stage: StageName, artifact: Artifact) {
+        self.artifacts.entry(stage).or_default().push(artifact);
+    }
+
+    /// Append `notes` under `stage`, applying the per-result and per-run
+    /// caps and truncating long messages.
+    pub fn append_user_log(&mut self, stage: StageName, notes: Vec<UserLogNote>) {
+        let offered = notes.len();
+        let room = MAX_USER_LOG_ENTRIES.saturating_sub(self.user_log.len());
+        let now = Utc::now();
+        let kept: Vec<UserLogEntry> = notes
+            .into_iter()
+            .take(MAX_USER_LOG_NOTES_PER_RESULT.min(room))
+            .map(|note| UserLogEntry {
+                level: note.level,
+                stage: stage.clone(),
+                message: truncate_chars(note.message, MAX_USER_LOG_MESSAGE_CHARS),
+                timestamp: now,
+            })
+            .collect();
+        self.user_log_dropped = self.user_log_dropped.saturating_add((offered - kept.len()) as u32);
+        self.user_log.extend(kept);
+    }
+
+    /// Append a partial output for `agent` under `stage`, keeping the
+    /// newest `MAX_PARTIAL_OUTPUTS_PER_AGENT`.
+    pub fn append_partial_output(&mut self, agent: AgentName, stage: StageName, output: serde_json::Value) {
+        let partials = self.partial_outputs.entry(agent).or_default();
+        partials.push(PartialOutput { stage, output, timestamp: Utc::now() });
+        let excess = partials.len().saturating_sub(MAX_PARTIAL_OUTPUTS_PER_AGENT);
+        partials.drain(..excess);
+    }
+
+    /// Record `agent`'s current output keys as written now, at the current
+    /// stage and iteration, replacing its earlier provenance.
+    pub fn record_provenance(&mut self, agent: &str) {
+        let Some(output) = self.outputs.get(agent) else {
+            return;
+        };
+        let stage_number = self
+            .stage_order
+            .iter()
+            .position(|s| s == &self.current_stage)
+            .map_or(0, |p| (p + 1) as i32);
+        let now = Utc::now();
+        let written = output
+            .keys()
+            .map(|key| {
+                let provenance = Provenance {
+                    agent: agent.into(),
+                    stage: self.current_stage.clone(),
+                    stage_number,
+                    iteration: self.iteration,
+                    written_at: now,
+                };
+                (key.clone(), provenance)
+            })
+            .collect();
+        self.provenance.insert(agent.into(), written);
+    }
+
+    /// Provenance of every agent's output under `key`, oldest write first.
+    /// Empty when no agent wrote it or provenance is not tracked.
+    pub fn get_provenance(&self, key: &str) -> Vec<Provenance> {
+        let mut found: Vec<Provenance> = self
+            .provenance
+            .values()
+            .filter_map(|keys| keys.get(key))
+            .cloned()
+            .collect();
+        found.sort_by_key(|p| (p.written_at, p.iteration, p.stage_number));
+        found
+    }
+
     pub fn add_processing_record(&mut self, record: ProcessingRecord) {
         self.audit.processing_history.push(record);
     }
@@ -159,6 +371,56 @@ impl Run {
         self.interrupts.interrupt = None;
     }
 
+    /// Clear the pending interrupt and append it to
+    /// `audit.metadata[INTERRUPT_HISTORY_KEY]` with `outcome` and the
+    /// consumer's response, if any.
+    pub fn close_interrupt(&mut self, outcome: InterruptOutcome, response: Option<serde_json::Value>) {
+        let Some(interrupt) = self.interrupts.interrupt.take() else {
+            return;
+        };
+        let entry = serde_json::json!({
+            "id": interrupt.id,
+            "kind": interrupt.kind(),
+            "prompt": interrupt.question.as_deref().or(interrupt.message.as_deref()),
+            "raised_at": interrupt.created_at.to_rfc3339(),
+            "closed_at": Utc::now().to_rfc3339(),
+            "outcome": outcome,
+            "response": response,
+        });
+        match self.audit.metadata.get_mut(INTERRUPT_HISTORY_KEY) {
+            Some(serde_json::Value::Array(list)) => list.push(entry),
+            _ => {
+                self.audit.metadata.insert(INTERRUPT_HISTORY_KEY.to_string(), serde_json::json!([entry]));
+            }
+        }
+    }
+
+    /// Interrupts of `kind` this run raised at or after `since` (all of
+    /// them when `None`): the closed ones in the interrupt history plus the
+    /// pending one.
+    pub fn interrupts_raised(&self, kind: InterruptKind, since: Option<DateTime<Utc>>) -> usize {
+        let in_window = |raised_at: DateTime<Utc>| since.map_or(true, |since| raised_at >= since);
+        let closed = self
+            .audit
+            .metadata
+            .get(INTERRUPT_HISTORY_KEY)
+            .and_then(|history| history.as_array())
+            .into_iter()
+            .flatten()
+            .filter(|entry| entry.get("kind").and_then(|v| v.as_str()) == Some(kind.as_str()))
+            .filter_map(|entry| entry.get("raised_at").and_then(|v| v.as_str()))
+            .filter_map(|raised_at| DateTime::parse_from_rfc3339(raised_at).ok())
+            .filter(|raised_at| in_window(raised_at.with_timezone(&Utc)))
+            .count();
+        let pending = self
+            .interrupts
+            .interrupt
+            .as_ref()
+            .filter(|pending| pending.kind() == kind && in_window(pending.created_at))
+            .is_some();
+        closed + usize::from(pending)
+    }
+
     /// Validate run invariants.
     ///
     /// Called after deserialization from external input to catch malformed
@@ -239,8 +501,9 @@ impl Run {
                 "outputs" => {
                     if let Ok(output_map) = serde_json::from_value::<HashMap<AgentName, HashMap<OutputKey, serde_json::Value>>>(value) {
                         for (agent, output) in output_map {
-                            self.outputs.entry(agent).or_default().extend(output);
+                            Arc::make_mut(self.outputs.entry(agent).or_default()).extend(output);
                         }
+                        self.recount_output_bytes();
                     }
                 }
                 _ => {
@@ -298,8 +561,88 @@ mod tests {
         assert!(env.audit.metadata.is_empty());
     }
 
+    // ── 1b. batch: shared root, ordered siblings ────────────────────────
+
+    #[test]
+    fn test_batch_shares_root_request_id() {
+        let runs = Run::batch(
+            "u1",
+            "s1",
+            &["first", "second", "third"],
+            Some(serde_json::json!({"source": "import"})),
+        );
+        assert_eq!(runs.len(), 3);
+
+        let root = runs[0].audit.metadata["root_request_id"].clone();
+        for (i, run) in runs.iter().enumerate() {
+            assert_eq!(run.audit.metadata["root_request_id"], root);
+            assert_eq!(run.audit.metadata["sibling_index"], serde_json::json!(i));
+            assert_eq!(run.audit.metadata["source"], serde_json::json!("import"));
+            assert_eq!(
+                run.identity.request_id.as_str(),
+                format!("{}_{}", root.as_str().unwrap(), i)
+            );
+            assert_eq!(run.identity.user_id.as_str(), "u1");
+            assert_eq!(run.identity.session_id.as_str(), "s1");
+        }
+        assert_eq!(runs[1].raw_input, "second");
+        assert_ne!(runs[0].identity.envelope_id, runs[1].identity.envelope_id);
+    }
+
+    #[test]
+    fn test_batch_empty_inputs() {
+        assert!(Run::batch("u1", "s1", &[], None).is_empty());
+    }
+
     // ── 4. at_limit: LLM calls ─────────────────────────────────────────
 
+    #[test]
+    fn test_user_log_caps_per_result_and_per_run() {
+        let mut env = Run::anonymous();
+        let note = |i: usize| UserLogNote { level: UserLogLevel::Info, message: format!("step {}", i) };
+        env.append_user_log("s".into(), (0..25).map(note).collect());
+        assert_eq!(env.user_log.len(), MAX_USER_LOG_NOTES_PER_RESULT);
+        assert_eq!(env.user_log_dropped, 5);
+
+        for _ in 0..10 {
+            env.append_user_log("s".into(), (0..20).map(note).collect());
+        }
+        assert_eq!(env.user_log.len(), MAX_USER_LOG_ENTRIES);
+        assert_eq!(env.user_log_dropped, 25);
+    }
+
+    #[test]
+    fn test_partial_outputs_keep_the_newest() {
+        let mut env = Run::anonymous();
+        for i in 0..MAX_PARTIAL_OUTPUTS_PER_AGENT + 3 {
+            env.append_partial_output("scanner".into(), "scan".into(), serde_json::json!({ "files": i }));
+        }
+        let partials = &env.partial_outputs["scanner"];
+        assert_eq!(partials.len(), MAX_PARTIAL_OUTPUTS_PER_AGENT);
+        assert_eq!(partials[0].output["files"], 3);
+        assert_eq!(partials[0].stage.as_str(), "scan");
+    }
+
+    #[test]
+    fn test_provenance_follows_the_latest_write() {
+        let mut env = Run::anonymous();
+        env.stage_order = vec!["draft".into(), "review".into()];
+        env.current_stage = "draft".into();
+        env.outputs.insert("writer".into(), Arc::new(HashMap::from([("claim".into(), serde_json::json!("x"))])));
+        env.record_provenance("writer");
+
+        env.current_stage = "review".into();
+        env.iteration = 1;
+        env.outputs.insert("reviewer".into(), Arc::new(HashMap::from([("claim".into(), serde_json::json!("y"))])));
+        env.record_provenance("reviewer");
+
+        let claim = env.get_provenance("claim");
+        assert_eq!(claim.len(), 2);
+        assert_eq!((claim[0].agent.as_str(), claim[0].stage_number, claim[0].iteration), ("writer", 1, 0));
+        assert_eq!((claim[1].agent.as_str(), claim[1].stage.as_str(), claim[1].stage_number), ("reviewer", "review", 2));
+        assert!(env.get_provenance("missing").is_empty());
+    }
+
     #[test]
     fn test_at_limit_llm_calls() {
         let mut env = Run::anonymous();
@@ -338,6 +681,35 @@ mod tests {
         assert_eq!(env.check_bounds(), Some(TerminalReason::MaxIterationsExceeded));
     }
 
+    #[test]
+    fn test_exceeded_bounds_allows_exactly_the_limit() {
+        let mut env = Run::anonymous();
+        env.max_iterations = 3;
+        env.limits.max_llm_calls = 10;
+        env.iteration = 3;
+        env.metrics.llm_calls = 10;
+        assert!(env.check_bounds().is_some(), "no further dispatch");
+        assert_eq!(env.exceeded_bounds(), None, "but the budget was not broken");
+
+        env.metrics.llm_calls = 11;
+        assert_eq!(env.exceeded_bounds(), Some(TerminalReason::MaxLlmCallsExceeded));
+    }
+
+    // ── 5c. at_limit: deadline ───────────────────────────────────────────
+
+    #[test]
+    fn test_at_limit_deadline() {
+        let mut env = Run::anonymous();
+        assert!(env.remaining_time().is_none());
+
+        env.limits.deadline = Some(Utc::now() + chrono::Duration::seconds(60));
+        assert!(!env.at_limit());
+
+        env.limits.deadline = Some(Utc::now() - chrono::Duration::seconds(1));
+        assert_eq!(env.check_bounds(), Some(TerminalReason::TimeoutExceeded));
+        assert_eq!(TerminalReason::TimeoutExceeded.outcome(), "bounds_exceeded");
+    }
+
     // ── 6. not at limit when below max ──────────────────────────────────
 
     #[test]
@@ -411,6 +783,7 @@ mod tests {
             tool_calls: 0,
             tokens_in: 0,
             tokens_out: 0,
+            worker: None,
         };
 
         env.add_processing_record(record.clone());
@@ -430,9 +803,11 @@ mod tests {
             (TerminalReason::MaxLlmCallsExceeded, "\"MAX_LLM_CALLS_EXCEEDED\""),
             (TerminalReason::MaxAgentHopsExceeded, "\"MAX_AGENT_HOPS_EXCEEDED\""),
             (TerminalReason::UserCancelled, "\"USER_CANCELLED\""),
+            (TerminalReason::ClientCancelled, "\"CLIENT_CANCELLED\""),
             (TerminalReason::ToolFailedFatally, "\"TOOL_FAILED_FATALLY\""),
             (TerminalReason::LlmFailedFatally, "\"LLM_FAILED_FATALLY\""),
             (TerminalReason::PolicyViolation, "\"POLICY_VIOLATION\""),
+            (TerminalReason::InterruptLimitExceeded, "\"INTERRUPT_LIMIT_EXCEEDED\""),
             (TerminalReason::BreakRequested, "\"BREAK_REQUESTED\""),
         ];
 
@@ -494,7 +869,7 @@ mod tests {
         let mut env = Run::anonymous();
         let mut agent_out: HashMap<OutputKey, serde_json::Value> = HashMap::new();
         agent_out.insert("key1".into(), serde_json::json!("value1"));
-        env.outputs.insert("agent1".into(), agent_out);
+        env.outputs.insert("agent1".into(), agent_out.into());
 
         let updates = HashMap::new();
         env.merge_updates(updates);
@@ -506,6 +881,28 @@ mod tests {
         );
     }
 
+    // ── 14b. outputs are copy-on-write across clones ─────────────────────
+
+    #[test]
+    fn test_clone_shares_outputs_until_written() {
+        let mut env = Run::anonymous();
+        env.outputs.insert(
+            "agent1".into(),
+            HashMap::from([("key1".into(), serde_json::json!("v1"))]).into(),
+        );
+
+        let mut copy = env.clone();
+        assert!(Arc::ptr_eq(&env.outputs["agent1"], &copy.outputs["agent1"]));
+
+        copy.merge_updates(HashMap::from([(
+            "outputs".to_string(),
+            serde_json::json!({"agent1": {"key2": "v2"}}),
+        )]));
+        assert!(!Arc::ptr_eq(&env.outputs["agent1"], &copy.outputs["agent1"]));
+        assert_eq!(env.outputs["agent1"].len(), 1);
+        assert_eq!(copy.outputs["agent1"].len(), 2);
+    }
+
     // ── 15. merge_updates: metadata merge ────────────────────────────────
 
     #[test]
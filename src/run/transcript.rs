@@ -0,0 +1,307 @@
+//! Human-readable transcript of a run — stages, durations, key outputs
+//! (with their provenance when tracked), interrupts, and the final
+//! response — for attaching to tickets. Like `explain_latency`, works on a
+//! live or archived `Run`.
+
+use serde::{Deserialize, Serialize};
+
+use super::{Run, INTERRUPT_HISTORY_KEY};
+
+/// Output values longer than this are cut in transcripts.
+const MAX_VALUE_CHARS: usize = 300;
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "snake_case")]
+pub enum TranscriptFormat {
+    Markdown,
+    Html,
+}
+
+/// Format-neutral content, rendered by `markdown` / `html`.
+struct Transcript {
+    title: String,
+    facts: Vec<(&'static str, String)>,
+    input: String,
+    /// `[#, agent, status, duration, error]`
+    stages: Vec<[String; 5]>,
+    interrupts: Vec<String>,
+    /// `(agent.key, value, provenance)`
+    outputs: Vec<(String, String, Option<String>)>,
+    final_response: Option<String>,
+}
+
+impl Run {
+    /// Render the run for people. Output values are cut at
+    /// `MAX_VALUE_CHARS`; the final response is the rendered terminal
+    /// response if any, else the termination message.
+    pub fn export_transcript(&self, format: TranscriptFormat) -> String {
+        let transcript = self.transcript();
+        match format {
+            TranscriptFormat::Markdown => transcript.markdown(),
+            TranscriptFormat::Html => transcript.html(),
+        }
+    }
+
+    fn transcript(&self) -> Transcript {
+        let status = match &self.termination {
+            Some(termination) => format!("{:?}", termination.reason),
+            None => format!("Running (stage {})", self.current_stage),
+        };
+        let mut facts = vec![
+            ("Request", self.identity.request_id.to_string()),
+            ("Session", self.identity.session_id.to_string()),
+            ("Status", status),
+            ("Created", self.audit.created_at.to_rfc3339()),
+        ];
+        if let Some(completed_at) = self.audit.completed_at {
+            facts.push(("Completed", completed_at.to_rfc3339()));
+            let total_ms = (completed_at - self.audit.created_at).num_milliseconds();
+            facts.push(("Duration", format!("{} ms", total_ms)));
+        }
+
+        let stages = self
+            .audit
+            .processing_history
+            .iter()
+            .enumerate()
+            .map(|(i, record)| {
+                [
+                    (i + 1).to_string(),
+                    record.agent.clone(),
+                    format!("{:?}", record.status),
+                    format!("{} ms", record.duration_ms),
+                    record.error.clone().unwrap_or_default(),
+                ]
+            })
+            .collect();
+
+        let mut interrupts: Vec<String> = self
+            .audit
+            .metadata
+            .get(INTERRUPT_HISTORY_KEY)
+            .and_then(|history| history.as_array())
+            .into_iter()
+            .flatten()
+            .map(|entry| {
+                let text = |key: &str| entry.get(key).and_then(|v| v.as_str()).unwrap_or("");
+                let response = entry.get("response").filter(|v| !v.is_null()).map(|v| format!(" → {}", v));
+                format!("[{}] {}: {}{}", text("outcome"), text("kind"), text("prompt"), response.unwrap_or_default())
+            })
+            .collect();
+        if let Some(pending) = &self.interrupts.interrupt {
+            let prompt = pending.question.as_deref().or(pending.message.as_deref()).unwrap_or("");
+            interrupts.push(format!("[pending] {}: {}", pending.kind().as_str(), prompt));
+        }
+
+        let mut agents: Vec<&str> = Vec::new();
+        for record in &self.audit.processing_history {
+            if !agents.contains(&record.agent.as_str()) {
+                agents.push(record.agent.as_str());
+            }
+        }
+        let mut outputs = Vec::new();
+        for agent in agents {
+            let Some(output) = self.outputs.get(agent) else {
+                continue;
+            };
+            let mut keys: Vec<_> = output.keys().collect();
+            keys.sort();
+            for key in keys {
+                let value = match &output[key] {
+                    serde_json::Value::String(s) => s.clone(),
+                    other => other.to_string(),
+                };
+                let provenance = self.provenance.get(agent).and_then(|keys| keys.get(key)).map(|p| {
+                    format!("stage {} (#{}), iteration {}, {}", p.stage, p.stage_number, p.iteration, p.written_at.to_rfc3339())
+                });
+                outputs.push((format!("{}.{}", agent, key), super::truncate_chars(value, MAX_VALUE_CHARS), provenance));
+            }
+        }
+
+        let final_response = self
+            .outputs
+            .get(crate::kernel::INTEGRATION_AGENT)
+            .and_then(|output| output.get("final_response"))
+            .and_then(|v| v.as_str())
+            .map(str::to_string)
+            .or_else(|| self.termination.as_ref().and_then(|t| t.message.clone()));
+
+        Transcript {
+            title: format!("Run {}", self.identity.envelope_id),
+            facts,
+            input: self.raw_input.clone(),
+            stages,
+            interrupts,
+            outputs,
+            final_response,
+        }
+    }
+}
+
+impl Transcript {
+    fn markdown(&self) -> String {
+        let cell = |s: &str| s.replace('|', "\\|").replace('\n', " ");
+        let quote = |s: &str| s.lines().map(|line| format!("> {}\n", line)).collect::<String>();
+        let mut out = format!("# {}\n\n", self.title);
+        for (name, value) in &self.facts {
+            out.push_str(&format!("- **{}:** {}\n", name, value));
+        }
+        out.push_str(&format!("\n## Input\n\n{}", quote(&self.input)));
+        out.push_str("\n## Stages\n\n| # | Agent | Status | Duration | Error |\n|---|---|---|---|---|\n");
+        for row in &self.stages {
+            let cells: Vec<String> = row.iter().map(|c| cell(c)).collect();
+            out.push_str(&format!("| {} |\n", cells.join(" | ")));
+        }
+        if !self.interrupts.is_empty() {
+            out.push_str("\n## Interrupts\n\n");
+            for line in &self.interrupts {
+                out.push_str(&format!("- {}\n", cell(line)));
+            }
+        }
+        if !self.outputs.is_empty() {
+            out.push_str("\n## Outputs\n\n");
+            for (key, value, provenance) in &self.outputs {
+                let written = provenance.as_ref().map(|p| format!(" _(written at {})_", p));
+                out.push_str(&format!("- `{}`: {}{}\n", key, cell(value), written.unwrap_or_default()));
+            }
+        }
+        if let Some(response) = &self.final_response {
+            out.push_str(&format!("\n## Final response\n\n{}", quote(response)));
+        }
+        out
+    }
+
+    fn html(&self) -> String {
+        let mut out = format!("<article>\n<h1>{}</h1>\n<dl>\n", escape_html(&self.title));
+        for (name, value) in &self.facts {
+            out.push_str(&format!("<dt>{}</dt><dd>{}</dd>\n", name, escape_html(value)));
+        }
+        out.push_str(&format!("</dl>\n<h2>Input</h2>\n<blockquote>{}</blockquote>\n", escape_html(&self.input)));
+        out.push_str("<h2>Stages</h2>\n<table>\n<tr><th>#</th><th>Agent</th><th>Status</th><th>Duration</th><th>Error</th></tr>\n");
+        for row in &self.stages {
+            let cells: String = row.iter().map(|c| format!("<td>{}</td>", escape_html(c))).collect();
+            out.push_str(&format!("<tr>{}</tr>\n", cells));
+        }
+        out.push_str("</table>\n");
+        if !self.interrupts.is_empty() {
+            out.push_str("<h2>Interrupts</h2>\n<ul>\n");
+            for line in &self.interrupts {
+                out.push_str(&format!("<li>{}</li>\n", escape_html(line)));
+            }
+            out.push_str("</ul>\n");
+        }
+        if !self.outputs.is_empty() {
+            out.push_str("<h2>Outputs</h2>\n<dl>\n");
+            for (key, value, provenance) in &self.outputs {
+                let written = provenance
+                    .as_ref()
+                    .map(|p| format!(" <small>written at {}</small>", escape_html(p)));
+                out.push_str(&format!(
+                    "<dt><code>{}</code></dt><dd>{}{}</dd>\n",
+                    escape_html(key),
+                    escape_html(value),
+                    written.unwrap_or_default()
+                ));
+            }
+            out.push_str("</dl>\n");
+        }
+        if let Some(response) = &self.final_response {
+            out.push_str(&format!("<h2>Final response</h2>\n<blockquote>{}</blockquote>\n", escape_html(response)));
+        }
+        out.push_str("</article>\n");
+        out
+    }
+}
+
+fn escape_html(text: &str) -> String {
+    let mut out = String::with_capacity(text.len());
+    for c in text.chars() {
+        match c {
+            '&' => out.push_str("&amp;"),
+            '<' => out.push_str("&lt;"),
+            '>' => out.push_str("&gt;"),
+            '"' => out.push_str("&quot;"),
+            '\'' => out.push_str("&#39;"),
+            _ => out.push(c),
+        }
+    }
+    out
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::run::{FlowInterrupt, InterruptOutcome, InterruptResponse, ProcessingRecord, ProcessingStatus, TerminalReason};
+    use std::collections::HashMap;
+    use std::sync::Arc;
+
+    fn sample_run() -> Run {
+        let mut run = Run::new("user", "sess", "Fix the <login> bug", None);
+        let started_at = run.audit.created_at;
+        run.audit.processing_history.push(ProcessingRecord {
+            agent: "planner".to_string(),
+            stage_order: 1,
+            started_at,
+            completed_at: Some(started_at + chrono::Duration::milliseconds(120)),
+            duration_ms: 120,
+            status: ProcessingStatus::Success,
+            error: None,
+            llm_calls: 1,
+            tool_calls: 0,
+            tokens_in: 0,
+            tokens_out: 0,
+            worker: None,
+        });
+        let mut output = HashMap::new();
+        output.insert("plan".into(), serde_json::json!("Patch a|b"));
+        run.outputs.insert("planner".into(), Arc::new(output));
+
+        run.set_interrupt(FlowInterrupt::new().with_message("Apply patch?".into()));
+        let response = InterruptResponse {
+            text: None,
+            approved: Some(true),
+            decision: None,
+            data: None,
+            received_at: chrono::Utc::now(),
+        };
+        run.close_interrupt(InterruptOutcome::Resolved, Some(serde_json::to_value(&response).unwrap()));
+        run.set_interrupt(FlowInterrupt::new().with_question("Which branch?".into()));
+        run.terminate_with(TerminalReason::Completed, Some("Patched & deployed".into()));
+        run
+    }
+
+    #[test]
+    fn markdown_lists_stages_interrupts_and_outputs() {
+        let md = sample_run().export_transcript(TranscriptFormat::Markdown);
+        assert!(md.contains("- **Status:** Completed"));
+        assert!(md.contains("> Fix the <login> bug"));
+        assert!(md.contains("| 1 | planner | Success | 120 ms |  |"));
+        assert!(md.contains("- [resolved] confirmation: Apply patch? → {\"approved\":true"));
+        assert!(md.contains("- [pending] question: Which branch?"));
+        assert!(md.contains("- `planner.plan`: Patch a\\|b"));
+        assert!(md.ends_with("## Final response\n\n> Patched & deployed\n"));
+    }
+
+    #[test]
+    fn tracked_outputs_show_their_provenance() {
+        let mut run = sample_run();
+        assert!(!run.export_transcript(TranscriptFormat::Markdown).contains("written at"));
+
+        run.stage_order = vec!["plan".into()];
+        run.current_stage = "plan".into();
+        run.record_provenance("planner");
+        let md = run.export_transcript(TranscriptFormat::Markdown);
+        assert!(md.contains("- `planner.plan`: Patch a\\|b _(written at stage plan (#1), iteration 0, "));
+        let html = run.export_transcript(TranscriptFormat::Html);
+        assert!(html.contains("<small>written at stage plan (#1), iteration 0, "));
+    }
+
+    #[test]
+    fn html_escapes_user_text() {
+        let html = sample_run().export_transcript(TranscriptFormat::Html);
+        assert!(html.contains("<blockquote>Fix the &lt;login&gt; bug</blockquote>"));
+        assert!(html.contains("<td>planner</td><td>Success</td><td>120 ms</td>"));
+        assert!(html.contains("<blockquote>Patched &amp; deployed</blockquote>"));
+        assert!(!html.contains("<login>"));
+    }
+}
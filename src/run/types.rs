@@ -5,6 +5,7 @@ use serde::{Deserialize, Serialize};
 use std::collections::HashMap;
 
 use crate::types::{EnvelopeId, InterruptId, RequestId, SessionId, UserId};
+use super::InterruptKind;
 
 
 /// Response to a flow interrupt.
@@ -50,6 +51,61 @@ pub struct FlowInterrupt {
 
     #[serde(default, skip_serializing_if = "Option::is_none")]
     pub expires_at: Option<DateTime<Utc>>,
+
+    /// Files or snippets the consumer shows alongside the prompt, e.g. the
+    /// diff a confirmation is about.
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub attachments: Vec<InterruptAttachment>,
+}
+
+/// Cap on the combined inline bytes of one interrupt's attachments; anything
+/// larger goes through an [`Artifact`] reference.
+pub const MAX_INLINE_ATTACHMENT_BYTES: usize = 256 * 1024;
+
+/// A named payload carried by a [`FlowInterrupt`].
+#[derive(Debug, Clone, Serialize, Deserialize, PartialEq)]
+pub struct InterruptAttachment {
+    pub name: String,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub mime_type: Option<String>,
+    pub content: AttachmentContent,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, PartialEq)]
+#[serde(tag = "kind", rename_all = "snake_case")]
+pub enum AttachmentContent {
+    /// Small payload held in the interrupt record — base64 on the wire.
+    Inline {
+        #[serde(with = "crate::types::base64_bytes")]
+        data: Vec<u8>,
+    },
+    /// Large payload stored elsewhere; the kernel keeps the reference only.
+    Artifact { artifact: Artifact },
+}
+
+impl InterruptAttachment {
+    pub fn inline(name: impl Into<String>, mime_type: impl Into<String>, data: Vec<u8>) -> Self {
+        Self {
+            name: name.into(),
+            mime_type: Some(mime_type.into()),
+            content: AttachmentContent::Inline { data },
+        }
+    }
+
+    pub fn artifact(name: impl Into<String>, artifact: Artifact) -> Self {
+        Self {
+            name: name.into(),
+            mime_type: artifact.mime_type.clone(),
+            content: AttachmentContent::Artifact { artifact },
+        }
+    }
+
+    pub fn inline_len(&self) -> usize {
+        match &self.content {
+            AttachmentContent::Inline { data } => data.len(),
+            AttachmentContent::Artifact { .. } => 0,
+        }
+    }
 }
 
 impl FlowInterrupt {
@@ -62,6 +118,17 @@ impl FlowInterrupt {
             response: None,
             created_at: Utc::now(),
             expires_at: None,
+            attachments: Vec::new(),
+        }
+    }
+
+    /// `Question` when the interrupt asks for a free-text answer,
+    /// `Confirmation` otherwise. Derived, not stored.
+    pub fn kind(&self) -> InterruptKind {
+        if self.question.is_some() {
+            InterruptKind::Question
+        } else {
+            InterruptKind::Confirmation
         }
     }
 
@@ -84,6 +151,27 @@ impl FlowInterrupt {
         self.expires_at = Some(Utc::now() + chrono::Duration::from_std(duration).unwrap_or(chrono::TimeDelta::MAX));
         self
     }
+
+    pub fn with_attachment(mut self, attachment: InterruptAttachment) -> Self {
+        self.attachments.push(attachment);
+        self
+    }
+
+    /// Reject unnamed attachments and inline payloads over
+    /// [`MAX_INLINE_ATTACHMENT_BYTES`] in total.
+    pub fn validate_attachments(&self) -> crate::types::Result<()> {
+        if let Some(i) = self.attachments.iter().position(|a| a.name.trim().is_empty()) {
+            return Err(crate::types::Error::validation(format!("attachments[{}].name must not be empty", i)));
+        }
+        let inline: usize = self.attachments.iter().map(InterruptAttachment::inline_len).sum();
+        if inline > MAX_INLINE_ATTACHMENT_BYTES {
+            return Err(crate::types::Error::validation(format!(
+                "inline attachments total {} bytes, over the {} byte cap; pass large payloads as artifact references",
+                inline, MAX_INLINE_ATTACHMENT_BYTES
+            )));
+        }
+        Ok(())
+    }
 }
 
 impl Default for FlowInterrupt {
@@ -102,6 +190,60 @@ pub enum ProcessingStatus {
     Skipped,
 }
 
+/// Which worker executed a stage. Required on every agent result so the
+/// runs a misbehaving worker build touched can be found afterwards
+/// (`RunQuery::worker`), and on claims so data-residency rules can be
+/// and capability requirements can be applied before work is handed out.
+#[derive(Debug, Clone, Default, Serialize, Deserialize, PartialEq, Eq)]
+pub struct WorkerIdentity {
+    pub id: String,
+    pub version: String,
+    #[serde(default, skip_serializing_if = "String::is_empty")]
+    pub host: String,
+    /// Where the worker runs; checked against the run's allowed regions.
+    #[serde(default, skip_serializing_if = "String::is_empty")]
+    pub region: String,
+    /// What the worker's environment provides; checked against a stage's
+    /// `required_capabilities`.
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub capabilities: Vec<String>,
+}
+
+impl WorkerIdentity {
+    pub fn new(id: impl Into<String>, version: impl Into<String>, host: impl Into<String>) -> Self {
+        Self {
+            id: id.into(),
+            version: version.into(),
+            host: host.into(),
+            region: String::new(),
+            capabilities: Vec::new(),
+        }
+    }
+
+    pub fn with_region(mut self, region: impl Into<String>) -> Self {
+        self.region = region.into();
+        self
+    }
+
+    pub fn with_capabilities<S: Into<String>>(mut self, capabilities: impl IntoIterator<Item = S>) -> Self {
+        self.capabilities = capabilities.into_iter().map(Into::into).collect();
+        self
+    }
+
+    /// Entries of `required` this worker does not provide, in order.
+    pub fn missing_capabilities(&self, required: &[String]) -> Vec<String> {
+        required.iter().filter(|c| !self.capabilities.contains(c)).cloned().collect()
+    }
+
+    /// The in-process runner's default identity: this crate's version, host
+    /// from `$HOSTNAME`, no region and no capabilities. Declare them with
+    /// `with_region` / `with_capabilities` and pass the identity to
+    /// `runner::run_as`.
+    pub fn in_process() -> Self {
+        Self::new("in-process", env!("CARGO_PKG_VERSION"), std::env::var("HOSTNAME").unwrap_or_default())
+    }
+}
+
 /// Processing record for audit trail.
 #[derive(Debug, Clone, Serialize, Deserialize, PartialEq)]
 pub struct ProcessingRecord {
@@ -128,6 +270,9 @@ pub struct ProcessingRecord {
 
     #[serde(default)]
     pub tokens_out: i64,
+
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub worker: Option<WorkerIdentity>,
 }
 
 /// Run identity fields.
@@ -141,6 +286,79 @@ pub struct Identity {
 }
 
 
+/// Severity of a [`UserLogEntry`].
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "snake_case")]
+pub enum UserLogLevel {
+    Info,
+    Warning,
+    Error,
+}
+
+/// Progress line an agent wants the end user to see ("searched 1,204
+/// files…"). Agents add them with `AgentOutput::log_to_user`; the kernel
+/// stamps the stage and time.
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+pub struct UserLogNote {
+    pub level: UserLogLevel,
+    pub message: String,
+}
+
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+pub struct UserLogEntry {
+    pub level: UserLogLevel,
+    pub stage: crate::types::StageName,
+    pub message: String,
+    pub timestamp: DateTime<Utc>,
+}
+
+/// Longer messages are cut to this many characters, with a trailing `…`.
+pub const MAX_USER_LOG_MESSAGE_CHARS: usize = 500;
+/// Notes kept from one agent result; the rest are dropped.
+pub const MAX_USER_LOG_NOTES_PER_RESULT: usize = 20;
+/// Entries kept per run.
+pub const MAX_USER_LOG_ENTRIES: usize = 200;
+
+/// Intermediate finding an agent reported mid-stage with
+/// `KernelHandle::report_agent_progress`. The stage stays open; the agent's
+/// final result closes it and clears these.
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+pub struct PartialOutput {
+    pub stage: crate::types::StageName,
+    pub output: serde_json::Value,
+    pub timestamp: DateTime<Utc>,
+}
+
+/// Partial outputs kept per agent; older ones are dropped first.
+pub const MAX_PARTIAL_OUTPUTS_PER_AGENT: usize = 50;
+
+/// Who wrote an output key, recorded when the workflow sets
+/// `track_provenance`.
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+pub struct Provenance {
+    pub agent: crate::types::AgentName,
+    pub stage: crate::types::StageName,
+    /// 1-based position of `stage` in `stage_order`, as on
+    /// `ProcessingRecord::stage_order`.
+    pub stage_number: i32,
+    pub iteration: i32,
+    pub written_at: DateTime<Utc>,
+}
+
+/// Reference to something an agent produced for the user (report, patch).
+/// The kernel stores the reference only, never the bytes.
+#[derive(Debug, Clone, Serialize, Deserialize, PartialEq)]
+pub struct Artifact {
+    /// Where the consumer fetches it: URL, object-store key, path.
+    pub uri: String,
+    /// Consumer-defined category, e.g. `"report"` or `"patch"`.
+    pub kind: String,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub mime_type: Option<String>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub size_bytes: Option<u64>,
+}
+
 /// Represents a completed termination with reason and optional message.
 #[derive(Debug, Clone, Serialize, Deserialize, PartialEq)]
 pub struct Termination {
@@ -156,6 +374,11 @@ pub struct Termination {
 pub struct Limits {
     pub max_llm_calls: i32,
     pub max_agent_hops: i32,
+    /// Wall-clock deadline. Session init sets it from
+    /// `Workflow::max_duration_seconds`, keeping any earlier deadline the
+    /// caller already placed on the run.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub deadline: Option<DateTime<Utc>>,
 }
 
 /// Live execution counters, incremented as the run progresses. Bounds checking
@@ -163,10 +386,27 @@ pub struct Limits {
 #[derive(Debug, Clone, Default, Serialize, Deserialize, PartialEq)]
 pub struct Metrics {
     pub llm_calls: i32,
+    /// LLM requests served from the run's `PromptCache`; not bounded by
+    /// `max_llm_calls`.
+    #[serde(default)]
+    pub llm_cache_hits: i32,
     pub tool_calls: i32,
     pub agent_hops: i32,
     pub tokens_in: i64,
     pub tokens_out: i64,
+    /// Serialized size of `Run.outputs`, maintained on every output write.
+    #[serde(default)]
+    pub output_bytes: u64,
+    /// Tool-call argument and result sizes, summed over
+    /// `ToolCallResult::bytes_in` / `bytes_out`.
+    #[serde(default)]
+    pub tool_bytes_in: u64,
+    #[serde(default)]
+    pub tool_bytes_out: u64,
+    /// Tool calls agents refused under their stage's `tool_policy`
+    /// (`AgentExecutionMetrics::refused_tools`); not in `tool_calls`.
+    #[serde(default)]
+    pub tool_calls_refused: i32,
 }
 
 /// Human-in-the-loop interrupt state.
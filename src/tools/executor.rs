@@ -54,29 +54,11 @@ pub enum ContentPart {
     /// Small inline binary (icons, thumbnails, charts) — base64 on the wire.
     Blob {
         content_type: String,
-        #[serde(with = "base64_bytes")]
+        #[serde(with = "crate::types::base64_bytes")]
         data: Vec<u8>,
     },
 }
 
-mod base64_bytes {
-    use serde::de::Error;
-    use serde::{Deserialize, Deserializer, Serializer};
-
-    pub fn serialize<S: Serializer>(data: &[u8], ser: S) -> Result<S::Ok, S::Error> {
-        use base64::Engine;
-        ser.serialize_str(&base64::engine::general_purpose::STANDARD.encode(data))
-    }
-
-    pub fn deserialize<'de, D: Deserializer<'de>>(de: D) -> Result<Vec<u8>, D::Error> {
-        use base64::Engine;
-        let s = String::deserialize(de)?;
-        base64::engine::general_purpose::STANDARD
-            .decode(&s)
-            .map_err(D::Error::custom)
-    }
-}
-
 /// Resolves a [`ContentPart::Ref`] to bytes when the next LLM message is built.
 /// Consumers implement this to bridge external content stores (frame buffers,
 /// file caches) into the message pipeline.
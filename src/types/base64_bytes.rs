@@ -0,0 +1,18 @@
+//! `#[serde(with = "crate::types::base64_bytes")]` — raw bytes as a base64
+//! string on the wire.
+
+use serde::de::Error;
+use serde::{Deserialize, Deserializer, Serializer};
+
+pub fn serialize<S: Serializer>(data: &[u8], ser: S) -> Result<S::Ok, S::Error> {
+    use base64::Engine;
+    ser.serialize_str(&base64::engine::general_purpose::STANDARD.encode(data))
+}
+
+pub fn deserialize<'de, D: Deserializer<'de>>(de: D) -> Result<Vec<u8>, D::Error> {
+    use base64::Engine;
+    let s = String::deserialize(de)?;
+    base64::engine::general_purpose::STANDARD
+        .decode(&s)
+        .map_err(D::Error::custom)
+}
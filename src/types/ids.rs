@@ -6,7 +6,8 @@
 //!   from a non-empty string via `must()` or `from_string()`.
 //!
 //! Every ID implements `AsRef<str>` and `Borrow<str>` so it works as a
-//! `HashMap` key looked up by `&str` without an allocation.
+//! `HashMap` key looked up by `&str` without an allocation, and orders by its
+//! string value.
 
 use schemars::JsonSchema;
 use serde::{Deserialize, Serialize};
@@ -15,7 +16,7 @@ use std::fmt;
 
 macro_rules! define_id {
     ($name:ident, uuid) => {
-        #[derive(Debug, Clone, PartialEq, Eq, Hash, Serialize, Deserialize, JsonSchema)]
+        #[derive(Debug, Clone, PartialEq, Eq, PartialOrd, Ord, Hash, Serialize, Deserialize, JsonSchema)]
         #[serde(transparent)]
         pub struct $name(String);
 
@@ -84,7 +85,7 @@ macro_rules! define_id {
         }
     };
     ($name:ident) => {
-        #[derive(Debug, Clone, Default, PartialEq, Eq, Hash, Serialize, Deserialize, JsonSchema)]
+        #[derive(Debug, Clone, Default, PartialEq, Eq, PartialOrd, Ord, Hash, Serialize, Deserialize, JsonSchema)]
         #[serde(transparent)]
         pub struct $name(String);
 
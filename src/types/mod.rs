@@ -5,6 +5,7 @@
 //! - **Errors**: Application error types with thiserror derives
 //! - **Config**: Configuration structures for kernel, workflow, and resources
 
+pub(crate) mod base64_bytes;
 pub mod config;
 mod errors;
 mod ids;
@@ -0,0 +1,438 @@
+//! Fluent `Workflow` construction.
+//!
+//! Stage-local mistakes (duplicate names, non-positive bounds, a stage setter
+//! before any stage) are caught at the call that introduces them; the first
+//! one sticks and is returned from `build()`. Cross-stage checks (forward
+//! `next` references) run once in `build()` via `Workflow::validate`.
+
+use super::policy::{ContextView, DeliverySemantics, OutputOverflow, RetryPolicy, SecurityContext, ToolPolicy};
+use super::stage::Stage;
+use super::state_schema::{MergeStrategy, StateField};
+use super::{TerminalResponse, Workflow};
+use crate::run::TerminalReason;
+use crate::types::{Error, Result};
+
+const DEFAULT_MAX_ITERATIONS: i32 = 10;
+const DEFAULT_MAX_LLM_CALLS: i32 = 50;
+const DEFAULT_MAX_AGENT_HOPS: i32 = 10;
+
+/// Builds a validated `Workflow`.
+///
+/// # Example
+/// ```text
+/// let workflow = Workflow::builder("chat")
+///     .agent("understand").llm("understand").next("respond")
+///     .agent("respond").llm("respond")
+///     .max_llm_calls(10)
+///     .build()?;
+/// ```
+#[derive(Debug)]
+pub struct WorkflowBuilder {
+    workflow: Workflow,
+    error: Option<Error>,
+}
+
+impl Workflow {
+    /// Start a fluent builder. Bounds default to 10 iterations, 50 LLM calls
+    /// and 10 agent hops.
+    pub fn builder(name: impl Into<String>) -> WorkflowBuilder {
+        WorkflowBuilder::new(name)
+    }
+
+    /// Parse and validate a workflow from JSON.
+    pub fn from_json(json: &str) -> Result<Self> {
+        let workflow: Self = serde_json::from_str(json)?;
+        workflow.validate()?;
+        Ok(workflow)
+    }
+
+    /// Serialize to pretty-printed JSON (the shape `from_json` accepts).
+    pub fn to_json(&self) -> Result<String> {
+        Ok(serde_json::to_string_pretty(self)?)
+    }
+}
+
+impl WorkflowBuilder {
+    pub fn new(name: impl Into<String>) -> Self {
+        let name = name.into();
+        let error = name
+            .is_empty()
+            .then(|| Error::validation("Pipeline name is required"));
+        Self {
+            workflow: Workflow {
+                name,
+                stages: Vec::new(),
+                max_iterations: DEFAULT_MAX_ITERATIONS,
+                max_llm_calls: DEFAULT_MAX_LLM_CALLS,
+                max_agent_hops: DEFAULT_MAX_AGENT_HOPS,
+                state_schema: Vec::new(),
+                max_concurrent_sessions: None,
+                write_once_outputs: false,
+                track_provenance: false,
+                terminal_responses: Vec::new(),
+                max_duration_seconds: None,
+                max_output_bytes: None,
+                output_overflow: Default::default(),
+                max_interrupts_per_kind: None,
+                interrupt_window_seconds: None,
+                stage_renames: Default::default(),
+                allowed_regions: Vec::new(),
+                cleanup_agents: Vec::new(),
+                cleanup_timeout_seconds: None,
+            },
+            error,
+        }
+    }
+
+    /// Append a stage named after its agent. Subsequent stage setters apply
+    /// to this stage.
+    pub fn agent(self, name: &str) -> Self {
+        self.stage(name, name)
+    }
+
+    /// Append a stage dispatching `agent`. Subsequent stage setters apply to
+    /// this stage.
+    pub fn stage(mut self, name: &str, agent: &str) -> Self {
+        if self.error.is_some() {
+            return self;
+        }
+        if name.is_empty() || agent.is_empty() {
+            self.error = Some(Error::validation("Stage name and agent must not be empty"));
+            return self;
+        }
+        if self.workflow.stages.iter().any(|s| s.name.as_str() == name) {
+            self.error = Some(Error::validation(format!("Duplicate stage name '{}'", name)));
+            return self;
+        }
+        self.workflow.stages.push(Stage {
+            name: name.into(),
+            agent: agent.into(),
+            ..Stage::default()
+        });
+        self
+    }
+
+    /// Mark the current stage as an LLM agent using `prompt_key`.
+    pub fn llm(self, prompt_key: &str) -> Self {
+        self.with_stage("llm", |stage| {
+            stage.agent_config.has_llm = true;
+            stage.agent_config.prompt_key = Some(prompt_key.into());
+            Ok(())
+        })
+    }
+
+    /// Mark the current stage as an LLM agent with an inline prompt template.
+    pub fn prompt_template(self, template: impl Into<String>) -> Self {
+        let template = template.into();
+        self.with_stage("prompt_template", |stage| {
+            stage.agent_config.has_llm = true;
+            stage.agent_config.prompt_template = Some(template);
+            Ok(())
+        })
+    }
+
+    pub fn model_role(self, role: impl Into<String>) -> Self {
+        let role = role.into();
+        self.with_stage("model_role", |stage| {
+            stage.agent_config.model_role = Some(role);
+            Ok(())
+        })
+    }
+
+    /// Serve repeated identical LLM requests from the run's prompt cache.
+    pub fn cache_prompts(self) -> Self {
+        self.with_stage("cache_prompts", |stage| {
+            stage.agent_config.cache_prompts = true;
+            Ok(())
+        })
+    }
+
+    /// `default_next` for the current stage. The target may be declared later.
+    pub fn next(self, stage_name: &str) -> Self {
+        self.with_stage("next", |stage| {
+            stage.default_next = Some(stage_name.into());
+            Ok(())
+        })
+    }
+
+    /// `error_next` for the current stage. The target may be declared later.
+    pub fn on_error(self, stage_name: &str) -> Self {
+        self.with_stage("on_error", |stage| {
+            stage.error_next = Some(stage_name.into());
+            Ok(())
+        })
+    }
+
+    pub fn routing_fn(self, name: &str) -> Self {
+        self.with_stage("routing_fn", |stage| {
+            stage.routing_fn = Some(name.into());
+            Ok(())
+        })
+    }
+
+    pub fn output_key(self, key: &str) -> Self {
+        self.with_stage("output_key", |stage| {
+            stage.output_key = Some(key.into());
+            Ok(())
+        })
+    }
+
+    pub fn max_visits(self, max: i32) -> Self {
+        self.with_stage("max_visits", |stage| {
+            if max <= 0 {
+                return Err(Error::validation(format!(
+                    "Stage '{}' has max_visits {} which must be positive",
+                    stage.name, max
+                )));
+            }
+            stage.max_visits = Some(max);
+            Ok(())
+        })
+    }
+
+    pub fn timeout_seconds(self, secs: u64) -> Self {
+        self.with_stage("timeout_seconds", |stage| {
+            stage.timeout_seconds = Some(secs);
+            Ok(())
+        })
+    }
+
+    pub fn retry_policy(self, policy: RetryPolicy) -> Self {
+        self.with_stage("retry_policy", |stage| {
+            stage.retry_policy = Some(policy);
+            Ok(())
+        })
+    }
+
+    pub fn delivery(self, delivery: DeliverySemantics) -> Self {
+        self.with_stage("delivery", |stage| {
+            stage.delivery = delivery;
+            Ok(())
+        })
+    }
+
+    /// Mark the stage as a run-once setup step (see `Stage::bootstrap`).
+    pub fn bootstrap(self) -> Self {
+        self.with_stage("bootstrap", |stage| {
+            stage.bootstrap = true;
+            Ok(())
+        })
+    }
+
+    pub fn security_context(self, context: SecurityContext) -> Self {
+        self.with_stage("security_context", |stage| {
+            stage.security_context = Some(context);
+            Ok(())
+        })
+    }
+
+    pub fn tool_policy(self, policy: ToolPolicy) -> Self {
+        self.with_stage("tool_policy", |stage| {
+            stage.agent_config.tool_policy = policy;
+            Ok(())
+        })
+    }
+
+    /// Narrow what the stage's dispatch context carries.
+    pub fn context_view(self, view: ContextView) -> Self {
+        self.with_stage("context_view", |stage| {
+            stage.agent_config.context_view = view;
+            Ok(())
+        })
+    }
+
+    pub fn max_iterations(mut self, max: i32) -> Self {
+        self.workflow.max_iterations = max;
+        self.check_bound("max_iterations", max)
+    }
+
+    pub fn max_llm_calls(mut self, max: i32) -> Self {
+        self.workflow.max_llm_calls = max;
+        self.check_bound("max_llm_calls", max)
+    }
+
+    pub fn max_agent_hops(mut self, max: i32) -> Self {
+        self.workflow.max_agent_hops = max;
+        self.check_bound("max_agent_hops", max)
+    }
+
+    pub fn max_concurrent_sessions(mut self, max: u32) -> Self {
+        if max == 0 && self.error.is_none() {
+            self.error = Some(Error::validation("max_concurrent_sessions must be > 0 when set"));
+        }
+        self.workflow.max_concurrent_sessions = Some(max);
+        self
+    }
+
+    /// Wall-clock budget per run, counted from session init.
+    pub fn max_duration_seconds(mut self, secs: u64) -> Self {
+        if secs == 0 && self.error.is_none() {
+            self.error = Some(Error::validation("max_duration_seconds must be > 0 when set"));
+        }
+        self.workflow.max_duration_seconds = Some(secs);
+        self
+    }
+
+    /// Cap on the serialized size of `run.outputs`, with the overflow policy.
+    pub fn max_output_bytes(mut self, max: u64, overflow: OutputOverflow) -> Self {
+        if max == 0 && self.error.is_none() {
+            self.error = Some(Error::validation("max_output_bytes must be > 0 when set"));
+        }
+        self.workflow.max_output_bytes = Some(max);
+        self.workflow.output_overflow = overflow;
+        self
+    }
+
+    /// Cap on interrupts of one kind per run, over a trailing window
+    /// (`None` = the whole run).
+    pub fn max_interrupts_per_kind(mut self, max: u32, window_seconds: Option<u64>) -> Self {
+        if max == 0 && self.error.is_none() {
+            self.error = Some(Error::validation("max_interrupts_per_kind must be > 0 when set"));
+        }
+        if window_seconds == Some(0) && self.error.is_none() {
+            self.error = Some(Error::validation("interrupt_window_seconds must be > 0 when set"));
+        }
+        self.workflow.max_interrupts_per_kind = Some(max);
+        self.workflow.interrupt_window_seconds = window_seconds;
+        self
+    }
+
+    /// Restrict execution to workers in these regions (data residency).
+    pub fn allowed_regions<S: Into<String>>(mut self, regions: impl IntoIterator<Item = S>) -> Self {
+        self.workflow.allowed_regions = regions.into_iter().map(Into::into).collect();
+        self
+    }
+
+    /// Agents run best effort when a run terminates, each bounded by
+    /// `timeout_seconds` (`None` = the default).
+    pub fn cleanup_agents<S: Into<String>>(mut self, agents: impl IntoIterator<Item = S>, timeout_seconds: Option<u64>) -> Self {
+        if timeout_seconds == Some(0) && self.error.is_none() {
+            self.error = Some(Error::validation("cleanup_timeout_seconds must be > 0 when set"));
+        }
+        self.workflow.cleanup_agents = agents.into_iter().map(Into::into).collect();
+        self.workflow.cleanup_timeout_seconds = timeout_seconds;
+        self
+    }
+
+    pub fn state_field(mut self, key: &str, merge: MergeStrategy) -> Self {
+        if self.error.is_none() && self.workflow.state_schema.iter().any(|f| f.key == key) {
+            self.error = Some(Error::validation(format!(
+                "Duplicate state_schema key '{}'",
+                key
+            )));
+        }
+        self.workflow.state_schema.push(StateField {
+            key: key.to_string(),
+            merge,
+        });
+        self
+    }
+
+    /// Fallback response rendered when the run terminates with `reason`.
+    pub fn terminal_response(mut self, reason: TerminalReason, template: impl Into<String>) -> Self {
+        self.workflow.terminal_responses.push(TerminalResponse {
+            reason,
+            template: template.into(),
+        });
+        self
+    }
+
+    /// Return the first error recorded while building, or the fully
+    /// validated workflow.
+    pub fn build(self) -> Result<Workflow> {
+        if let Some(err) = self.error {
+            return Err(err);
+        }
+        self.workflow.validate()?;
+        Ok(self.workflow)
+    }
+
+    fn with_stage(mut self, op: &str, f: impl FnOnce(&mut Stage) -> Result<()>) -> Self {
+        if self.error.is_some() {
+            return self;
+        }
+        let result = match self.workflow.stages.last_mut() {
+            Some(stage) => f(stage),
+            None => Err(Error::validation(format!(
+                "'{}' called before any stage was added",
+                op
+            ))),
+        };
+        if let Err(err) = result {
+            self.error = Some(err);
+        }
+        self
+    }
+
+    fn check_bound(mut self, field: &str, value: i32) -> Self {
+        if value <= 0 && self.error.is_none() {
+            self.error = Some(Error::validation(format!(
+                "{} must be > 0, got {}",
+                field, value
+            )));
+        }
+        self
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn builder_produces_valid_workflow() {
+        let workflow = Workflow::builder("chat")
+            .agent("understand").llm("understand").next("think")
+            .agent("think").llm("think").on_error("understand")
+            .state_field("notes", MergeStrategy::Append)
+            .max_llm_calls(5)
+            .build()
+            .unwrap();
+
+        assert_eq!(workflow.stages.len(), 2);
+        assert_eq!(workflow.max_llm_calls, 5);
+        assert_eq!(workflow.max_iterations, DEFAULT_MAX_ITERATIONS);
+        let first = &workflow.stages[0];
+        assert!(first.agent_config.has_llm);
+        assert_eq!(first.default_next.as_ref().map(|s| s.as_str()), Some("think"));
+    }
+
+    #[test]
+    fn builder_reports_first_error() {
+        let err = Workflow::builder("w")
+            .agent("a")
+            .max_visits(0)
+            .agent("a")
+            .build()
+            .unwrap_err();
+        assert!(err.to_string().contains("max_visits 0"), "{}", err);
+
+        let err = Workflow::builder("w").llm("p").agent("a").build().unwrap_err();
+        assert!(err.to_string().contains("'llm' called before any stage"));
+
+        let err = Workflow::builder("w").agent("a").agent("a").build().unwrap_err();
+        assert!(err.to_string().contains("Duplicate stage name 'a'"));
+    }
+
+    #[test]
+    fn builder_checks_forward_references_at_build() {
+        let err = Workflow::builder("w").agent("a").next("missing").build().unwrap_err();
+        assert_eq!(err.to_error_code(), "INVALID_ARGUMENT");
+        assert!(err.to_string().contains("'missing'"));
+    }
+
+    #[test]
+    fn json_round_trip() {
+        let workflow = Workflow::builder("w")
+            .agent("a").llm("p").next("b")
+            .stage("b", "worker").max_visits(3).next("b")
+            .build()
+            .unwrap();
+        let json = workflow.to_json().unwrap();
+        let back = Workflow::from_json(&json).unwrap();
+        assert_eq!(back.to_json().unwrap(), json);
+
+        let invalid = json.replace("\"max_iterations\": 10", "\"max_iterations\": 0");
+        assert!(Workflow::from_json(&invalid).is_err());
+    }
+}
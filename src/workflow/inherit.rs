@@ -0,0 +1,207 @@
+//! Workflow inheritance. A `WorkflowLibrary` holds raw JSON definitions that
+//! may name a parent (`"extends": "base"`) and mixins (`"mixins": [...]`).
+//! `resolve` flattens one definition — parent first, then mixins in order,
+//! then the definition's own fields — and validates only the flattened
+//! result, so bases and mixins may be partial.
+//!
+//! Merge rules: top-level fields override; `stages` merge by `name`, with an
+//! overriding stage's fields replacing the inherited stage's field by field
+//! and new stages appended in order.
+
+use std::collections::HashMap;
+
+use serde_json::{Map, Value};
+
+use super::Workflow;
+use crate::types::{Error, Result};
+
+const EXTENDS: &str = "extends";
+const MIXINS: &str = "mixins";
+
+/// Named raw workflow definitions that can build on each other.
+#[derive(Debug, Clone, Default)]
+pub struct WorkflowLibrary {
+    definitions: HashMap<String, Map<String, Value>>,
+}
+
+impl WorkflowLibrary {
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// Register one JSON definition under its `name`, replacing any earlier
+    /// definition with that name.
+    pub fn add_json(&mut self, json: &str) -> Result<()> {
+        let Value::Object(definition) = serde_json::from_str(json)? else {
+            return Err(Error::validation("Workflow definition must be a JSON object"));
+        };
+        let name = definition
+            .get("name")
+            .and_then(Value::as_str)
+            .filter(|name| !name.is_empty())
+            .ok_or_else(|| Error::validation("Workflow definition needs a non-empty 'name'"))?
+            .to_string();
+        self.definitions.insert(name, definition);
+        Ok(())
+    }
+
+    /// Flatten `name` through its `extends`/`mixins` graph and validate it.
+    pub fn resolve(&self, name: &str) -> Result<Workflow> {
+        let flattened = self.flatten(name, &mut Vec::new())?;
+        let workflow: Workflow = serde_json::from_value(Value::Object(flattened))?;
+        workflow.validate()?;
+        Ok(workflow)
+    }
+
+    fn flatten(&self, name: &str, path: &mut Vec<String>) -> Result<Map<String, Value>> {
+        if path.iter().any(|seen| seen == name) {
+            path.push(name.to_string());
+            return Err(Error::validation(format!(
+                "Workflow inheritance cycle: {}",
+                path.join(" -> ")
+            )));
+        }
+        let definition = self
+            .definitions
+            .get(name)
+            .ok_or_else(|| Error::validation(format!("Unknown workflow '{}'", name)))?;
+        path.push(name.to_string());
+
+        let mut parents = Vec::new();
+        if let Some(parent) = definition.get(EXTENDS) {
+            parents.push(reference(parent, EXTENDS, name)?);
+        }
+        match definition.get(MIXINS) {
+            None => {}
+            Some(Value::Array(mixins)) => {
+                for mixin in mixins {
+                    parents.push(reference(mixin, MIXINS, name)?);
+                }
+            }
+            Some(_) => {
+                return Err(Error::validation(format!(
+                    "Workflow '{}': '{}' must be an array of names",
+                    name, MIXINS
+                )))
+            }
+        }
+
+        let mut merged = Map::new();
+        for parent in parents {
+            let inherited = self.flatten(parent, path)?;
+            merge(&mut merged, inherited);
+        }
+        let mut own = definition.clone();
+        own.remove(EXTENDS);
+        own.remove(MIXINS);
+        merge(&mut merged, own);
+
+        path.pop();
+        Ok(merged)
+    }
+}
+
+fn reference<'a>(value: &'a Value, field: &str, name: &str) -> Result<&'a str> {
+    value.as_str().ok_or_else(|| {
+        Error::validation(format!(
+            "Workflow '{}': '{}' entries must be workflow names",
+            name, field
+        ))
+    })
+}
+
+fn merge(into: &mut Map<String, Value>, from: Map<String, Value>) {
+    for (key, value) in from {
+        match (key.as_str(), into.get_mut(&key), value) {
+            ("stages", Some(Value::Array(stages)), Value::Array(overrides)) => {
+                for stage in overrides {
+                    merge_stage(stages, stage);
+                }
+            }
+            (_, _, value) => {
+                into.insert(key, value);
+            }
+        }
+    }
+}
+
+fn merge_stage(stages: &mut Vec<Value>, stage: Value) {
+    let existing = stage
+        .get("name")
+        .and_then(|name| stages.iter_mut().find(|s| s.get("name") == Some(name)));
+    match (existing, stage) {
+        (Some(Value::Object(existing)), Value::Object(fields)) => existing.extend(fields),
+        (_, stage) => stages.push(stage),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn library(definitions: &[Value]) -> WorkflowLibrary {
+        let mut library = WorkflowLibrary::new();
+        for definition in definitions {
+            library.add_json(&definition.to_string()).unwrap();
+        }
+        library
+    }
+
+    #[test]
+    fn extends_and_mixins_merge_stages_by_name() {
+        let library = library(&[
+            serde_json::json!({
+                "name": "base",
+                "max_iterations": 10, "max_llm_calls": 20, "max_agent_hops": 10,
+                "stages": [
+                    {"name": "understand", "agent": "understand", "default_next": "respond"},
+                    {"name": "respond", "agent": "respond"},
+                ],
+            }),
+            serde_json::json!({"name": "strict", "max_llm_calls": 5}),
+            serde_json::json!({
+                "name": "support",
+                "extends": "base",
+                "mixins": ["strict"],
+                "stages": [
+                    {"name": "understand", "agent": "triage"},
+                    {"name": "escalate", "agent": "escalate"},
+                ],
+            }),
+        ]);
+
+        let workflow = library.resolve("support").unwrap();
+        assert_eq!(workflow.name, "support");
+        assert_eq!(workflow.max_llm_calls, 5);
+        assert_eq!(workflow.max_iterations, 10);
+        let stages: Vec<(&str, &str)> = workflow
+            .stages
+            .iter()
+            .map(|s| (s.name.as_str(), s.agent.as_str()))
+            .collect();
+        assert_eq!(
+            stages,
+            vec![("understand", "triage"), ("respond", "respond"), ("escalate", "escalate")]
+        );
+        assert_eq!(workflow.stages[0].default_next.as_ref().map(|s| s.as_str()), Some("respond"));
+    }
+
+    #[test]
+    fn partial_mixins_are_not_validated_alone() {
+        let library = library(&[serde_json::json!({"name": "strict", "max_llm_calls": 5})]);
+        assert!(library.resolve("strict").is_err());
+    }
+
+    #[test]
+    fn cycles_and_unknown_parents_are_rejected() {
+        let library = library(&[
+            serde_json::json!({"name": "a", "extends": "b"}),
+            serde_json::json!({"name": "b", "mixins": ["a"]}),
+            serde_json::json!({"name": "orphan", "extends": "missing"}),
+        ]);
+        let err = library.resolve("a").unwrap_err();
+        assert!(err.to_string().contains("cycle: a -> b -> a"), "{}", err);
+        let err = library.resolve("orphan").unwrap_err();
+        assert!(err.to_string().contains("Unknown workflow 'missing'"), "{}", err);
+    }
+}
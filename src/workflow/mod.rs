@@ -6,19 +6,26 @@
 //! pipelines, and self-routing agent harnesses all share this shape — the
 //! difference is purely in how stages route to each other.
 
+pub mod builder;
+pub mod inherit;
 pub mod policy;
+pub mod report;
 pub mod stage;
 pub mod state_schema;
 
-pub use policy::RetryPolicy;
+pub use builder::WorkflowBuilder;
+pub use inherit::WorkflowLibrary;
+pub use policy::{ContextView, DeliverySemantics, OutputOverflow, RetryPolicy, SecurityContext, ToolPolicy};
+pub use report::{ValidationIssue, ValidationReport};
 pub use stage::{AgentConfig, Stage};
 pub use state_schema::{MergeStrategy, StateField};
 
 use schemars::JsonSchema;
 use serde::{Deserialize, Serialize};
-use std::collections::HashSet;
+use std::collections::{HashMap, HashSet};
 
-use crate::types::{Error, Result};
+use crate::run::TerminalReason;
+use crate::types::Result;
 
 /// Pipeline shape. Linear/branching/cyclic flows come from per-stage
 /// `routing_fn` + `default_next`; no graph topology in the kernel.
@@ -35,6 +42,83 @@ pub struct Workflow {
     /// Merge strategies for state accumulation across loop-backs.
     #[serde(default, skip_serializing_if = "Vec::is_empty")]
     pub state_schema: Vec<StateField>,
+    /// Cap on live sessions running this workflow (by `name`). Session init
+    /// beyond the cap fails with `QuotaExceeded`. `None` = unbounded.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub max_concurrent_sessions: Option<u32>,
+    /// Reject a stage's output when its agent already has an entry in
+    /// `run.outputs`, unless the stage sets `overwrite_output`. Rejected
+    /// writes keep the first output and are recorded under
+    /// `metadata["output_write_violations"]`.
+    #[serde(default)]
+    pub write_once_outputs: bool,
+    /// Record who wrote each output key (agent, stage, iteration, time) in
+    /// `run.provenance`, for audits and `get_provenance`.
+    #[serde(default)]
+    pub track_provenance: bool,
+    /// Fallback responses for abnormal termination. When a run terminates
+    /// with a listed reason, the template is rendered into
+    /// `outputs["integration"]["final_response"]`.
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub terminal_responses: Vec<TerminalResponse>,
+    /// Wall-clock budget for a run, counted from session init. Once it
+    /// passes, the next bounds check terminates with `TIMEOUT_EXCEEDED`.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub max_duration_seconds: Option<u64>,
+    /// Cap on the serialized size of `run.outputs`, checked after every
+    /// agent result. `None` = unbounded.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub max_output_bytes: Option<u64>,
+    /// Applied when `max_output_bytes` is exceeded.
+    #[serde(default)]
+    pub output_overflow: OutputOverflow,
+    /// Cap on interrupts of one kind (`question` / `confirmation`) a run
+    /// may raise, counted over `interrupt_window_seconds`. One more ends
+    /// the run with `INTERRUPT_LIMIT_EXCEEDED`. `None` = unbounded.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub max_interrupts_per_kind: Option<u32>,
+    /// Trailing window for `max_interrupts_per_kind`. `None` = the whole run.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub interrupt_window_seconds: Option<u64>,
+    /// Old stage name → stage in this workflow, used when a live session
+    /// migrates onto this definition (`KernelHandle::migrate_session`).
+    /// Stages not listed keep their name.
+    #[serde(default, skip_serializing_if = "HashMap::is_empty")]
+    pub stage_renames: HashMap<String, String>,
+    /// Data residency: only workers reporting one of these regions
+    /// (`WorkerIdentity::region`) may execute this workflow's stages.
+    /// Empty = any region.
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub allowed_regions: Vec<String>,
+    /// Agents run once each, best effort, by the worker that receives the
+    /// run's `Terminate` (completion, bounds, cancellation), so it can
+    /// release external resources (temp clones, containers). Runs removed
+    /// from the kernel without a `Terminate` get no cleanup, except when the
+    /// in-process runner was driving them. Failures are logged and never
+    /// change the run's outcome.
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub cleanup_agents: Vec<String>,
+    /// Per-agent bound for `cleanup_agents`. `None` =
+    /// `DEFAULT_CLEANUP_TIMEOUT_SECONDS`.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub cleanup_timeout_seconds: Option<u64>,
+}
+
+/// Bound on each cleanup agent when the workflow sets none.
+pub const DEFAULT_CLEANUP_TIMEOUT_SECONDS: u64 = 30;
+
+/// Upper bound on second-valued windows (about 100 years), so they stay
+/// well inside chrono's date range when added to `Utc::now()`.
+pub const MAX_WINDOW_SECONDS: u64 = 100 * 365 * 24 * 60 * 60;
+
+/// Templated response for one abnormal `TerminalReason`.
+#[derive(Debug, Clone, Serialize, Deserialize, JsonSchema)]
+pub struct TerminalResponse {
+    pub reason: TerminalReason,
+    /// `{placeholder}` template over the run's input, outputs, state and
+    /// metadata (as for `prompt_template`), plus `terminal_reason` and
+    /// `terminal_message`.
+    pub template: String,
 }
 
 impl Workflow {
@@ -42,121 +126,267 @@ impl Workflow {
         self.stages.iter().map(|s| s.name.as_str().into()).collect()
     }
 
+    /// Template for `reason`, if the workflow declares one.
+    pub fn terminal_response(&self, reason: TerminalReason) -> Option<&str> {
+        self.terminal_responses
+            .iter()
+            .find(|r| r.reason == reason)
+            .map(|r| r.template.as_str())
+    }
+
+    /// Per-agent timeout for `cleanup_agents`.
+    pub fn cleanup_timeout(&self) -> u64 {
+        self.cleanup_timeout_seconds.unwrap_or(DEFAULT_CLEANUP_TIMEOUT_SECONDS)
+    }
+
+    /// Reject an invalid definition. The error message lists every problem;
+    /// its source is the full `ValidationReport`.
     pub fn validate(&self) -> Result<()> {
+        self.check().into_result()
+    }
+
+    /// Every problem in the definition, with field paths and codes.
+    pub fn check(&self) -> ValidationReport {
+        let mut report = ValidationReport::default();
         if self.name.is_empty() {
-            return Err(Error::validation("Pipeline name is required"));
+            report.push("name", "required", "Pipeline name is required");
         }
         if self.stages.is_empty() {
-            return Err(Error::validation("Pipeline must have at least one stage"));
+            report.push("stages", "required", "Pipeline must have at least one stage");
         }
 
         if self.max_iterations <= 0 {
-            return Err(Error::validation(format!(
-                "max_iterations must be > 0, got {}",
-                self.max_iterations
-            )));
+            report.push(
+                "max_iterations",
+                "out_of_range",
+                format!("max_iterations must be > 0, got {}", self.max_iterations),
+            );
         }
         if self.max_llm_calls <= 0 {
-            return Err(Error::validation(format!(
-                "max_llm_calls must be > 0, got {}",
-                self.max_llm_calls
-            )));
+            report.push(
+                "max_llm_calls",
+                "out_of_range",
+                format!("max_llm_calls must be > 0, got {}", self.max_llm_calls),
+            );
         }
         if self.max_agent_hops <= 0 {
-            return Err(Error::validation(format!(
-                "max_agent_hops must be > 0, got {}",
-                self.max_agent_hops
-            )));
+            report.push(
+                "max_agent_hops",
+                "out_of_range",
+                format!("max_agent_hops must be > 0, got {}", self.max_agent_hops),
+            );
+        }
+
+        if self.max_concurrent_sessions == Some(0) {
+            report.push("max_concurrent_sessions", "out_of_range", "max_concurrent_sessions must be > 0 when set");
+        }
+        if self.max_duration_seconds == Some(0) {
+            report.push("max_duration_seconds", "out_of_range", "max_duration_seconds must be > 0 when set");
+        }
+        if let Some(secs) = self.max_duration_seconds.filter(|&s| s > MAX_WINDOW_SECONDS) {
+            report.push(
+                "max_duration_seconds",
+                "out_of_range",
+                format!("max_duration_seconds must be <= {}, got {}", MAX_WINDOW_SECONDS, secs),
+            );
+        }
+        if self.max_output_bytes == Some(0) {
+            report.push("max_output_bytes", "out_of_range", "max_output_bytes must be > 0 when set");
+        }
+        if self.max_interrupts_per_kind == Some(0) {
+            report.push("max_interrupts_per_kind", "out_of_range", "max_interrupts_per_kind must be > 0 when set");
+        }
+        if self.interrupt_window_seconds == Some(0) {
+            report.push("interrupt_window_seconds", "out_of_range", "interrupt_window_seconds must be > 0 when set");
+        }
+        if let Some(secs) = self.interrupt_window_seconds.filter(|&s| s > MAX_WINDOW_SECONDS) {
+            report.push(
+                "interrupt_window_seconds",
+                "out_of_range",
+                format!("interrupt_window_seconds must be <= {}, got {}", MAX_WINDOW_SECONDS, secs),
+            );
+        }
+        if self.cleanup_timeout_seconds == Some(0) {
+            report.push("cleanup_timeout_seconds", "out_of_range", "cleanup_timeout_seconds must be > 0 when set");
+        }
+        for (i, agent) in self.cleanup_agents.iter().enumerate() {
+            if agent.is_empty() {
+                report.push(format!("cleanup_agents[{}]", i), "required", "Cleanup agent name must not be empty");
+            }
         }
 
         let mut stage_names: HashSet<&str> = HashSet::new();
         let mut output_keys: HashSet<&str> = HashSet::new();
-        for stage in &self.stages {
+        for (i, stage) in self.stages.iter().enumerate() {
             if stage.name.is_empty() {
-                return Err(Error::validation("Stage name must not be empty"));
-            }
-            if !stage_names.insert(stage.name.as_str()) {
-                return Err(Error::validation(format!(
-                    "Duplicate stage name '{}'",
-                    stage.name
-                )));
+                report.push(format!("stages[{}].name", i), "required", "Stage name must not be empty");
+            } else if !stage_names.insert(stage.name.as_str()) {
+                report.push(
+                    format!("stages[{}].name", i),
+                    "duplicate",
+                    format!("Duplicate stage name '{}'", stage.name),
+                );
             }
             if let Some(ref ok) = stage.output_key {
                 if !output_keys.insert(ok.as_str()) {
-                    return Err(Error::validation(format!(
-                        "Duplicate output_key '{}' on stage '{}'",
-                        ok, stage.name
-                    )));
+                    report.push(
+                        format!("stages[{}].output_key", i),
+                        "duplicate",
+                        format!("Duplicate output_key '{}' on stage '{}'", ok, stage.name),
+                    );
                 }
             }
         }
 
-        for stage in &self.stages {
+        for (i, stage) in self.stages.iter().enumerate() {
             if stage.agent.is_empty() {
-                return Err(Error::validation(format!(
-                    "Stage '{}' must have a non-empty agent field",
-                    stage.name
-                )));
+                report.push(
+                    format!("stages[{}].agent", i),
+                    "required",
+                    format!("Stage '{}' must have a non-empty agent field", stage.name),
+                );
             }
 
-            // Reject `default_next` self-loops without `max_visits` — that's an
-            // infinite loop hiding behind static config.
-            if let Some(ref dn) = stage.default_next {
-                if dn == &stage.name && stage.max_visits.is_none() {
-                    return Err(Error::validation(format!(
-                        "Stage '{}' has default_next pointing to itself without max_visits (infinite loop)",
-                        stage.name
-                    )));
+            if stage.delivery == DeliverySemantics::AtMostOnce
+                && stage.retry_policy.as_ref().is_some_and(|policy| policy.max_retries > 0)
+            {
+                report.push(
+                    format!("stages[{}].retry_policy", i),
+                    "not_allowed",
+                    format!("Stage '{}' is at_most_once and cannot have retries", stage.name),
+                );
+            }
+
+            if stage.bootstrap {
+                if i > 0 && !self.stages[i - 1].bootstrap {
+                    report.push(
+                        format!("stages[{}].bootstrap", i),
+                        "not_allowed",
+                        format!("Bootstrap stage '{}' must come before every regular stage", stage.name),
+                    );
+                }
+                if stage.default_next.is_some() || stage.routing_fn.is_some() {
+                    report.push(
+                        format!("stages[{}].default_next", i),
+                        "not_allowed",
+                        format!("Bootstrap stage '{}' cannot route; it always moves to the next stage", stage.name),
+                    );
+                }
+            }
+            for (field, target) in [("default_next", &stage.default_next), ("error_next", &stage.error_next)] {
+                let bootstrap_target = target
+                    .as_ref()
+                    .and_then(|t| self.stages.iter().position(|s| &s.name == t))
+                    .filter(|&j| self.stages[j].bootstrap && j <= i);
+                if bootstrap_target.is_some() {
+                    report.push(
+                        format!("stages[{}].{}", i, field),
+                        "not_allowed",
+                        format!("Stage '{}' cannot route back to a bootstrap stage", stage.name),
+                    );
                 }
             }
 
             if let Some(ref dn) = stage.default_next {
-                if !stage_names.contains(dn.as_str()) {
-                    return Err(Error::validation(format!(
-                        "Stage '{}' has default_next '{}' which does not exist in workflow",
-                        stage.name, dn
-                    )));
+                // Reject `default_next` self-loops without `max_visits` — that's an
+                // infinite loop hiding behind static config.
+                if dn == &stage.name && stage.max_visits.is_none() {
+                    report.push(
+                        format!("stages[{}].default_next", i),
+                        "infinite_loop",
+                        format!(
+                            "Stage '{}' has default_next pointing to itself without max_visits (infinite loop)",
+                            stage.name
+                        ),
+                    );
+                } else if !stage_names.contains(dn.as_str()) {
+                    report.push(
+                        format!("stages[{}].default_next", i),
+                        "unknown_stage",
+                        format!("Stage '{}' has default_next '{}' which does not exist in workflow", stage.name, dn),
+                    );
                 }
             }
 
             if let Some(ref en) = stage.error_next {
                 if !stage_names.contains(en.as_str()) {
-                    return Err(Error::validation(format!(
-                        "Stage '{}' has error_next '{}' which does not exist in workflow",
-                        stage.name, en
-                    )));
+                    report.push(
+                        format!("stages[{}].error_next", i),
+                        "unknown_stage",
+                        format!("Stage '{}' has error_next '{}' which does not exist in workflow", stage.name, en),
+                    );
                 }
             }
 
             if let Some(mv) = stage.max_visits {
                 if mv <= 0 {
-                    return Err(Error::validation(format!(
-                        "Stage '{}' has max_visits {} which must be positive",
-                        stage.name, mv
-                    )));
+                    report.push(
+                        format!("stages[{}].max_visits", i),
+                        "out_of_range",
+                        format!("Stage '{}' has max_visits {} which must be positive", stage.name, mv),
+                    );
                 }
             }
             if let Some(mct) = stage.max_context_tokens {
                 if mct <= 0 {
-                    return Err(Error::validation(format!(
-                        "Stage '{}' has max_context_tokens {} which must be positive",
-                        stage.name, mct
-                    )));
+                    report.push(
+                        format!("stages[{}].max_context_tokens", i),
+                        "out_of_range",
+                        format!("Stage '{}' has max_context_tokens {} which must be positive", stage.name, mct),
+                    );
                 }
             }
         }
 
+        if !self.stages.is_empty() && self.stages.iter().all(|s| s.bootstrap) {
+            report.push("stages", "required", "Pipeline needs a regular stage after its bootstrap stages");
+        }
+
         let mut state_keys: HashSet<&str> = HashSet::new();
-        for field in &self.state_schema {
+        for (i, field) in self.state_schema.iter().enumerate() {
             if !state_keys.insert(field.key.as_str()) {
-                return Err(Error::validation(format!(
-                    "Duplicate state_schema key '{}'",
-                    field.key
-                )));
+                report.push(
+                    format!("state_schema[{}].key", i),
+                    "duplicate",
+                    format!("Duplicate state_schema key '{}'", field.key),
+                );
             }
         }
 
-        Ok(())
+        let mut terminal_reasons: Vec<TerminalReason> = Vec::new();
+        for (i, response) in self.terminal_responses.iter().enumerate() {
+            if matches!(response.reason, TerminalReason::Completed | TerminalReason::BreakRequested) {
+                report.push(
+                    format!("terminal_responses[{}].reason", i),
+                    "not_allowed",
+                    format!(
+                        "terminal_responses cannot target {:?}; only abnormal terminations use a fallback",
+                        response.reason
+                    ),
+                );
+            } else if terminal_reasons.contains(&response.reason) {
+                report.push(
+                    format!("terminal_responses[{}].reason", i),
+                    "duplicate",
+                    format!("Duplicate terminal_responses reason {:?}", response.reason),
+                );
+            }
+            terminal_reasons.push(response.reason);
+        }
+
+        let mut renames: Vec<(&String, &String)> = self.stage_renames.iter().collect();
+        renames.sort();
+        for (old, new) in renames {
+            if !stage_names.contains(new.as_str()) {
+                report.push(
+                    format!("stage_renames.{}", old),
+                    "unknown_stage",
+                    format!("stage_renames maps '{}' to '{}' which does not exist in workflow", old, new),
+                );
+            }
+        }
+
+        report
     }
 
     /// Test-only minimal config constructor. Avoids field boilerplate.
@@ -169,6 +399,19 @@ impl Workflow {
             max_llm_calls: 50,
             max_agent_hops: 10,
             state_schema: vec![],
+            max_concurrent_sessions: None,
+            write_once_outputs: false,
+            track_provenance: false,
+            terminal_responses: vec![],
+            max_duration_seconds: None,
+            max_output_bytes: None,
+            output_overflow: OutputOverflow::default(),
+            max_interrupts_per_kind: None,
+            interrupt_window_seconds: None,
+            stage_renames: HashMap::new(),
+            allowed_regions: vec![],
+            cleanup_agents: vec![],
+            cleanup_timeout_seconds: None,
         }
     }
 }
@@ -225,6 +468,32 @@ mod tests {
         assert!(err.to_string().contains("must have a non-empty agent field"));
     }
 
+    #[test]
+    fn test_validate_terminal_responses() {
+        let mut config = minimal_config(vec![minimal_stage("a")]);
+        config.terminal_responses = vec![TerminalResponse {
+            reason: TerminalReason::Completed,
+            template: "done".to_string(),
+        }];
+        let err = config.validate().unwrap_err();
+        assert!(err.to_string().contains("cannot target Completed"));
+
+        let timeout = TerminalResponse {
+            reason: TerminalReason::MaxIterationsExceeded,
+            template: "Still working on it.".to_string(),
+        };
+        config.terminal_responses = vec![timeout.clone(), timeout];
+        let err = config.validate().unwrap_err();
+        assert!(err.to_string().contains("Duplicate terminal_responses reason"));
+
+        config.terminal_responses.pop();
+        config.validate().unwrap();
+        assert_eq!(
+            config.terminal_response(TerminalReason::MaxIterationsExceeded),
+            Some("Still working on it.")
+        );
+    }
+
     #[test]
     fn test_validate_self_loop_without_max_visits() {
         let mut stage = minimal_stage("loop");
@@ -254,6 +523,123 @@ mod tests {
         assert!(err.to_string().contains("Duplicate output_key 'shared'"));
     }
 
+    #[test]
+    fn test_validate_zero_max_concurrent_sessions() {
+        let mut config = minimal_config(vec![minimal_stage("a")]);
+        config.max_concurrent_sessions = Some(0);
+        let err = config.validate().unwrap_err();
+        assert!(err.to_string().contains("max_concurrent_sessions"));
+    }
+
+    #[test]
+    fn test_validate_zero_max_duration_seconds() {
+        let mut config = minimal_config(vec![minimal_stage("a")]);
+        config.max_duration_seconds = Some(0);
+        let err = config.validate().unwrap_err();
+        assert!(err.to_string().contains("max_duration_seconds"));
+    }
+
+    #[test]
+    fn test_validate_max_duration_seconds_upper_bound() {
+        let mut config = minimal_config(vec![minimal_stage("a")]);
+        config.max_duration_seconds = Some(MAX_WINDOW_SECONDS);
+        assert!(config.validate().is_ok());
+        config.max_duration_seconds = Some(u64::MAX);
+        let err = config.validate().unwrap_err();
+        assert!(err.to_string().contains("max_duration_seconds must be <="));
+    }
+
+    #[test]
+    fn test_validate_interrupt_window_seconds_upper_bound() {
+        let mut config = minimal_config(vec![minimal_stage("a")]);
+        config.interrupt_window_seconds = Some(u64::MAX);
+        let err = config.validate().unwrap_err();
+        assert!(err.to_string().contains("interrupt_window_seconds must be <="));
+    }
+
+    #[test]
+    fn test_validate_stage_renames_target_existing_stages() {
+        let mut config = minimal_config(vec![minimal_stage("a")]);
+        config.stage_renames.insert("old".to_string(), "a".to_string());
+        assert!(config.validate().is_ok());
+        config.stage_renames.insert("gone".to_string(), "missing".to_string());
+        let err = config.validate().unwrap_err();
+        assert!(err.to_string().contains("stage_renames maps 'gone'"));
+    }
+
+    #[test]
+    fn test_validate_zero_max_output_bytes() {
+        let mut config = minimal_config(vec![minimal_stage("a")]);
+        config.max_output_bytes = Some(0);
+        let err = config.validate().unwrap_err();
+        assert!(err.to_string().contains("max_output_bytes"));
+    }
+
+    #[test]
+    fn test_validate_at_most_once_rejects_retries() {
+        let mut stage = minimal_stage("a");
+        stage.delivery = DeliverySemantics::AtMostOnce;
+        stage.retry_policy = Some(RetryPolicy::default());
+        let mut config = minimal_config(vec![stage]);
+        assert!(config.validate().is_ok(), "max_retries 0 is fine");
+        config.stages[0].retry_policy.as_mut().unwrap().max_retries = 1;
+        let err = config.validate().unwrap_err();
+        assert!(err.to_string().contains("at_most_once and cannot have retries"));
+    }
+
+    #[test]
+    fn test_validate_bootstrap_placement_and_routing() {
+        let bootstrap = |name: &str| Stage { bootstrap: true, ..minimal_stage(name) };
+        let config = minimal_config(vec![bootstrap("warm"), bootstrap("checkout"), minimal_stage("work")]);
+        assert!(config.validate().is_ok());
+
+        let mut back = minimal_stage("work");
+        back.error_next = Some("warm".into());
+        let mut routed = bootstrap("checkout");
+        routed.default_next = Some("work".into());
+        let config = minimal_config(vec![bootstrap("warm"), routed, back, bootstrap("late")]);
+        let found: Vec<(String, &str)> = config.check().issues.into_iter().map(|i| (i.path, i.code)).collect();
+        assert_eq!(
+            found,
+            vec![
+                ("stages[1].default_next".to_string(), "not_allowed"),
+                ("stages[2].error_next".to_string(), "not_allowed"),
+                ("stages[3].bootstrap".to_string(), "not_allowed"),
+            ]
+        );
+
+        let err = minimal_config(vec![bootstrap("warm")]).validate().unwrap_err();
+        assert!(err.to_string().contains("needs a regular stage"));
+    }
+
+    #[test]
+    fn test_check_reports_every_problem() {
+        let mut looped = minimal_stage("a");
+        looped.default_next = Some("a".into());
+        let mut dangling = minimal_stage("b");
+        dangling.error_next = Some("nowhere".into());
+        let mut config = minimal_config(vec![looped, dangling, minimal_stage("a")]);
+        config.max_iterations = 0;
+
+        let report = config.check();
+        let found: Vec<(&str, &str)> = report.issues.iter().map(|i| (i.path.as_str(), i.code)).collect();
+        assert_eq!(
+            found,
+            vec![
+                ("max_iterations", "out_of_range"),
+                ("stages[2].name", "duplicate"),
+                ("stages[0].default_next", "infinite_loop"),
+                ("stages[1].error_next", "unknown_stage"),
+            ]
+        );
+
+        let err = config.validate().unwrap_err();
+        assert_eq!(err.to_error_code(), "INVALID_ARGUMENT");
+        assert!(err.to_string().contains("4 problems: max_iterations must be > 0"), "{}", err);
+        let source = std::error::Error::source(&err).and_then(|s| s.downcast_ref::<ValidationReport>());
+        assert_eq!(source, Some(&report));
+    }
+
     #[test]
     fn test_validate_valid_pipeline() {
         let mut router = minimal_stage("router");
@@ -1,10 +1,14 @@
 //! Workflow-level execution policies. `ContextOverflow` lives in
 //! `crate::agent::policy` (it's consumed inside the agent loop); this module
-//! owns retry-with-backoff which the runner consumes.
+//! owns retry-with-backoff which the runner consumes, the sandbox and
+//! tool policies forwarded to tool-executing workers, and the view of the
+//! run each agent's dispatch context carries.
 
 use schemars::JsonSchema;
 use serde::{Deserialize, Serialize};
 
+use crate::types::{AgentName, ToolName};
+
 /// Retry-with-backoff for transient agent failures (Temporal activity retry
 /// pattern). Applied before routing to `error_next`; no retry on interrupt
 /// requests.
@@ -35,6 +39,74 @@ impl Default for RetryPolicy {
     }
 }
 
+/// Delivery guarantee for a stage's agent dispatch.
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq, Serialize, Deserialize, JsonSchema)]
+#[serde(rename_all = "snake_case")]
+pub enum DeliverySemantics {
+    /// Retried under `retry_policy` and re-dispatched when a worker's lease
+    /// lapses. Every dispatch carries an `idempotency_key`, stable across
+    /// those repeats, for handlers to deduplicate on.
+    #[default]
+    AtLeastOnce,
+    /// For non-idempotent work: never retried and never re-dispatched. A
+    /// lapsed lease ends the run with `DeliveryAmbiguous`, since the stage
+    /// may or may not have taken effect.
+    AtMostOnce,
+}
+
+/// Sandbox policy for a stage's tool execution. The kernel does not enforce
+/// it; it rides on every `RunAgent` for the stage so tool-executing workers
+/// apply one centrally configured policy. Empty lists deny.
+#[derive(Debug, Clone, Default, PartialEq, Serialize, Deserialize, JsonSchema)]
+pub struct SecurityContext {
+    /// Filesystem path prefixes tools may touch.
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub allowed_paths: Vec<String>,
+    /// Hosts (`host` or `host:port`) tools may connect to.
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub network_allowlist: Vec<String>,
+    /// Cap on concurrent subprocesses spawned by tools. `None` = no cap.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub max_subprocesses: Option<u32>,
+}
+
+/// Per-agent tool allow/deny lists. Unlike `SecurityContext` the kernel
+/// enforces this one: `LlmAgent` refuses calls outside it, and results
+/// reporting such a call terminate the run with `PolicyViolation`.
+#[derive(Debug, Clone, Default, PartialEq, Serialize, Deserialize, JsonSchema)]
+pub struct ToolPolicy {
+    /// Tools the agent may call. `None` = any tool the registry grants it.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub allowed_tools: Option<Vec<ToolName>>,
+    /// Tools the agent may never call; wins over `allowed_tools`.
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub denied_tools: Vec<ToolName>,
+}
+
+impl ToolPolicy {
+    pub fn is_unrestricted(&self) -> bool {
+        self.allowed_tools.is_none() && self.denied_tools.is_empty()
+    }
+
+    pub fn permits(&self, tool: &str) -> bool {
+        !self.denied_tools.iter().any(|t| t.as_str() == tool)
+            && self
+                .allowed_tools
+                .as_ref()
+                .map_or(true, |allowed| allowed.iter().any(|t| t.as_str() == tool))
+    }
+}
+
+/// What happens when a run's outputs exceed `Workflow::max_output_bytes`.
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq, Serialize, Deserialize, JsonSchema)]
+pub enum OutputOverflow {
+    #[default]
+    Terminate,
+    /// Replace the oldest other stages' outputs with size stubs until under
+    /// budget; terminate if that is not enough.
+    CompactOldest,
+}
+
 fn default_initial_backoff_ms() -> u64 {
     1000
 }
@@ -44,3 +116,25 @@ fn default_max_backoff_ms() -> u64 {
 fn default_backoff_multiplier() -> f64 {
     2.0
 }
+
+/// How much of the run a stage's dispatch context (`agent_context`)
+/// carries. The default sends everything; agents that read only a few
+/// predecessors can narrow it to keep instructions small.
+#[derive(Debug, Clone, Default, PartialEq, Serialize, Deserialize, JsonSchema)]
+pub struct ContextView {
+    /// Agents whose outputs (and `{agent}_{key}` template vars) are sent.
+    /// `None` = every agent's.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub context_outputs: Option<Vec<AgentName>>,
+    /// Leave the accumulated `state` out of the dispatch context.
+    #[serde(default, skip_serializing_if = "std::ops::Not::not")]
+    pub omit_state: bool,
+}
+
+impl ContextView {
+    pub fn includes_output(&self, agent: &str) -> bool {
+        self.context_outputs
+            .as_ref()
+            .map_or(true, |agents| agents.iter().any(|a| a.as_str() == agent))
+    }
+}
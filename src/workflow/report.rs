@@ -0,0 +1,64 @@
+//! Workflow validation results. `Workflow::check` collects every problem
+//! instead of stopping at the first, so authors can fix a definition in one
+//! pass; `Workflow::validate` wraps the report as the `source` of an
+//! `INVALID_ARGUMENT` error for callers that want the details.
+
+use serde::Serialize;
+
+use crate::types::{Error, Result};
+
+/// One problem in a workflow definition.
+#[derive(Debug, Clone, PartialEq, Eq, Serialize)]
+pub struct ValidationIssue {
+    /// Field path, e.g. `stages[2].default_next` or `stage_renames.old`.
+    pub path: String,
+    /// Stable machine-readable code: `required`, `out_of_range`,
+    /// `duplicate`, `unknown_stage`, `infinite_loop`, `not_allowed`.
+    pub code: &'static str,
+    pub message: String,
+}
+
+/// Every problem found in a workflow, in definition order.
+#[derive(Debug, Clone, Default, PartialEq, Eq, Serialize)]
+pub struct ValidationReport {
+    pub issues: Vec<ValidationIssue>,
+}
+
+impl ValidationReport {
+    pub fn is_empty(&self) -> bool {
+        self.issues.is_empty()
+    }
+
+    pub(crate) fn push(&mut self, path: impl Into<String>, code: &'static str, message: impl Into<String>) {
+        self.issues.push(ValidationIssue { path: path.into(), code, message: message.into() });
+    }
+
+    /// `Ok` when empty; otherwise a validation error listing every issue,
+    /// with the report itself as the error's source.
+    pub fn into_result(self) -> Result<()> {
+        if self.is_empty() {
+            return Ok(());
+        }
+        Err(Error::validation_with_source(self.to_string(), self))
+    }
+}
+
+impl std::fmt::Display for ValidationReport {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        match self.issues.as_slice() {
+            [only] => write!(f, "{}", only.message),
+            issues => {
+                write!(f, "{} problems: ", issues.len())?;
+                for (i, issue) in issues.iter().enumerate() {
+                    if i > 0 {
+                        write!(f, "; ")?;
+                    }
+                    write!(f, "{}", issue.message)?;
+                }
+                Ok(())
+            }
+        }
+    }
+}
+
+impl std::error::Error for ValidationReport {}
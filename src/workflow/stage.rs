@@ -4,7 +4,7 @@
 use schemars::JsonSchema;
 use serde::{Deserialize, Serialize};
 
-use super::policy::RetryPolicy;
+use super::policy::{ContextView, DeliverySemantics, RetryPolicy, SecurityContext, ToolPolicy};
 use crate::agent::policy::ContextOverflow;
 use crate::types::{AgentName, OutputKey, PromptKey, RoutingFnName, StageName};
 
@@ -40,7 +40,8 @@ pub struct Stage {
     #[serde(default, skip_serializing_if = "Option::is_none")]
     pub output_key: Option<OutputKey>,
     /// Maximum estimated tokens allowed in LLM context for this stage.
-    /// Uses chars/4 heuristic. When exceeded, applies `context_overflow`.
+    /// Sized by the agent's `TokenEstimator` (4 chars/token by default).
+    /// When exceeded, applies `context_overflow`.
     #[serde(default, skip_serializing_if = "Option::is_none")]
     pub max_context_tokens: Option<i64>,
     #[serde(default)]
@@ -52,6 +53,23 @@ pub struct Stage {
     /// Retry policy for transient agent failures.
     #[serde(default, skip_serializing_if = "Option::is_none")]
     pub retry_policy: Option<RetryPolicy>,
+    /// Whether the agent may run more than once per stage visit.
+    #[serde(default)]
+    pub delivery: DeliverySemantics,
+    /// Setup step (warm a cache, validate a checkout) run once per session
+    /// before the first regular stage. Bootstrap stages lead `stages`, run
+    /// in definition order, and take no routing: success moves to the next
+    /// stage in order, failure to `error_next` or termination with
+    /// `BootstrapFailed`. `timeout_seconds` and `retry_policy` apply as usual.
+    #[serde(default)]
+    pub bootstrap: bool,
+    /// Sandbox policy forwarded to the worker on `RunAgent`.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub security_context: Option<SecurityContext>,
+    /// Exempts this stage from the workflow's `write_once_outputs` (e.g. a
+    /// self-looping stage that refines its own output).
+    #[serde(default)]
+    pub overwrite_output: bool,
     /// Agent execution config — transparent to kernel, consumed by worker.
     #[serde(flatten)]
     pub agent_config: AgentConfig,
@@ -64,6 +82,12 @@ pub struct AgentConfig {
     /// Prompt template key for this agent. None = deterministic (no LLM call).
     #[serde(default, skip_serializing_if = "Option::is_none")]
     pub prompt_key: Option<PromptKey>,
+    /// Inline prompt with `{var}` placeholders, rendered by the kernel into
+    /// the dispatch instruction. Vars: `raw_input`, `{agent}_{key}` for prior
+    /// outputs, state keys, and metadata keys. Takes precedence over
+    /// `prompt_key`.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub prompt_template: Option<String>,
     /// Whether this agent makes LLM calls (default: false — explicit opt-in).
     #[serde(default)]
     pub has_llm: bool,
@@ -74,4 +98,17 @@ pub struct AgentConfig {
     /// Model role (e.g. "fast", "reasoning") — resolved by the LLM provider.
     #[serde(default, skip_serializing_if = "Option::is_none")]
     pub model_role: Option<String>,
+    /// Answer repeated identical LLM requests within a run from a bounded
+    /// per-run cache. Hits are counted as `llm_cache_hits`, not `llm_calls`.
+    #[serde(default)]
+    pub cache_prompts: bool,
+    /// Worker environment this stage needs (e.g. `python3.11`, `docker`,
+    /// `repo-access`). Only workers listing all of them in
+    /// `WorkerIdentity::capabilities` are handed or may report the stage.
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub required_capabilities: Vec<String>,
+    #[serde(flatten)]
+    pub tool_policy: ToolPolicy,
+    #[serde(flatten)]
+    pub context_view: ContextView,
 }
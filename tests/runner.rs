@@ -11,17 +11,19 @@
 //! E. Error handling (LLM failure, tool failure)
 //! F. Validation (definition-time rejection)
 
-use jeeves_core::run::{Run, TerminalReason};
+use jeeves_core::run::{Artifact, Run, TerminalReason, UserLogLevel, WorkerIdentity};
 use jeeves_core::kernel::Kernel;
-use jeeves_core::workflow::Workflow;
+use jeeves_core::workflow::{RetryPolicy, Workflow};
 use jeeves_core::types::RunId;
 use jeeves_core::kernel::actor::spawn;
-use jeeves_core::agent::{AgentRegistry, DeterministicAgent, LlmAgent};
+use jeeves_core::agent::{Agent, AgentContext, AgentOutput, AgentRegistry, DeterministicAgent, LlmAgent};
 use jeeves_core::agent::llm::mock::SequentialMockLlmProvider;
 use jeeves_core::agent::llm::{ChatResponse, RunEvent, TokenUsage, ToolCall};
 use jeeves_core::agent::prompts::PromptRegistry;
+use jeeves_core::agent::policy::FailureClass;
+use jeeves_core::agent::tokens::CharRatioEstimator;
 use jeeves_core::tools::{ToolExecutor, ToolInfo, ToolRegistry};
-use jeeves_core::kernel::runner::{run, run_loop};
+use jeeves_core::kernel::runner::{run, run_as, run_loop, run_streaming, run_streaming_with, DisconnectPolicy};
 use std::sync::Arc;
 use tokio_util::sync::CancellationToken;
 
@@ -135,6 +137,7 @@ fn make_llm_agent(
         max_tool_rounds: 10,
         content_resolver: None,
         hooks: Vec::new(),
+        token_estimator: Arc::new(CharRatioEstimator::default()),
     }
 }
 
@@ -195,6 +198,32 @@ async fn test_kernel_actor_system_status() {
     cancel.cancel();
 }
 
+#[tokio::test]
+async fn test_run_from_kernel_template() {
+    let mut kernel = Kernel::new();
+    kernel
+        .add_run_template(
+            jeeves_core::kernel::RunTemplate::new("code-review", two_stage_pipeline())
+                .with_metadata("timezone", serde_json::json!("Europe/London")),
+        )
+        .unwrap();
+    let cancel = CancellationToken::new();
+    let handle = spawn(kernel, cancel.clone());
+
+    let mut agents = AgentRegistry::new();
+    agents.register("understand", Arc::new(DeterministicAgent));
+    agents.register("respond", Arc::new(DeterministicAgent));
+
+    let template = handle.get_run_template("code-review").await.unwrap();
+    let (workflow, request) = template.instantiate("user1", "sess1", "review this", None).unwrap();
+    let result = run(&handle, RunId::must("templated"), workflow, request, &agents).await.unwrap();
+    assert_eq!(result.terminal_reason(), Some(TerminalReason::Completed));
+
+    let err = handle.get_run_template("missing").await.unwrap_err();
+    assert_eq!(err.to_error_code(), "NOT_FOUND");
+    cancel.cancel();
+}
+
 #[tokio::test]
 async fn test_kernel_actor_terminate_run() {
     let kernel = Kernel::new();
@@ -607,6 +636,61 @@ async fn test_streaming_event_ordering() {
     cancel.cancel();
 }
 
+#[tokio::test]
+async fn test_streaming_receiver_dropped_cancels_run() {
+    let kernel = Kernel::new();
+    let cancel = CancellationToken::new();
+    let handle = spawn(kernel, cancel.clone());
+
+    let mut agents = AgentRegistry::new();
+    agents.register("understand", Arc::new(DeterministicAgent));
+    agents.register("respond", Arc::new(DeterministicAgent));
+
+    let (join, rx) = run_streaming(
+        handle.clone(),
+        RunId::must("disconnect-cancel"),
+        two_stage_pipeline(),
+        Run::new("user", "sess", "hi", None),
+        Arc::new(agents),
+    )
+    .await
+    .unwrap();
+    drop(rx);
+
+    let result = join.await.unwrap().unwrap();
+    assert_eq!(result.terminal_reason(), Some(TerminalReason::ClientCancelled));
+    assert_eq!(handle.get_system_status().await.active_orchestration_sessions, 0);
+    cancel.cancel();
+}
+
+#[tokio::test]
+async fn test_streaming_receiver_dropped_detach_completes() {
+    let kernel = Kernel::new();
+    let cancel = CancellationToken::new();
+    let handle = spawn(kernel, cancel.clone());
+
+    let mut agents = AgentRegistry::new();
+    agents.register("understand", Arc::new(DeterministicAgent));
+    agents.register("respond", Arc::new(DeterministicAgent));
+
+    let (join, rx) = run_streaming_with(
+        handle,
+        RunId::must("disconnect-detach"),
+        two_stage_pipeline(),
+        Run::new("user", "sess", "hi", None),
+        Arc::new(agents),
+        DisconnectPolicy::Detach,
+        WorkerIdentity::in_process(),
+    )
+    .await
+    .unwrap();
+    drop(rx);
+
+    let result = join.await.unwrap().unwrap();
+    assert_eq!(result.terminal_reason(), Some(TerminalReason::Completed));
+    cancel.cancel();
+}
+
 #[tokio::test]
 async fn test_gate_routing() {
     let kernel = Kernel::new();
@@ -684,6 +768,366 @@ async fn test_max_visits_terminates() {
     cancel.cancel();
 }
 
+#[tokio::test]
+async fn test_cache_prompts_serves_loop_back_from_cache() {
+    let kernel = Kernel::new();
+    let cancel = CancellationToken::new();
+    let handle = spawn(kernel, cancel.clone());
+
+    // Every visit sends the same request; only the first reaches the provider.
+    let config: Workflow = serde_json::from_value(serde_json::json!({
+        "name": "prompt_cache_test",
+        "stages": [{
+            "name": "worker",
+            "agent": "worker",
+            "default_next": "worker",
+            "max_visits": 3,
+            "has_llm": true,
+            "cache_prompts": true
+        }],
+        "max_iterations": 20,
+        "max_llm_calls": 10,
+        "max_agent_hops": 10
+    }))
+    .unwrap();
+
+    let llm = Arc::new(SequentialMockLlmProvider::new(vec![make_text_response(r#"{"answer":"42"}"#)]));
+    let mut agents = AgentRegistry::new();
+    agents.register("worker", Arc::new(make_llm_agent(llm, Arc::new(ToolRegistry::new()))));
+
+    let result = run(
+        &handle, RunId::must("prompt-cache"), config, Run::new("user", "sess", "same question", None), &agents,
+    )
+    .await
+    .unwrap();
+
+    assert_eq!(result.terminal_reason(), Some(TerminalReason::MaxStageVisitsExceeded));
+    let metrics = result.aggregate_metrics.expect("aggregate metrics on terminate");
+    assert_eq!(metrics.total_llm_calls, 1);
+    assert_eq!(metrics.total_llm_cache_hits, 2);
+    cancel.cancel();
+}
+
+/// Writes a report, hands back a reference to it, and tells the user.
+#[derive(Debug)]
+struct ReportAgent;
+
+#[async_trait::async_trait]
+impl Agent for ReportAgent {
+    async fn process(&self, _ctx: &AgentContext) -> jeeves_core::types::Result<AgentOutput> {
+        Ok(AgentOutput {
+            output: serde_json::json!({"summary": "see report"}),
+            metrics: Default::default(),
+            success: true,
+            error_message: String::new(),
+            interrupt_request: None,
+            artifacts: vec![Artifact {
+                uri: "s3://reports/r1.pdf".to_string(),
+                kind: "report".to_string(),
+                mime_type: Some("application/pdf".to_string()),
+                size_bytes: Some(2048),
+            }],
+            user_log: vec![],
+            failure_class: Default::default(),
+        }
+        .log_to_user(UserLogLevel::Info, "searched 1,204 files")
+        .log_to_user(UserLogLevel::Warning, "x".repeat(600)))
+    }
+}
+
+#[tokio::test]
+async fn test_artifacts_and_user_log_keyed_by_stage_in_result() {
+    let kernel = Kernel::new();
+    let cancel = CancellationToken::new();
+    let handle = spawn(kernel, cancel.clone());
+
+    let mut agents = AgentRegistry::new();
+    agents.register("understand", Arc::new(DeterministicAgent));
+    agents.register("respond", Arc::new(ReportAgent));
+
+    let result = run(
+        &handle, RunId::must("artifacts"), two_stage_pipeline(), Run::new("user", "sess", "write it up", None), &agents,
+    )
+    .await
+    .unwrap();
+
+    assert_eq!(result.terminal_reason(), Some(TerminalReason::Completed));
+    assert_eq!(result.artifacts.len(), 1);
+    let report = &result.artifacts["respond"];
+    assert_eq!(report.len(), 1);
+    assert_eq!(report[0].kind, "report");
+    assert_eq!(report[0].size_bytes, Some(2048));
+
+    assert_eq!(result.user_log.len(), 2);
+    assert_eq!(result.user_log[0].stage.as_str(), "respond");
+    assert_eq!(result.user_log[0].message, "searched 1,204 files");
+    assert_eq!(result.user_log[1].level, UserLogLevel::Warning);
+    assert_eq!(result.user_log[1].message.chars().count(), 501, "truncated to 500 + ellipsis");
+    cancel.cancel();
+}
+
+/// Records the `workdir` it was asked to release; fails when told to.
+#[derive(Debug)]
+struct CleanupAgent {
+    released: Arc<std::sync::Mutex<Vec<String>>>,
+    fail: bool,
+}
+
+#[async_trait::async_trait]
+impl Agent for CleanupAgent {
+    async fn process(&self, ctx: &AgentContext) -> jeeves_core::types::Result<AgentOutput> {
+        if self.fail {
+            return Err(jeeves_core::types::Error::internal("container already gone"));
+        }
+        let workdir = ctx.metadata.get("workdir").and_then(|v| v.as_str()).unwrap_or_default();
+        self.released.lock().unwrap().push(workdir.to_string());
+        Ok(AgentOutput {
+            output: serde_json::json!({}),
+            metrics: Default::default(),
+            success: true,
+            error_message: String::new(),
+            interrupt_request: None,
+            artifacts: vec![],
+            user_log: vec![],
+            failure_class: Default::default(),
+        })
+    }
+}
+
+#[tokio::test]
+async fn test_cleanup_agents_run_on_termination_best_effort() {
+    let kernel = Kernel::new();
+    let cancel = CancellationToken::new();
+    let handle = spawn(kernel, cancel.clone());
+
+    let released = Arc::new(std::sync::Mutex::new(Vec::new()));
+    let mut agents = AgentRegistry::new();
+    agents.register("understand", Arc::new(DeterministicAgent));
+    agents.register("respond", Arc::new(DeterministicAgent));
+    agents.register("drop_container", Arc::new(CleanupAgent { released: released.clone(), fail: true }));
+    agents.register("remove_clone", Arc::new(CleanupAgent { released: released.clone(), fail: false }));
+
+    let mut workflow = two_stage_pipeline();
+    workflow.cleanup_agents = vec!["drop_container".to_string(), "unregistered".to_string(), "remove_clone".to_string()];
+    let run_input = Run::new("user", "sess", "clone it", Some(serde_json::json!({"workdir": "/tmp/clone-1"})));
+
+    let result = run(&handle, RunId::must("cleanup"), workflow, run_input, &agents).await.unwrap();
+
+    // The failing and unregistered cleanups don't stop the next one or
+    // change the outcome.
+    assert_eq!(result.terminal_reason(), Some(TerminalReason::Completed));
+    assert_eq!(*released.lock().unwrap(), vec!["/tmp/clone-1".to_string()]);
+    cancel.cancel();
+}
+
+/// Signals that it started, then never finishes on its own.
+#[derive(Debug)]
+struct StallAgent {
+    started: Arc<tokio::sync::Notify>,
+}
+
+#[async_trait::async_trait]
+impl Agent for StallAgent {
+    async fn process(&self, _ctx: &AgentContext) -> jeeves_core::types::Result<AgentOutput> {
+        self.started.notify_one();
+        std::future::pending().await
+    }
+}
+
+#[tokio::test]
+async fn test_cleanup_agents_run_when_session_is_removed_mid_drive() {
+    let kernel = Kernel::new();
+    let cancel = CancellationToken::new();
+    let handle = spawn(kernel, cancel.clone());
+
+    let started = Arc::new(tokio::sync::Notify::new());
+    let released = Arc::new(std::sync::Mutex::new(Vec::new()));
+    let mut agents = AgentRegistry::new();
+    agents.register("understand", Arc::new(StallAgent { started: started.clone() }));
+    agents.register("respond", Arc::new(DeterministicAgent));
+    agents.register("remove_clone", Arc::new(CleanupAgent { released: released.clone(), fail: false }));
+
+    let mut workflow = two_stage_pipeline();
+    workflow.cleanup_agents = vec!["remove_clone".to_string()];
+    let run_input = Run::new("user", "sess", "clone it", Some(serde_json::json!({"workdir": "/tmp/clone-2"})));
+    let run_id = RunId::must("removed");
+    let worker = {
+        let (handle, run_id) = (handle.clone(), run_id.clone());
+        tokio::spawn(async move { run(&handle, run_id, workflow, run_input, &agents).await })
+    };
+    started.notified().await;
+    handle.terminate_run(&run_id).await.unwrap();
+
+    // No `Terminate` reaches the drive; it aborts, and cleanup still runs.
+    let result = tokio::time::timeout(std::time::Duration::from_secs(5), worker)
+        .await
+        .expect("removed session should not block the worker")
+        .unwrap();
+    assert!(result.is_err());
+    assert_eq!(*released.lock().unwrap(), vec!["/tmp/clone-2".to_string()]);
+    cancel.cancel();
+}
+
+#[tokio::test]
+async fn test_cancel_run_aborts_in_flight_stage() {
+    let kernel = Kernel::new();
+    let cancel = CancellationToken::new();
+    let handle = spawn(kernel, cancel.clone());
+
+    let started = Arc::new(tokio::sync::Notify::new());
+    let mut agents = AgentRegistry::new();
+    agents.register("understand", Arc::new(StallAgent { started: started.clone() }));
+    agents.register("respond", Arc::new(DeterministicAgent));
+
+    let run_id = RunId::must("stalled");
+    let worker = {
+        let (handle, run_id) = (handle.clone(), run_id.clone());
+        tokio::spawn(async move {
+            run(&handle, run_id, two_stage_pipeline(), Run::new("user", "sess", "hi", None), &agents).await
+        })
+    };
+    started.notified().await;
+    handle.cancel_run(&run_id, TerminalReason::UserCancelled).await.unwrap();
+
+    let result = tokio::time::timeout(std::time::Duration::from_secs(5), worker)
+        .await
+        .expect("cancelled stage should not block the worker")
+        .unwrap()
+        .unwrap();
+    assert_eq!(result.terminal_reason(), Some(TerminalReason::UserCancelled));
+    cancel.cancel();
+}
+
+/// Fails every attempt with a fixed classification, counting attempts.
+#[derive(Debug)]
+struct FailingAgent {
+    class: FailureClass,
+    attempts: Arc<std::sync::atomic::AtomicU32>,
+}
+
+#[async_trait::async_trait]
+impl Agent for FailingAgent {
+    async fn process(&self, _ctx: &AgentContext) -> jeeves_core::types::Result<AgentOutput> {
+        self.attempts.fetch_add(1, std::sync::atomic::Ordering::SeqCst);
+        Ok(AgentOutput {
+            output: serde_json::json!({}),
+            metrics: Default::default(),
+            success: false,
+            error_message: "upstream said no".to_string(),
+            interrupt_request: None,
+            artifacts: vec![],
+            user_log: vec![],
+            failure_class: self.class,
+        })
+    }
+}
+
+async fn attempts_for(class: FailureClass) -> u32 {
+    let kernel = Kernel::new();
+    let cancel = CancellationToken::new();
+    let handle = spawn(kernel, cancel.clone());
+
+    let attempts = Arc::new(std::sync::atomic::AtomicU32::new(0));
+    let mut agents = AgentRegistry::new();
+    agents.register("flaky", Arc::new(FailingAgent { class, attempts: attempts.clone() }));
+    let workflow = Workflow::builder("retries")
+        .agent("flaky")
+        .retry_policy(RetryPolicy {
+            max_retries: 2,
+            initial_backoff_ms: 1,
+            max_backoff_ms: 1,
+            backoff_multiplier: 1.0,
+        })
+        .build()
+        .unwrap();
+
+    let _ = run(&handle, RunId::must("retries"), workflow, Run::new("user", "sess", "hi", None), &agents)
+        .await
+        .unwrap();
+    cancel.cancel();
+    attempts.load(std::sync::atomic::Ordering::SeqCst)
+}
+
+#[tokio::test]
+async fn test_retry_honours_failure_class() {
+    assert_eq!(attempts_for(FailureClass::Retryable).await, 3);
+    assert_eq!(attempts_for(FailureClass::Fatal).await, 1);
+
+    let started = std::time::Instant::now();
+    assert_eq!(attempts_for(FailureClass::Throttled { retry_after_ms: 50 }).await, 3);
+    assert!(started.elapsed() >= std::time::Duration::from_millis(100));
+}
+
+#[tokio::test]
+async fn test_missing_capabilities_fail_without_running_the_stage() {
+    let kernel = Kernel::new();
+    let cancel = CancellationToken::new();
+    let handle = spawn(kernel, cancel.clone());
+
+    let attempts = Arc::new(std::sync::atomic::AtomicU32::new(0));
+    let mut agents = AgentRegistry::new();
+    agents.register("build", Arc::new(FailingAgent { class: FailureClass::Fatal, attempts: attempts.clone() }));
+    let workflow: Workflow = serde_json::from_value(serde_json::json!({
+        "name": "needs_sandbox",
+        "stages": [
+            {"name": "build", "agent": "build", "required_capabilities": ["test-only-sandbox"]}
+        ],
+        "max_iterations": 5,
+        "max_llm_calls": 5,
+        "max_agent_hops": 5
+    }))
+    .unwrap();
+
+    let result = run(&handle, RunId::must("caps"), workflow.clone(), Run::new("user", "sess", "hi", None), &agents)
+        .await
+        .unwrap();
+    assert_eq!(result.terminal_reason(), Some(TerminalReason::PolicyViolation));
+    assert_eq!(attempts.load(std::sync::atomic::Ordering::SeqCst), 0);
+
+    // Declared explicitly, the capability lets the stage run.
+    let sandboxed = WorkerIdentity::in_process().with_capabilities(["test-only-sandbox"]);
+    let _ = run_as(&handle, RunId::must("caps-ok"), workflow, Run::new("user", "sess", "hi", None), &agents, sandboxed)
+        .await
+        .unwrap();
+    assert_eq!(attempts.load(std::sync::atomic::Ordering::SeqCst), 1);
+    cancel.cancel();
+}
+
+#[tokio::test]
+async fn test_outside_allowed_regions_fails_without_running_the_stage() {
+    let kernel = Kernel::new();
+    let cancel = CancellationToken::new();
+    let handle = spawn(kernel, cancel.clone());
+
+    let attempts = Arc::new(std::sync::atomic::AtomicU32::new(0));
+    let mut agents = AgentRegistry::new();
+    agents.register("build", Arc::new(FailingAgent { class: FailureClass::Fatal, attempts: attempts.clone() }));
+    let workflow: Workflow = serde_json::from_value(serde_json::json!({
+        "name": "eu_only",
+        "stages": [{"name": "build", "agent": "build"}],
+        "allowed_regions": ["eu-west"],
+        "max_iterations": 5,
+        "max_llm_calls": 5,
+        "max_agent_hops": 5
+    }))
+    .unwrap();
+
+    let us = WorkerIdentity::in_process().with_region("us-east");
+    let result = run_as(&handle, RunId::must("us"), workflow.clone(), Run::new("user", "sess", "hi", None), &agents, us)
+        .await
+        .unwrap();
+    assert_eq!(result.terminal_reason(), Some(TerminalReason::PolicyViolation));
+    assert_eq!(attempts.load(std::sync::atomic::Ordering::SeqCst), 0);
+
+    // Inside the region the stage runs (and fails on its own terms).
+    let eu = WorkerIdentity::in_process().with_region("eu-west");
+    let _ = run_as(&handle, RunId::must("eu"), workflow, Run::new("user", "sess", "hi", None), &agents, eu)
+        .await
+        .unwrap();
+    assert_eq!(attempts.load(std::sync::atomic::Ordering::SeqCst), 1);
+    cancel.cancel();
+}
+
 #[tokio::test]
 async fn test_error_next_routing() {
     let kernel = Kernel::new();
@@ -978,6 +1422,15 @@ async fn test_confirmation_gate_mcp_agent_buffered() {
         context_overflow: None,
         interrupt_response: None,
         response_format: None,
+        rendered_prompt: None,
+        prompt_cache: None,
+        cancellation: None,
+        security_context: None,
+        tool_policy: None,
+        locale: None,
+        timezone: None,
+        params: HashMap::new(),
+        idempotency_key: None,
     };
 
     let output = agent.process(&ctx).await.unwrap();
@@ -1029,6 +1482,15 @@ async fn test_confirmation_gate_safe_tool_no_interrupt() {
         context_overflow: None,
         interrupt_response: None,
         response_format: None,
+        rendered_prompt: None,
+        prompt_cache: None,
+        cancellation: None,
+        security_context: None,
+        tool_policy: None,
+        locale: None,
+        timezone: None,
+        params: HashMap::new(),
+        idempotency_key: None,
     };
 
     let output = agent.process(&ctx).await.unwrap();
@@ -1102,6 +1564,16 @@ async fn test_confirmation_gate_full_pipeline_buffered() {
         received_at: chrono::Utc::now(),
     }).await.unwrap();
 
+    // A second UI answering the same interrupt loses the race.
+    let err = handle.resolve_interrupt(&run_id, interrupt_id.as_str(), jeeves_core::run::InterruptResponse {
+        text: None,
+        approved: Some(false),
+        decision: None,
+        data: None,
+        received_at: chrono::Utc::now(),
+    }).await.unwrap_err();
+    assert_eq!(err.to_error_code(), "FAILED_PRECONDITION");
+
     // Re-run pipeline loop — should now execute the tool and complete
     let result2 = run_loop(&handle, &run_id, &agents, None, "confirm_test").await.unwrap();
     assert!(result2.terminated(), "Pipeline should complete after interrupt resolved");